@@ -0,0 +1,183 @@
+package client
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/neubot/dash/model"
+	"github.com/neubot/dash/spec"
+)
+
+// Adapter is the adaptation (ABR) algorithm selecting the rate of the next
+// segment, which allows to compare adaptation strategies. The default is the
+// ThroughputAdapter, i.e., the original behavior of the DASH test.
+type Adapter interface {
+	// NextRate returns the rate in kbit/s of the next segment given the
+	// results of the segments transferred so far, in order, including
+	// the failed ones. We only call this method after a successful segment
+	// and we do not call it when emulating a stream (see StreamRate).
+	NextRate(history []model.ClientResults) int64
+}
+
+// ThroughputAdapter is the throughput-based Adapter selecting the rate of
+// the next segment equal to the throughput measured for the last segment.
+type ThroughputAdapter struct{}
+
+var _ Adapter = ThroughputAdapter{}
+
+// NextRate implements Adapter.
+func (ThroughputAdapter) NextRate(history []model.ClientResults) int64 {
+	last, found := lastSuccess(history)
+	if !found || last.Elapsed <= 0 {
+		return spec.DefaultRates[0]
+	}
+	speed := float64(last.Received) / float64(last.Elapsed)
+	speed *= 8.0    // to bits per second
+	speed /= 1000.0 // to kbit/s
+	return int64(speed)
+}
+
+// BufferAdapter is the buffer-based Adapter (i.e., BBA-0) selecting the
+// rate of the next segment among spec.DefaultRates only depending on the
+// level of the emulated playback buffer (see bufferLevel). We use the lowest
+// rate when the buffer is below the Reservoir, the highest rate when the
+// buffer is above the Reservoir plus the Cushion, and otherwise a rate
+// that linearly depends on the buffer level within the Cushion.
+type BufferAdapter struct {
+	// Cushion is the size of the buffer region where the rate grows.
+	Cushion time.Duration
+
+	// Reservoir is the buffer level below which we use the lowest rate.
+	Reservoir time.Duration
+}
+
+var _ Adapter = &BufferAdapter{}
+
+// NextRate implements Adapter.
+func (ba *BufferAdapter) NextRate(history []model.ClientResults) int64 {
+	buffer := bufferLevel(history)
+	lowest, highest := spec.DefaultRates[0], spec.DefaultRates[len(spec.DefaultRates)-1]
+	switch {
+	case buffer <= ba.Reservoir:
+		return lowest
+	case buffer >= ba.Reservoir+ba.Cushion:
+		return highest
+	}
+	fraction := float64(buffer-ba.Reservoir) / float64(ba.Cushion)
+	return rateNotAbove(lowest + int64(fraction*float64(highest-lowest)))
+}
+
+// BOLAAdapter is the buffer-based Adapter implementing BOLA-BASIC, which
+// selects the rate among spec.DefaultRates maximizing the utility of the
+// segment, that is logarithmic in the rate, relative to its size, given the
+// level of the emulated playback buffer (see bufferLevel). See Spiteri et al.,
+// "BOLA: Near-Optimal Bitrate Adaptation for Online Videos", 2016.
+type BOLAAdapter struct {
+	// BufferSize is the size of the playback buffer in segments, which
+	// must be greater than one. The larger the buffer, the more we wait
+	// for the buffer to grow before selecting higher rates.
+	BufferSize int64
+}
+
+var _ Adapter = &BOLAAdapter{}
+
+// bolaGamma is the BOLA parameter trading off the utility and the risk of
+// rebuffering, which the paper calls gamma times p.
+const bolaGamma = 5.0
+
+// NextRate implements Adapter.
+func (ba *BOLAAdapter) NextRate(history []model.ClientResults) int64 {
+	rates := spec.DefaultRates
+	utility := func(rate int64) float64 {
+		return math.Log(float64(rate) / float64(rates[0]))
+	}
+	control := float64(ba.BufferSize-1) / (utility(rates[len(rates)-1]) + bolaGamma)
+	buffer := bufferLevel(history).Seconds() / segmentDuration.Seconds()
+	best, bestScore := rates[0], math.Inf(-1)
+	for _, rate := range rates {
+		score := (control*(utility(rate)+bolaGamma) - buffer) / float64(rate)
+		if score > bestScore {
+			best, bestScore = rate, score
+		}
+	}
+	return best
+}
+
+// bufferLevel returns the level of the playback buffer of a player that
+// starts playing as soon as it receives the first segment, given the results
+// of the segments transferred so far. Each successful segment adds its
+// duration to the buffer, while the player consumes the buffer while the
+// segment is being transferred. Failed segments do not add anything.
+func bufferLevel(history []model.ClientResults) time.Duration {
+	var buffer float64
+	for _, result := range history {
+		buffer = math.Max(buffer-result.Elapsed, 0)
+		if result.Failure == "" {
+			buffer += float64(result.ElapsedTarget)
+		}
+	}
+	return time.Duration(buffer * float64(time.Second))
+}
+
+// lastSuccess returns the last successful result of the given history.
+func lastSuccess(history []model.ClientResults) (model.ClientResults, bool) {
+	for idx := len(history) - 1; idx >= 0; idx-- {
+		if history[idx].Failure == "" {
+			return history[idx], true
+		}
+	}
+	return model.ClientResults{}, false
+}
+
+// rateNotAbove returns the largest default rate that is not above the
+// given rate (in kbit/s), or the lowest default rate if there is none.
+func rateNotAbove(rate int64) int64 {
+	return lowerRate(rate + 1)
+}
+
+// adapters maps the name of each built-in Adapter to its constructor.
+var adapters = map[string]func() Adapter{
+	"bola": func() Adapter {
+		return &BOLAAdapter{BufferSize: 10}
+	},
+	"buffer": func() Adapter {
+		return &BufferAdapter{Cushion: 10 * time.Second, Reservoir: 4 * time.Second}
+	},
+	"throughput": func() Adapter {
+		return ThroughputAdapter{}
+	},
+}
+
+// errUnknownAdapter is returned when the Adapter name is unknown.
+var errUnknownAdapter = errors.New("unknown adapter")
+
+// errNoAdapter is returned when the Adapter is nil.
+var errNoAdapter = errors.New("no adapter")
+
+// validateAdapter returns an error when the Adapter is nil and we need
+// it, i.e., unless we are emulating a stream (see StreamRate).
+func (c *Client) validateAdapter() error {
+	if c.Adapter == nil && c.StreamRate <= 0 {
+		return errNoAdapter
+	}
+	return nil
+}
+
+// Adapters returns the sorted names of the built-in adapters that one
+// can create using NewAdapter.
+func Adapters() []string {
+	return slices.Sorted(maps.Keys(adapters))
+}
+
+// NewAdapter returns a new instance of the built-in Adapter with the given
+// name, i.e., "bola", "buffer", or "throughput", using the default settings.
+func NewAdapter(name string) (Adapter, error) {
+	constructor, found := adapters[name]
+	if !found {
+		return nil, errUnknownAdapter
+	}
+	return constructor(), nil
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/neubot/dash/model"
+	"github.com/neubot/dash/spec"
+)
+
+func TestNewAdapter(t *testing.T) {
+	for _, name := range Adapters() {
+		if _, err := NewAdapter(name); err != nil {
+			t.Fatal(name, err)
+		}
+	}
+	if _, err := NewAdapter("antani"); !errors.Is(err, errUnknownAdapter) {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestThroughputAdapter(t *testing.T) {
+	t.Run("with a successful segment", func(t *testing.T) {
+		rate := ThroughputAdapter{}.NextRate([]model.ClientResults{
+			{Elapsed: 1, Received: 1000},
+			{Elapsed: 0, Failure: "mocked error"},
+		})
+		if rate != 8 {
+			t.Fatal("unexpected rate", rate)
+		}
+	})
+
+	t.Run("without successful segments", func(t *testing.T) {
+		rate := ThroughputAdapter{}.NextRate(nil)
+		if rate != spec.DefaultRates[0] {
+			t.Fatal("unexpected rate", rate)
+		}
+	})
+}
+
+func TestBufferLevel(t *testing.T) {
+	buffer := bufferLevel([]model.ClientResults{
+		{Elapsed: 1, ElapsedTarget: 2},                          // 2 s
+		{Elapsed: 1, ElapsedTarget: 2},                          // 1 s + 2 s
+		{Elapsed: 4, ElapsedTarget: 2, Failure: "mocked error"}, // stalled
+		{Elapsed: 0.5, ElapsedTarget: 2},                        // 2 s
+	})
+	if buffer != 2*time.Second {
+		t.Fatal("unexpected buffer level", buffer)
+	}
+}
+
+func TestBufferAdapter(t *testing.T) {
+	adapter := &BufferAdapter{Cushion: 10 * time.Second, Reservoir: 4 * time.Second}
+	segments := func(count int) (history []model.ClientResults) {
+		for idx := 0; idx < count; idx++ {
+			history = append(history, model.ClientResults{ElapsedTarget: 2})
+		}
+		return
+	}
+	if rate := adapter.NextRate(segments(1)); rate != spec.DefaultRates[0] {
+		t.Fatal("unexpected rate below the reservoir", rate)
+	}
+	if rate := adapter.NextRate(segments(7)); rate != spec.DefaultRates[len(spec.DefaultRates)-1] {
+		t.Fatal("unexpected rate above the cushion", rate)
+	}
+	// 9 s of buffer are half of the cushion, i.e., (20000+100)/2 kbit/s
+	if rate := adapter.NextRate(append(segments(4), model.ClientResults{Elapsed: 1, ElapsedTarget: 2})); rate != 10000 {
+		t.Fatal("unexpected rate within the cushion", rate)
+	}
+}
+
+func TestBOLAAdapter(t *testing.T) {
+	adapter := &BOLAAdapter{BufferSize: 10}
+	var (
+		history  []model.ClientResults
+		previous int64
+	)
+	for idx := 0; idx < 10; idx++ {
+		rate := adapter.NextRate(history)
+		if rate < previous {
+			t.Fatal("the rate should not decrease while the buffer grows")
+		}
+		previous = rate
+		history = append(history, model.ClientResults{ElapsedTarget: 2})
+	}
+	if previous != spec.DefaultRates[len(spec.DefaultRates)-1] {
+		t.Fatal("expected the highest rate with a full buffer", previous)
+	}
+	if rate := adapter.NextRate(nil); rate != spec.DefaultRates[0] {
+		t.Fatal("expected the lowest rate with an empty buffer", rate)
+	}
+}
+
+func TestClientValidateAdapter(t *testing.T) {
+	t.Run("with the default adapter", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		if err := client.validateAdapter(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("without an adapter", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.Adapter = nil
+		if err := client.validateAdapter(); !errors.Is(err, errNoAdapter) {
+			t.Fatal("not the error we expected", err)
+		}
+		ch, err := client.StartDownload(context.Background())
+		if !errors.Is(err, errNoAdapter) || ch != nil {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+
+	t.Run("without an adapter when emulating a stream", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.Adapter = nil
+		client.StreamRate = 25000
+		if err := client.validateAdapter(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
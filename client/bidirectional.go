@@ -0,0 +1,106 @@
+package client
+
+import (
+	"context"
+	"sync"
+
+	"github.com/neubot/dash/model"
+)
+
+// BidirectionalResults contains the results of running the download and the
+// upload tests concurrently, using separate sessions and connections, which
+// resembles a video call while streaming. To quantify the impact of the cross
+// traffic, we also run each direction alone before running them concurrently.
+type BidirectionalResults struct {
+	// Download contains the results of the download direction.
+	Download *BidirectionalSideResults `json:"download"`
+
+	// Upload contains the results of the upload direction.
+	Upload *BidirectionalSideResults `json:"upload"`
+}
+
+// BidirectionalSideResults contains the results of one direction
+// of [BidirectionalResults].
+type BidirectionalSideResults struct {
+	// Alone contains the results of running this direction alone.
+	Alone *PairedSideResults `json:"alone"`
+
+	// Concurrent contains the results of running this direction
+	// concurrently with the other direction.
+	Concurrent *PairedSideResults `json:"concurrent"`
+
+	// RateRatio is the ratio between the concurrent median rate and the
+	// alone median rate, which quantifies the impact of the cross traffic
+	// on this direction. It is zero when either median is zero.
+	RateRatio float64 `json:"rate_ratio"`
+}
+
+// RunBidirectional runs the download alone, the upload alone, and then both
+// concurrently, and returns the results of each direction. Because a client
+// runs a single test, we call newClient to obtain a client for each test,
+// thus newClient should configure all the clients to use the same server
+// (e.g., by setting FQDN). When the concurrent clients share the same HTTP
+// client, we make sure that the upload client uses separate connections.
+func RunBidirectional(ctx context.Context, newClient func() *Client) *BidirectionalResults {
+	// 1. measure each direction alone to obtain the baselines
+	results := &BidirectionalResults{
+		Download: &BidirectionalSideResults{},
+		Upload:   &BidirectionalSideResults{},
+	}
+	results.Download.Alone = runSide(ctx, newClient(), (*Client).StartDownload)
+	results.Upload.Alone = runSide(ctx, newClient(), (*Client).StartUpload)
+
+	// 2. measure both directions concurrently
+	download, upload := newClient(), newClient()
+	if download.HTTPClient == upload.HTTPClient {
+		upload.HTTPClient = separateHTTPClient(upload.HTTPClient)
+	}
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		results.Download.Concurrent = runSide(ctx, download, (*Client).StartDownload)
+	}()
+	go func() {
+		defer wg.Done()
+		results.Upload.Concurrent = runSide(ctx, upload, (*Client).StartUpload)
+	}()
+	wg.Wait()
+
+	// 3. quantify the impact of the cross traffic
+	for _, side := range []*BidirectionalSideResults{results.Download, results.Upload} {
+		side.RateRatio = rateRatio(side.Concurrent.MedianRate, side.Alone.MedianRate)
+	}
+	return results
+}
+
+// runSide runs a test using the given client and start method.
+func runSide(
+	ctx context.Context,
+	c *Client,
+	start func(c *Client, ctx context.Context) (<-chan model.ClientResults, error),
+) *PairedSideResults {
+	side := &PairedSideResults{Client: []model.ClientResults{}, FQDN: c.FQDN}
+	ch, err := start(c, ctx)
+	if err != nil {
+		side.Failure = err.Error()
+		return side
+	}
+	for current := range ch {
+		side.Client = append(side.Client, current)
+	}
+	if err := c.Error(); err != nil {
+		side.Failure = err.Error()
+	}
+	side.MedianRate = MedianRate(side.Client)
+	return side
+}
+
+// rateRatio returns the ratio between the given median rates
+// or zero when either median rate is zero.
+func rateRatio(rate, reference float64) float64 {
+	if rate <= 0 || reference <= 0 {
+		return 0
+	}
+	return rate / reference
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+// newBidirectionalFactory returns a factory of clients for testing
+// RunBidirectional that transfer each segment at the alone rate in kbit/s
+// when running alone and at the concurrent rate otherwise, knowing that
+// RunBidirectional creates the two concurrent clients last.
+func newBidirectionalFactory(alone, concurrent int64, uploadErr error) func() *Client {
+	var created int
+	return func() *Client {
+		rate := alone
+		if created++; created > 2 {
+			rate = concurrent
+		}
+		transfer := func(current *model.ClientResults) {
+			current.Elapsed = 1
+			current.Received = rate * 1000 / 8
+		}
+		client := New(softwareName, softwareVersion)
+		client.FQDN = "dash.example.com"
+		client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+			return model.NegotiateResponse{}, nil
+		}
+		client.deps.Download = func(
+			ctx context.Context, authorization string,
+			current *model.ClientResults, negotiateURL *url.URL,
+		) error {
+			transfer(current)
+			return nil
+		}
+		client.deps.Upload = func(
+			ctx context.Context, authorization string,
+			current *model.ClientResults, negotiateURL *url.URL,
+		) error {
+			transfer(current)
+			return uploadErr
+		}
+		client.deps.Collect = func(ctx context.Context, authorization string, negotiateURL *url.URL) error {
+			return nil
+		}
+		return client
+	}
+}
+
+func TestRunBidirectional(t *testing.T) {
+	t.Run("common case", func(t *testing.T) {
+		results := RunBidirectional(context.Background(), newBidirectionalFactory(4000, 1000, nil))
+		for _, side := range []*BidirectionalSideResults{results.Download, results.Upload} {
+			if side.Alone.Failure != "" || side.Concurrent.Failure != "" {
+				t.Fatal("expected no failures")
+			}
+			if side.Alone.MedianRate != 4000 || side.Concurrent.MedianRate != 1000 {
+				t.Fatal("unexpected median rates", side.Alone.MedianRate, side.Concurrent.MedianRate)
+			}
+			if side.RateRatio != 0.25 {
+				t.Fatal("unexpected rate ratio", side.RateRatio)
+			}
+		}
+		for _, current := range results.Upload.Concurrent.Client {
+			if current.Direction != directionUpload {
+				t.Fatal("expected upload results")
+			}
+		}
+		for _, current := range results.Download.Concurrent.Client {
+			if current.Direction != "" {
+				t.Fatal("expected download results")
+			}
+		}
+	})
+
+	t.Run("we use separate connections", func(t *testing.T) {
+		var clients []*Client
+		factory := newBidirectionalFactory(4000, 1000, nil)
+		RunBidirectional(context.Background(), func() *Client {
+			client := factory()
+			clients = append(clients, client)
+			return client
+		})
+		if len(clients) != 4 {
+			t.Fatal("unexpected number of clients", len(clients))
+		}
+		if clients[2].HTTPClient == clients[3].HTTPClient {
+			t.Fatal("expected separate HTTP clients")
+		}
+	})
+
+	t.Run("failure of one direction", func(t *testing.T) {
+		results := RunBidirectional(context.Background(), newBidirectionalFactory(
+			4000, 1000, errors.New("Mocked error")))
+		if results.Upload.Alone.Failure != "Mocked error" || results.Upload.Concurrent.Failure != "Mocked error" {
+			t.Fatal("not the failure we expected")
+		}
+		if results.Upload.RateRatio != 0 {
+			t.Fatal("expected zero rate ratio")
+		}
+		if results.Download.Alone.MedianRate != 4000 {
+			t.Fatal("unexpected download median rate", results.Download.Alone.MedianRate)
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neubot/dash/spec"
+)
+
+// ttfbTracer measures the time to first byte of an HTTP request, i.e., the
+// time elapsed between writing the request and receiving the first byte of
+// the response, using [httptrace.ClientTrace] hooks.
+type ttfbTracer struct {
+	// mtx protects the fields below.
+	mtx sync.Mutex
+
+	// ttfb is the time to first byte or zero.
+	ttfb time.Duration
+
+	// wrote is when we finished writing the request.
+	wrote time.Time
+}
+
+// wrap returns a context configured to use the tracer hooks.
+func (tt *ttfbTracer) wrap(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotFirstResponseByte: tt.gotFirstResponseByte,
+		WroteRequest:         tt.wroteRequest,
+	})
+}
+
+// wroteRequest is called when we finished writing the request.
+func (tt *ttfbTracer) wroteRequest(info httptrace.WroteRequestInfo) {
+	tt.mtx.Lock()
+	defer tt.mtx.Unlock()
+	tt.wrote = time.Now()
+}
+
+// gotFirstResponseByte is called when we receive the first response byte.
+func (tt *ttfbTracer) gotFirstResponseByte() {
+	tt.mtx.Lock()
+	defer tt.mtx.Unlock()
+	if !tt.wrote.IsZero() {
+		tt.ttfb = time.Since(tt.wrote)
+	}
+}
+
+// get returns the time to first byte or zero if we could not measure it.
+func (tt *ttfbTracer) get() time.Duration {
+	tt.mtx.Lock()
+	defer tt.mtx.Unlock()
+	return tt.ttfb
+}
+
+// suspectCached returns whether an intermediary cache likely served the
+// response to the download request, given the cache busting token we sent,
+// if any, and the time to first byte. We consider the following evidence:
+//
+// 1. the response contains the Age header, which caches add;
+//
+// 2. the response contains X-Cache or X-Cache-Status headers indicating a hit;
+//
+// 3. the response contains the Via header, which proxies add;
+//
+// 4. the server echoed a cache busting token different from ours;
+//
+// 5. the time to first byte is much lower than the one of the negotiate
+// request, which caches cannot serve, while generating the segment makes
+// the server slower to respond to download requests than to negotiate.
+func (c *Client) suspectCached(resp *http.Response, token string, ttfb time.Duration) bool {
+	if resp.Header.Get("Age") != "" || resp.Header.Get("Via") != "" {
+		return true
+	}
+	for _, key := range []string{"X-Cache", "X-Cache-Status"} {
+		if strings.Contains(strings.ToUpper(resp.Header.Get(key)), "HIT") {
+			return true
+		}
+	}
+	if echo := resp.Header.Get(spec.CacheBustingHeader); token != "" && echo != "" && echo != token {
+		return true
+	}
+	const ratio = 2
+	return ttfb > 0 && c.negotiateTTFB > 0 && ttfb*ratio < c.negotiateTTFB
+}
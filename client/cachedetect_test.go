@@ -0,0 +1,105 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptrace"
+	"testing"
+	"time"
+
+	"github.com/neubot/dash/spec"
+)
+
+func TestTTFBTracer(t *testing.T) {
+	t.Run("without writing the request", func(t *testing.T) {
+		tracer := &ttfbTracer{}
+		trace := httptrace.ContextClientTrace(tracer.wrap(context.Background()))
+		trace.GotFirstResponseByte()
+		if tracer.get() != 0 {
+			t.Fatal("expected zero time to first byte")
+		}
+	})
+
+	t.Run("common case", func(t *testing.T) {
+		tracer := &ttfbTracer{}
+		trace := httptrace.ContextClientTrace(tracer.wrap(context.Background()))
+		trace.WroteRequest(httptrace.WroteRequestInfo{})
+		time.Sleep(time.Millisecond)
+		trace.GotFirstResponseByte()
+		if tracer.get() <= 0 {
+			t.Fatal("expected positive time to first byte")
+		}
+	})
+}
+
+func TestClientSuspectCached(t *testing.T) {
+	cases := []struct {
+		name          string
+		header        http.Header
+		token         string
+		ttfb          time.Duration
+		negotiateTTFB time.Duration
+		expect        bool
+	}{{
+		name:   "without evidence",
+		header: http.Header{},
+		expect: false,
+	}, {
+		name:   "with Age header",
+		header: http.Header{"Age": {"10"}},
+		expect: true,
+	}, {
+		name:   "with Via header",
+		header: http.Header{"Via": {"1.1 proxy.example.com"}},
+		expect: true,
+	}, {
+		name:   "with X-Cache hit",
+		header: http.Header{"X-Cache": {"Hit from cloudfront"}},
+		expect: true,
+	}, {
+		name:   "with X-Cache miss",
+		header: http.Header{"X-Cache": {"MISS"}},
+		expect: false,
+	}, {
+		name:   "with X-Cache-Status hit",
+		header: http.Header{"X-Cache-Status": {"HIT"}},
+		expect: true,
+	}, {
+		name:   "with matching token",
+		header: http.Header{spec.CacheBustingHeader: {"abc"}},
+		token:  "abc",
+		expect: false,
+	}, {
+		name:   "with different token",
+		header: http.Header{spec.CacheBustingHeader: {"def"}},
+		token:  "abc",
+		expect: true,
+	}, {
+		name:          "with comparable latency",
+		header:        http.Header{},
+		ttfb:          40 * time.Millisecond,
+		negotiateTTFB: 50 * time.Millisecond,
+		expect:        false,
+	}, {
+		name:          "with much lower latency",
+		header:        http.Header{},
+		ttfb:          10 * time.Millisecond,
+		negotiateTTFB: 50 * time.Millisecond,
+		expect:        true,
+	}, {
+		name:   "without negotiate latency",
+		header: http.Header{},
+		ttfb:   10 * time.Millisecond,
+		expect: false,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := New(softwareName, softwareVersion)
+			client.negotiateTTFB = tc.negotiateTTFB
+			resp := &http.Response{Header: tc.header}
+			if client.suspectCached(resp, tc.token, tc.ttfb) != tc.expect {
+				t.Fatal("unexpected result")
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	locatev2 "github.com/m-lab/locate/api/v2"
+	"github.com/neubot/dash/model"
+)
+
+// These are the files we persist inside the CacheDir.
+const (
+	cacheDirLastResultFile = "last-result.json"
+	cacheDirLastRunFile    = "last-run.json"
+	cacheDirLastServerFile = "last-server.json"
+	cacheDirLocateFile     = "locate.json"
+)
+
+// cacheDirLocateTTL is the time to live of the persisted locate response,
+// whose URLs contain access tokens with a limited lifetime.
+const cacheDirLocateTTL = 2 * time.Minute
+
+// errNoLastServer indicates that there is no usable last server.
+var errNoLastServer = errors.New("no usable last server")
+
+// cachedLocate is the locate response persisted inside the CacheDir.
+type cachedLocate struct {
+	// Stamp is when we received the response.
+	Stamp time.Time `json:"stamp"`
+
+	// Targets contains the targets returned by locate.
+	Targets []locatev2.Target `json:"targets"`
+}
+
+// LastServer describes the server used by the last run.
+type LastServer struct {
+	// Stamp is when we used the server.
+	Stamp time.Time `json:"stamp"`
+
+	// URL is the negotiate URL of the server.
+	URL string `json:"url"`
+}
+
+// LastRun contains the summary of the last run.
+type LastRun struct {
+	// Failure describes why the run failed or is nil on success.
+	Failure *model.FailureReport `json:"failure,omitempty"`
+
+	// Metadata contains metadata about the run.
+	Metadata *FinalMetadata `json:"metadata"`
+
+	// Summary summarizes the results of the run.
+	Summary *FinalSummary `json:"summary"`
+}
+
+// ReadLastRun reads the summary of the last run from the given cache
+// directory (see the CacheDir field of [*Client]), which allows to take
+// scheduling decisions (e.g., to back off after failures).
+func ReadLastRun(cacheDir string) (*LastRun, error) {
+	var lastRun LastRun
+	if err := readCacheFile(cacheDir, cacheDirLastRunFile, &lastRun); err != nil {
+		return nil, err
+	}
+	return &lastRun, nil
+}
+
+// ReadLastServer reads the server used by the last run from the given
+// cache directory (see the CacheDir field of [*Client]).
+func ReadLastServer(cacheDir string) (*LastServer, error) {
+	var lastServer LastServer
+	if err := readCacheFile(cacheDir, cacheDirLastServerFile, &lastServer); err != nil {
+		return nil, err
+	}
+	return &lastServer, nil
+}
+
+// readCacheFile reads and parses the given file of the cache directory.
+func readCacheFile(cacheDir, name string, v any) error {
+	data, err := os.ReadFile(filepath.Join(cacheDir, name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeCache atomically writes the given file of the CacheDir, if
+// configured, and returns whether it succeeded. We log errors because
+// the cache is just an optimization.
+func (c *Client) writeCache(name string, v any) bool {
+	if c.CacheDir == "" {
+		return false
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		c.Logger.Warnf("dash: cannot marshal %s: %s", name, err.Error())
+		return false
+	}
+	if err := os.MkdirAll(c.CacheDir, 0700); err != nil {
+		c.Logger.Warnf("dash: cannot create the cache directory: %s", err.Error())
+		return false
+	}
+	filename := filepath.Join(c.CacheDir, name)
+	if err := os.WriteFile(filename+".tmp", data, 0600); err != nil {
+		c.Logger.Warnf("dash: cannot write %s: %s", name, err.Error())
+		return false
+	}
+	if err := os.Rename(filename+".tmp", filename); err != nil {
+		c.Logger.Warnf("dash: cannot rename %s: %s", name, err.Error())
+		return false
+	}
+	return true
+}
+
+// cachedTargets returns the targets of the locate response persisted
+// inside the CacheDir, if configured, when they have not expired yet.
+func (c *Client) cachedTargets() ([]locatev2.Target, bool) {
+	if c.CacheDir == "" {
+		return nil, false
+	}
+	var cached cachedLocate
+	if err := readCacheFile(c.CacheDir, cacheDirLocateFile, &cached); err != nil {
+		return nil, false
+	}
+	if len(cached.Targets) <= 0 || time.Since(cached.Stamp) >= cacheDirLocateTTL {
+		return nil, false
+	}
+	return cached.Targets, true
+}
+
+// lastServerURL returns the negotiate URL of the server used by the last
+// run, which we read from the CacheDir, without the query string, which
+// contains the locate access token, because by now it has likely expired.
+func (c *Client) lastServerURL() (*url.URL, error) {
+	if c.CacheDir == "" {
+		return nil, errNoLastServer
+	}
+	lastServer, err := ReadLastServer(c.CacheDir)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := url.Parse(lastServer.URL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Host == "" {
+		return nil, errNoLastServer
+	}
+	parsed.RawQuery = ""
+	return parsed, nil
+}
+
+// saveLastRun saves the summary and the results of the run inside the CacheDir.
+func (c *Client) saveLastRun() {
+	if c.CacheDir == "" {
+		return
+	}
+	final := c.FinalResult()
+	c.writeCache(cacheDirLastRunFile, &LastRun{
+		Failure:  final.Failure,
+		Metadata: final.Metadata,
+		Summary:  final.Summary,
+	})
+	c.writeCache(cacheDirLastResultFile, final)
+}
+
+// complete calls the CompletionHook, if any, with the FinalResult.
+func (c *Client) complete() {
+	if c.CompletionHook != nil {
+		c.CompletionHook(c.FinalResult())
+	}
+}
@@ -0,0 +1,194 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestClientCompletionHook(t *testing.T) {
+	t.Run("when StartDownload fails without cache dir", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.deps.Locator = &failingLocator{}
+		var got *FinalResult
+		client.CompletionHook = func(result *FinalResult) {
+			got = result
+		}
+		if _, err := client.StartDownload(context.Background()); err == nil {
+			t.Fatal("Expected an error here")
+		}
+		if got == nil || got.Failure == nil || got.Failure.Phase != "locate" {
+			t.Fatalf("unexpected final result: %+v", got)
+		}
+	})
+
+	t.Run("after the loop closes the channel", func(t *testing.T) {
+		srvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(500)
+		}))
+		defer srvr.Close()
+		URL, err := url.Parse(srvr.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := New(softwareName, softwareVersion)
+		ch := make(chan model.ClientResults)
+		var got *FinalResult
+		var closed bool
+		client.CompletionHook = func(result *FinalResult) {
+			_, open := <-ch
+			got, closed = result, !open
+		}
+		client.transferLoop(context.Background(), ch, URL, &transfer{phase: phaseDownload, segment: client.downloadSegment})
+		if !closed {
+			t.Fatal("expected the channel to be closed before calling the hook")
+		}
+		if got == nil || got.Failure == nil || got.Failure.Phase != phaseNegotiate {
+			t.Fatalf("unexpected final result: %+v", got)
+		}
+	})
+}
+
+func TestClientCacheDir(t *testing.T) {
+	t.Run("saves and reuses the last server", func(t *testing.T) {
+		dir := t.TempDir()
+		client := New(softwareName, softwareVersion)
+		client.CacheDir = dir
+		client.deps.Locator = &countingLocator{}
+		client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+			return model.NegotiateResponse{}, nil
+		}
+		client.deps.Loop = func(ctx context.Context, ch chan<- model.ClientResults, negotiateURL *url.URL) {
+			client.negotiateTargets(ctx, negotiateURL)
+			close(ch)
+		}
+		ch, err := client.StartDownload(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range ch {
+			// drain channel
+		}
+		lastServer, err := ReadLastServer(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lastServer.URL != "https://mlab1.example.com/negotiate/dash" {
+			t.Fatal("unexpected last server", lastServer.URL)
+		}
+
+		locator := &countingLocator{}
+		client = New(softwareName, softwareVersion)
+		client.CacheDir = dir
+		client.UseLastServer = true
+		client.deps.Locator = locator
+		var gotURL string
+		client.deps.Loop = func(ctx context.Context, ch chan<- model.ClientResults, negotiateURL *url.URL) {
+			gotURL = negotiateURL.String()
+			close(ch)
+		}
+		ch, err = client.StartDownload(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range ch {
+			// drain channel
+		}
+		if gotURL != "https://mlab1.example.com/negotiate/dash" {
+			t.Fatal("unexpected URL", gotURL)
+		}
+		if locator.count != 0 {
+			t.Fatal("expected not to query locate", locator.count)
+		}
+	})
+
+	t.Run("strips the query from the last server", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.CacheDir = t.TempDir()
+		client.writeCache(cacheDirLastServerFile, &LastServer{
+			URL: "https://mlab1.example.com/negotiate/dash?access_token=abc",
+		})
+		URL, err := client.lastServerURL()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if URL.String() != "https://mlab1.example.com/negotiate/dash" {
+			t.Fatal("unexpected URL", URL.String())
+		}
+	})
+
+	t.Run("persists the locate response", func(t *testing.T) {
+		dir := t.TempDir()
+		locator := &countingLocator{}
+		for idx := 0; idx < 3; idx++ {
+			client := New(softwareName, softwareVersion)
+			client.CacheDir = dir
+			client.deps.Locator = locator
+			URL, err := client.locate(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if URL.Host != "mlab1.example.com" {
+				t.Fatal("unexpected host", URL.Host)
+			}
+		}
+		if locator.count != 1 {
+			t.Fatal("expected to query locate once", locator.count)
+		}
+	})
+
+	t.Run("saves the last run on failure", func(t *testing.T) {
+		dir := t.TempDir()
+		client := New(softwareName, softwareVersion)
+		client.CacheDir = dir
+		client.deps.Locator = &failingLocator{}
+		if _, err := client.StartDownload(context.Background()); err == nil {
+			t.Fatal("Expected an error here")
+		}
+		lastRun, err := ReadLastRun(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lastRun.Failure == nil || lastRun.Failure.Phase != "locate" {
+			t.Fatal("unexpected failure", lastRun.Failure)
+		}
+	})
+
+	t.Run("saves the final result", func(t *testing.T) {
+		dir := t.TempDir()
+		client := New(softwareName, softwareVersion)
+		client.CacheDir = dir
+		client.deps.Locator = &failingLocator{}
+		if _, err := client.StartDownload(context.Background()); err == nil {
+			t.Fatal("Expected an error here")
+		}
+		data, err := os.ReadFile(filepath.Join(dir, cacheDirLastResultFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var final FinalResult
+		if err := json.Unmarshal(data, &final); err != nil {
+			t.Fatal(err)
+		}
+		if final.Failure == nil || final.Failure.Phase != "locate" {
+			t.Fatal("unexpected failure", final.Failure)
+		}
+	})
+
+	t.Run("without cache dir", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		if _, err := client.lastServerURL(); err != errNoLastServer {
+			t.Fatal("not the error we expected", err)
+		}
+		if _, found := client.cachedTargets(); found {
+			t.Fatal("expected no targets")
+		}
+	})
+}
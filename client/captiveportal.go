@@ -0,0 +1,65 @@
+package client
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/neubot/dash/model"
+)
+
+// ErrCaptivePortal is returned when a captive portal intercepts the requests,
+// in which case measuring makes no sense until the user logs in.
+var ErrCaptivePortal = errors.New("captive portal detected")
+
+// captivePortalError is the error returned when we detect a captive
+// portal. It matches ErrCaptivePortal.
+type captivePortalError struct {
+	// Evidence is the evidence of the captive portal.
+	Evidence *model.CaptivePortalEvidence
+}
+
+// Error implements error.
+func (err *captivePortalError) Error() string {
+	return ErrCaptivePortal.Error()
+}
+
+// Is allows errors.Is to match ErrCaptivePortal.
+func (err *captivePortalError) Is(target error) bool {
+	return target == ErrCaptivePortal
+}
+
+// detectCaptivePortal returns a non-nil error when the response to the given
+// request looks like it comes from a captive portal rather than from the
+// server, i.e., when it is a redirect to another host, when we followed a
+// redirect to another host, or when the body is HTML, which the server never
+// sends. Without this check we would measure the speed of downloading the
+// login page. See detectRedirect for the redirects to the same host.
+func detectCaptivePortal(req *http.Request, resp *http.Response) error {
+	evidence := &model.CaptivePortalEvidence{
+		ContentType: resp.Header.Get("Content-Type"),
+		StatusCode:  resp.StatusCode,
+	}
+	mediaType, _, _ := mime.ParseMediaType(evidence.ContentType)
+	switch {
+	case resp.StatusCode >= 300 && resp.StatusCode < 400:
+		if !redirectsElsewhere(req, resp) {
+			return nil // see detectRedirect
+		}
+		evidence.Location = resp.Header.Get("Location")
+	case resp.Request != nil && resp.Request.URL != nil && resp.Request.URL.Host != req.URL.Host:
+		evidence.Location = resp.Request.URL.String()
+	case mediaType == "text/html":
+		// nothing else to record
+	default:
+		return nil
+	}
+	return &captivePortalError{Evidence: evidence}
+}
+
+// redirectsElsewhere returns whether the Location of the given redirect
+// points to a host other than the one of the request.
+func redirectsElsewhere(req *http.Request, resp *http.Response) bool {
+	location, err := req.URL.Parse(resp.Header.Get("Location"))
+	return err == nil && location.Host != req.URL.Host
+}
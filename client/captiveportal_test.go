@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestDetectCaptivePortal(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://mlab1.example.com/dash/download", nil)
+	for _, tc := range []struct {
+		name           string
+		resp           *http.Response
+		expectPortal   bool
+		expectLocation string
+	}{{
+		name: "with segment",
+		resp: &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{"Content-Type": {"video/mp4"}},
+			Request:    req,
+		},
+	}, {
+		name: "with redirect",
+		resp: &http.Response{
+			StatusCode: 302,
+			Header:     http.Header{"Location": {"http://portal.example.com/login"}},
+			Request:    req,
+		},
+		expectPortal:   true,
+		expectLocation: "http://portal.example.com/login",
+	}, {
+		name: "with followed redirect",
+		resp: &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{},
+			Request:    httptest.NewRequest("GET", "http://portal.example.com/login", nil),
+		},
+		expectPortal:   true,
+		expectLocation: "http://portal.example.com/login",
+	}, {
+		name: "with HTML body",
+		resp: &http.Response{
+			StatusCode: 200,
+			Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+			Request:    req,
+		},
+		expectPortal: true,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := detectCaptivePortal(req, tc.resp)
+			if !tc.expectPortal {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, ErrCaptivePortal) {
+				t.Fatal("not the error we expected", err)
+			}
+			var portalErr *captivePortalError
+			if !errors.As(err, &portalErr) {
+				t.Fatal("expected a captivePortalError")
+			}
+			if portalErr.Evidence.StatusCode != tc.resp.StatusCode {
+				t.Fatal("unexpected status code", portalErr.Evidence.StatusCode)
+			}
+			if portalErr.Evidence.Location != tc.expectLocation {
+				t.Fatal("unexpected location", portalErr.Evidence.Location)
+			}
+		})
+	}
+}
+
+func TestClientDownloadCaptivePortal(t *testing.T) {
+	srvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html>Please login</html>"))
+	}))
+	defer srvr.Close()
+	URL, err := url.Parse(srvr.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(softwareName, softwareVersion)
+	client.HTTPClient = &http.Client{Transport: &http.Transport{}}
+	current := &model.ClientResults{Rate: 100, ElapsedTarget: 2}
+	err = client.download(context.Background(), "abc", current, URL)
+	if !errors.Is(err, ErrCaptivePortal) {
+		t.Fatal("not the error we expected", err)
+	}
+	client.fail(phaseDownload, URL, err)
+	report := client.FailureReport()
+	if report.CaptivePortal == nil || report.CaptivePortal.ContentType != "text/html" {
+		t.Fatalf("unexpected evidence: %+v", report.CaptivePortal)
+	}
+}
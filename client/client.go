@@ -4,13 +4,18 @@ package client
 import (
 	"bytes"
 	"context"
+	cryptorand "crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
 	"runtime"
+	"syscall"
 	"time"
 
 	"github.com/m-lab/locate/api/locate"
@@ -29,6 +34,23 @@ const (
 	// libraryVersion is the version of this library.
 	libraryVersion = "0.4.3"
 
+	// defaultCollectDelay is the default initial delay before
+	// retrying collect after a transient failure.
+	defaultCollectDelay = time.Second
+
+	// defaultCollectRetries is the default maximum number of times
+	// we retry collect after transient failures.
+	defaultCollectRetries = 3
+
+	// defaultStreamDuration is the default duration of the emulated
+	// stream when using the stream emulation mode.
+	defaultStreamDuration = 60 * time.Second
+
+	// maxRenegotiations is the maximum number of times we renegotiate
+	// during a test (see Renegotiate), which bounds the time we spend
+	// negotiating and collecting (see DefaultTimeout).
+	maxRenegotiations = 3
+
 	// magicVersion is a magic number that identifies in a unique
 	// way this implementation of DASH. 0.007xxxyyy is Measurement
 	// Kit. Values lower than that are Neubot.
@@ -41,6 +63,25 @@ var (
 
 	// errHTTPRequestFailed is returned when an HTTP request fails.
 	errHTTPRequestFailed = errors.New("HTTP request failed")
+
+	// errNoValidFallbackServer is returned when locate failed and
+	// none of the configured fallback servers is valid.
+	errNoValidFallbackServer = errors.New("no valid fallback server")
+
+	// errInvalidBaseURL is returned when the server returns an
+	// invalid base URL for download and collect.
+	errInvalidBaseURL = errors.New("invalid base URL")
+
+	// errInvalidDSCP is returned when the DSCP value is out of range.
+	errInvalidDSCP = errors.New("DSCP value must be between 0 and 63")
+
+	// errDSCPNotSupported is returned when we cannot set the DSCP value
+	// either because of the platform or because of a custom transport.
+	errDSCPNotSupported = errors.New("setting DSCP is not supported")
+
+	// errCPUTimesNotSupported is returned when we cannot obtain the
+	// CPU times of the current process on this platform.
+	errCPUTimesNotSupported = errors.New("obtaining CPU times is not supported")
 )
 
 // locator is an interface used to locate a server.
@@ -65,9 +106,16 @@ type dependencies struct {
 	// HTTPNewRequest allows to override calling [http.NewRequest].
 	HTTPNewRequest func(method, url string, body io.Reader) (*http.Request, error)
 
+	// IOCopy allows to override calling [io.Copy].
+	IOCopy func(dst io.Writer, src io.Reader) (int64, error)
+
 	// IOReadAll allows to override calling [io.ReadAll].
 	IOReadAll func(r io.Reader) ([]byte, error)
 
+	// InterfaceByLocalIP allows to override finding the interface
+	// having the local address of the measurement connection.
+	InterfaceByLocalIP func(ip net.IP) (string, error)
+
 	// JSONUnmarshal allows to override calling [json.Unmarshal].
 	JSONMarshal func(v interface{}) ([]byte, error)
 
@@ -79,11 +127,38 @@ type dependencies struct {
 
 	// Negotiate allows to override the method performing the negotiate phase.
 	Negotiate func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error)
+
+	// ProcessCPUTimes allows to override obtaining the process CPU times.
+	ProcessCPUTimes func() (user, sys time.Duration, err error)
+
+	// RandShuffle allows to override calling [rand.Shuffle].
+	RandShuffle func(n int, swap func(i, j int))
+
+	// Upload allows to override the method performing the upload phase.
+	Upload func(
+		ctx context.Context, authorization string,
+		current *model.ClientResults,
+		negotiateURL *url.URL) error
 }
 
 // Client is a DASH client. The zero value of this structure is
 // invalid. Use NewClient to correctly initialize the fields.
 type Client struct {
+	// AcceptEncoding is the optional Accept-Encoding header value (e.g.,
+	// "gzip, deflate") to advertise when requesting segments. Because the
+	// server never compresses the random payload, this is useful to detect
+	// intermediaries that compress or recode responses, whose evidence we
+	// record in the results. By default NewClient sets this field to an
+	// empty string, meaning that we use the Go standard library default.
+	AcceptEncoding string
+
+	// Adapter is the adaptation (ABR) algorithm selecting the rate of the
+	// next segment (see NewAdapter for the built-in ones). We do not use
+	// it when emulating a stream (see StreamRate). Otherwise, the test
+	// fails when this field is nil. By default NewClient sets this field
+	// to a ThroughputAdapter.
+	Adapter Adapter
+
 	// ClientName is the name of the client application. This field is
 	// initialized by the NewClient constructor.
 	ClientName string
@@ -92,40 +167,341 @@ type Client struct {
 	// initialized by the NewClient constructor.
 	ClientVersion string
 
+	// CacheBusting indicates whether to add a random token to each download
+	// request (see spec.CacheBustingQuery), so that transparent caches cannot
+	// serve segments and inflate the measured rate. When the server echoes a
+	// different token, we set the SuspectCached field of the results. By
+	// default NewClient sets this field to false.
+	CacheBusting bool
+
+	// CacheDir is the optional directory where we persist the locate
+	// response, the server we used, and the summary of the run, such
+	// that later runs can reuse them (see UseLastServer and ReadLastRun),
+	// as well as the [FinalResult] of the run. By
+	// default NewClient sets this field to empty, meaning that we do
+	// not persist anything.
+	CacheDir string
+
+	// CollectRetries is the maximum number of times we retry collecting
+	// after transient failures (i.e., network errors and 5xx responses).
+	// Retrying is safe because the server uses the session authorization
+	// as the idempotency key and responds again with the same results to
+	// repeated collect requests. This field is initialized by NewClient
+	// to a reasonable default value.
+	CollectRetries int
+
+	// CompletionHook is the optional function we call at the end of each
+	// run with the [FinalResult] of the run, which allows to upload the
+	// results or to raise alerts. We call it after closing the channel
+	// returned by StartDownload, on the goroutine that closed it, hence it
+	// may run concurrently with the code draining the channel, or before
+	// StartDownload returns an error. By default NewClient sets this
+	// field to nil.
+	CompletionHook func(result *FinalResult)
+
+	// CorrectClockSkew causes [*Client.FinalResult] to shift the timestamps
+	// of the server results by the estimated clock offset between the client
+	// and the server (see [ClockSkew]), so they are comparable with the client
+	// timestamps. By default NewClient sets this field to false, meaning
+	// that we only report the estimated offset in the summary.
+	CorrectClockSkew bool
+
+	// DSCP is the Differentiated Services Code Point (between 0 and 63)
+	// used to mark the measurement connections. When nonzero, we wrap the
+	// transport of the HTTPClient to set the DSCP on new connections and we
+	// record the marking in the client results. This only works when the
+	// HTTPClient uses an [*http.Transport] and DialContext is nil. By default
+	// NewClient initializes this field to zero, meaning that we do not mark
+	// connections.
+	DSCP int
+
+	// DialContext is the optional function to establish TCP connections
+	// (e.g., for using a circumvention transport). When not nil, we use a
+	// copy of the transport of the HTTPClient (or of Transport) using this
+	// function. This only works with an [*http.Transport]. Because we measure
+	// timings using [httptrace.ClientTrace] hooks, we still record accurate
+	// timings, except for DNS lookups performed by this function. By default
+	// NewClient sets this field to nil.
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+
+	// DialTLSContext is like DialContext but for establishing TLS connections
+	// (e.g., using a TLS library that randomizes the fingerprint). By default
+	// NewClient sets this field to nil.
+	DialTLSContext func(ctx context.Context, network, address string) (net.Conn, error)
+
 	// FQDN is the server of the server to use. If the FQDN is not
 	// specified, we use m-lab/locate/v2 to discover a server.
 	FQDN string
 
+	// FallbackServers contains the URLs of the servers to use, in random
+	// order, when m-lab/locate/v2 fails or times out. Each URL should
+	// be like "https://dash.example.com", in which case we use the default
+	// negotiate path, or contain the full negotiate URL. This field is
+	// initialized by the NewClient constructor to an empty list.
+	FallbackServers []string
+
 	// HTTPClient is the HTTP client used by this implementation. This field
 	// is initialized by the NewClient to http.DefaultClient.
 	HTTPClient *http.Client
 
+	// Iterations is the number of segments we download, which allows to
+	// run long-form tests or short smoke tests. It must be between one and
+	// MaxIterations. When the server advertises a lower maximum number of
+	// iterations for the session, we run that many iterations. We ignore
+	// this field when emulating a stream (see StreamRate). By default
+	// NewClient sets this field to DefaultIterations.
+	Iterations int64
+
+	// LocateCache is the optional cache for m-lab/locate/v2 targets. When
+	// nil, which is the default, we query locate on every run.
+	LocateCache *LocateCache
+
+	// LocateTargets is the maximum number of servers returned by
+	// m-lab/locate/v2 we try, in order, when negotiating fails, which
+	// allows to complete the run when a server is down. This field is
+	// initialized by NewClient to one, meaning that we only try the
+	// nearest server. See also TargetBudget.
+	LocateTargets int
+
 	// Logger is the logger to use. This field is initialized by the
 	// NewClient constructor to a do-nothing logger.
 	Logger model.Logger
 
+	// PinConnection indicates whether to use a single connection for the
+	// whole session (negotiate, all downloads, and collect), so that the
+	// server's per-connection stats (e.g., TCPInfo) correspond exactly to the
+	// measured traffic. In this mode, we use a separate connection pool and
+	// we fail when a request does not reuse the connection, while the server
+	// rejects requests using other connections. This only works when the
+	// HTTPClient uses an [*http.Transport]. By default NewClient sets this
+	// field to false.
+	PinConnection bool
+
+	// PersistProbeID enables the opt-in mode where we persist the probe ID
+	// included in the [FinalMetadata] inside the CacheDir and reuse it for
+	// all the runs, which allows the longitudinal analysis of the results
+	// of consenting users. By default NewClient sets this field to false,
+	// meaning that we use a new random probe ID for each run, so that one
+	// cannot link the results of different runs using the probe ID.
+	PersistProbeID bool
+
+	// Renegotiate enables renegotiating when the server refuses to serve
+	// more segments as part of the current session with 429, which happens
+	// when the session has downloaded too many segments or bytes. In such a
+	// case, we collect the results of the current session, negotiate a new
+	// session, step the rate down, and continue the test, so long tests can
+	// transparently span several server sessions. We only renegotiate when
+	// the current session downloaded at least one segment, to avoid looping
+	// with servers that refuse any segment, and at most three times per test,
+	// such that the test ends within the DefaultTimeout. By default NewClient
+	// sets this field to false, meaning that a 429 response stops the test.
+	Renegotiate bool
+
+	// Rates is the optional ladder of rates (in kbit/s) of the emulated
+	// video, which must contain at most 64 positive rates in strictly
+	// increasing order. When not empty, we send it when negotiating, instead
+	// of spec.DefaultRates, and we clamp the rate of each segment to the
+	// largest rate of the ladder not above the one that the Adapter selected
+	// (or to the lowest rate). We do not clamp the StreamRate. By default
+	// NewClient sets this field to nil, meaning that we do not constrain
+	// the rate of the segments.
+	Rates []int64
+
+	// RequestLimiter is the optional limiter pacing the requests we send to
+	// locate and to the server, which you can share among several clients
+	// (see [RequestLimiter]). By default NewClient sets this field to nil,
+	// meaning that we do not pace requests.
+	RequestLimiter *RequestLimiter
+
+	// Resilient enables the resilient mode. By default, which is what
+	// NewClient configures, we stop the test when a segment download fails.
+	// In resilient mode, instead, we record the failure in the results, step
+	// the rate down, and continue the test, like actual players do.
+	Resilient bool
+
+	// SampleTCPInfo enables sampling the TCP_INFO statistics of the connection
+	// before requesting each segment and after receiving it, which allows to
+	// record the retransmissions and the delivery rate of each segment and
+	// whether loss recovery dominated the download (see model.TCPInfoDelta).
+	// This only works on Linux and with the connections created by an
+	// [*http.Transport]. By default NewClient sets this field to false.
+	SampleTCPInfo bool
+
 	// Scheme is the protocol scheme to use. By default NewClient configures
 	// it to "https", but you can override it to "http".
 	Scheme string
 
+	// SegmentTimeout is the maximum time to download a segment. By default
+	// NewClient sets this field to zero, meaning there is no timeout. This
+	// option is most useful in combination with Resilient.
+	SegmentTimeout time.Duration
+
+	// StreamDuration is the duration of the emulated stream when using the
+	// stream emulation mode (see StreamRate). This field is initialized by
+	// the NewClient constructor to a reasonable default value.
+	StreamDuration time.Duration
+
+	// StreamRate enables the stream emulation mode when positive. In this
+	// mode, rather than adapting the rate to the measured speed, we download
+	// back-to-back segments at this fixed rate (in kbit/s) until we have
+	// downloaded StreamDuration worth of video. Use StreamSustained after
+	// the test to know whether the network sustained the rate. By default
+	// NewClient initializes this field to zero (i.e., disabled).
+	StreamRate int64
+
+	// Streams is the number of parallel streams to request when negotiating.
+	// The server may approve fewer streams, and we record the approved number
+	// in the results. Note that we currently download segments sequentially
+	// regardless of this setting. By default NewClient sets this field to
+	// zero, meaning that we do not request any specific number of streams.
+	Streams int64
+
+	// StrictPrivacy enables the strict privacy mode where we zero the
+	// RealAddress, InternalAddress, and RemoteAddress fields of the results
+	// we emit locally, i.e., the ones posted on the channel returned by
+	// StartDownload and the ones returned by ClientResults. We still submit
+	// the original results to the server, which handles them according to
+	// its own data policy. By default NewClient sets this field to false.
+	StrictPrivacy bool
+
+	// TargetBudget is the maximum time for negotiating with each server
+	// before moving on to the next locate target (see LocateTargets), which,
+	// along with LocateTargets, bounds the time we spend finding a working
+	// server, so the run fits within tight scheduling windows. By default
+	// NewClient sets this field to zero, meaning that there is no budget.
+	TargetBudget time.Duration
+
+	// TimeNow is the function returning the current time, which we use to
+	// measure the download time of segments and the duration of the test.
+	// Overriding it along with Transport allows to run deterministic
+	// simulations (see the clienttest package). By default NewClient sets
+	// this field to [time.Now].
+	TimeNow func() time.Time
+
+	// Transport is the optional transport to use instead of the transport
+	// of the HTTPClient, which allows to replace the transport while keeping
+	// the other HTTPClient settings (e.g., the timeout). By default NewClient
+	// sets this field to nil, meaning that we use the HTTPClient transport.
+	Transport http.RoundTripper
+
+	// UseLastServer indicates whether to reuse the server used by the last
+	// run, which we read from the CacheDir, rather than using locate, when
+	// the FQDN is not set. We fall back to locate when there is no usable
+	// last server. Because the access tokens returned by locate expire, we
+	// reuse the URL without its query string, hence this option is most
+	// useful with servers not requiring access tokens. By default NewClient
+	// sets this field to false.
+	UseLastServer bool
+
+	// UserAgentProfile is the optional name of the video player (e.g.,
+	// "exoplayer"; see UserAgentProfiles) whose User-Agent header we use
+	// for downloading segments, which allows to study whether networks treat
+	// recognized video players differently. We still use our own User-Agent
+	// for negotiating and collecting, so the server knows who we are, and we
+	// record the profile in the client results. By default NewClient sets
+	// this field to empty, meaning that we always use our own User-Agent.
+	UserAgentProfile string
+
+	// alternateTargets contains the negotiate URLs of the locate targets
+	// to try when negotiating with the first one fails.
+	alternateTargets []*url.URL
+
 	// begin is when the test started.
 	begin time.Time
 
 	// clientResults contains results collected by the client.
 	clientResults []model.ClientResults
 
+	// clockSkew is the best estimate of the clock offset between the
+	// client and the server or nil when we do not have any estimate.
+	clockSkew *ClockSkew
+
+	// collectDelay is the initial delay before retrying collect, which
+	// we double after each failure.
+	collectDelay time.Duration
+
 	// deps contains the mockable dependencies.
 	deps dependencies
 
+	// end is when the loop terminated or zero.
+	end time.Time
+
 	// err is the overall error that occurred.
 	err error
 
-	// numIterations is the number of iterations to run.
-	numIterations int64
+	// failureReport describes the failure or is nil on success.
+	failureReport *model.FailureReport
+
+	// negotiateConnect is the time to establish the connection used by
+	// the negotiate request or zero if it reused a connection.
+	negotiateConnect time.Duration
+
+	// negotiateDNS contains the DNS lookup details of the negotiate
+	// request or nil if the negotiate request did not need a lookup.
+	negotiateDNS *model.DNSResults
+
+	// negotiateHappyEyeballs contains the Happy Eyeballs results of
+	// the negotiate request or nil.
+	negotiateHappyEyeballs *model.HappyEyeballsResults
+
+	// negotiateTTFB is the time to first byte of the negotiate request.
+	negotiateTTFB time.Duration
+
+	// network contains the metadata of the interface having networkIP.
+	network *model.NetworkMetadata
+
+	// networkIP is the local IP address of the last connection.
+	networkIP net.IP
+
+	// payloadIteration is the server iteration index of the next segment,
+	// which we need to derive the expected payload.
+	payloadIteration int64
+
+	// payloadSeed is the seed of the payload returned by the server
+	// during the negotiation or nil.
+	payloadSeed []byte
+
+	// pinner tracks the connections used in PinConnection mode.
+	pinner *connPinner
+
+	// probeID is the probe ID of the run (see PersistProbeID).
+	probeID string
+
+	// probeIDPersistent indicates whether we persisted the probeID.
+	probeIDPersistent bool
+
+	// renegotiations is the number of times we renegotiated.
+	renegotiations int
+
+	// runMetadata contains the run metadata (see [WithRunMetadata]).
+	runMetadata map[string]string
+
+	// segmentMaxSize is the maximum segment size returned by the server
+	// during the negotiation or zero.
+	segmentMaxSize int64
+
+	// segmentMinSize is the minimum segment size returned by the server
+	// during the negotiation or zero.
+	segmentMinSize int64
+
+	// server is the negotiate URL of the server we are using.
+	server string
 
 	// serverResults contains the server results.
 	serverResults []model.ServerResults
 
+	// sessionBegin is the index inside clientResults of the first result
+	// of the current server session (see Renegotiate).
+	sessionBegin int
+
+	// streamSustained indicates whether the network sustained the
+	// configured StreamRate in stream emulation mode.
+	streamSustained bool
+
+	// timeout is the time between begin and the deadline of the
+	// context passed to StartDownload, or zero.
+	timeout time.Duration
+
 	// userAgent is the user-agent HTTP header to use.
 	userAgent string
 }
@@ -135,7 +511,49 @@ func makeUserAgent(clientName, clientVersion string) string {
 }
 
 func (c *Client) httpClientDo(req *http.Request) (*http.Response, error) {
-	return c.HTTPClient.Do(req)
+	httpClient := *c.HTTPClient
+	httpClient.CheckRedirect = noRedirects
+	if !c.PinConnection {
+		return httpClient.Do(req)
+	}
+	resp, err := httpClient.Do(req.WithContext(c.pinner.wrap(req.Context())))
+	if err == nil && !c.pinner.pinned() {
+		resp.Body.Close()
+		return nil, errConnectionNotPinned
+	}
+	return resp, err
+}
+
+// newDSCPHTTPClient returns a copy of the HTTPClient whose transport
+// marks the new connections using the configured DSCP value.
+func (c *Client) newDSCPHTTPClient() (*http.Client, error) {
+	if c.DSCP < 0 || c.DSCP > 63 {
+		return nil, errInvalidDSCP
+	}
+	if c.DialContext != nil {
+		return nil, errDSCPNotSupported
+	}
+	roundTripper := c.HTTPClient.Transport
+	if roundTripper == nil {
+		roundTripper = http.DefaultTransport
+	}
+	transport, ok := roundTripper.(*http.Transport)
+	if !ok {
+		return nil, errDSCPNotSupported
+	}
+	dscp := c.DSCP
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, rc syscall.RawConn) error {
+			return setDSCP(network, rc, dscp)
+		},
+	}
+	transport = transport.Clone()
+	transport.DialContext = dialer.DialContext
+	httpClient := *c.HTTPClient
+	httpClient.Transport = transport
+	return &httpClient, nil
 }
 
 // New creates a new Client instance using the specified
@@ -143,30 +561,88 @@ func (c *Client) httpClientDo(req *http.Request) (*http.Response, error) {
 func New(clientName, clientVersion string) (client *Client) {
 	ua := makeUserAgent(clientName, clientVersion)
 	client = &Client{
-		ClientName:    clientName,
-		ClientVersion: clientVersion,
-		FQDN:          "", // user specified and defaults to empty
-		HTTPClient:    http.DefaultClient,
-		Logger:        internal.NoLogger{},
-		Scheme:        "https",
-		begin:         time.Now(),
-		clientResults: []model.ClientResults{},
-		deps:          dependencies{}, // initialized below
-		err:           nil,
-		numIterations: 15,
-		serverResults: []model.ServerResults{},
-		userAgent:     ua,
+		AcceptEncoding:         "",
+		Adapter:                ThroughputAdapter{},
+		CacheBusting:           false,
+		CacheDir:               "",
+		ClientName:             clientName,
+		ClientVersion:          clientVersion,
+		CollectRetries:         defaultCollectRetries,
+		CompletionHook:         nil,
+		CorrectClockSkew:       false,
+		DSCP:                   0,
+		DialContext:            nil,
+		DialTLSContext:         nil,
+		FQDN:                   "", // user specified and defaults to empty
+		FallbackServers:        []string{},
+		HTTPClient:             http.DefaultClient,
+		Iterations:             DefaultIterations,
+		LocateCache:            nil,
+		LocateTargets:          defaultLocateTargets,
+		Logger:                 internal.NoLogger{},
+		PersistProbeID:         false,
+		PinConnection:          false,
+		Rates:                  nil,
+		Renegotiate:            false,
+		RequestLimiter:         nil,
+		Resilient:              false,
+		SampleTCPInfo:          false,
+		Scheme:                 "https",
+		SegmentTimeout:         0,
+		StreamDuration:         defaultStreamDuration,
+		StreamRate:             0,
+		Streams:                0,
+		StrictPrivacy:          false,
+		TargetBudget:           0,
+		TimeNow:                time.Now,
+		Transport:              nil,
+		UseLastServer:          false,
+		UserAgentProfile:       "",
+		alternateTargets:       nil,
+		begin:                  time.Now(),
+		clientResults:          []model.ClientResults{},
+		clockSkew:              nil,
+		collectDelay:           defaultCollectDelay,
+		deps:                   dependencies{}, // initialized below
+		end:                    time.Time{},
+		err:                    nil,
+		failureReport:          nil,
+		negotiateConnect:       0,
+		negotiateDNS:           nil,
+		negotiateHappyEyeballs: nil,
+		negotiateTTFB:          0,
+		network:                nil,
+		networkIP:              nil,
+		payloadIteration:       0,
+		payloadSeed:            nil,
+		pinner:                 &connPinner{},
+		probeID:                "",
+		probeIDPersistent:      false,
+		renegotiations:         0,
+		runMetadata:            nil,
+		segmentMaxSize:         0,
+		segmentMinSize:         0,
+		server:                 "",
+		serverResults:          []model.ServerResults{},
+		sessionBegin:           0,
+		timeout:                0,
+		userAgent:              ua,
 	}
 	client.deps = dependencies{
-		Collect:        client.collect,
-		Download:       client.download,
-		HTTPClientDo:   client.httpClientDo,
-		HTTPNewRequest: http.NewRequest,
-		IOReadAll:      io.ReadAll,
-		JSONMarshal:    json.Marshal,
-		Locator:        locate.NewClient(ua),
-		Loop:           client.loop,
-		Negotiate:      client.negotiate,
+		Collect:            client.collect,
+		Download:           client.download,
+		HTTPClientDo:       client.httpClientDo,
+		HTTPNewRequest:     http.NewRequest,
+		IOCopy:             io.Copy,
+		IOReadAll:          io.ReadAll,
+		InterfaceByLocalIP: interfaceByLocalIP,
+		JSONMarshal:        json.Marshal,
+		Locator:            locate.NewClient(ua),
+		Loop:               client.loop,
+		Negotiate:          client.negotiate,
+		ProcessCPUTimes:    processCPUTimes,
+		RandShuffle:        rand.Shuffle,
+		Upload:             client.upload,
 	}
 	return
 }
@@ -182,9 +658,17 @@ func (c *Client) negotiate(
 	//
 	// TODO(bassosimone): use http.NewRequestWithContext
 	var negotiateResponse model.NegotiateResponse
-	data, err := c.deps.JSONMarshal(model.NegotiateRequest{
-		DASHRates: spec.DefaultRates,
-	})
+	request := model.NegotiateRequest{
+		DASHRates:       c.ladder(),
+		Iterations:      c.plannedIterations(),
+		PinConnection:   c.PinConnection,
+		SegmentDuration: int64(segmentDuration / time.Second),
+		Streams:         c.Streams,
+	}
+	if c.StreamRate > 0 {
+		request.StreamDuration = c.streamDurationSeconds()
+	}
+	data, err := c.deps.JSONMarshal(request)
 	if err != nil {
 		return negotiateResponse, err
 	}
@@ -197,19 +681,37 @@ func (c *Client) negotiate(
 	req.Header.Set("User-Agent", c.userAgent)
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "")
-	req = req.WithContext(ctx)
+	tracer, ttfb, connect := &dnsTracer{}, &ttfbTracer{}, &connectTracer{}
+	eyeballs := &happyEyeballsTracer{}
+	req = req.WithContext(eyeballs.wrap(connect.wrap(ttfb.wrap(tracer.wrap(ctx)))))
 
 	// 2. send the request and receive the response headers
+	if err := c.pace(ctx); err != nil {
+		return negotiateResponse, err
+	}
+	sent := time.Now()
 	resp, err := c.deps.HTTPClientDo(req)
 	if err != nil {
 		return negotiateResponse, err
 	}
 	defer resp.Body.Close()
-
-	// 3. handle the case where the status code indicates failure
+	received := time.Now()
+	c.negotiateConnect = connect.get()
+	c.negotiateDNS = tracer.get()
+	c.negotiateHappyEyeballs = happyEyeballs(c.negotiateDNS, eyeballs)
+	c.negotiateTTFB = ttfb.get()
+
+	// 3. handle the case where the status code indicates failure, after
+	// making sure that a captive portal did not intercept the request
 	c.Logger.Debugf("dash: StatusCode: %d", resp.StatusCode)
+	if err := detectCaptivePortal(req, resp); err != nil {
+		return negotiateResponse, err
+	}
+	if err := detectRedirect(resp); err != nil {
+		return negotiateResponse, err
+	}
 	if resp.StatusCode != 200 {
-		return negotiateResponse, errHTTPRequestFailed
+		return negotiateResponse, &httpStatusError{StatusCode: resp.StatusCode}
 	}
 
 	// 4. read the raw response body
@@ -231,6 +733,7 @@ func (c *Client) negotiate(
 	if err != nil {
 		return negotiateResponse, err
 	}
+	c.observeClock(sent, received, resp, negotiateResponse.ServerTime)
 
 	// 6. make sure that the server isn't busy
 	//
@@ -254,6 +757,13 @@ func makeDownloadURL(negotiateURL *url.URL, path string) *url.URL {
 	}
 }
 
+// makeCacheBustingToken returns a random cache busting token.
+func makeCacheBustingToken() string {
+	data := make([]byte, 8)
+	_, _ = cryptorand.Read(data) // never fails on supported platforms
+	return hex.EncodeToString(data)
+}
+
 // download implements the DASH test proper. We compute the number of bytes
 // to request given the current rate, download the fake DASH segment, and
 // then we return the measured performance of this segment to the caller. This
@@ -267,56 +777,167 @@ func (c *Client) download(
 	// 1. create the HTTP request
 	//
 	// TODO(bassosimone): use http.NewRequestWithContext
-	nbytes := (current.Rate * 1000 * current.ElapsedTarget) >> 3
+	nbytes := c.segmentSize(current)
 	URL := makeDownloadURL(negotiateURL, fmt.Sprintf("%s%d", spec.DownloadPath, nbytes))
+	var token string
+	if c.CacheBusting {
+		token = makeCacheBustingToken()
+		URL.RawQuery = url.Values{spec.CacheBustingQuery: {token}}.Encode()
+	}
 	req, err := c.deps.HTTPNewRequest("GET", URL.String(), nil)
 	if err != nil {
 		return err
 	}
 	c.Logger.Debugf("dash: GET %s", URL.String())
 	current.ServerURL = URL.String()
-	req.Header.Set("User-Agent", c.userAgent)
+	ua, err := c.segmentUserAgent()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", ua)
 	req.Header.Set("Authorization", authorization)
-	req = req.WithContext(ctx)
-	savedTicks := time.Now()
+	if c.AcceptEncoding != "" {
+		// Note: setting Accept-Encoding explicitly disables the transparent
+		// decompression performed by [*http.Transport], so we see the body
+		// exactly as it has been received from the network.
+		req.Header.Set("Accept-Encoding", c.AcceptEncoding)
+	}
+	tracer, ttfb := &dnsTracer{}, &ttfbTracer{}
+	conn, connect, eyeballs := &connTracer{}, &connectTracer{}, &happyEyeballsTracer{}
+	var sampler *tcpInfoTracer
+	if c.SampleTCPInfo {
+		sampler = &tcpInfoTracer{}
+		conn.onConn = sampler.gotConn
+	}
+	req = req.WithContext(eyeballs.wrap(connect.wrap(conn.wrap(ttfb.wrap(tracer.wrap(ctx))))))
+	if err := c.pace(ctx); err != nil {
+		return err
+	}
+	savedUser, savedSys, cpuErr := c.deps.ProcessCPUTimes()
+	if cpuErr != nil {
+		c.Logger.Debugf("dash: cannot obtain CPU times: %s", cpuErr.Error())
+	}
+	savedTicks := c.TimeNow()
 
 	// 2. send the request and receive the response headers
+	//
+	// Because we typically reuse the connection used for negotiating, there
+	// usually is no DNS lookup or connect here, so we report the negotiate
+	// lookup and connect as part of the first iteration's results when that
+	// is the case. We record the address of the server for each iteration
+	// because the connection may change across iterations.
 	resp, err := c.deps.HTTPClientDo(req)
+	current.RemoteAddress = conn.remoteAddress()
+	current.DNS = tracer.get()
+	if current.DNS == nil && current.Iteration == 0 {
+		current.DNS = c.negotiateDNS
+	}
+	current.HappyEyeballs = happyEyeballs(tracer.get(), eyeballs)
+	if current.HappyEyeballs == nil && current.Iteration == 0 {
+		current.HappyEyeballs = c.negotiateHappyEyeballs
+	}
+	current.ConnectTime = connect.get().Seconds()
+	if current.ConnectTime == 0 && current.Iteration == 0 {
+		current.ConnectTime = c.negotiateConnect.Seconds()
+	}
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	current.Network = c.networkMetadata(conn.localAddr())
 
-	// 3. handle the case where the status code indicates failure
+	// 3. handle the case where the status code indicates failure, after
+	// making sure that a captive portal did not intercept the request
 	c.Logger.Debugf("dash: StatusCode: %d", resp.StatusCode)
+	if err := detectCaptivePortal(req, resp); err != nil {
+		return err
+	}
+	if err := detectRedirect(resp); err != nil {
+		return err
+	}
 	if resp.StatusCode != 200 {
-		return errHTTPRequestFailed
+		return &httpStatusError{StatusCode: resp.StatusCode}
 	}
 
-	// 4. read the raw response body
-	//
-	// TODO(bassosimone):
-	//
-	// a) protect against arbitrarily large bodies
+	// 3.1. record evidence of intermediaries recoding the payload: because
+	// the server never compresses the random payload, any Content-Encoding
+	// means that a transparent proxy has modified the response
+	current.ContentEncoding = resp.Header.Get("Content-Encoding")
+	current.Via = resp.Header.Get("Via")
+	if current.ContentEncoding != "" {
+		c.Logger.Warnf("dash: payload recoded by an intermediary: %s", current.ContentEncoding)
+	}
+
+	// 3.2. record evidence of intermediary caches serving the segment
+	current.SuspectCached = c.suspectCached(resp, token, ttfb.get())
+	if current.SuspectCached {
+		c.Logger.Warn("dash: segment possibly served by an intermediary cache")
+	}
+
+	// 3.3. when the server derives the payload from a seed, verify it while
+	// reading, noting that the server counts an iteration for each segment
+	var (
+		body     io.Reader = resp.Body
+		verifier *payloadVerifier
+	)
+	if c.payloadSeed != nil {
+		verifier = newPayloadVerifier(resp.Body, c.payloadSeed, c.payloadIteration)
+		body = verifier
+		c.payloadIteration++
+	}
+
+	// 4. read and discard the raw response body, which uses constant
+	// memory regardless of the segment size
 	//
-	// b) make sure the context can still interrupt a client otherwise
-	// with some amount of interference, we'll block here forever
-	data, err := c.deps.IOReadAll(resp.Body)
+	// TODO(bassosimone): make sure the context can still interrupt a client
+	// otherwise with some amount of interference, we'll block here forever
+	received, err := c.deps.IOCopy(io.Discard, body)
 	if err != nil {
 		return err
 	}
+	if verifier != nil {
+		current.PayloadCheck = verifier.result()
+		if current.PayloadCheck != payloadCheckOK {
+			c.Logger.Warn("dash: the payload differs from the expected one")
+		}
+	}
 
 	// 5. compute performance metrics and update current
 	//
 	// Implementation note: MK contains a comment that says that Neubot uses
 	// the elapsed time since when we start receiving the response but it
-	// turns out that Neubot and MK do the same. So, we do what they do. At
-	// the same time, we are currently not able to include the overhead that
-	// is caused by HTTP headers etc. So, we're a bit less precise.
-	current.Elapsed = time.Since(savedTicks).Seconds()
-	current.Received = int64(len(data))
+	// turns out that Neubot and MK do the same. So, we do what they do. The
+	// Received field only counts the body bytes (i.e., the goodput), hence
+	// we also record an estimate including the HTTP and TLS overhead. Since
+	// the server may send a different number of bytes than the requested one,
+	// we record the difference and we always compute rates using Received.
+	current.Elapsed = c.TimeNow().Sub(savedTicks).Seconds()
+	current.Received = received
+	current.SizeDelta = current.Received - nbytes
+	current.Clamped = current.SizeDelta != 0
+	if current.Clamped {
+		c.Logger.Debugf("dash: requested %d bytes but received %d bytes", nbytes, current.Received)
+	}
+	current.WireBytes = estimateWireBytes(resp, current.Received)
+	current.ServerQueueDelay, current.ServerSendDuration = serverTiming(resp)
+	current.TCPInfo = nil
+	if sampler != nil {
+		current.TCPInfo = sampler.delta()
+		if current.TCPInfo != nil && current.TCPInfo.LossLimited {
+			c.Logger.Warn("dash: loss recovery dominated the segment download")
+		}
+	}
 	current.RequestTicks = savedTicks.Sub(c.begin).Seconds()
-	current.Timestamp = time.Now().Unix()
+	current.Timestamp = c.TimeNow().Unix()
+
+	// 6. record the CPU time we consumed, so that one can discard the
+	// measurements where the client device was CPU bound
+	if cpuErr == nil {
+		if user, sys, err := c.deps.ProcessCPUTimes(); err == nil {
+			current.DeltaUserTime = (user - savedUser).Seconds()
+			current.DeltaSysTime = (sys - savedSys).Seconds()
+		}
+	}
 
 	//c.Logger.Debugf("dash: current: %+v", current) /* for debugging */
 	return nil
@@ -342,7 +963,7 @@ func (c *Client) collect(
 	//
 	// TODO(bassosimone): our request constructor should use http.NewRequestWithContext
 	// such that we don't actually need to set the context as a separate operation
-	data, err := c.deps.JSONMarshal(c.clientResults)
+	data, err := c.deps.JSONMarshal(c.clientResults[c.sessionBegin:])
 	if err != nil {
 		return err
 	}
@@ -359,16 +980,24 @@ func (c *Client) collect(
 	req = req.WithContext(ctx)
 
 	// 2. send the request and receive the corresponding response headers
+	if err := c.pace(ctx); err != nil {
+		return err
+	}
+	sent := time.Now()
 	resp, err := c.deps.HTTPClientDo(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
+	c.observeClock(sent, time.Now(), resp, 0)
 
 	// 3. handle the case where the status code indicates failure
 	c.Logger.Debugf("dash: StatusCode: %d", resp.StatusCode)
+	if err := detectRedirect(resp); err != nil {
+		return err
+	}
 	if resp.StatusCode != 200 {
-		return errHTTPRequestFailed
+		return &httpStatusError{StatusCode: resp.StatusCode}
 	}
 
 	// 4. read the raw response body
@@ -389,6 +1018,14 @@ func (c *Client) collect(
 	return json.Unmarshal(data, &c.serverResults)
 }
 
+// initialBitrate is the rate (in kbit/s) of the first segment.
+//
+// Note: according to a comment in MK sources 3000 kbit/s was the
+// minimum speed recommended by Netflix for SD quality in 2017.
+//
+// See: <https://help.netflix.com/en/node/306>.
+const initialBitrate = 3000
+
 // loop is the main loop of the DASH test. It performs negotiation, the test
 // proper, and then collection. It posts interim results on |ch|.
 func (c *Client) loop(
@@ -396,8 +1033,40 @@ func (c *Client) loop(
 	ch chan<- model.ClientResults,
 	negotiateURL *url.URL,
 ) {
-	// 1. make sure we close the channel when done
+	c.transferLoop(ctx, ch, negotiateURL, &transfer{
+		phase:   phaseDownload,
+		segment: c.downloadSegment,
+	})
+}
+
+// transfer describes how [*Client.transferLoop] transfers the segments.
+type transfer struct {
+	// direction is the Direction of the results (empty for download).
+	direction string
+
+	// phase is the phase to which the segment failures belong.
+	phase string
+
+	// segment transfers the current segment.
+	segment func(ctx context.Context, authorization string,
+		current *model.ClientResults, baseURL *url.URL) error
+}
+
+// transferLoop implements loop and uploadLoop using the given transfer.
+func (c *Client) transferLoop(
+	ctx context.Context,
+	ch chan<- model.ClientResults,
+	negotiateURL *url.URL,
+	xfer *transfer,
+) {
+	// 1. make sure we close the channel when done, after recording
+	// when the loop terminated, and then call the CompletionHook
+	defer c.complete()
 	defer close(ch)
+	defer func() {
+		c.end = c.TimeNow()
+		c.saveLastRun()
+	}()
 
 	// 2. negotiate an authorization token with the server
 	//
@@ -405,42 +1074,318 @@ func (c *Client) loop(
 	// possiblity of keeping clients in queue. For this reason it's becoming
 	// increasingly less important to loop waiting for the ready signal. Hence
 	// if the server is busy, we just return a well known error.
-	var negotiateResponse model.NegotiateResponse
-	negotiateResponse, c.err = c.deps.Negotiate(ctx, negotiateURL)
+	var (
+		baseURL           *url.URL
+		negotiateResponse model.NegotiateResponse
+	)
+	negotiateResponse, baseURL, negotiateURL, c.err = c.negotiateTargets(ctx, negotiateURL)
 	if c.err != nil {
+		c.fail(phaseNegotiate, negotiateURL, c.err)
 		return
 	}
 
 	// 3. run the measurement loop proper
-	//
-	// Note: according to a comment in MK sources 3000 kbit/s was the
-	// minimum speed recommended by Netflix for SD quality in 2017.
-	//
-	// See: <https://help.netflix.com/en/node/306>.
-	const initialBitrate = 3000
 	current := model.ClientResults{
-		ElapsedTarget: 2,
-		Platform:      runtime.GOOS,
-		Rate:          initialBitrate,
-		RealAddress:   negotiateResponse.RealAddress,
-		Version:       magicVersion,
-	}
-	for current.Iteration < c.numIterations {
-		c.err = c.deps.Download(ctx, negotiateResponse.Authorization, &current, negotiateURL)
+		DSCP:             c.DSCP,
+		Direction:        xfer.direction,
+		ElapsedTarget:    int64(segmentDuration / time.Second),
+		Platform:         runtime.GOOS,
+		Rate:             c.clampRate(initialBitrate),
+		RealAddress:      negotiateResponse.RealAddress,
+		Streams:          negotiateResponse.Streams,
+		UserAgentProfile: c.UserAgentProfile,
+		Version:          magicVersion,
+	}
+	numIterations := c.allowedIterations(negotiateResponse)
+	if c.StreamRate > 0 {
+		current.Rate = c.StreamRate
+	}
+	var (
+		failures       int64
+		previousServer []model.ServerResults
+		totalElapsed   float64
+	)
+	for current.Iteration < numIterations {
+		c.err = xfer.segment(ctx, negotiateResponse.Authorization, &current, baseURL)
+		if c.err != nil && c.shouldRenegotiate(ctx, c.err) {
+			// When the server refuses to continue the session, we collect,
+			// negotiate a new session, and retry the same iteration.
+			c.Logger.Warnf("dash: session exhausted: %s; renegotiating", c.err.Error())
+			c.renegotiations++
+			if err := c.collectWithRetry(ctx, negotiateResponse.Authorization, baseURL); err != nil {
+				c.Logger.Warnf("dash: cannot collect the exhausted session: %s", err.Error())
+			} else {
+				previousServer = append(previousServer, c.serverResults...)
+			}
+			c.sessionBegin = len(c.clientResults)
+			negotiateResponse, baseURL, c.err = c.negotiateSession(ctx, negotiateURL)
+			if c.err != nil {
+				c.fail(phaseNegotiate, negotiateURL, c.err)
+				return
+			}
+			current.RealAddress = negotiateResponse.RealAddress
+			current.Streams = negotiateResponse.Streams
+			if c.StreamRate <= 0 {
+				current.Rate = c.stepDown(current.Rate)
+			}
+			continue
+		}
 		if c.err != nil {
-			return
+			// In resilient mode, like actual players do, we record the failure,
+			// step the rate down, and continue, unless the whole test is over
+			// or a captive portal or a middlebox is redirecting all the requests.
+			if !c.Resilient || ctx.Err() != nil || isRedirected(c.err) {
+				c.fail(xfer.phase, baseURL, c.err)
+				return
+			}
+			c.Logger.Warnf("dash: segment %s failed: %s", xfer.phase, c.err.Error())
+			current.Failure = c.err.Error()
+			current.Elapsed, current.Received = 0, 0
+			current.Clamped, current.SizeDelta = false, 0
+			current.ServerQueueDelay, current.ServerSendDuration = 0, 0
+			current.TCPInfo = nil
+			c.err = nil
+			c.recordQoE(&current)
+			c.clientResults = append(c.clientResults, current)
+			ch <- c.redact(current)
+			current.Failure = ""
+			current.Iteration++
+			failures++
+			if c.StreamRate <= 0 {
+				current.Rate = c.stepDown(current.Rate)
+			}
+			continue
 		}
+		c.recordQoE(&current)
 		c.clientResults = append(c.clientResults, current)
-		ch <- current
+		ch <- c.redact(current)
 		current.Iteration++
-		speed := float64(current.Received) / float64(current.Elapsed)
-		speed *= 8.0    // to bits per second
-		speed /= 1000.0 // to kbit/s
-		current.Rate = int64(speed)
+		totalElapsed += current.Elapsed
+		if c.StreamRate > 0 {
+			continue // in stream emulation mode the rate is fixed
+		}
+		current.Rate = c.clampRate(c.Adapter.NextRate(c.clientResults))
 	}
 
-	// 4. submit the measurement results
-	c.err = c.deps.Collect(ctx, negotiateResponse.Authorization, negotiateURL)
+	// 4. in stream emulation mode, the network sustained the rate if
+	// transferring the segments took no longer than playing them
+	if c.StreamRate > 0 {
+		playback := float64(numIterations * current.ElapsedTarget)
+		c.streamSustained = numIterations > 0 && failures == 0 && totalElapsed <= playback
+	}
+
+	// 5. submit the measurement results, also including the results
+	// collected from the previous sessions when we renegotiated
+	c.err = c.collectWithRetry(ctx, negotiateResponse.Authorization, baseURL)
+	if c.err != nil {
+		c.fail(phaseCollect, baseURL, c.err)
+	}
+	if len(previousServer) > 0 {
+		c.serverResults = append(previousServer, c.serverResults...)
+	}
+}
+
+// negotiateSession negotiates a new session with the server and returns the
+// negotiate response and the base URL for download and collect.
+func (c *Client) negotiateSession(
+	ctx context.Context,
+	negotiateURL *url.URL,
+) (model.NegotiateResponse, *url.URL, error) {
+	// 1. negotiate an authorization token with the server
+	negotiateResponse, err := c.deps.Negotiate(ctx, negotiateURL)
+	if err != nil {
+		return negotiateResponse, nil, err
+	}
+
+	// 2. honor the base URL for download and collect, if any, which
+	// allows the server to delegate serving segments to other nodes
+	baseURL := negotiateURL
+	if negotiateResponse.BaseURL != "" {
+		baseURL, err = parseBaseURL(negotiateResponse.BaseURL)
+		if err != nil {
+			return negotiateResponse, nil, err
+		}
+		c.Logger.Debugf("dash: using base URL: %s", baseURL.String())
+	}
+
+	// 3. save the segment size bounds, if any, to clamp the sizes
+	c.segmentMaxSize, c.segmentMinSize = negotiateResponse.MaxSize, negotiateResponse.MinSize
+
+	// 4. save the payload seed, if any, to verify the segments, noting
+	// that the server counts iterations from zero in each session
+	c.payloadIteration, c.payloadSeed = 0, nil
+	if negotiateResponse.Seed != "" {
+		seed, err := hex.DecodeString(negotiateResponse.Seed)
+		if err != nil {
+			c.Logger.Warnf("dash: invalid payload seed: %s", err.Error())
+		} else {
+			c.payloadSeed = seed
+		}
+	}
+	return negotiateResponse, baseURL, nil
+}
+
+// parseBaseURL parses and validates the base URL returned by the server.
+func parseBaseURL(value string) (*url.URL, error) {
+	URL, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+	if (URL.Scheme != "http" && URL.Scheme != "https") || URL.Host == "" {
+		return nil, errInvalidBaseURL
+	}
+	return URL, nil
+}
+
+// downloadSegment calls the Download dependency possibly limiting the
+// time it could take using the configured SegmentTimeout.
+func (c *Client) downloadSegment(
+	ctx context.Context,
+	authorization string,
+	current *model.ClientResults,
+	negotiateURL *url.URL,
+) error {
+	if c.SegmentTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.SegmentTimeout)
+		defer cancel()
+	}
+	return c.deps.Download(ctx, authorization, current, negotiateURL)
+}
+
+// collectWithRetry is like collect but retries transient failures at
+// most CollectRetries times using exponential backoff.
+func (c *Client) collectWithRetry(
+	ctx context.Context,
+	authorization string,
+	negotiateURL *url.URL,
+) error {
+	delay := c.collectDelay
+	for attempt := 0; ; attempt++ {
+		err := c.deps.Collect(ctx, authorization, negotiateURL)
+		if err == nil || attempt >= c.CollectRetries || !isTransient(err) {
+			return err
+		}
+		c.Logger.Warnf("dash: collect failed: %s; retrying in %s", err.Error(), delay)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// shouldRenegotiate returns whether we should negotiate a new session
+// after the given download error (see Renegotiate).
+func (c *Client) shouldRenegotiate(ctx context.Context, err error) bool {
+	var statusErr *httpStatusError
+	if !c.Renegotiate || ctx.Err() != nil || !errors.As(err, &statusErr) {
+		return false
+	}
+	if c.renegotiations >= maxRenegotiations {
+		c.Logger.Warn("dash: too many renegotiations")
+		return false
+	}
+	return statusErr.StatusCode == http.StatusTooManyRequests && len(c.clientResults) > c.sessionBegin
+}
+
+// isTransient returns whether the given collect error is transient, i.e.,
+// whether it is a network error or a 5xx response rather than, e.g., a 4xx
+// response, a redirect, or an invalid response body.
+func isTransient(err error) bool {
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= 500
+	}
+	if errors.Is(err, ErrRedirect) {
+		return false
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	return !errors.As(err, &syntaxErr) && !errors.As(err, &typeErr)
+}
+
+// lowerRate returns the largest default rate that is lower than the given
+// rate (in kbit/s), or the lowest default rate if there is none.
+func lowerRate(rate int64) int64 {
+	lower := spec.DefaultRates[0]
+	for _, candidate := range spec.DefaultRates {
+		if candidate < rate {
+			lower = candidate
+		}
+	}
+	return lower
+}
+
+// locateTimeout is the maximum amount of time we wait for locate.
+const locateTimeout = 15 * time.Second
+
+// locate uses m-lab/locate/v2 to discover the negotiate URL. It also saves
+// the negotiate URLs of the alternate targets (see LocateTargets).
+func (c *Client) locate(ctx context.Context) (*url.URL, error) {
+	targets, err := c.nearest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) < 1 {
+		return nil, errors.New("no targets")
+	}
+	c.alternateTargets = c.alternateTargetURLs(targets)
+	URL := targets[0].URLs[locateNegotiateKey]
+	return url.Parse(URL)
+}
+
+// nearest returns the nearest targets using the LocateCache, if
+// configured, and otherwise querying m-lab/locate/v2.
+func (c *Client) nearest(ctx context.Context) ([]locatev2.Target, error) {
+	if c.LocateCache != nil {
+		if targets, found := c.LocateCache.get(); found {
+			c.Logger.Debug("dash: using cached locate targets")
+			return targets, nil
+		}
+	}
+	if targets, found := c.cachedTargets(); found {
+		c.Logger.Debug("dash: using locate targets persisted in the cache directory")
+		return targets, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, locateTimeout)
+	defer cancel()
+	if err := c.pace(ctx); err != nil {
+		return nil, err
+	}
+	targets, err := c.deps.Locator.Nearest(ctx, "neubot/dash")
+	if err != nil {
+		return nil, err
+	}
+	if c.LocateCache != nil && len(targets) > 0 {
+		c.LocateCache.put(targets)
+	}
+	if len(targets) > 0 {
+		c.writeCache(cacheDirLocateFile, &cachedLocate{Stamp: time.Now(), Targets: targets})
+	}
+	return targets, nil
+}
+
+// fallbackURL returns the negotiate URL of a random server among
+// the configured FallbackServers, skipping invalid entries.
+func (c *Client) fallbackURL() (*url.URL, error) {
+	servers := append([]string{}, c.FallbackServers...)
+	c.deps.RandShuffle(len(servers), func(i, j int) {
+		servers[i], servers[j] = servers[j], servers[i]
+	})
+	for _, server := range servers {
+		parsed, err := url.Parse(server)
+		if err != nil || parsed.Host == "" {
+			c.Logger.Warnf("dash: invalid fallback server: %s", server)
+			continue
+		}
+		if parsed.Path == "" || parsed.Path == "/" {
+			parsed.Path = spec.NegotiatePath
+		}
+		return parsed, nil
+	}
+	return nil, errNoValidFallbackServer
 }
 
 // StartDownload starts the DASH download. It returns a channel where
@@ -448,11 +1393,84 @@ func (c *Client) loop(
 // fail if we cannot even initiate the experiment. If you see some
 // results on the returned channel, then maybe it means the experiment
 // has somehow worked. You can see if there has been any error during
-// the experiment by using the Error function.
+// the experiment by using the Error function. Use [WithRunMetadata] to
+// attach metadata to the final result of this run.
 func (c *Client) StartDownload(ctx context.Context) (<-chan model.ClientResults, error) {
+	c.begin = c.TimeNow()
+	c.loadProbeID()
+	c.runMetadata = RunMetadata(ctx)
+	c.saveTimeout(ctx)
+	ch, err := c.start(ctx, c.deps.Loop)
+	if err != nil {
+		c.saveLastRun() // otherwise the loop saves it
+		c.complete()
+	}
+	return ch, err
+}
+
+// start implements StartDownload and StartUpload using the given loop.
+func (c *Client) start(
+	ctx context.Context,
+	loop func(ctx context.Context, ch chan<- model.ClientResults, negotiateURL *url.URL),
+) (<-chan model.ClientResults, error) {
+
+	// 0. possibly use the custom transport and dial functions
+	if c.Transport != nil || c.DialContext != nil || c.DialTLSContext != nil {
+		httpClient, err := c.newCustomHTTPClient()
+		if err != nil {
+			return nil, c.fail(phaseSetup, nil, err)
+		}
+		c.HTTPClient = httpClient
+	}
+
+	// 0.1. possibly configure DSCP marking for the measurement connections
+	if c.DSCP != 0 {
+		httpClient, err := c.newDSCPHTTPClient()
+		if err != nil {
+			return nil, c.fail(phaseSetup, nil, err)
+		}
+		c.HTTPClient = httpClient
+	}
+
+	// 0.2. possibly pin the whole session to a single connection
+	if c.PinConnection {
+		httpClient, err := c.newPinnedHTTPClient()
+		if err != nil {
+			return nil, c.fail(phaseSetup, nil, err)
+		}
+		c.HTTPClient = httpClient
+	}
 
-	// 1. use the provided FQDN or use m-lab/locate/v2
+	// 0.3. make sure we know the User-Agent profile, if any
+	if _, err := c.segmentUserAgent(); err != nil {
+		return nil, c.fail(phaseSetup, nil, err)
+	}
+
+	// 0.4. make sure the rates, if any, are valid
+	if err := c.validateRates(); err != nil {
+		return nil, c.fail(phaseSetup, nil, err)
+	}
+
+	// 0.5. make sure the number of iterations is valid
+	if err := c.validateIterations(); err != nil {
+		return nil, c.fail(phaseSetup, nil, err)
+	}
+
+	// 0.6. make sure we have an adaptation algorithm, if needed
+	if err := c.validateAdapter(); err != nil {
+		return nil, c.fail(phaseSetup, nil, err)
+	}
+
+	// 1. use the provided FQDN, the last server, or use m-lab/locate/v2
 	var negotiateURL *url.URL
+	c.alternateTargets = nil
+	if c.UseLastServer && c.FQDN == "" {
+		parsed, err := c.lastServerURL()
+		if err != nil {
+			c.Logger.Warnf("dash: cannot reuse the last server: %s", err.Error())
+		}
+		negotiateURL = parsed
+	}
 	switch {
 
 	// 1.1: the user manually specified the server FQDN
@@ -462,22 +1480,22 @@ func (c *Client) StartDownload(ctx context.Context) (<-chan model.ClientResults,
 		negotiateURL.Host = c.FQDN
 		negotiateURL.Path = spec.NegotiatePath
 
-	// 1.2: we're going to use m-lab/locate/v2 for discovering the server
+	// 1.2: we're reusing the server used by the last run
+	case negotiateURL != nil:
+		c.Logger.Debug("dash: reusing the last server")
+
+	// 1.3: we're going to use m-lab/locate/v2 for discovering the server
 	default:
 		c.Logger.Debug("dash: discovering server with locate v2")
+		parsed, err := c.locate(ctx)
 
-		targets, err := c.deps.Locator.Nearest(ctx, "neubot/dash")
-		if err != nil {
-			return nil, err
+		// 1.4: if locate failed, possibly use the fallback servers
+		if err != nil && ctx.Err() == nil && len(c.FallbackServers) > 0 {
+			c.Logger.Warnf("dash: locate failed: %s; using fallback servers", err.Error())
+			parsed, err = c.fallbackURL()
 		}
-		if len(targets) < 1 {
-			return nil, errors.New("no targets")
-		}
-
-		URL := targets[0].URLs["https:///negotiate/dash"]
-		parsed, err := url.Parse(URL)
 		if err != nil {
-			return nil, err
+			return nil, c.fail(phaseLocate, nil, err)
 		}
 
 		negotiateURL = parsed
@@ -487,13 +1505,14 @@ func (c *Client) StartDownload(ctx context.Context) (<-chan model.ClientResults,
 	//
 	// this check is useful to write better tests
 	if ctx.Err() != nil {
-		return nil, ctx.Err()
+		return nil, c.fail(phaseLocate, negotiateURL, ctx.Err())
 	}
 
 	// 3. run the client loop and return the resulting channel
 	c.Logger.Debugf("dash: using server: %v", negotiateURL)
+	c.server = negotiateURL.String()
 	ch := make(chan model.ClientResults)
-	go c.deps.Loop(ctx, ch, negotiateURL)
+	go loop(ctx, ch, negotiateURL)
 	return ch, nil
 }
 
@@ -507,6 +1526,46 @@ func (c *Client) Error() error {
 	return c.err
 }
 
+// StreamSustained returns whether the network sustained the configured
+// StreamRate when running in stream emulation mode. It always returns false
+// when the stream emulation mode is disabled.
+//
+// To avoid data races you MUST call this method after the channel
+// returned by [*Client.StartDownload] has been drained.
+func (c *Client) StreamSustained() bool {
+	return c.streamSustained
+}
+
+// streamDurationSeconds returns the StreamDuration in seconds.
+func (c *Client) streamDurationSeconds() int64 {
+	return int64(c.StreamDuration / time.Second)
+}
+
+// ClientResults returns the results of the experiment measured by the
+// client, i.e., the same results posted on the channel returned by
+// [*Client.StartDownload].
+//
+// To avoid data races you MUST call this method after the channel
+// returned by [*Client.StartDownload] has been drained.
+func (c *Client) ClientResults() []model.ClientResults {
+	results := []model.ClientResults{}
+	for _, current := range c.clientResults {
+		results = append(results, c.redact(current))
+	}
+	return results
+}
+
+// redact returns the copy of the results that we emit, i.e., without the
+// addresses when we are running in strict privacy mode.
+func (c *Client) redact(current model.ClientResults) model.ClientResults {
+	if c.StrictPrivacy {
+		current.InternalAddress = ""
+		current.RealAddress = ""
+		current.RemoteAddress = ""
+	}
+	return current
+}
+
 // ServerResults returns the results of the experiment collected by the
 // server. In case [*Client.Error] returns non nil, this function will typically
 // return an empty slice to the caller.
@@ -3,16 +3,20 @@ package client
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	locatev2 "github.com/m-lab/locate/api/v2"
 	"github.com/neubot/dash/model"
+	"github.com/neubot/dash/spec"
 )
 
 const (
@@ -175,7 +179,7 @@ func TestClientDownload(t *testing.T) {
 		}
 	})
 
-	t.Run("io.ReadAll failure", func(t *testing.T) {
+	t.Run("io.Copy failure", func(t *testing.T) {
 		client := New(softwareName, softwareVersion)
 		client.deps.HTTPClientDo = func(req *http.Request) (*http.Response, error) {
 			return &http.Response{
@@ -183,8 +187,8 @@ func TestClientDownload(t *testing.T) {
 				Body:       io.NopCloser(bytes.NewReader(nil)),
 			}, nil
 		}
-		client.deps.IOReadAll = func(r io.Reader) ([]byte, error) {
-			return nil, errors.New("Mocked error")
+		client.deps.IOCopy = func(dst io.Writer, src io.Reader) (int64, error) {
+			return 0, errors.New("Mocked error")
 		}
 		current := new(model.ClientResults)
 		err := client.download(context.Background(), "abc", current, &url.URL{})
@@ -367,6 +371,363 @@ func TestClientLoop(t *testing.T) {
 	})
 }
 
+func TestClientLoopResilient(t *testing.T) {
+	ch := make(chan model.ClientResults)
+	client := New(softwareName, softwareVersion)
+	client.Resilient = true
+	client.SegmentTimeout = time.Second
+	client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+		return model.NegotiateResponse{}, nil
+	}
+	client.deps.Download = func(
+		ctx context.Context, authorization string,
+		current *model.ClientResults, negotiateURL *url.URL,
+	) error {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("expected a deadline")
+		}
+		if current.Iteration == 1 {
+			return errors.New("mocked error")
+		}
+		current.Elapsed = 1
+		current.Received = 1
+		return nil
+	}
+	client.deps.Collect = func(ctx context.Context, authorization string, negotiateURL *url.URL) error {
+		return nil
+	}
+	go client.loop(context.Background(), ch, &url.URL{})
+	var results []model.ClientResults
+	for result := range ch {
+		results = append(results, result)
+	}
+	if client.Error() != nil {
+		t.Fatal(client.Error())
+	}
+	if len(results) != int(client.Iterations) {
+		t.Fatal("unexpected number of results", len(results))
+	}
+	if results[1].Failure != "mocked error" || results[2].Failure != "" {
+		t.Fatal("unexpected failures")
+	}
+	if results[2].Rate != lowerRate(results[1].Rate) {
+		t.Fatal("expected the rate to step down")
+	}
+}
+
+func TestClientLoopRenegotiate(t *testing.T) {
+	run := func(maxSegments int) (*Client, []string, []int) {
+		ch := make(chan model.ClientResults)
+		client := New(softwareName, softwareVersion)
+		client.Renegotiate = true
+		client.Iterations = 5
+		var sessions int
+		client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+			sessions++
+			return model.NegotiateResponse{Authorization: fmt.Sprintf("session-%d", sessions)}, nil
+		}
+		var segments int
+		client.deps.Download = func(
+			ctx context.Context, authorization string,
+			current *model.ClientResults, negotiateURL *url.URL,
+		) error {
+			if segments >= maxSegments {
+				segments = 0
+				return &httpStatusError{StatusCode: 429}
+			}
+			segments++
+			current.Elapsed = 1
+			current.Received = 1000000
+			return nil
+		}
+		var (
+			collected []string
+			counts    []int
+		)
+		client.deps.Collect = func(ctx context.Context, authorization string, negotiateURL *url.URL) error {
+			collected = append(collected, authorization)
+			counts = append(counts, len(client.clientResults[client.sessionBegin:]))
+			return nil
+		}
+		go client.loop(context.Background(), ch, &url.URL{})
+		for range ch {
+			// drain channel
+		}
+		return client, collected, counts
+	}
+
+	t.Run("with exhausted sessions", func(t *testing.T) {
+		client, collected, counts := run(2)
+		if client.Error() != nil {
+			t.Fatal(client.Error())
+		}
+		if len(client.clientResults) != 5 {
+			t.Fatal("unexpected number of results", len(client.clientResults))
+		}
+		if len(collected) != 3 || collected[0] != "session-1" || collected[2] != "session-3" {
+			t.Fatal("unexpected collected sessions", collected)
+		}
+		if counts[0] != 2 || counts[1] != 2 || counts[2] != 1 {
+			t.Fatal("unexpected collected results", counts)
+		}
+		if client.clientResults[2].Rate != lowerRate(client.clientResults[1].Rate) {
+			t.Fatal("expected the rate to step down")
+		}
+	})
+
+	t.Run("with too many renegotiations", func(t *testing.T) {
+		client, collected, _ := run(1)
+		var statusErr *httpStatusError
+		if !errors.As(client.Error(), &statusErr) || statusErr.StatusCode != 429 {
+			t.Fatal("not the error we expected", client.Error())
+		}
+		if len(collected) != maxRenegotiations {
+			t.Fatal("unexpected collected sessions", collected)
+		}
+		if len(client.clientResults) != maxRenegotiations+1 {
+			t.Fatal("unexpected number of results", len(client.clientResults))
+		}
+	})
+
+	t.Run("without any progress", func(t *testing.T) {
+		client, collected, _ := run(0)
+		var statusErr *httpStatusError
+		if !errors.As(client.Error(), &statusErr) || statusErr.StatusCode != 429 {
+			t.Fatal("not the error we expected", client.Error())
+		}
+		if len(collected) != 0 {
+			t.Fatal("unexpected collected sessions", collected)
+		}
+	})
+}
+
+func TestClientLoopBaseURL(t *testing.T) {
+	run := func(baseURL string) (*Client, []string) {
+		ch := make(chan model.ClientResults)
+		client := New(softwareName, softwareVersion)
+		client.Iterations = 1
+		client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+			return model.NegotiateResponse{BaseURL: baseURL}, nil
+		}
+		var hosts []string
+		client.deps.Download = func(
+			ctx context.Context, authorization string,
+			current *model.ClientResults, negotiateURL *url.URL,
+		) error {
+			hosts = append(hosts, negotiateURL.Host)
+			current.Elapsed = 1
+			return nil
+		}
+		client.deps.Collect = func(ctx context.Context, authorization string, negotiateURL *url.URL) error {
+			hosts = append(hosts, negotiateURL.Host)
+			return nil
+		}
+		go client.loop(context.Background(), ch, &url.URL{Scheme: "https", Host: "negotiate.example.com"})
+		for range ch {
+			// drain channel
+		}
+		return client, hosts
+	}
+
+	t.Run("without base URL", func(t *testing.T) {
+		client, hosts := run("")
+		if client.Error() != nil {
+			t.Fatal(client.Error())
+		}
+		if len(hosts) != 2 || hosts[0] != "negotiate.example.com" || hosts[1] != "negotiate.example.com" {
+			t.Fatal("unexpected hosts", hosts)
+		}
+	})
+
+	t.Run("with base URL", func(t *testing.T) {
+		client, hosts := run("https://node.example.com:4443")
+		if client.Error() != nil {
+			t.Fatal(client.Error())
+		}
+		if len(hosts) != 2 || hosts[0] != "node.example.com:4443" || hosts[1] != "node.example.com:4443" {
+			t.Fatal("unexpected hosts", hosts)
+		}
+	})
+
+	t.Run("with invalid base URL", func(t *testing.T) {
+		client, hosts := run("ftp://node.example.com")
+		if !errors.Is(client.Error(), errInvalidBaseURL) {
+			t.Fatal("not the error we expected", client.Error())
+		}
+		if len(hosts) != 0 {
+			t.Fatal("unexpected hosts", hosts)
+		}
+	})
+}
+
+func TestClientLoopStreams(t *testing.T) {
+	ch := make(chan model.ClientResults)
+	client := New(softwareName, softwareVersion)
+	client.Iterations = 2
+	client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+		return model.NegotiateResponse{Streams: 2}, nil
+	}
+	client.deps.Download = func(
+		ctx context.Context, authorization string,
+		current *model.ClientResults, negotiateURL *url.URL,
+	) error {
+		current.Elapsed = 1
+		return nil
+	}
+	client.deps.Collect = func(ctx context.Context, authorization string, negotiateURL *url.URL) error {
+		return nil
+	}
+	go client.loop(context.Background(), ch, &url.URL{})
+	var count int
+	for results := range ch {
+		if results.Streams != 2 {
+			t.Fatal("unexpected number of streams", results.Streams)
+		}
+		count++
+	}
+	if count != 2 {
+		t.Fatal("unexpected number of iterations", count)
+	}
+}
+
+func TestLowerRate(t *testing.T) {
+	if rate := lowerRate(3000); rate != 2500 {
+		t.Fatal("unexpected rate", rate)
+	}
+	if rate := lowerRate(3001); rate != 3000 {
+		t.Fatal("unexpected rate", rate)
+	}
+	if rate := lowerRate(1); rate != 100 {
+		t.Fatal("unexpected rate", rate)
+	}
+}
+
+func TestClientLoopStreamEmulation(t *testing.T) {
+	runWithElapsed := func(elapsed float64) *Client {
+		ch := make(chan model.ClientResults)
+		client := New(softwareName, softwareVersion)
+		client.StreamRate = 25000
+		client.StreamDuration = 10 * time.Second
+		client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+			return model.NegotiateResponse{}, nil
+		}
+		client.deps.Download = func(
+			ctx context.Context, authorization string,
+			current *model.ClientResults, negotiateURL *url.URL,
+		) error {
+			if current.Rate != 25000 {
+				t.Error("unexpected rate", current.Rate)
+			}
+			current.Elapsed = elapsed
+			current.Received = 1
+			return nil
+		}
+		client.deps.Collect = func(ctx context.Context, authorization string, negotiateURL *url.URL) error {
+			return nil
+		}
+		go client.loop(context.Background(), ch, &url.URL{})
+		var count int
+		for range ch {
+			count++
+		}
+		if count != 5 {
+			t.Fatal("unexpected number of iterations", count)
+		}
+		return client
+	}
+
+	t.Run("rate sustained", func(t *testing.T) {
+		client := runWithElapsed(1.5)
+		if !client.StreamSustained() {
+			t.Fatal("expected the rate to be sustained")
+		}
+	})
+
+	t.Run("rate not sustained", func(t *testing.T) {
+		client := runWithElapsed(2.5)
+		if client.StreamSustained() {
+			t.Fatal("expected the rate not to be sustained")
+		}
+	})
+}
+
+func TestClientLoopStrictPrivacy(t *testing.T) {
+	ch := make(chan model.ClientResults)
+	client := New(softwareName, softwareVersion)
+	client.StrictPrivacy = true
+	client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+		return model.NegotiateResponse{RealAddress: "130.192.91.211"}, nil
+	}
+	client.deps.Download = func(
+		ctx context.Context, authorization string,
+		current *model.ClientResults, negotiateURL *url.URL,
+	) error {
+		current.Elapsed = 1
+		current.Received = 1
+		return nil
+	}
+	client.deps.Collect = func(ctx context.Context, authorization string, negotiateURL *url.URL) error {
+		return nil
+	}
+	go client.loop(context.Background(), ch, &url.URL{})
+	for current := range ch {
+		if current.RealAddress != "" {
+			t.Fatal("expected the address to be redacted")
+		}
+	}
+	for _, current := range client.ClientResults() {
+		if current.RealAddress != "" {
+			t.Fatal("expected the address to be redacted")
+		}
+	}
+	for _, current := range client.clientResults {
+		if current.RealAddress != "130.192.91.211" {
+			t.Fatal("expected the server to receive the address")
+		}
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+// RoundTrip implements http.RoundTripper.
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestClientNewDSCPHTTPClient(t *testing.T) {
+	t.Run("invalid DSCP value", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.DSCP = 64
+		if _, err := client.newDSCPHTTPClient(); !errors.Is(err, errInvalidDSCP) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+
+	t.Run("custom transport", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.DSCP = 46
+		client.HTTPClient = &http.Client{Transport: roundTripperFunc(nil)}
+		if _, err := client.newDSCPHTTPClient(); !errors.Is(err, errDSCPNotSupported) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+
+	t.Run("common case", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.DSCP = 46
+		httpClient, err := client.newDSCPHTTPClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if httpClient == http.DefaultClient {
+			t.Fatal("expected a copy of the default client")
+		}
+		if _, ok := httpClient.Transport.(*http.Transport); !ok {
+			t.Fatal("expected an *http.Transport")
+		}
+	})
+}
+
 type failingLocator struct{}
 
 // Nearest implements locator.
@@ -387,6 +748,46 @@ func TestClientStartDownload(t *testing.T) {
 		}
 	})
 
+	t.Run("locate failure with invalid fallback servers", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.deps.Locator = &failingLocator{}
+		client.FallbackServers = []string{"\t", "/only/a/path"}
+		ch, err := client.StartDownload(context.Background())
+		if !errors.Is(err, errNoValidFallbackServer) {
+			t.Fatal("not the error we expected", err)
+		}
+		if ch != nil {
+			t.Fatal("Expected nil channel here")
+		}
+	})
+
+	t.Run("locate failure with fallback servers", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.deps.Locator = &failingLocator{}
+		client.FallbackServers = []string{"https://a.example.com", "https://b.example.com/negotiate/dash"}
+		client.deps.RandShuffle = func(n int, swap func(i, j int)) {
+			swap(0, 1) // deterministically reverse the list
+		}
+		var gotURL string
+		client.deps.Loop = func(ctx context.Context, ch chan<- model.ClientResults, negotiateURL *url.URL) {
+			gotURL = negotiateURL.String()
+			close(ch)
+		}
+		ch, err := client.StartDownload(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range ch {
+			// drain channel
+		}
+		if gotURL != "https://b.example.com/negotiate/dash" {
+			t.Fatal("unexpected URL", gotURL)
+		}
+		if client.FallbackServers[0] != "https://a.example.com" {
+			t.Fatal("the original list should not be modified")
+		}
+	})
+
 	t.Run("common case", func(t *testing.T) {
 		client := New(softwareName, softwareVersion)
 		client.deps.Loop = func(ctx context.Context, ch chan<- model.ClientResults, negotiateURL *url.URL) {
@@ -401,3 +802,210 @@ func TestClientStartDownload(t *testing.T) {
 		}
 	})
 }
+
+func TestClientDownloadAcceptEncoding(t *testing.T) {
+	client := New(softwareName, softwareVersion)
+	client.AcceptEncoding = "gzip"
+	client.deps.HTTPClientDo = func(req *http.Request) (*http.Response, error) {
+		if req.Header.Get("Accept-Encoding") != "gzip" {
+			t.Fatal("expected the Accept-Encoding header")
+		}
+		header := make(http.Header)
+		header.Set("Content-Encoding", "gzip")
+		header.Set("Via", "1.1 proxy.example.com")
+		return &http.Response{
+			StatusCode: 200,
+			Header:     header,
+			Body:       io.NopCloser(bytes.NewReader(nil)),
+		}, nil
+	}
+	current := new(model.ClientResults)
+	err := client.download(context.Background(), "abc", current, &url.URL{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.ContentEncoding != "gzip" || current.Via != "1.1 proxy.example.com" {
+		t.Fatalf("unexpected results: %+v", current)
+	}
+}
+
+func TestClientDownloadCacheBusting(t *testing.T) {
+	run := func(respond func(token string, header http.Header)) *model.ClientResults {
+		client := New(softwareName, softwareVersion)
+		client.CacheBusting = true
+		client.deps.HTTPClientDo = func(req *http.Request) (*http.Response, error) {
+			token := req.URL.Query().Get(spec.CacheBustingQuery)
+			if token == "" {
+				t.Fatal("expected a cache busting token")
+			}
+			header := make(http.Header)
+			respond(token, header)
+			return &http.Response{
+				StatusCode: 200,
+				Header:     header,
+				Body:       io.NopCloser(bytes.NewReader(nil)),
+			}, nil
+		}
+		current := new(model.ClientResults)
+		if err := client.download(context.Background(), "abc", current, &url.URL{}); err != nil {
+			t.Fatal(err)
+		}
+		return current
+	}
+
+	t.Run("token echoed", func(t *testing.T) {
+		current := run(func(token string, header http.Header) {
+			header.Set(spec.CacheBustingHeader, token)
+		})
+		if current.SuspectCached {
+			t.Fatal("expected no cache")
+		}
+	})
+
+	t.Run("different token echoed", func(t *testing.T) {
+		current := run(func(token string, header http.Header) {
+			header.Set(spec.CacheBustingHeader, "antani")
+		})
+		if !current.SuspectCached {
+			t.Fatal("expected a cache")
+		}
+	})
+
+	t.Run("with Age header", func(t *testing.T) {
+		current := run(func(token string, header http.Header) {
+			header.Set("Age", "10")
+		})
+		if !current.SuspectCached {
+			t.Fatal("expected a cache")
+		}
+	})
+}
+
+func TestIsTransient(t *testing.T) {
+	for _, tc := range []struct {
+		err    error
+		expect bool
+	}{
+		{errors.New("connection reset by peer"), true},
+		{&httpStatusError{StatusCode: 503}, true},
+		{&httpStatusError{StatusCode: 400}, false},
+		{&json.SyntaxError{}, false},
+		{&json.UnmarshalTypeError{}, false},
+	} {
+		if isTransient(tc.err) != tc.expect {
+			t.Fatal("unexpected result for", tc.err)
+		}
+	}
+}
+
+func TestClientCollectWithRetry(t *testing.T) {
+	newClient := func(errs ...error) (*Client, *int) {
+		client := New(softwareName, softwareVersion)
+		client.collectDelay = 0
+		var attempts int
+		client.deps.Collect = func(ctx context.Context, authorization string, negotiateURL *url.URL) error {
+			defer func() { attempts++ }()
+			if authorization != "deadbeef" {
+				t.Error("unexpected authorization", authorization)
+			}
+			if attempts < len(errs) {
+				return errs[attempts]
+			}
+			return nil
+		}
+		return client, &attempts
+	}
+
+	t.Run("success after transient failures", func(t *testing.T) {
+		client, attempts := newClient(errors.New("Mocked error"), &httpStatusError{StatusCode: 502})
+		if err := client.collectWithRetry(context.Background(), "deadbeef", &url.URL{}); err != nil {
+			t.Fatal(err)
+		}
+		if *attempts != 3 {
+			t.Fatal("unexpected number of attempts", *attempts)
+		}
+	})
+
+	t.Run("permanent failure", func(t *testing.T) {
+		client, attempts := newClient(&httpStatusError{StatusCode: 400})
+		if err := client.collectWithRetry(context.Background(), "deadbeef", &url.URL{}); err == nil {
+			t.Fatal("Expected an error here")
+		}
+		if *attempts != 1 {
+			t.Fatal("unexpected number of attempts", *attempts)
+		}
+	})
+
+	t.Run("too many transient failures", func(t *testing.T) {
+		mocked := errors.New("Mocked error")
+		client, attempts := newClient(mocked, mocked, mocked, mocked, mocked)
+		if err := client.collectWithRetry(context.Background(), "deadbeef", &url.URL{}); err != mocked {
+			t.Fatal("not the error we expected", err)
+		}
+		if *attempts != defaultCollectRetries+1 {
+			t.Fatal("unexpected number of attempts", *attempts)
+		}
+	})
+
+	t.Run("cancelled context", func(t *testing.T) {
+		client, attempts := newClient(errors.New("Mocked error"))
+		client.collectDelay = time.Hour
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := client.collectWithRetry(ctx, "deadbeef", &url.URL{}); err == nil {
+			t.Fatal("Expected an error here")
+		}
+		if *attempts != 1 {
+			t.Fatal("unexpected number of attempts", *attempts)
+		}
+	})
+}
+
+func TestClientDownloadCPUTimes(t *testing.T) {
+	run := func(cpuTimes func() (time.Duration, time.Duration, error)) *model.ClientResults {
+		client := New(softwareName, softwareVersion)
+		client.deps.HTTPClientDo = func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 200,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(bytes.NewReader(nil)),
+			}, nil
+		}
+		client.deps.ProcessCPUTimes = cpuTimes
+		current := new(model.ClientResults)
+		if err := client.download(context.Background(), "abc", current, &url.URL{}); err != nil {
+			t.Fatal(err)
+		}
+		return current
+	}
+
+	t.Run("common case", func(t *testing.T) {
+		var calls time.Duration
+		current := run(func() (time.Duration, time.Duration, error) {
+			calls++
+			return calls * 100 * time.Millisecond, calls * 50 * time.Millisecond, nil
+		})
+		if current.DeltaUserTime != 0.1 || current.DeltaSysTime != 0.05 {
+			t.Fatalf("unexpected CPU times: %+v", current)
+		}
+	})
+
+	t.Run("when we cannot obtain CPU times", func(t *testing.T) {
+		current := run(func() (time.Duration, time.Duration, error) {
+			return time.Second, time.Second, errors.New("Mocked error")
+		})
+		if current.DeltaUserTime != 0 || current.DeltaSysTime != 0 {
+			t.Fatalf("unexpected CPU times: %+v", current)
+		}
+	})
+}
+
+func TestProcessCPUTimes(t *testing.T) {
+	user, sys, err := processCPUTimes()
+	if err != nil {
+		t.Skip("not supported on this platform", err)
+	}
+	if user < 0 || sys < 0 || user+sys <= 0 {
+		t.Fatal("unexpected CPU times", user, sys)
+	}
+}
@@ -0,0 +1,186 @@
+// Package clienttest allows to run the DASH client against a scripted fake
+// server, which emulates the bandwidth available for downloading each segment
+// using a virtual clock. Because the client measures time using the same
+// virtual clock, simulations are deterministic and do not take any real time,
+// which allows to evaluate ABR algorithms in unit tests and in CI without
+// network access. For example:
+//
+//	server := clienttest.NewServer([]int64{3000, 5000, 1000})
+//	clnt := client.New("example", "0.1.0")
+//	server.Configure(clnt)
+//	ch, err := clnt.StartDownload(ctx)
+package clienttest
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neubot/dash/client"
+	"github.com/neubot/dash/model"
+	"github.com/neubot/dash/spec"
+)
+
+const (
+	// authorization is the session token we return when negotiating.
+	authorization = "clienttest"
+
+	// FQDN is the fake server FQDN that Configure configures.
+	FQDN = "dash.clienttest.invalid"
+)
+
+// Epoch is the initial value of the virtual clock of each [*Server].
+var Epoch = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Server is a scripted fake DASH server. It implements [http.RoundTripper],
+// so you can use it as the transport of the client (see Configure), and
+// it advances its virtual clock as if it were sending data. The zero value
+// is invalid; use [NewServer] to construct a new instance.
+type Server struct {
+	// RTT is the round trip time we add to each request. By default
+	// NewServer sets this field to zero.
+	RTT time.Duration
+
+	// bandwidth contains the bandwidth trace in kbit/s.
+	bandwidth []int64
+
+	// mtx protects now and segments.
+	mtx sync.Mutex
+
+	// now is the current time of the virtual clock.
+	now time.Time
+
+	// segments contains the size of the requested segments.
+	segments []int64
+}
+
+// NewServer creates a new [*Server] instance using the given bandwidth trace,
+// which contains the bandwidth in kbit/s available for downloading each
+// segment. When we run out of values, we keep using the last one. A zero or
+// negative value emulates an outage, where the download fails with 503.
+func NewServer(bandwidth []int64) *Server {
+	return &Server{
+		RTT:       0,
+		bandwidth: append([]int64{}, bandwidth...),
+		mtx:       sync.Mutex{},
+		now:       Epoch,
+		segments:  []int64{},
+	}
+}
+
+// Configure configures the client to use this server as the transport
+// and its virtual clock for measuring time.
+func (s *Server) Configure(clnt *client.Client) {
+	clnt.FQDN = FQDN
+	clnt.Scheme = "https"
+	clnt.TimeNow = s.Now
+	clnt.Transport = s
+}
+
+// Now returns the current time of the virtual clock.
+func (s *Server) Now() time.Time {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.now
+}
+
+// Segments returns the size in bytes of the segments requested so far.
+func (s *Server) Segments() []int64 {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]int64{}, s.segments...)
+}
+
+// RoundTrip implements [http.RoundTripper].
+func (s *Server) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		_, _ = io.Copy(io.Discard, req.Body)
+		req.Body.Close()
+	}
+	switch {
+	case req.URL.Path == spec.NegotiatePath:
+		s.advance(s.RTT)
+		return s.jsonResponse(req, &model.NegotiateResponse{
+			Authorization: authorization,
+			Unchoked:      1,
+		})
+	case strings.HasPrefix(req.URL.Path, spec.DownloadPath):
+		return s.download(req)
+	case req.URL.Path == spec.CollectPath:
+		s.advance(s.RTT)
+		return s.jsonResponse(req, []model.ServerResults{})
+	default:
+		return s.response(req, http.StatusNotFound, nil), nil
+	}
+}
+
+// download emulates downloading a segment using the bandwidth trace.
+func (s *Server) download(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != authorization {
+		return s.response(req, http.StatusBadRequest, nil), nil
+	}
+	size, err := strconv.ParseInt(strings.TrimPrefix(req.URL.Path, spec.DownloadPath), 10, 64)
+	if err != nil || size < 0 {
+		return s.response(req, http.StatusBadRequest, nil), nil
+	}
+	s.mtx.Lock()
+	idx := len(s.segments)
+	s.segments = append(s.segments, size)
+	s.mtx.Unlock()
+	bandwidth := s.bandwidthAt(idx)
+	if bandwidth <= 0 {
+		s.advance(s.RTT)
+		return s.response(req, http.StatusServiceUnavailable, nil), nil
+	}
+	seconds := float64(size*8) / float64(bandwidth*1000)
+	s.advance(s.RTT + time.Duration(seconds*float64(time.Second)))
+	resp := s.response(req, http.StatusOK, make([]byte, size))
+	resp.Header.Set("Content-Type", "video/mp4")
+	return resp, nil
+}
+
+// bandwidthAt returns the bandwidth for downloading the given segment.
+func (s *Server) bandwidthAt(idx int) int64 {
+	if len(s.bandwidth) <= 0 {
+		return 0
+	}
+	return s.bandwidth[min(idx, len(s.bandwidth)-1)]
+}
+
+// advance advances the virtual clock by the given duration.
+func (s *Server) advance(d time.Duration) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.now = s.now.Add(d)
+}
+
+// jsonResponse returns a successful response with the given JSON body.
+func (s *Server) jsonResponse(req *http.Request, v any) (*http.Response, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	resp := s.response(req, http.StatusOK, data)
+	resp.Header.Set("Content-Type", "application/json")
+	return resp, nil
+}
+
+// response returns a response with the given status code and body.
+func (s *Server) response(req *http.Request, code int, body []byte) *http.Response {
+	return &http.Response{
+		Status:        strconv.Itoa(code) + " " + http.StatusText(code),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Length": {strconv.Itoa(len(body))}},
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
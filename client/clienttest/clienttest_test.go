@@ -0,0 +1,121 @@
+package clienttest
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/neubot/dash/client"
+	"github.com/neubot/dash/model"
+)
+
+// run runs the client against the server and returns the results.
+func run(t *testing.T, server *Server, clnt *client.Client) []model.ClientResults {
+	server.Configure(clnt)
+	ch, err := clnt.StartDownload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []model.ClientResults
+	for current := range ch {
+		results = append(results, current)
+	}
+	return results
+}
+
+func TestServer(t *testing.T) {
+	t.Run("the client adapts the rate to the bandwidth trace", func(t *testing.T) {
+		trace := []int64{8000, 2000, 16000, 4000}
+		server := NewServer(trace)
+		clnt := client.New("clienttest", "0.1.0")
+		results := run(t, server, clnt)
+		if err := clnt.Error(); err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 15 {
+			t.Fatal("unexpected number of results", len(results))
+		}
+		for idx, current := range results {
+			bandwidth := trace[min(idx, len(trace)-1)]
+			if current.Elapsed != float64(current.Received*8)/float64(bandwidth*1000) {
+				t.Fatal("unexpected elapsed time", idx, current.Elapsed)
+			}
+			if idx > 0 {
+				previous := trace[min(idx-1, len(trace)-1)]
+				if diff := current.Rate - previous; diff < -1 || diff > 1 {
+					t.Fatal("unexpected rate", idx, current.Rate)
+				}
+			}
+		}
+		if len(server.Segments()) != len(results) {
+			t.Fatal("unexpected number of segments")
+		}
+	})
+
+	t.Run("the simulation is deterministic", func(t *testing.T) {
+		simulate := func() []model.ClientResults {
+			server := NewServer([]int64{3000, 7000, 500})
+			server.RTT = 50 * time.Millisecond
+			return run(t, server, client.New("clienttest", "0.1.0"))
+		}
+		first, second := simulate(), simulate()
+		if len(first) != len(second) {
+			t.Fatal("expected the same number of results")
+		}
+		for idx := range first {
+			if first[idx].Rate != second[idx].Rate || first[idx].Elapsed != second[idx].Elapsed {
+				t.Fatal("expected the same results", idx)
+			}
+			if first[idx].Timestamp != second[idx].Timestamp {
+				t.Fatal("expected the same timestamps", idx)
+			}
+		}
+	})
+
+	t.Run("the virtual clock advances", func(t *testing.T) {
+		server := NewServer([]int64{1000})
+		server.RTT = time.Second
+		clnt := client.New("clienttest", "0.1.0")
+		results := run(t, server, clnt)
+		// the download time of the segments plus an RTT for negotiating and collecting
+		expected := 2 * server.RTT.Seconds()
+		for _, current := range results {
+			expected += current.Elapsed
+		}
+		if elapsed := server.Now().Sub(Epoch).Seconds(); elapsed-expected > 1e-6 || expected-elapsed > 1e-6 {
+			t.Fatal("unexpected time", server.Now())
+		}
+		if elapsed := clnt.FinalResult().Metadata.Elapsed; elapsed != server.Now().Sub(Epoch).Seconds() {
+			t.Fatal("unexpected elapsed time", elapsed)
+		}
+	})
+
+	t.Run("outages fail the downloads", func(t *testing.T) {
+		server := NewServer([]int64{4000, 0, 4000})
+		clnt := client.New("clienttest", "0.1.0")
+		clnt.Resilient = true
+		results := run(t, server, clnt)
+		if err := clnt.Error(); err != nil {
+			t.Fatal(err)
+		}
+		if results[1].Failure == "" || results[0].Failure != "" || results[2].Failure != "" {
+			t.Fatal("expected only the second segment to fail")
+		}
+	})
+
+	t.Run("unknown paths", func(t *testing.T) {
+		server := NewServer(nil)
+		req, err := http.NewRequest("GET", "https://"+FQDN+"/nonexistent", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := server.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			t.Fatal("Expected different status code")
+		}
+	})
+}
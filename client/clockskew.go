@@ -0,0 +1,84 @@
+package client
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/neubot/dash/model"
+)
+
+// ClockSkew is the estimated offset between the clocks of the client and
+// of the server. We estimate it like NTP does, assuming that the server
+// read its clock halfway between sending the request and receiving the
+// response headers, for negotiate and collect. We use the ServerTime field
+// of the negotiate response, when available, and otherwise the Date header,
+// whose one second resolution increases the uncertainty. We keep the
+// estimate with the lowest uncertainty.
+type ClockSkew struct {
+	// Corrected indicates that we shifted the timestamps of the server
+	// results by the offset (see the CorrectClockSkew client option).
+	Corrected bool `json:"corrected,omitempty"`
+
+	// Offset is the time of the server clock minus the time of the client
+	// clock in seconds, hence it is positive when the server is ahead.
+	Offset float64 `json:"offset"`
+
+	// Samples is the number of responses we used for the estimate.
+	Samples int64 `json:"samples"`
+
+	// Uncertainty is the maximum error of the Offset in seconds.
+	Uncertainty float64 `json:"uncertainty"`
+}
+
+// observeClock updates the clock skew estimate using a response whose headers
+// we received at received for a request we sent at sent. The serverTime is
+// the time, in seconds since the epoch, reported by the server in the body of
+// the response, or zero, in which case we use the Date header, if any.
+func (c *Client) observeClock(sent, received time.Time, resp *http.Response, serverTime float64) {
+	var resolution float64
+	if serverTime <= 0 {
+		date, err := http.ParseTime(resp.Header.Get("Date"))
+		if err != nil {
+			return
+		}
+		serverTime, resolution = float64(date.Unix()), 1
+	}
+	offset, uncertainty := estimateClockOffset(sent, received, serverTime, resolution)
+	if c.clockSkew == nil {
+		c.clockSkew = &ClockSkew{}
+	}
+	c.clockSkew.Samples++
+	if c.clockSkew.Samples == 1 || uncertainty < c.clockSkew.Uncertainty {
+		c.clockSkew.Offset, c.clockSkew.Uncertainty = offset, uncertainty
+	}
+}
+
+// estimateClockOffset estimates the clock offset, and its uncertainty, given
+// when we sent the request, when we received the response, and the server
+// time, which the server truncated to the given resolution in seconds.
+func estimateClockOffset(sent, received time.Time, serverTime, resolution float64) (float64, float64) {
+	halfRTT := received.Sub(sent).Seconds() / 2
+	midpoint := float64(sent.UnixNano())/1e9 + halfRTT
+	return serverTime + resolution/2 - midpoint, halfRTT + resolution/2
+}
+
+// correctClockSkew returns a copy of the clock skew estimate, if any, and,
+// when the CorrectClockSkew option is set, replaces the server results of
+// the given final result with a copy whose timestamps use the client clock.
+func (c *Client) correctClockSkew(final *FinalResult) *ClockSkew {
+	if c.clockSkew == nil {
+		return nil
+	}
+	skew := *c.clockSkew
+	if !c.CorrectClockSkew {
+		return &skew
+	}
+	shift := int64(math.Round(skew.Offset))
+	final.Server = append([]model.ServerResults{}, final.Server...)
+	for idx := range final.Server {
+		final.Server[idx].Timestamp -= shift
+	}
+	skew.Corrected = true
+	return &skew
+}
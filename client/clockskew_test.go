@@ -0,0 +1,97 @@
+package client
+
+import (
+	"math"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestEstimateClockOffset(t *testing.T) {
+	sent := time.Unix(1000, 0)
+	received := sent.Add(200 * time.Millisecond)
+	offset, uncertainty := estimateClockOffset(sent, received, 1002.1, 0)
+	if math.Abs(offset-2) > 1e-6 || math.Abs(uncertainty-0.1) > 1e-6 {
+		t.Fatal("unexpected estimate", offset, uncertainty)
+	}
+	offset, uncertainty = estimateClockOffset(sent, received, 1002, 1)
+	if math.Abs(offset-2.4) > 1e-6 || math.Abs(uncertainty-0.6) > 1e-6 {
+		t.Fatal("unexpected estimate", offset, uncertainty)
+	}
+}
+
+func TestClientObserveClock(t *testing.T) {
+	sent := time.Now()
+	received := sent.Add(100 * time.Millisecond)
+	withDate := &http.Response{Header: http.Header{
+		"Date": {sent.Add(time.Hour).UTC().Format(http.TimeFormat)},
+	}}
+
+	t.Run("without any server time", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.observeClock(sent, received, &http.Response{Header: http.Header{}}, 0)
+		if client.clockSkew != nil {
+			t.Fatal("expected no estimate")
+		}
+	})
+
+	t.Run("keeps the most precise estimate", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		serverTime := float64(received.UnixNano())/1e9 + 3600
+		client.observeClock(sent, received, withDate, serverTime)
+		client.observeClock(sent, received, withDate, 0)
+		skew := client.clockSkew
+		if skew.Samples != 2 {
+			t.Fatal("unexpected number of samples", skew.Samples)
+		}
+		if math.Abs(skew.Offset-3600.05) > 1e-3 || math.Abs(skew.Uncertainty-0.05) > 1e-6 {
+			t.Fatalf("unexpected estimate: %+v", skew)
+		}
+	})
+
+	t.Run("uses the Date header", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.observeClock(sent, received, withDate, 0)
+		if skew := client.clockSkew; math.Abs(skew.Offset-3600) > 1 {
+			t.Fatalf("unexpected estimate: %+v", skew)
+		}
+	})
+}
+
+func TestClientFinalResultClockSkew(t *testing.T) {
+	newClient := func() *Client {
+		client := New(softwareName, softwareVersion)
+		client.clockSkew = &ClockSkew{Offset: 9.7, Samples: 1, Uncertainty: 0.01}
+		client.serverResults = []model.ServerResults{{Timestamp: 1000}}
+		return client
+	}
+
+	t.Run("without correction", func(t *testing.T) {
+		final := newClient().FinalResult()
+		if final.Summary.ClockSkew.Corrected || final.Server[0].Timestamp != 1000 {
+			t.Fatal("expected no correction")
+		}
+	})
+
+	t.Run("with correction", func(t *testing.T) {
+		client := newClient()
+		client.CorrectClockSkew = true
+		for idx := 0; idx < 2; idx++ {
+			final := client.FinalResult()
+			if !final.Summary.ClockSkew.Corrected || final.Server[0].Timestamp != 990 {
+				t.Fatal("expected a correction", final.Server[0].Timestamp)
+			}
+		}
+		if client.serverResults[0].Timestamp != 1000 {
+			t.Fatal("expected not to modify the server results")
+		}
+	})
+
+	t.Run("without estimate", func(t *testing.T) {
+		if New(softwareName, softwareVersion).FinalResult().Summary.ClockSkew != nil {
+			t.Fatal("expected no estimate")
+		}
+	})
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"fmt"
+)
+
+// FinalConfig contains the effective configuration of the client, which
+// allows to tell exactly how a given measurement was parameterized. We
+// express the durations in seconds, where zero means that the corresponding
+// limit is disabled, and we omit the options that are disabled.
+type FinalConfig struct {
+	// AcceptEncoding is the AcceptEncoding option.
+	AcceptEncoding string `json:"accept_encoding,omitempty"`
+
+	// Adapter is the name of the built-in Adapter (see Adapters) or
+	// the Go type of the custom Adapter.
+	Adapter string `json:"adapter"`
+
+	// CacheBusting is the CacheBusting option.
+	CacheBusting bool `json:"cache_busting,omitempty"`
+
+	// CollectRetries is the CollectRetries option.
+	CollectRetries int `json:"collect_retries"`
+
+	// CorrectClockSkew is the CorrectClockSkew option.
+	CorrectClockSkew bool `json:"correct_clock_skew,omitempty"`
+
+	// CustomTransport indicates whether the caller configured the
+	// Transport, the DialContext, or the DialTLSContext option.
+	CustomTransport bool `json:"custom_transport,omitempty"`
+
+	// DSCP is the DSCP option.
+	DSCP int `json:"dscp,omitempty"`
+
+	// Iterations is the number of iterations we planned to perform.
+	Iterations int64 `json:"iterations"`
+
+	// LocateTargets is the LocateTargets option.
+	LocateTargets int `json:"locate_targets"`
+
+	// PinConnection is the PinConnection option.
+	PinConnection bool `json:"pin_connection,omitempty"`
+
+	// Rates contains the rates we sent when negotiating.
+	Rates []int64 `json:"rates"`
+
+	// RatesClamped indicates whether we clamped the rates of the segments
+	// to the Rates, i.e., whether the caller configured the Rates option.
+	RatesClamped bool `json:"rates_clamped,omitempty"`
+
+	// RateLimited indicates whether the caller configured the
+	// RequestLimiter option.
+	RateLimited bool `json:"rate_limited,omitempty"`
+
+	// Renegotiate is the Renegotiate option.
+	Renegotiate bool `json:"renegotiate,omitempty"`
+
+	// Resilient is the Resilient option.
+	Resilient bool `json:"resilient,omitempty"`
+
+	// SampleTCPInfo is the SampleTCPInfo option.
+	SampleTCPInfo bool `json:"sample_tcp_info,omitempty"`
+
+	// Scheme is the Scheme option.
+	Scheme string `json:"scheme"`
+
+	// SegmentDuration is the duration of each segment.
+	SegmentDuration float64 `json:"segment_duration"`
+
+	// SegmentTimeout is the SegmentTimeout option.
+	SegmentTimeout float64 `json:"segment_timeout"`
+
+	// StreamDuration is the duration of the emulated stream (omitted
+	// when not in stream emulation mode).
+	StreamDuration float64 `json:"stream_duration,omitempty"`
+
+	// StreamRate is the StreamRate option.
+	StreamRate int64 `json:"stream_rate,omitempty"`
+
+	// Streams is the Streams option.
+	Streams int64 `json:"streams,omitempty"`
+
+	// StrictPrivacy is the StrictPrivacy option.
+	StrictPrivacy bool `json:"strict_privacy,omitempty"`
+
+	// TargetBudget is the TargetBudget option.
+	TargetBudget float64 `json:"target_budget"`
+
+	// Timeout is the time between the beginning of the test and the
+	// deadline of the context passed to [*Client.StartDownload].
+	Timeout float64 `json:"timeout"`
+
+	// UseLastServer is the UseLastServer option.
+	UseLastServer bool `json:"use_last_server,omitempty"`
+
+	// UserAgentProfile is the UserAgentProfile option.
+	UserAgentProfile string `json:"user_agent_profile,omitempty"`
+}
+
+// finalConfig returns the effective configuration of the client.
+func (c *Client) finalConfig() *FinalConfig {
+	config := &FinalConfig{
+		AcceptEncoding:   c.AcceptEncoding,
+		Adapter:          adapterName(c.Adapter),
+		CacheBusting:     c.CacheBusting,
+		CollectRetries:   c.CollectRetries,
+		CorrectClockSkew: c.CorrectClockSkew,
+		CustomTransport:  c.Transport != nil || c.DialContext != nil || c.DialTLSContext != nil,
+		DSCP:             c.DSCP,
+		Iterations:       c.plannedIterations(),
+		LocateTargets:    c.LocateTargets,
+		PinConnection:    c.PinConnection,
+		Rates:            c.ladder(),
+		RatesClamped:     len(c.Rates) > 0,
+		RateLimited:      c.RequestLimiter != nil,
+		Renegotiate:      c.Renegotiate,
+		Resilient:        c.Resilient,
+		SampleTCPInfo:    c.SampleTCPInfo,
+		Scheme:           c.Scheme,
+		SegmentDuration:  segmentDuration.Seconds(),
+		SegmentTimeout:   c.SegmentTimeout.Seconds(),
+		StreamRate:       c.StreamRate,
+		Streams:          c.Streams,
+		StrictPrivacy:    c.StrictPrivacy,
+		TargetBudget:     c.TargetBudget.Seconds(),
+		Timeout:          c.timeout.Seconds(),
+		UseLastServer:    c.UseLastServer,
+		UserAgentProfile: c.UserAgentProfile,
+	}
+	if c.StreamRate > 0 {
+		config.StreamDuration = float64(c.streamDurationSeconds())
+	}
+	return config
+}
+
+// saveTimeout saves the time between the beginning of the test and
+// the deadline of the given context, if any.
+func (c *Client) saveTimeout(ctx context.Context) {
+	c.timeout = 0
+	if deadline, found := ctx.Deadline(); found {
+		c.timeout = deadline.Sub(c.begin)
+	}
+}
+
+// adapterName returns the name of the built-in adapter or the Go
+// type of the custom adapter.
+func adapterName(adapter Adapter) string {
+	switch adapter.(type) {
+	case ThroughputAdapter, *ThroughputAdapter:
+		return "throughput"
+	case *BufferAdapter:
+		return "buffer"
+	case *BOLAAdapter:
+		return "bola"
+	default:
+		return fmt.Sprintf("%T", adapter)
+	}
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/neubot/dash/model"
+	"github.com/neubot/dash/spec"
+)
+
+type customAdapter struct{}
+
+func (customAdapter) NextRate(history []model.ClientResults) int64 {
+	return 100
+}
+
+func TestClientFinalConfig(t *testing.T) {
+	t.Run("with the default configuration", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		config := client.FinalResult().Metadata.Config
+		if config.Adapter != "throughput" || config.Iterations != 15 || config.Scheme != "https" {
+			t.Fatalf("unexpected config: %+v", config)
+		}
+		if !slices.Equal(config.Rates, spec.DefaultRates) || config.RatesClamped {
+			t.Fatal("unexpected rates", config.Rates)
+		}
+		if config.SegmentDuration != 2 || config.StreamDuration != 0 || config.Timeout != 0 {
+			t.Fatalf("unexpected durations: %+v", config)
+		}
+	})
+
+	t.Run("with a custom configuration", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.Adapter = customAdapter{}
+		client.Rates = []int64{500, 1000}
+		client.SegmentTimeout = 5 * time.Second
+		client.StreamDuration = 60 * time.Second
+		client.StreamRate = 1000
+		config := client.finalConfig()
+		if config.Adapter != "client.customAdapter" || !config.RatesClamped {
+			t.Fatalf("unexpected config: %+v", config)
+		}
+		if config.Iterations != 30 || config.SegmentTimeout != 5 || config.StreamDuration != 60 {
+			t.Fatalf("unexpected config: %+v", config)
+		}
+	})
+
+	t.Run("we save the timeout of the context", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.begin = time.Now()
+		ctx, cancel := context.WithDeadline(context.Background(), client.begin.Add(30*time.Second))
+		defer cancel()
+		client.saveTimeout(ctx)
+		if config := client.finalConfig(); config.Timeout != 30 {
+			t.Fatal("unexpected timeout", config.Timeout)
+		}
+	})
+
+	t.Run("we name the built-in adapters", func(t *testing.T) {
+		for _, name := range Adapters() {
+			adapter, err := NewAdapter(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if adapterName(adapter) != name {
+				t.Fatal("unexpected name", adapterName(adapter))
+			}
+		}
+	})
+}
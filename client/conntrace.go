@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http/httptrace"
+	"sync"
+)
+
+// connTracer records the connection used by an HTTP request using
+// [httptrace.ClientTrace] hooks, which allows to know the local address
+// (e.g., to find the outgoing interface) and the remote address (e.g., to
+// tell apart the servers behind a multi-homed or load-balanced service).
+type connTracer struct {
+	// conn is the connection or nil.
+	conn net.Conn
+
+	// mtx protects conn.
+	mtx sync.Mutex
+
+	// onConn is the optional function called with the connection as soon
+	// as we have it (e.g., to sample TCP_INFO before the transfer).
+	onConn func(conn net.Conn)
+}
+
+// wrap returns a context configured to use the tracer hooks.
+func (ct *connTracer) wrap(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: ct.gotConn,
+	})
+}
+
+// gotConn is called when we have a connection for the request.
+func (ct *connTracer) gotConn(info httptrace.GotConnInfo) {
+	if info.Conn == nil {
+		return
+	}
+	ct.mtx.Lock()
+	ct.conn = info.Conn
+	ct.mtx.Unlock()
+	if ct.onConn != nil {
+		ct.onConn(info.Conn)
+	}
+}
+
+// get returns the connection or nil if we did not get a connection.
+func (ct *connTracer) get() net.Conn {
+	ct.mtx.Lock()
+	defer ct.mtx.Unlock()
+	return ct.conn
+}
+
+// localAddr returns the local address or nil if we did not get a connection.
+func (ct *connTracer) localAddr() net.Addr {
+	conn := ct.get()
+	if conn == nil {
+		return nil
+	}
+	return conn.LocalAddr()
+}
+
+// remoteAddress returns the IP address of the remote endpoint or an empty
+// string if we did not get a connection.
+func (ct *connTracer) remoteAddress() string {
+	conn := ct.get()
+	if conn == nil {
+		return ""
+	}
+	address, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return conn.RemoteAddr().String()
+	}
+	return address
+}
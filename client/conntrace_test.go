@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestClientDownloadRecordsRemoteAddress(t *testing.T) {
+	srvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("abc"))
+	}))
+	defer srvr.Close()
+	URL, err := url.Parse(srvr.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(softwareName, softwareVersion)
+	client.HTTPClient = &http.Client{Transport: &http.Transport{}}
+	current := &model.ClientResults{Rate: 100, ElapsedTarget: 2}
+	if err := client.download(context.Background(), "abc", current, URL); err != nil {
+		t.Fatal(err)
+	}
+	if current.RemoteAddress != "127.0.0.1" {
+		t.Fatal("unexpected remote address", current.RemoteAddress)
+	}
+}
+
+func TestConnTracer(t *testing.T) {
+	t.Run("without a connection", func(t *testing.T) {
+		tracer := &connTracer{}
+		if tracer.get() != nil || tracer.localAddr() != nil || tracer.remoteAddress() != "" {
+			t.Fatal("expected no connection information")
+		}
+	})
+
+	t.Run("with a connection", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		var observed net.Conn
+		tracer := &connTracer{onConn: func(conn net.Conn) { observed = conn }}
+		tracer.gotConn(httptrace.GotConnInfo{Conn: conn})
+		if observed != conn {
+			t.Fatal("expected to observe the connection")
+		}
+		if tracer.get() != conn || tracer.localAddr().String() != conn.LocalAddr().String() {
+			t.Fatal("unexpected connection information")
+		}
+		if tracer.remoteAddress() != "127.0.0.1" {
+			t.Fatal("unexpected remote address", tracer.remoteAddress())
+		}
+	})
+}
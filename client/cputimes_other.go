@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || windows)
+
+package client
+
+import "time"
+
+// processCPUTimes always fails on this platform because we do not
+// know how to obtain the CPU times of the current process.
+func processCPUTimes() (user, sys time.Duration, err error) {
+	return 0, 0, errCPUTimesNotSupported
+}
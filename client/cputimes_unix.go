@@ -0,0 +1,20 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package client
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTimes returns the user and system CPU times consumed
+// so far by the current process, using getrusage.
+func processCPUTimes() (user, sys time.Duration, err error) {
+	var ru syscall.Rusage
+	if err = syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return
+	}
+	user = time.Duration(ru.Utime.Nano())
+	sys = time.Duration(ru.Stime.Nano())
+	return
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package client
+
+import (
+	"syscall"
+	"time"
+)
+
+// processCPUTimes returns the user and system CPU times consumed
+// so far by the current process, using GetProcessTimes.
+func processCPUTimes() (user, sys time.Duration, err error) {
+	process, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return
+	}
+	var creation, exit, kernel, usermode syscall.Filetime
+	if err = syscall.GetProcessTimes(process, &creation, &exit, &kernel, &usermode); err != nil {
+		return
+	}
+	user = filetimeDuration(usermode)
+	sys = filetimeDuration(kernel)
+	return
+}
+
+// filetimeDuration converts a Filetime containing a duration, expressed
+// in 100-nanosecond intervals, to a [time.Duration].
+func filetimeDuration(ft syscall.Filetime) time.Duration {
+	return time.Duration(int64(ft.HighDateTime)<<32|int64(ft.LowDateTime)) * 100
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neubot/dash/model"
+)
+
+// unknownResolver is the name of the resolver we use when we cannot
+// tell which nameservers the system is configured to use (e.g., on
+// systems without the resolv.conf file).
+const unknownResolver = "system"
+
+// resolvConfPath is the path of the resolv.conf file.
+const resolvConfPath = "/etc/resolv.conf"
+
+// systemResolver returns the name of the resolver we use, i.e., the
+// resolver configured for the system, which we use through the
+// [net.DefaultResolver] of the standard library. We read the
+// resolv.conf file only once, so we do not notice later changes.
+var systemResolver = sync.OnceValue(func() string {
+	return readResolver(resolvConfPath)
+})
+
+// readResolver returns the comma-separated nameservers configured in
+// the given resolv.conf file or the unknownResolver on failure.
+func readResolver(filename string) string {
+	filep, err := os.Open(filename)
+	if err != nil {
+		return unknownResolver
+	}
+	defer filep.Close()
+	return parseResolver(filep)
+}
+
+// parseResolver is like readResolver but reads from the given reader.
+func parseResolver(reader io.Reader) string {
+	var nameservers []string
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			nameservers = append(nameservers, fields[1])
+		}
+	}
+	if scanner.Err() != nil || len(nameservers) < 1 {
+		return unknownResolver
+	}
+	return strings.Join(nameservers, ",")
+}
+
+// dnsTracer records the DNS resolution details of an HTTP request
+// using [httptrace.ClientTrace] hooks.
+type dnsTracer struct {
+	// mtx protects the fields below.
+	mtx sync.Mutex
+
+	// results contains the results or nil if no lookup occurred.
+	results *model.DNSResults
+
+	// started is when the DNS lookup started.
+	started time.Time
+}
+
+// wrap returns a context configured to use the tracer hooks.
+func (dt *dnsTracer) wrap(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: dt.dnsStart,
+		DNSDone:  dt.dnsDone,
+		GotConn:  dt.gotConn,
+	})
+}
+
+// dnsStart is called when the DNS lookup starts.
+func (dt *dnsTracer) dnsStart(info httptrace.DNSStartInfo) {
+	dt.mtx.Lock()
+	defer dt.mtx.Unlock()
+	dt.started = time.Now()
+}
+
+// dnsDone is called when the DNS lookup completes.
+func (dt *dnsTracer) dnsDone(info httptrace.DNSDoneInfo) {
+	dt.mtx.Lock()
+	defer dt.mtx.Unlock()
+	results := &model.DNSResults{
+		Addresses: []string{},
+		Latency:   time.Since(dt.started).Seconds(),
+		Resolver:  systemResolver(),
+	}
+	for _, addr := range info.Addrs {
+		results.Addresses = append(results.Addresses, addr.String())
+	}
+	if info.Err != nil {
+		results.Failure = info.Err.Error()
+	}
+	dt.results = results
+}
+
+// gotConn is called when we have a connection for the request.
+func (dt *dnsTracer) gotConn(info httptrace.GotConnInfo) {
+	dt.mtx.Lock()
+	defer dt.mtx.Unlock()
+	if dt.results == nil || info.Conn == nil {
+		return
+	}
+	if host, _, err := net.SplitHostPort(info.Conn.RemoteAddr().String()); err == nil {
+		dt.results.UsedAddress = host
+	}
+}
+
+// get returns the DNS results or nil if no lookup occurred.
+func (dt *dnsTracer) get() *model.DNSResults {
+	dt.mtx.Lock()
+	defer dt.mtx.Unlock()
+	return dt.results
+}
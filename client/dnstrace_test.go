@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestClientDownloadRecordsDNS(t *testing.T) {
+	srvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("abc"))
+	}))
+	defer srvr.Close()
+	URL, err := url.Parse(srvr.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	URL.Host = "localhost:" + URL.Port()
+	client := New(softwareName, softwareVersion)
+	client.HTTPClient = &http.Client{Transport: &http.Transport{}}
+	current := &model.ClientResults{Rate: 100, ElapsedTarget: 2}
+	if err := client.download(context.Background(), "abc", current, URL); err != nil {
+		t.Fatal(err)
+	}
+	if current.DNS == nil {
+		t.Fatal("expected DNS results")
+	}
+	if len(current.DNS.Addresses) < 1 || current.DNS.Resolver != systemResolver() {
+		t.Fatalf("unexpected DNS results: %+v", current.DNS)
+	}
+	if current.DNS.UsedAddress == "" || current.DNS.Failure != "" {
+		t.Fatalf("unexpected DNS results: %+v", current.DNS)
+	}
+
+	t.Run("the first iteration falls back to the negotiate lookup", func(t *testing.T) {
+		negotiateDNS := &model.DNSResults{Resolver: systemResolver()}
+		client.negotiateDNS = negotiateDNS
+		current := &model.ClientResults{Rate: 100, ElapsedTarget: 2}
+		if err := client.download(context.Background(), "abc", current, URL); err != nil {
+			t.Fatal(err)
+		}
+		if current.DNS != negotiateDNS {
+			t.Fatal("expected the negotiate DNS results")
+		}
+		current.Iteration++
+		if err := client.download(context.Background(), "abc", current, URL); err != nil {
+			t.Fatal(err)
+		}
+		if current.DNS != nil {
+			t.Fatal("expected no DNS results")
+		}
+	})
+}
+
+func TestReadResolver(t *testing.T) {
+	t.Run("with nameservers", func(t *testing.T) {
+		reader := strings.NewReader("# comment\nsearch example.com\nnameserver 8.8.8.8\nnameserver  ::1\noptions ndots:1\n")
+		if resolver := parseResolver(reader); resolver != "8.8.8.8,::1" {
+			t.Fatal("unexpected resolver", resolver)
+		}
+	})
+
+	t.Run("without nameservers", func(t *testing.T) {
+		if resolver := parseResolver(strings.NewReader("search example.com\n")); resolver != unknownResolver {
+			t.Fatal("unexpected resolver", resolver)
+		}
+	})
+
+	t.Run("without the file", func(t *testing.T) {
+		if resolver := readResolver(filepath.Join(t.TempDir(), "resolv.conf")); resolver != unknownResolver {
+			t.Fatal("unexpected resolver", resolver)
+		}
+	})
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd)
+
+package client
+
+import "syscall"
+
+// setDSCP always fails on this platform because we do not know
+// how to set the DSCP field of the socket.
+func setDSCP(network string, rc syscall.RawConn, dscp int) error {
+	return errDSCPNotSupported
+}
@@ -0,0 +1,23 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package client
+
+import "syscall"
+
+// setDSCP sets the DSCP field of the traffic class (IPv6) or type
+// of service (IPv4) byte of the socket referenced by rc.
+func setDSCP(network string, rc syscall.RawConn, dscp int) (err error) {
+	tos := dscp << 2 // the two least significant bits are for ECN
+	cerr := rc.Control(func(fd uintptr) {
+		switch network {
+		case "tcp6", "udp6":
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+		default:
+			err = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		}
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return
+}
@@ -0,0 +1,293 @@
+package client
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/neubot/dash/internal"
+	"github.com/neubot/dash/model"
+)
+
+const (
+	// eventsAcceptGUID is the GUID defined by RFC 6455 for computing
+	// the Sec-WebSocket-Accept header.
+	eventsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	// eventsMaxFrameSize is the maximum size of the frames we accept from
+	// subscribers, which have no reason to send us anything big.
+	eventsMaxFrameSize = 1 << 16
+
+	// eventsQueueSize is the number of events we queue for each subscriber
+	// before dropping events, so a slow subscriber cannot block the test.
+	eventsQueueSize = 64
+
+	// eventsWriteTimeout is the maximum time for writing an event.
+	eventsWriteTimeout = 10 * time.Second
+)
+
+const (
+	// These are the WebSocket opcodes we use.
+	eventsOpcodeText  = 0x1
+	eventsOpcodeClose = 0x8
+)
+
+// errEventsFrameTooLarge indicates that a subscriber sent a frame
+// larger than eventsMaxFrameSize.
+var errEventsFrameTooLarge = errors.New("events: frame too large")
+
+// EventServer streams JSON events to local WebSocket subscribers (e.g., an
+// Electron GUI visualizing an in-progress test). Use it as an http.Handler
+// and call Publish for each event (e.g., each [model.ClientResults] posted
+// on the channel returned by [*Client.StartDownload]).
+//
+// Because the events contain the results, including the addresses, we only
+// accept subscribers that are not browsers or whose Origin is local (i.e.,
+// a file:// page, localhost, or a loopback address). You should also make
+// sure you only listen on a loopback address.
+type EventServer struct {
+	// Logger is the logger to use. By default NewEventServer sets this
+	// field to a logger that does not emit any message.
+	Logger model.Logger
+
+	// mtx protects subscribers.
+	mtx sync.Mutex
+
+	// subscribers contains the current subscribers.
+	subscribers map[*eventSubscriber]struct{}
+}
+
+// NewEventServer creates a new EventServer.
+func NewEventServer() *EventServer {
+	return &EventServer{
+		Logger:      internal.NoLogger{},
+		mtx:         sync.Mutex{},
+		subscribers: make(map[*eventSubscriber]struct{}),
+	}
+}
+
+// eventSubscriber is a subscriber of an EventServer.
+type eventSubscriber struct {
+	// conn is the hijacked connection.
+	conn net.Conn
+
+	// done is closed when the subscriber goes away.
+	done chan struct{}
+
+	// queue contains the events to write.
+	queue chan []byte
+}
+
+// Publish marshals the given event to JSON and sends it to all the current
+// subscribers. We drop the event for the subscribers that are too slow.
+func (s *EventServer) Publish(event interface{}) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for sub := range s.subscribers {
+		select {
+		case sub.queue <- data:
+		default:
+			s.Logger.Debug("dash: events: dropping event for slow subscriber")
+		}
+	}
+	return nil
+}
+
+// ServeHTTP implements http.Handler by upgrading the connection to
+// WebSocket and streaming the published events until the subscriber
+// closes the connection or we fail to write an event.
+func (s *EventServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// 1. refuse pages loaded from somewhere else
+	if !eventsAllowedOrigin(r.Header.Get("Origin")) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	// 2. make sure this is a WebSocket handshake we support
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != "GET" || key == "" ||
+		!eventsHeaderContainsToken(r.Header, "Connection", "upgrade") ||
+		!eventsHeaderContainsToken(r.Header, "Upgrade", "websocket") {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		w.WriteHeader(http.StatusUpgradeRequired)
+		return
+	}
+
+	// 3. take control of the connection and complete the handshake
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		s.Logger.Warnf("dash: events: cannot hijack: %s", err.Error())
+		return
+	}
+	conn.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	rw.WriteString("Upgrade: websocket\r\n")
+	rw.WriteString("Connection: Upgrade\r\n")
+	rw.WriteString("Sec-WebSocket-Accept: " + eventsAcceptKey(key) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return
+	}
+
+	// 4. stream the events until the subscriber goes away
+	sub := &eventSubscriber{
+		conn:  conn,
+		done:  make(chan struct{}),
+		queue: make(chan []byte, eventsQueueSize),
+	}
+	s.mtx.Lock()
+	s.subscribers[sub] = struct{}{}
+	s.mtx.Unlock()
+	go sub.writeLoop()
+	sub.readLoop(rw.Reader)
+	s.mtx.Lock()
+	delete(s.subscribers, sub)
+	s.mtx.Unlock()
+	close(sub.done)
+}
+
+// readLoop reads and discards the frames sent by the subscriber, which
+// we need to do to notice when the subscriber goes away.
+func (sub *eventSubscriber) readLoop(reader *bufio.Reader) {
+	for {
+		opcode, err := eventsReadFrame(reader)
+		if err != nil || opcode == eventsOpcodeClose {
+			return
+		}
+	}
+}
+
+// writeLoop writes the queued events until the subscriber goes away, in
+// which case it sends a close frame, or until a write fails.
+func (sub *eventSubscriber) writeLoop() {
+	defer sub.conn.Close()
+	for {
+		select {
+		case data := <-sub.queue:
+			sub.conn.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+			if _, err := sub.conn.Write(eventsFrame(eventsOpcodeText, data)); err != nil {
+				return
+			}
+		case <-sub.done:
+			sub.conn.SetWriteDeadline(time.Now().Add(eventsWriteTimeout))
+			sub.conn.Write(eventsFrame(eventsOpcodeClose, nil))
+			return
+		}
+	}
+}
+
+// eventsFrame returns an unmasked final frame with the given opcode
+// and payload, which is what a WebSocket server sends.
+func eventsFrame(opcode byte, payload []byte) []byte {
+	frame := []byte{0x80 | opcode}
+	switch length := len(payload); {
+	case length < 126:
+		frame = append(frame, byte(length))
+	case length <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(length))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(length))
+	}
+	return append(frame, payload...)
+}
+
+// eventsReadFrame reads a frame, discarding its payload, and returns
+// its opcode. We do not need to unmask the payload to discard it.
+func eventsReadFrame(reader *bufio.Reader) (byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, err
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(reader, extended[:]); err != nil {
+			return 0, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(reader, extended[:]); err != nil {
+			return 0, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+	}
+	if length > eventsMaxFrameSize {
+		return 0, errEventsFrameTooLarge
+	}
+	if header[1]&0x80 != 0 {
+		length += 4 // the masking key
+	}
+	if _, err := io.CopyN(io.Discard, reader, int64(length)); err != nil {
+		return 0, err
+	}
+	return header[0] & 0x0f, nil
+}
+
+// eventsAcceptKey computes the Sec-WebSocket-Accept header value.
+func eventsAcceptKey(key string) string {
+	digest := sha1.Sum([]byte(key + eventsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(digest[:])
+}
+
+// eventsHeaderContainsToken returns whether the given comma separated
+// header contains the given token, ignoring the case.
+func eventsHeaderContainsToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, entry := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(entry), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// eventsAllowedOrigin returns whether we accept a subscriber with the given
+// Origin header, i.e., a subscriber that is not a browser, a page loaded from
+// the filesystem (e.g., by Electron), or a page served by localhost.
+func eventsAllowedOrigin(origin string) bool {
+	if origin == "" {
+		return true
+	}
+	URL, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if URL.Scheme == "file" {
+		return true
+	}
+	host := URL.Hostname()
+	if host == "localhost" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEventServer(t *testing.T) {
+	events := NewEventServer()
+	srvr := httptest.NewServer(events)
+	defer srvr.Close()
+
+	subscribe := func(t *testing.T, origin string) (net.Conn, *bufio.Reader, *http.Response) {
+		conn, err := net.Dial("tcp", srvr.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := http.NewRequest("GET", srvr.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if err := req.Write(conn); err != nil {
+			t.Fatal(err)
+		}
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return conn, reader, resp
+	}
+
+	waitSubscribers := func(t *testing.T, count int) {
+		for idx := 0; idx < 100; idx++ {
+			events.mtx.Lock()
+			current := len(events.subscribers)
+			events.mtx.Unlock()
+			if current == count {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatal("unexpected number of subscribers")
+	}
+
+	t.Run("streams the published events", func(t *testing.T) {
+		conn, reader, resp := subscribe(t, "file://")
+		defer conn.Close()
+		if resp.StatusCode != 101 {
+			t.Fatal("Expected different status code")
+		}
+		// See the example in RFC 6455 Section 1.3.
+		if resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			t.Fatal("unexpected accept key")
+		}
+		waitSubscribers(t, 1)
+		if err := events.Publish(map[string]int{"iteration": 1}); err != nil {
+			t.Fatal(err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(reader, header); err != nil {
+			t.Fatal(err)
+		}
+		if header[0] != 0x80|eventsOpcodeText {
+			t.Fatal("unexpected first byte", header[0])
+		}
+		payload := make([]byte, header[1])
+		if _, err := io.ReadFull(reader, payload); err != nil {
+			t.Fatal(err)
+		}
+		if string(payload) != `{"iteration":1}` {
+			t.Fatal("unexpected payload", string(payload))
+		}
+
+		// send a masked close frame and expect the close frame back
+		if _, err := conn.Write([]byte{0x80 | eventsOpcodeClose, 0x80, 1, 2, 3, 4}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(reader, header); err != nil {
+			t.Fatal(err)
+		}
+		if header[0] != 0x80|eventsOpcodeClose {
+			t.Fatal("unexpected first byte", header[0])
+		}
+		waitSubscribers(t, 0)
+	})
+
+	t.Run("refuses remote origins", func(t *testing.T) {
+		conn, _, resp := subscribe(t, "https://evil.example.com")
+		defer conn.Close()
+		if resp.StatusCode != 403 {
+			t.Fatal("Expected different status code")
+		}
+	})
+
+	t.Run("refuses plain requests", func(t *testing.T) {
+		resp, err := http.Get(srvr.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Fatal("Expected different status code")
+		}
+	})
+}
+
+func TestEventsFrame(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		length       int
+		expectHeader int
+	}{{
+		name:         "small",
+		length:       125,
+		expectHeader: 2,
+	}, {
+		name:         "medium",
+		length:       0xffff,
+		expectHeader: 4,
+	}, {
+		name:         "large",
+		length:       0x10000,
+		expectHeader: 10,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			frame := eventsFrame(eventsOpcodeText, make([]byte, tc.length))
+			if len(frame) != tc.expectHeader+tc.length {
+				t.Fatal("unexpected frame length", len(frame))
+			}
+			switch tc.expectHeader {
+			case 4:
+				if binary.BigEndian.Uint16(frame[2:]) != uint16(tc.length) {
+					t.Fatal("unexpected extended length")
+				}
+			case 10:
+				if binary.BigEndian.Uint64(frame[2:]) != uint64(tc.length) {
+					t.Fatal("unexpected extended length")
+				}
+			}
+		})
+	}
+}
+
+func TestEventsAllowedOrigin(t *testing.T) {
+	for origin, expect := range map[string]bool{
+		"":                          true,
+		"file://":                   true,
+		"http://localhost:3000":     true,
+		"http://127.0.0.1:8080":     true,
+		"http://[::1]:8080":         true,
+		"https://evil.example.com":  false,
+		"http://192.168.1.1":        false,
+		"http://localhost.evil.com": false,
+	} {
+		if eventsAllowedOrigin(origin) != expect {
+			t.Fatal("unexpected result for", origin)
+		}
+	}
+}
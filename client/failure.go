@@ -0,0 +1,74 @@
+package client
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/neubot/dash/model"
+)
+
+// These are the phases of the test that may fail.
+const (
+	phaseSetup     = "setup"
+	phaseLocate    = "locate"
+	phaseNegotiate = "negotiate"
+	phaseDownload  = "download"
+	phaseUpload    = "upload"
+	phaseCollect   = "collect"
+)
+
+// httpStatusError is the error returned when the server responds with
+// a non-successful status code. It matches errHTTPRequestFailed.
+type httpStatusError struct {
+	// StatusCode is the status code returned by the server.
+	StatusCode int
+}
+
+// Error implements error.
+func (err *httpStatusError) Error() string {
+	return errHTTPRequestFailed.Error()
+}
+
+// Is allows errors.Is to match errHTTPRequestFailed.
+func (err *httpStatusError) Is(target error) bool {
+	return target == errHTTPRequestFailed
+}
+
+// fail records the failure report of the given phase and error and
+// returns the error, which allows to use this method when returning.
+func (c *Client) fail(phase string, server *url.URL, err error) error {
+	report := &model.FailureReport{
+		Elapsed:    c.TimeNow().Sub(c.begin).Seconds(),
+		Failure:    err.Error(),
+		Iterations: int64(len(c.clientResults)),
+		Phase:      phase,
+	}
+	if server != nil {
+		report.Server = server.String()
+	}
+	var statusErr *httpStatusError
+	if errors.As(err, &statusErr) {
+		report.HTTPStatus = statusErr.StatusCode
+	}
+	var portalErr *captivePortalError
+	if errors.As(err, &portalErr) {
+		report.CaptivePortal = portalErr.Evidence
+	}
+	var redirectErr *redirectError
+	if errors.As(err, &redirectErr) {
+		report.HTTPStatus = redirectErr.StatusCode
+		report.Redirect = redirectErr.Location
+	}
+	c.failureReport = report
+	return err
+}
+
+// FailureReport returns the structured description of the failure that
+// occurred during the test, or nil when the test did not fail. Use this
+// method when [*Client.StartDownload] or [*Client.Error] return an error.
+//
+// To avoid data races you MUST call this method after the channel
+// returned by [*Client.StartDownload] has been drained.
+func (c *Client) FailureReport() *model.FailureReport {
+	return c.failureReport
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestHTTPStatusError(t *testing.T) {
+	var err error = &httpStatusError{StatusCode: 503}
+	if !errors.Is(err, errHTTPRequestFailed) {
+		t.Fatal("expected to match errHTTPRequestFailed")
+	}
+	if err.Error() != errHTTPRequestFailed.Error() {
+		t.Fatal("unexpected error string", err.Error())
+	}
+}
+
+func TestClientFailureReport(t *testing.T) {
+	serverURL := &url.URL{Scheme: "https", Host: "dash.example.com", Path: "/negotiate/dash"}
+
+	t.Run("on success", func(t *testing.T) {
+		client := newPairedClient("dash.example.com", 1000, nil)
+		ch := make(chan model.ClientResults)
+		go client.loop(context.Background(), ch, serverURL)
+		for range ch {
+			// drain channel
+		}
+		if client.FailureReport() != nil {
+			t.Fatal("expected no failure report")
+		}
+	})
+
+	t.Run("on locate failure", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.deps.Locator = &failingLocator{}
+		if _, err := client.StartDownload(context.Background()); err == nil {
+			t.Fatal("Expected an error here")
+		}
+		report := client.FailureReport()
+		if report == nil || report.Phase != phaseLocate || report.Failure != "mocked error" {
+			t.Fatal("unexpected failure report", report)
+		}
+	})
+
+	t.Run("on negotiate failure", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.HTTPClient = &http.Client{Transport: roundTripperFunc(
+			func(req *http.Request) (*http.Response, error) {
+				return &http.Response{
+					Body:       http.NoBody,
+					StatusCode: 503,
+				}, nil
+			},
+		)}
+		ch := make(chan model.ClientResults)
+		go client.loop(context.Background(), ch, serverURL)
+		for range ch {
+			// drain channel
+		}
+		report := client.FailureReport()
+		if report == nil || report.Phase != phaseNegotiate {
+			t.Fatal("unexpected failure report", report)
+		}
+		if report.HTTPStatus != 503 || report.Server != serverURL.String() {
+			t.Fatal("unexpected failure report", report)
+		}
+	})
+
+	t.Run("on download failure", func(t *testing.T) {
+		client := newPairedClient("dash.example.com", 1000, nil)
+		client.deps.Download = func(
+			ctx context.Context, authorization string,
+			current *model.ClientResults, negotiateURL *url.URL,
+		) error {
+			return errors.New("Mocked error")
+		}
+		ch := make(chan model.ClientResults)
+		go client.loop(context.Background(), ch, serverURL)
+		for range ch {
+			// drain channel
+		}
+		report := client.FailureReport()
+		if report == nil || report.Phase != phaseDownload || report.Iterations != 0 {
+			t.Fatal("unexpected failure report", report)
+		}
+		if !strings.Contains(report.Failure, "Mocked error") {
+			t.Fatal("unexpected failure", report.Failure)
+		}
+	})
+
+	t.Run("on collect failure", func(t *testing.T) {
+		client := newPairedClient("dash.example.com", 1000, nil)
+		client.deps.Collect = func(ctx context.Context, authorization string, negotiateURL *url.URL) error {
+			return errors.New("Mocked error")
+		}
+		ch := make(chan model.ClientResults)
+		go client.loop(context.Background(), ch, serverURL)
+		for range ch {
+			// drain channel
+		}
+		report := client.FailureReport()
+		if report == nil || report.Phase != phaseCollect || report.Iterations != client.Iterations {
+			t.Fatal("unexpected failure report", report)
+		}
+	})
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"maps"
+	"runtime"
+
+	"github.com/neubot/dash/model"
+)
+
+// FinalResult combines in a single document all the results of a test,
+// i.e., what the client measured, what the server measured, a summary,
+// metadata about the test, and the failure, if any.
+type FinalResult struct {
+	// Client contains the results measured by the client.
+	Client []model.ClientResults `json:"client"`
+
+	// Failure describes why the test failed or is nil on success.
+	Failure *model.FailureReport `json:"failure,omitempty"`
+
+	// Metadata contains metadata about the test.
+	Metadata *FinalMetadata `json:"metadata"`
+
+	// Server contains the results measured by the server, which is
+	// empty when the test failed before collecting.
+	Server []model.ServerResults `json:"server"`
+
+	// Summary summarizes the results measured by the client.
+	Summary *FinalSummary `json:"summary"`
+}
+
+// FinalMetadata contains the metadata of [FinalResult].
+type FinalMetadata struct {
+	// ClientName is the name of the application using this library.
+	ClientName string `json:"client_name"`
+
+	// ClientVersion is the version of the application.
+	ClientVersion string `json:"client_version"`
+
+	// Config contains the effective configuration of the client.
+	Config *FinalConfig `json:"config"`
+
+	// Elapsed is the duration of the test in seconds.
+	Elapsed float64 `json:"elapsed"`
+
+	// LibraryName is the name of this library.
+	LibraryName string `json:"library_name"`
+
+	// LibraryVersion is the version of this library.
+	LibraryVersion string `json:"library_version"`
+
+	// MLab contains the M-Lab annotation fields derived from the hostname
+	// of the Server (omitted when the Server is not an M-Lab server).
+	MLab *MLabAnnotation `json:"mlab,omitempty"`
+
+	// Platform is the platform where the client is running.
+	Platform string `json:"platform"`
+
+	// ProbeID is the random identifier of the client, which is different
+	// for each run unless the user opted in the persistent probe ID (see
+	// the PersistProbeID field of [*Client]), in which case it is the same
+	// for all the runs using the same CacheDir.
+	ProbeID string `json:"probe_id"`
+
+	// ProbeIDPersistent indicates whether the ProbeID is persistent
+	// (omitted when false).
+	ProbeIDPersistent bool `json:"probe_id_persistent,omitempty"`
+
+	// RunMetadata contains the metadata attached to the run using
+	// [WithRunMetadata] (omitted when empty).
+	RunMetadata map[string]string `json:"run_metadata,omitempty"`
+
+	// Server is the negotiate URL of the server or empty when the
+	// test failed before discovering the server.
+	Server string `json:"server,omitempty"`
+
+	// Timestamp is when the test started, in seconds since the epoch.
+	Timestamp int64 `json:"timestamp"`
+}
+
+// FinalSummary contains the summary of [FinalResult].
+type FinalSummary struct {
+	// ArrivalJitter is the jitter in seconds of the completion times of the
+	// successful segments, i.e., the mean absolute difference between
+	// consecutive intervals between segment completions, which predicts
+	// rebuffering even at high average rates (omitted when we have fewer
+	// than three successful segments).
+	ArrivalJitter float64 `json:"arrival_jitter,omitempty"`
+
+	// ClockSkew is the estimated offset between the clocks of the client
+	// and of the server (omitted when we could not estimate it).
+	ClockSkew *ClockSkew `json:"clock_skew,omitempty"`
+
+	// Failures is the number of iterations that failed in resilient mode.
+	Failures int64 `json:"failures"`
+
+	// Iterations is the number of iterations we performed.
+	Iterations int64 `json:"iterations"`
+
+	// MedianRate is the median rate of the successful iterations in kbit/s.
+	MedianRate float64 `json:"median_rate"`
+
+	// Received is the number of body bytes received.
+	Received int64 `json:"received"`
+
+	// StreamSustained indicates whether the network sustained the rate in
+	// stream emulation mode (omitted when not in stream emulation mode).
+	StreamSustained *bool `json:"stream_sustained,omitempty"`
+}
+
+// FinalResult returns all the results of the test combined in a single
+// document, which is convenient to save or submit somewhere else.
+//
+// To avoid data races you MUST call this method after the channel
+// returned by [*Client.StartDownload] has been drained.
+func (c *Client) FinalResult() *FinalResult {
+	end := c.end
+	if end.IsZero() {
+		end = c.TimeNow()
+	}
+	results := &FinalResult{
+		Client:  c.ClientResults(),
+		Failure: c.FailureReport(),
+		Metadata: &FinalMetadata{
+			ClientName:        c.ClientName,
+			ClientVersion:     c.ClientVersion,
+			Config:            c.finalConfig(),
+			Elapsed:           end.Sub(c.begin).Seconds(),
+			LibraryName:       libraryName,
+			LibraryVersion:    libraryVersion,
+			MLab:              newMLabAnnotation(c.server),
+			Platform:          runtime.GOOS,
+			ProbeID:           c.probeID,
+			ProbeIDPersistent: c.probeIDPersistent,
+			RunMetadata:       maps.Clone(c.runMetadata),
+			Server:            c.server,
+			Timestamp:         c.begin.Unix(),
+		},
+		Server: c.ServerResults(),
+		Summary: &FinalSummary{
+			ArrivalJitter: arrivalJitter(c.clientResults),
+			MedianRate:    MedianRate(c.clientResults),
+		},
+	}
+	for _, current := range results.Client {
+		results.Summary.Iterations++
+		if current.Failure != "" {
+			results.Summary.Failures++
+		}
+		results.Summary.Received += current.Received
+	}
+	results.Summary.ClockSkew = c.correctClockSkew(results)
+	if c.StreamRate > 0 {
+		sustained := c.StreamSustained()
+		results.Summary.StreamSustained = &sustained
+	}
+	return results
+}
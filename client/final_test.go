@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestClientFinalResult(t *testing.T) {
+	run := func(collectErr error) *Client {
+		ch := make(chan model.ClientResults)
+		client := New(softwareName, softwareVersion)
+		client.Iterations = 3
+		client.CollectRetries = 0
+		client.server = "https://dash.example.com/negotiate/dash"
+		client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+			return model.NegotiateResponse{}, nil
+		}
+		client.deps.Download = func(
+			ctx context.Context, authorization string,
+			current *model.ClientResults, negotiateURL *url.URL,
+		) error {
+			current.Elapsed = 1
+			current.Received = 1000 * (current.Iteration + 1)
+			return nil
+		}
+		client.deps.Collect = func(ctx context.Context, authorization string, negotiateURL *url.URL) error {
+			client.serverResults = []model.ServerResults{{Iteration: 1}}
+			return collectErr
+		}
+		go client.loop(context.Background(), ch, &url.URL{})
+		for range ch {
+			// drain channel
+		}
+		return client
+	}
+
+	t.Run("common case", func(t *testing.T) {
+		results := run(nil).FinalResult()
+		if len(results.Client) != 3 || len(results.Server) != 1 || results.Failure != nil {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+		if results.Summary.Iterations != 3 || results.Summary.Received != 6000 || results.Summary.Failures != 0 {
+			t.Fatalf("unexpected summary: %+v", results.Summary)
+		}
+		if results.Summary.MedianRate != 16 || results.Summary.StreamSustained != nil {
+			t.Fatalf("unexpected summary: %+v", results.Summary)
+		}
+		metadata := results.Metadata
+		if metadata.ClientName != softwareName || metadata.ClientVersion != softwareVersion {
+			t.Fatalf("unexpected metadata: %+v", metadata)
+		}
+		if metadata.Server != "https://dash.example.com/negotiate/dash" || metadata.Elapsed <= 0 {
+			t.Fatalf("unexpected metadata: %+v", metadata)
+		}
+	})
+
+	t.Run("when collect fails", func(t *testing.T) {
+		results := run(errors.New("Mocked error")).FinalResult()
+		if results.Failure == nil || results.Failure.Phase != phaseCollect {
+			t.Fatalf("unexpected failure: %+v", results.Failure)
+		}
+		if len(results.Client) != 3 {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+	})
+
+	t.Run("in stream emulation mode", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.StreamRate = 2500
+		results := client.FinalResult()
+		if results.Summary.StreamSustained == nil || *results.Summary.StreamSustained {
+			t.Fatalf("unexpected summary: %+v", results.Summary)
+		}
+		if len(results.Client) != 0 || len(results.Server) != 0 {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+	})
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"net/http/httptrace"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/neubot/dash/model"
+)
+
+// These are the address families we report.
+const (
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+)
+
+// connectAttempt is a connection attempt observed by [happyEyeballsTracer].
+type connectAttempt struct {
+	// done is when the attempt completed or zero.
+	done time.Time
+
+	// err is the error that occurred or nil.
+	err error
+
+	// started is when the attempt started.
+	started time.Time
+}
+
+// happyEyeballsTracer records the connection attempts performed by the
+// dialer using [httptrace.ClientTrace] hooks, which the standard library
+// calls for each attempt of the Happy Eyeballs race.
+type happyEyeballsTracer struct {
+	// attempts maps each address (e.g., "[::1]:443") to its attempt.
+	attempts map[string]*connectAttempt
+
+	// mtx protects attempts.
+	mtx sync.Mutex
+}
+
+// wrap returns a context configured to use the tracer hooks.
+func (ht *happyEyeballsTracer) wrap(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		ConnectStart: ht.connectStart,
+		ConnectDone:  ht.connectDone,
+	})
+}
+
+// connectStart is called when a connection attempt starts.
+func (ht *happyEyeballsTracer) connectStart(network, addr string) {
+	ht.mtx.Lock()
+	defer ht.mtx.Unlock()
+	if ht.attempts == nil {
+		ht.attempts = make(map[string]*connectAttempt)
+	}
+	ht.attempts[addr] = &connectAttempt{started: time.Now()}
+}
+
+// connectDone is called when a connection attempt completes.
+func (ht *happyEyeballsTracer) connectDone(network, addr string, err error) {
+	ht.mtx.Lock()
+	defer ht.mtx.Unlock()
+	if attempt := ht.attempts[addr]; attempt != nil {
+		attempt.done, attempt.err = time.Now(), err
+	}
+}
+
+// raceResults contains what [*happyEyeballsTracer.race] observed.
+type raceResults struct {
+	// loserConnect is the connect time of the first successful
+	// attempt of the losing family or zero.
+	loserConnect time.Duration
+
+	// loserFailure is the error of the first failed attempt of the
+	// losing family, when no attempt of such family succeeded.
+	loserFailure string
+
+	// loserRaced indicates whether any attempt of the losing family started.
+	loserRaced bool
+
+	// winnerConnect is the connect time of the winner.
+	winnerConnect time.Duration
+}
+
+// race returns what we observed about the race, given the address that
+// won the race and the family of the loser. Because map iteration order is
+// random, when there are several attempts of the losing family we prefer
+// the earliest one to obtain consistent results.
+func (ht *happyEyeballsTracer) race(winner netip.AddrPort, loserFamily string) raceResults {
+	ht.mtx.Lock()
+	defer ht.mtx.Unlock()
+	var (
+		first   *connectAttempt
+		results raceResults
+	)
+	for addr, attempt := range ht.attempts {
+		parsed, err := netip.ParseAddrPort(addr)
+		if err != nil {
+			continue
+		}
+		if parsed.Addr().Unmap() == winner.Addr() && !attempt.done.IsZero() && attempt.err == nil {
+			results.winnerConnect = attempt.done.Sub(attempt.started)
+		}
+		if addressFamily(parsed.Addr()) == loserFamily {
+			results.loserRaced = true
+			if first == nil || attempt.started.Before(first.started) {
+				first = attempt
+			}
+		}
+	}
+	switch {
+	case first == nil || first.done.IsZero():
+		// the loser did not start or did not complete
+	case first.err != nil:
+		results.loserFailure = first.err.Error()
+	default:
+		results.loserConnect = first.done.Sub(first.started)
+	}
+	return results
+}
+
+// winnerAddrPort returns the address and port that won the race, using the
+// address we connected to according to the lookup results, and the port
+// of the attempts, since the lookup results do not contain the port.
+func (ht *happyEyeballsTracer) winnerAddrPort(used netip.Addr) (netip.AddrPort, bool) {
+	ht.mtx.Lock()
+	defer ht.mtx.Unlock()
+	for addr := range ht.attempts {
+		parsed, err := netip.ParseAddrPort(addr)
+		if err == nil && parsed.Addr().Unmap() == used {
+			return netip.AddrPortFrom(used, parsed.Port()), true
+		}
+	}
+	return netip.AddrPort{}, false
+}
+
+// addressFamily returns the family of the given address.
+func addressFamily(addr netip.Addr) string {
+	if addr.Unmap().Is4() {
+		return familyIPv4
+	}
+	return familyIPv6
+}
+
+// happyEyeballs returns the Happy Eyeballs results given the lookup results
+// and the tracer used for the same request, or nil when the lookup did not
+// return both families or the request did not establish a new connection.
+// We only use what the dialer of the transport did during the race, so
+// that measuring the race does not affect the request we are timing.
+func happyEyeballs(dns *model.DNSResults, tracer *happyEyeballsTracer) *model.HappyEyeballsResults {
+	// 1. make sure the lookup returned both families and we connected
+	if dns == nil || dns.UsedAddress == "" {
+		return nil
+	}
+	used, err := netip.ParseAddr(dns.UsedAddress)
+	if err != nil {
+		return nil
+	}
+	used = used.Unmap()
+	winner, found := tracer.winnerAddrPort(used)
+	if !found {
+		return nil
+	}
+	var loser netip.Addr
+	for _, entry := range dns.Addresses {
+		addr, err := netip.ParseAddr(entry)
+		if err == nil && addressFamily(addr) != addressFamily(used) {
+			loser = addr.Unmap()
+			break
+		}
+	}
+	if !loser.IsValid() {
+		return nil
+	}
+
+	// 2. collect what we observed during the race
+	results := &model.HappyEyeballsResults{Winner: addressFamily(used)}
+	race := tracer.race(winner, addressFamily(loser))
+	results.LoserConnectTime = race.loserConnect.Seconds()
+	results.LoserFailure = race.loserFailure
+	results.LoserRaced = race.loserRaced
+	results.WinnerConnectTime = race.winnerConnect.Seconds()
+	return results
+}
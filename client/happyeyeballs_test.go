@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestHappyEyeballsTracer(t *testing.T) {
+	tracer := &happyEyeballsTracer{}
+	trace := tracer.wrap(context.Background())
+	if trace == nil {
+		t.Fatal("expected a context")
+	}
+	tracer.connectStart("tcp", "[2001:db8::1]:443")
+	tracer.connectStart("tcp", "192.0.2.1:443")
+	tracer.connectDone("tcp", "192.0.2.1:443", nil)
+	tracer.connectDone("tcp", "[2001:db8::1]:443", errors.New("mocked error"))
+	winner, found := tracer.winnerAddrPort(netip.MustParseAddr("192.0.2.1"))
+	if !found || winner.String() != "192.0.2.1:443" {
+		t.Fatalf("unexpected winner: %s", winner)
+	}
+	race := tracer.race(winner, familyIPv6)
+	if !race.loserRaced || race.winnerConnect < 0 {
+		t.Fatal("expected the IPv6 attempt to have raced")
+	}
+	if race.loserFailure != "mocked error" || race.loserConnect != 0 {
+		t.Fatalf("unexpected loser results: %+v", race)
+	}
+
+	t.Run("the loser family did not start", func(t *testing.T) {
+		tracer := &happyEyeballsTracer{}
+		tracer.connectStart("tcp", "192.0.2.1:443")
+		tracer.connectDone("tcp", "192.0.2.1:443", nil)
+		winner, _ := tracer.winnerAddrPort(netip.MustParseAddr("192.0.2.1"))
+		if race := tracer.race(winner, familyIPv6); race.loserRaced || race.loserFailure != "" {
+			t.Fatal("expected the IPv6 attempt not to have raced")
+		}
+	})
+
+	t.Run("the loser family did not complete", func(t *testing.T) {
+		tracer := &happyEyeballsTracer{}
+		tracer.connectStart("tcp", "192.0.2.1:443")
+		tracer.connectStart("tcp", "[2001:db8::1]:443")
+		tracer.connectDone("tcp", "192.0.2.1:443", nil)
+		winner, _ := tracer.winnerAddrPort(netip.MustParseAddr("192.0.2.1"))
+		race := tracer.race(winner, familyIPv6)
+		if !race.loserRaced || race.loserFailure != "" || race.loserConnect != 0 {
+			t.Fatalf("unexpected results: %+v", race)
+		}
+	})
+
+	t.Run("we did not connect to the used address", func(t *testing.T) {
+		tracer := &happyEyeballsTracer{}
+		if _, found := tracer.winnerAddrPort(netip.MustParseAddr("192.0.2.1")); found {
+			t.Fatal("expected no winner")
+		}
+	})
+}
+
+func TestHappyEyeballs(t *testing.T) {
+	dns := &model.DNSResults{
+		Addresses:   []string{"2001:db8::1", "192.0.2.1"},
+		UsedAddress: "2001:db8::1",
+	}
+
+	t.Run("we use the attempts of the dialer", func(t *testing.T) {
+		tracer := &happyEyeballsTracer{}
+		tracer.connectStart("tcp", "[2001:db8::1]:443")
+		tracer.connectStart("tcp", "192.0.2.1:443")
+		tracer.connectDone("tcp", "192.0.2.1:443", nil)
+		tracer.connectDone("tcp", "[2001:db8::1]:443", nil)
+		results := happyEyeballs(dns, tracer)
+		if results == nil {
+			t.Fatal("expected Happy Eyeballs results")
+		}
+		if results.Winner != familyIPv6 || !results.LoserRaced || results.LoserFailure != "" {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+		if results.LoserConnectTime <= 0 || results.WinnerConnectTime <= 0 {
+			t.Fatalf("unexpected connect times: %+v", results)
+		}
+	})
+
+	t.Run("the loser did not start", func(t *testing.T) {
+		tracer := &happyEyeballsTracer{}
+		tracer.connectStart("tcp", "[2001:db8::1]:443")
+		tracer.connectDone("tcp", "[2001:db8::1]:443", nil)
+		results := happyEyeballs(dns, tracer)
+		if results == nil || results.LoserRaced || results.LoserConnectTime != 0 {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+	})
+
+	t.Run("the lookup returned a single family", func(t *testing.T) {
+		dns := &model.DNSResults{
+			Addresses:   []string{"2001:db8::1", "2001:db8::2"},
+			UsedAddress: "2001:db8::1",
+		}
+		if happyEyeballs(dns, &happyEyeballsTracer{}) != nil {
+			t.Fatal("expected no results")
+		}
+	})
+
+	t.Run("there are no lookup results", func(t *testing.T) {
+		if happyEyeballs(nil, &happyEyeballsTracer{}) != nil {
+			t.Fatal("expected no results")
+		}
+	})
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/neubot/dash/model"
+)
+
+const (
+	// DefaultIterations is the default number of Iterations.
+	DefaultIterations = 15
+
+	// MaxIterations is the maximum number of Iterations, i.e., the number
+	// of segments in ten minutes, which is the maximum test duration that
+	// the server accepts.
+	MaxIterations = 300
+)
+
+// errInvalidIterations is returned when the Iterations are invalid.
+var errInvalidIterations = errors.New("invalid number of iterations")
+
+// errStreamTooShort is returned when the StreamDuration is too short
+// for downloading at least one segment in stream emulation mode.
+var errStreamTooShort = errors.New("stream duration shorter than a segment")
+
+// validateIterations returns an error when the Iterations are
+// not between one and MaxIterations or, in stream emulation mode,
+// when the StreamDuration does not allow for a single segment.
+func (c *Client) validateIterations() error {
+	if c.Iterations < 1 || c.Iterations > MaxIterations {
+		return errInvalidIterations
+	}
+	if c.StreamRate > 0 && c.plannedIterations() < 1 {
+		return errStreamTooShort
+	}
+	return nil
+}
+
+// allowedIterations returns the number of iterations we plan to run
+// capped to the maximum number of iterations advertised by the server
+// in the given negotiate response, if any.
+func (c *Client) allowedIterations(response model.NegotiateResponse) int64 {
+	planned := c.plannedIterations()
+	if response.MaxIterations > 0 && planned > response.MaxIterations {
+		c.Logger.Warnf("dash: the server only allows %d iterations", response.MaxIterations)
+		return response.MaxIterations
+	}
+	return planned
+}
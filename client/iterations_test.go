@@ -0,0 +1,91 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestClientValidateIterations(t *testing.T) {
+	for _, tc := range []struct {
+		iterations int64
+		valid      bool
+	}{
+		{iterations: 0, valid: false},
+		{iterations: 1, valid: true},
+		{iterations: DefaultIterations, valid: true},
+		{iterations: MaxIterations, valid: true},
+		{iterations: MaxIterations + 1, valid: false},
+	} {
+		client := New(softwareName, softwareVersion)
+		client.Iterations = tc.iterations
+		err := client.validateIterations()
+		if tc.valid != (err == nil) {
+			t.Fatal("unexpected result", tc.iterations, err)
+		}
+		if err != nil && !errors.Is(err, errInvalidIterations) {
+			t.Fatal("not the error we expected", err)
+		}
+	}
+
+	t.Run("with a stream shorter than a segment", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.StreamRate = 25000
+		client.StreamDuration = segmentDuration / 2
+		if err := client.validateIterations(); !errors.Is(err, errStreamTooShort) {
+			t.Fatal("not the error we expected", err)
+		}
+		client.StreamDuration = segmentDuration
+		if err := client.validateIterations(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestClientAllowedIterations(t *testing.T) {
+	client := New(softwareName, softwareVersion)
+	client.Iterations = 60
+	if n := client.allowedIterations(model.NegotiateResponse{}); n != 60 {
+		t.Fatal("unexpected iterations without server maximum", n)
+	}
+	if n := client.allowedIterations(model.NegotiateResponse{MaxIterations: 100}); n != 60 {
+		t.Fatal("unexpected iterations with a larger server maximum", n)
+	}
+	if n := client.allowedIterations(model.NegotiateResponse{MaxIterations: 17}); n != 17 {
+		t.Fatal("unexpected iterations with a smaller server maximum", n)
+	}
+}
+
+func TestClientLoopIterations(t *testing.T) {
+	ch := make(chan model.ClientResults)
+	client := New(softwareName, softwareVersion)
+	client.Iterations = 40
+	client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+		return model.NegotiateResponse{MaxIterations: 17}, nil
+	}
+	client.deps.Download = func(
+		ctx context.Context, authorization string,
+		current *model.ClientResults, negotiateURL *url.URL,
+	) error {
+		current.Elapsed = 1
+		current.Received = 1000
+		return nil
+	}
+	client.deps.Collect = func(ctx context.Context, authorization string, negotiateURL *url.URL) error {
+		return nil
+	}
+	go client.loop(context.Background(), ch, &url.URL{})
+	var count int
+	for range ch {
+		count++
+	}
+	if client.err != nil {
+		t.Fatal(client.err)
+	}
+	if count != 17 {
+		t.Fatal("unexpected number of iterations", count)
+	}
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"math"
+
+	"github.com/neubot/dash/model"
+)
+
+// arrivalJitter returns the jitter of the completion times of the
+// successful segments in seconds, which we define, like the interarrival
+// jitter of RFC 3550 but without smoothing, as the mean absolute difference
+// between consecutive intervals between the completion of segments. Because
+// players rebuffer when segments arrive irregularly, a large jitter predicts
+// rebuffering even when the average rate is high. We return zero when there
+// are fewer than three successful segments.
+func arrivalJitter(results []model.ClientResults) float64 {
+	var completions []float64
+	for _, current := range results {
+		if current.Failure != "" || current.Elapsed <= 0 {
+			continue
+		}
+		completions = append(completions, current.RequestTicks+current.Elapsed)
+	}
+	if len(completions) < 3 {
+		return 0
+	}
+	var sum float64
+	for idx := 2; idx < len(completions); idx++ {
+		previous := completions[idx-1] - completions[idx-2]
+		interval := completions[idx] - completions[idx-1]
+		sum += math.Abs(interval - previous)
+	}
+	return sum / float64(len(completions)-2)
+}
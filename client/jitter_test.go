@@ -0,0 +1,57 @@
+package client
+
+import (
+	"math"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestArrivalJitter(t *testing.T) {
+	t.Run("with regular arrivals", func(t *testing.T) {
+		results := []model.ClientResults{
+			{RequestTicks: 0, Elapsed: 1},
+			{RequestTicks: 1, Elapsed: 1},
+			{RequestTicks: 2, Elapsed: 1},
+			{RequestTicks: 3, Elapsed: 1},
+		}
+		if jitter := arrivalJitter(results); jitter != 0 {
+			t.Fatal("unexpected jitter", jitter)
+		}
+	})
+
+	t.Run("with irregular arrivals", func(t *testing.T) {
+		results := []model.ClientResults{
+			{RequestTicks: 0, Elapsed: 1},   // completes at 1
+			{RequestTicks: 1, Elapsed: 0.5}, // completes at 1.5
+			{RequestTicks: 1.5, Elapsed: 2}, // completes at 3.5
+			{RequestTicks: 3.5, Elapsed: 1}, // completes at 4.5
+		}
+		// the intervals are 0.5, 2 and 1, hence (1.5 + 1) / 2
+		if jitter := arrivalJitter(results); math.Abs(jitter-1.25) > 1e-9 {
+			t.Fatal("unexpected jitter", jitter)
+		}
+	})
+
+	t.Run("skipping failed segments", func(t *testing.T) {
+		results := []model.ClientResults{
+			{RequestTicks: 0, Elapsed: 1},
+			{RequestTicks: 1, Elapsed: 10, Failure: "generic_timeout_error"},
+			{RequestTicks: 1, Elapsed: 1},
+			{RequestTicks: 2, Elapsed: 1},
+		}
+		if jitter := arrivalJitter(results); jitter != 0 {
+			t.Fatal("unexpected jitter", jitter)
+		}
+	})
+
+	t.Run("with too few segments", func(t *testing.T) {
+		results := []model.ClientResults{
+			{RequestTicks: 0, Elapsed: 1},
+			{RequestTicks: 1, Elapsed: 3},
+		}
+		if jitter := arrivalJitter(results); jitter != 0 {
+			t.Fatal("unexpected jitter", jitter)
+		}
+	})
+}
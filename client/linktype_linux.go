@@ -0,0 +1,46 @@
+//go:build linux
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sysClassNet is the sysfs directory describing the network interfaces.
+const sysClassNet = "/sys/class/net"
+
+// linkType returns the link type of the network interface with the given
+// name, using the information exported by the kernel through sysfs.
+func linkType(name string) string {
+	return linkTypeFromSysfs(sysClassNet, name)
+}
+
+// linkTypeFromSysfs is like linkType but uses the given sysfs directory.
+func linkTypeFromSysfs(root, name string) string {
+	dir := filepath.Join(root, name)
+	if _, err := os.Stat(filepath.Join(dir, "wireless")); err == nil {
+		return linkTypeWiFi
+	}
+	for _, prefix := range []string{"wwan", "rmnet", "ccmni"} {
+		if strings.HasPrefix(name, prefix) {
+			return linkTypeCellular
+		}
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "type"))
+	if err != nil {
+		return linkTypeUnknown
+	}
+	// See the ARPHRD_* constants in include/uapi/linux/if_arp.h.
+	switch strings.TrimSpace(string(data)) {
+	case "1": // ARPHRD_ETHER
+		return linkTypeEthernet
+	case "772": // ARPHRD_LOOPBACK
+		return linkTypeLoopback
+	case "519": // ARPHRD_RAWIP, used by cellular modems
+		return linkTypeCellular
+	default:
+		return linkTypeUnknown
+	}
+}
@@ -0,0 +1,43 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkTypeFromSysfs(t *testing.T) {
+	root := t.TempDir()
+	mkif := func(name, arphrd string, wireless bool) {
+		dir := filepath.Join(root, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "type"), []byte(arphrd+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if wireless {
+			if err := os.Mkdir(filepath.Join(dir, "wireless"), 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	mkif("eth0", "1", false)
+	mkif("wlan0", "1", true)
+	mkif("wwan0", "1", false)
+	mkif("lo", "772", false)
+	mkif("tun0", "65534", false)
+	expect := map[string]string{
+		"eth0":    linkTypeEthernet,
+		"wlan0":   linkTypeWiFi,
+		"wwan0":   linkTypeCellular,
+		"lo":      linkTypeLoopback,
+		"tun0":    linkTypeUnknown,
+		"missing": linkTypeUnknown,
+	}
+	for name, linkType := range expect {
+		if got := linkTypeFromSysfs(root, name); got != linkType {
+			t.Fatal("unexpected link type for", name, got)
+		}
+	}
+}
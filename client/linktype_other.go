@@ -0,0 +1,23 @@
+//go:build !linux
+
+package client
+
+import "strings"
+
+// linkType returns the link type of the network interface with the given
+// name. Because we do not know how to query the link type on this platform,
+// we guess it from the naming conventions of common systems.
+func linkType(name string) string {
+	switch {
+	case strings.HasPrefix(name, "lo"):
+		return linkTypeLoopback
+	case strings.HasPrefix(name, "pdp_ip"), strings.HasPrefix(name, "rmnet"):
+		return linkTypeCellular
+	case strings.HasPrefix(name, "wl"):
+		return linkTypeWiFi
+	case strings.HasPrefix(name, "eth"):
+		return linkTypeEthernet
+	default:
+		return linkTypeUnknown
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	locatev2 "github.com/m-lab/locate/api/v2"
+)
+
+// LocateCache caches the targets returned by m-lab/locate/v2 such that
+// long running applications performing several measurements do not need
+// to query locate on every run, thus respecting its rate limits. You can
+// share the same cache among several [*Client] instances using their
+// LocateCache field. Use NewLocateCache to create a new instance.
+//
+// Note that the URLs returned by locate contain access tokens with a
+// limited lifetime, hence you should use a TTL of a few minutes at most.
+type LocateCache struct {
+	// jitter is the maximum random amount of time subtracted from the ttl
+	// when saving new targets, so that clients do not query locate in sync.
+	jitter time.Duration
+
+	// expiry is when the cached targets expire.
+	expiry time.Time
+
+	// mtx protects expiry and targets.
+	mtx sync.Mutex
+
+	// randInt63n allows to override calling [rand.Int63n].
+	randInt63n func(n int64) int64
+
+	// targets contains the cached targets.
+	targets []locatev2.Target
+
+	// timeNow allows to override calling [time.Now].
+	timeNow func() time.Time
+
+	// ttl is the time to live of the cached targets.
+	ttl time.Duration
+}
+
+// NewLocateCache creates a new [*LocateCache] where the targets returned
+// by locate live for the given ttl minus a random jitter between zero and
+// the given jitter. A zero jitter means we do not add any jitter.
+func NewLocateCache(ttl, jitter time.Duration) *LocateCache {
+	return &LocateCache{
+		jitter:     jitter,
+		expiry:     time.Time{},
+		mtx:        sync.Mutex{},
+		randInt63n: rand.Int63n,
+		targets:    nil,
+		timeNow:    time.Now,
+		ttl:        ttl,
+	}
+}
+
+// get returns the cached targets, if they have not expired yet.
+func (lc *LocateCache) get() ([]locatev2.Target, bool) {
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+	if len(lc.targets) <= 0 || !lc.timeNow().Before(lc.expiry) {
+		return nil, false
+	}
+	return append([]locatev2.Target{}, lc.targets...), true
+}
+
+// put saves the given targets into the cache.
+func (lc *LocateCache) put(targets []locatev2.Target) {
+	ttl := lc.ttl
+	if lc.jitter > 0 {
+		ttl -= time.Duration(lc.randInt63n(int64(lc.jitter)))
+	}
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+	lc.expiry = lc.timeNow().Add(ttl)
+	lc.targets = append([]locatev2.Target{}, targets...)
+}
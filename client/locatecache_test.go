@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	locatev2 "github.com/m-lab/locate/api/v2"
+)
+
+func TestLocateCache(t *testing.T) {
+	t.Run("empty cache", func(t *testing.T) {
+		cache := NewLocateCache(time.Minute, 0)
+		if _, found := cache.get(); found {
+			t.Fatal("expected no targets")
+		}
+	})
+
+	t.Run("put and get with jitter", func(t *testing.T) {
+		now := time.Date(2024, time.January, 29, 20, 23, 0, 0, time.UTC)
+		cache := NewLocateCache(time.Minute, 10*time.Second)
+		cache.timeNow = func() time.Time { return now }
+		cache.randInt63n = func(n int64) int64 {
+			if n != int64(10*time.Second) {
+				t.Fatal("unexpected jitter", n)
+			}
+			return int64(5 * time.Second)
+		}
+		cache.put([]locatev2.Target{{Machine: "mlab1"}})
+		now = now.Add(54 * time.Second)
+		targets, found := cache.get()
+		if !found || len(targets) != 1 || targets[0].Machine != "mlab1" {
+			t.Fatal("expected cached targets")
+		}
+		now = now.Add(time.Second)
+		if _, found := cache.get(); found {
+			t.Fatal("expected targets to be expired")
+		}
+	})
+}
+
+type countingLocator struct {
+	count int
+}
+
+// Nearest implements locator.
+func (cl *countingLocator) Nearest(ctx context.Context, service string) ([]locatev2.Target, error) {
+	cl.count++
+	return []locatev2.Target{{
+		URLs: map[string]string{"https:///negotiate/dash": "https://mlab1.example.com/negotiate/dash"},
+	}}, nil
+}
+
+func TestClientLocateWithCache(t *testing.T) {
+	cache := NewLocateCache(time.Minute, 0)
+	locator := &countingLocator{}
+	for idx := 0; idx < 3; idx++ {
+		client := New(softwareName, softwareVersion)
+		client.LocateCache = cache
+		client.deps.Locator = locator
+		URL, err := client.locate(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if URL.Host != "mlab1.example.com" {
+			t.Fatal("unexpected host", URL.Host)
+		}
+	}
+	if locator.count != 1 {
+		t.Fatal("expected to query locate once", locator.count)
+	}
+}
@@ -0,0 +1,68 @@
+package client
+
+import (
+	"net/url"
+	"strings"
+)
+
+// mlabDomain is the domain of the M-Lab servers.
+const mlabDomain = ".measurement-lab.org"
+
+// MLabAnnotation contains the fields that M-Lab uses to annotate the data
+// collected by its servers, which allows to join our results with the M-Lab
+// metadata without reverse engineering the server hostname downstream.
+type MLabAnnotation struct {
+	// Experiment is the name of the experiment in the hostname (e.g., "dash").
+	Experiment string `json:"experiment"`
+
+	// ExperimentVersion is the version of the DASH implementation, which is
+	// the same as the version in the client results.
+	ExperimentVersion string `json:"experiment_version"`
+
+	// Machine is the name of the machine within the site (e.g., "mlab1").
+	Machine string `json:"machine"`
+
+	// Project is the project of the machine (e.g., "mlab-oti"), or empty
+	// when the hostname does not contain any project.
+	Project string `json:"project,omitempty"`
+
+	// Site is the name of the site (e.g., "lga03").
+	Site string `json:"site"`
+}
+
+// newMLabAnnotation returns the [*MLabAnnotation] of the server with the given
+// negotiate URL or nil when the URL does not refer to an M-Lab server. We
+// support both the hostnames used by m-lab/locate/v2 (e.g., "dash-mlab1-lga03.
+// mlab-oti.measurement-lab.org") and the legacy dotted hostnames (e.g.,
+// "neubot.mlab.mlab1.lga03.measurement-lab.org").
+func newMLabAnnotation(negotiateURL string) *MLabAnnotation {
+	parsed, err := url.Parse(negotiateURL)
+	if err != nil {
+		return nil
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if !strings.HasSuffix(host, mlabDomain) {
+		return nil
+	}
+	labels := strings.Split(strings.TrimSuffix(host, mlabDomain), ".")
+	annotation := &MLabAnnotation{ExperimentVersion: magicVersion}
+	switch {
+	case len(labels) <= 2: // e.g., dash-mlab1-lga03[.mlab-oti]
+		parts := strings.Split(labels[0], "-")
+		if len(parts) < 3 {
+			return nil
+		}
+		annotation.Experiment = strings.Join(parts[:len(parts)-2], "-")
+		annotation.Machine, annotation.Site = parts[len(parts)-2], parts[len(parts)-1]
+		if len(labels) == 2 {
+			annotation.Project = labels[1]
+		}
+	default: // e.g., neubot.mlab.mlab1.lga03
+		annotation.Experiment = labels[0]
+		annotation.Machine, annotation.Site = labels[len(labels)-2], labels[len(labels)-1]
+	}
+	if !strings.HasPrefix(annotation.Machine, "mlab") || len(annotation.Site) != 5 {
+		return nil
+	}
+	return annotation
+}
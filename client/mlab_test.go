@@ -0,0 +1,63 @@
+package client
+
+import "testing"
+
+func TestNewMLabAnnotation(t *testing.T) {
+	type testcase struct {
+		name   string
+		URL    string
+		expect *MLabAnnotation
+	}
+	cases := []testcase{{
+		name: "with a locate v2 hostname",
+		URL:  "https://dash-mlab1-lga03.mlab-oti.measurement-lab.org/negotiate/dash?access_token=x",
+		expect: &MLabAnnotation{
+			Experiment:        "dash",
+			ExperimentVersion: magicVersion,
+			Machine:           "mlab1",
+			Project:           "mlab-oti",
+			Site:              "lga03",
+		},
+	}, {
+		name: "with a hyphenated experiment and no project",
+		URL:  "https://neubot-dash-mlab2-mil04.measurement-lab.org/negotiate/dash",
+		expect: &MLabAnnotation{
+			Experiment:        "neubot-dash",
+			ExperimentVersion: magicVersion,
+			Machine:           "mlab2",
+			Site:              "mil04",
+		},
+	}, {
+		name: "with a legacy dotted hostname",
+		URL:  "http://neubot.mlab.mlab1.trn01.measurement-lab.org:8080/negotiate/dash",
+		expect: &MLabAnnotation{
+			Experiment:        "neubot",
+			ExperimentVersion: magicVersion,
+			Machine:           "mlab1",
+			Site:              "trn01",
+		},
+	}, {
+		name: "with a non M-Lab server",
+		URL:  "https://dash.example.com/negotiate/dash",
+	}, {
+		name: "with an unexpected M-Lab hostname",
+		URL:  "https://www.measurement-lab.org/",
+	}, {
+		name: "with an empty URL",
+		URL:  "",
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			annotation := newMLabAnnotation(tc.URL)
+			if tc.expect == nil {
+				if annotation != nil {
+					t.Fatalf("expected nil, got %+v", annotation)
+				}
+				return
+			}
+			if annotation == nil || *annotation != *tc.expect {
+				t.Fatalf("unexpected annotation: %+v", annotation)
+			}
+		})
+	}
+}
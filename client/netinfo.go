@@ -0,0 +1,68 @@
+package client
+
+import (
+	"errors"
+	"net"
+
+	"github.com/neubot/dash/model"
+)
+
+// These are the link types we can detect.
+const (
+	linkTypeCellular = "cellular"
+	linkTypeEthernet = "ethernet"
+	linkTypeLoopback = "loopback"
+	linkTypeUnknown  = "unknown"
+	linkTypeWiFi     = "wifi"
+)
+
+// errNoInterfaceForAddress is returned when no network interface has
+// the local address of the measurement connection.
+var errNoInterfaceForAddress = errors.New("no interface for address")
+
+// interfaceByLocalIP returns the name of the network interface having
+// the given IP address, which is the outgoing interface of a connection
+// bound to such an address.
+func interfaceByLocalIP(ip net.IP) (string, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.Equal(ip) {
+				return iface.Name, nil
+			}
+		}
+	}
+	return "", errNoInterfaceForAddress
+}
+
+// networkMetadata returns the metadata of the outgoing network interface
+// given the local address of the measurement connection, or nil if we
+// cannot determine the interface. We cache the metadata of the most recent
+// local address, because we typically reuse the same connection.
+func (c *Client) networkMetadata(addr net.Addr) *model.NetworkMetadata {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	if c.network != nil && c.networkIP.Equal(tcpAddr.IP) {
+		return c.network
+	}
+	name, err := c.deps.InterfaceByLocalIP(tcpAddr.IP)
+	if err != nil {
+		c.Logger.Debugf("dash: cannot determine the outgoing interface: %s", err.Error())
+		return nil
+	}
+	c.network = &model.NetworkMetadata{
+		Interface: name,
+		LinkType:  linkType(name),
+	}
+	c.networkIP = tcpAddr.IP
+	return c.network
+}
@@ -0,0 +1,89 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestClientDownloadRecordsNetwork(t *testing.T) {
+	srvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("abc"))
+	}))
+	defer srvr.Close()
+	URL, err := url.Parse(srvr.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(softwareName, softwareVersion)
+	client.HTTPClient = &http.Client{Transport: &http.Transport{}}
+	current := &model.ClientResults{Rate: 100, ElapsedTarget: 2}
+	if err := client.download(context.Background(), "abc", current, URL); err != nil {
+		t.Fatal(err)
+	}
+	if current.Network == nil || current.Network.Interface == "" {
+		t.Fatalf("unexpected network metadata: %+v", current.Network)
+	}
+}
+
+func TestInterfaceByLocalIP(t *testing.T) {
+	t.Run("with the loopback address", func(t *testing.T) {
+		name, err := interfaceByLocalIP(net.IPv4(127, 0, 0, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "" {
+			t.Fatal("expected an interface name")
+		}
+	})
+
+	t.Run("with an address we do not have", func(t *testing.T) {
+		_, err := interfaceByLocalIP(net.ParseIP("2001:db8::1"))
+		if !errors.Is(err, errNoInterfaceForAddress) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+}
+
+func TestClientNetworkMetadata(t *testing.T) {
+	t.Run("with a non-TCP address", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		if client.networkMetadata(nil) != nil {
+			t.Fatal("expected nil metadata")
+		}
+	})
+
+	t.Run("when we cannot find the interface", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.deps.InterfaceByLocalIP = func(ip net.IP) (string, error) {
+			return "", errors.New("Mocked error")
+		}
+		if client.networkMetadata(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1)}) != nil {
+			t.Fatal("expected nil metadata")
+		}
+	})
+
+	t.Run("we cache the metadata of the last address", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		var lookups int
+		client.deps.InterfaceByLocalIP = func(ip net.IP) (string, error) {
+			lookups++
+			return "eth0", nil
+		}
+		first := client.networkMetadata(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1234})
+		second := client.networkMetadata(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5678})
+		if first == nil || first != second || lookups != 1 {
+			t.Fatal("expected cached metadata")
+		}
+		client.networkMetadata(&net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 1234})
+		if lookups != 2 {
+			t.Fatal("expected a new lookup")
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// tlsMaxRecordPayload is the maximum payload of a TLS record.
+	tlsMaxRecordPayload = 1 << 14
+
+	// tls12RecordOverhead is the per-record overhead of TLS 1.2 assuming
+	// AES-GCM: the record header, the explicit nonce, and the tag.
+	tls12RecordOverhead = 5 + 8 + 16
+
+	// tls13RecordOverhead is the per-record overhead of TLS 1.3: the
+	// record header, the inner content type, and the AEAD tag.
+	tls13RecordOverhead = 5 + 1 + 16
+
+	// http2MaxFramePayload is the default maximum payload of an HTTP/2 frame.
+	http2MaxFramePayload = 1 << 14
+
+	// http2FrameOverhead is the size of the HTTP/2 frame header.
+	http2FrameOverhead = 9
+)
+
+// estimateWireBytes estimates the number of bytes that we received at
+// the transport layer for the given response, i.e., including the HTTP
+// and TLS overhead, given the number of body bytes. This estimate is
+// comparable with the TCP payload bytes seen by packet-level tools.
+//
+// We do not know the exact framing used by the server, so we assume
+// that the server fills each HTTP/2 frame and each TLS record.
+func estimateWireBytes(resp *http.Response, body int64) int64 {
+	// 1. account for the HTTP framing of the response
+	total := body
+	switch resp.ProtoMajor {
+	case 2:
+		// HPACK makes headers smaller than this, but we have no way of
+		// knowing the compressed size, so this is an upper bound
+		total += int64(http2FrameOverhead + headersSize(resp.Header))
+		total += divCeil(body, http2MaxFramePayload) * http2FrameOverhead
+	default:
+		total += int64(len(fmt.Sprintf("%s %s\r\n", resp.Proto, resp.Status)))
+		total += int64(headersSize(resp.Header) + len("\r\n"))
+	}
+
+	// 2. account for the TLS records carrying the HTTP framing
+	if resp.TLS != nil {
+		overhead := int64(tls12RecordOverhead)
+		if resp.TLS.Version == tls.VersionTLS13 {
+			overhead = tls13RecordOverhead
+		}
+		total += divCeil(total, tlsMaxRecordPayload) * overhead
+	}
+	return total
+}
+
+// headersSize returns the size of the headers in HTTP/1.1 format.
+func headersSize(header http.Header) (size int) {
+	for key, values := range header {
+		for _, value := range values {
+			size += len(key) + len(": ") + len(value) + len("\r\n")
+		}
+	}
+	return
+}
+
+// divCeil returns the integer division of a by b rounded up.
+func divCeil(a, b int64) int64 {
+	return (a + b - 1) / b
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"crypto/tls"
+	"net/http"
+	"testing"
+)
+
+func TestEstimateWireBytes(t *testing.T) {
+	header := http.Header{"Content-Type": []string{"video/mp4"}}
+	headerSize := int64(len("Content-Type: video/mp4\r\n"))
+
+	t.Run("with HTTP/1.1 over TCP", func(t *testing.T) {
+		resp := &http.Response{
+			Header:     header,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			Status:     "200 OK",
+		}
+		expect := 1000 + int64(len("HTTP/1.1 200 OK\r\n")) + headerSize + 2
+		if got := estimateWireBytes(resp, 1000); got != expect {
+			t.Fatal("unexpected estimate", got, expect)
+		}
+	})
+
+	t.Run("with HTTP/1.1 over TLS 1.3", func(t *testing.T) {
+		resp := &http.Response{
+			Header:     header,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			Status:     "200 OK",
+			TLS:        &tls.ConnectionState{Version: tls.VersionTLS13},
+		}
+		plaintext := 1<<15 + int64(len("HTTP/1.1 200 OK\r\n")) + headerSize + 2
+		expect := plaintext + 3*tls13RecordOverhead
+		if got := estimateWireBytes(resp, 1<<15); got != expect {
+			t.Fatal("unexpected estimate", got, expect)
+		}
+	})
+
+	t.Run("with HTTP/2 over TLS 1.2", func(t *testing.T) {
+		resp := &http.Response{
+			Header:     header,
+			Proto:      "HTTP/2.0",
+			ProtoMajor: 2,
+			Status:     "200 OK",
+			TLS:        &tls.ConnectionState{Version: tls.VersionTLS12},
+		}
+		plaintext := 1<<15 + http2FrameOverhead + headerSize + 2*http2FrameOverhead
+		expect := plaintext + 3*tls12RecordOverhead
+		if got := estimateWireBytes(resp, 1<<15); got != expect {
+			t.Fatal("unexpected estimate", got, expect)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/neubot/dash/model"
+)
+
+// PairedResults contains the results of running the DASH test simultaneously
+// against a control server (e.g., an off-net server) and a test server (e.g.,
+// an on-net cache). Comparing the two allows to detect whether the ISP treats
+// the streaming traffic towards the two servers differently.
+type PairedResults struct {
+	// Control contains the results of the control measurement.
+	Control *PairedSideResults `json:"control"`
+
+	// RateRatio is the ratio between the test median rate and the
+	// control median rate. It is zero when either median is zero.
+	RateRatio float64 `json:"rate_ratio"`
+
+	// Test contains the results of the test measurement.
+	Test *PairedSideResults `json:"test"`
+}
+
+// PairedSideResults contains the results of one side of [PairedResults].
+type PairedSideResults struct {
+	// Client contains the results measured by the client.
+	Client []model.ClientResults `json:"client"`
+
+	// FQDN is the server we measured with.
+	FQDN string `json:"fqdn"`
+
+	// Failure is the error that occurred or empty on success.
+	Failure string `json:"failure,omitempty"`
+
+	// MedianRate is the median rate of the successful iterations in kbit/s.
+	MedianRate float64 `json:"median_rate"`
+}
+
+// RunPaired runs the DASH test simultaneously using the control and the
+// test clients, which should be configured to use different servers, and
+// returns the paired comparison. When both clients share the same HTTP
+// client, we make sure that the test client uses separate connections.
+func RunPaired(ctx context.Context, control, test *Client) *PairedResults {
+	if control.HTTPClient == test.HTTPClient {
+		test.HTTPClient = separateHTTPClient(test.HTTPClient)
+	}
+	results := &PairedResults{}
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		results.Control = runSide(ctx, control, (*Client).StartDownload)
+	}()
+	go func() {
+		defer wg.Done()
+		results.Test = runSide(ctx, test, (*Client).StartDownload)
+	}()
+	wg.Wait()
+	results.RateRatio = rateRatio(results.Test.MedianRate, results.Control.MedianRate)
+	return results
+}
+
+// MedianRate returns the median rate in kbit/s of the successful
+// iterations within the given results or zero if there are none.
+func MedianRate(results []model.ClientResults) float64 {
+	var rates []float64
+	for _, current := range results {
+		if current.Failure != "" || current.Elapsed <= 0 {
+			continue
+		}
+		rates = append(rates, float64(current.Received)*8/current.Elapsed/1000)
+	}
+	if len(rates) <= 0 {
+		return 0
+	}
+	sort.Float64s(rates)
+	if len(rates)%2 == 0 {
+		return (rates[len(rates)/2-1] + rates[len(rates)/2]) / 2
+	}
+	return rates[len(rates)/2]
+}
+
+// separateHTTPClient returns a copy of the given HTTP client using a
+// separate connection pool, when possible, or the client itself.
+func separateHTTPClient(httpClient *http.Client) *http.Client {
+	roundTripper := httpClient.Transport
+	if roundTripper == nil {
+		roundTripper = http.DefaultTransport
+	}
+	transport, ok := roundTripper.(*http.Transport)
+	if !ok {
+		return httpClient
+	}
+	separate := *httpClient
+	separate.Transport = transport.Clone()
+	return &separate
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+// newPairedClient returns a client for testing RunPaired that downloads
+// each segment at the given rate in kbit/s or fails to negotiate.
+func newPairedClient(fqdn string, rate int64, negotiateErr error) *Client {
+	client := New(softwareName, softwareVersion)
+	client.FQDN = fqdn
+	client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+		return model.NegotiateResponse{}, negotiateErr
+	}
+	client.deps.Download = func(
+		ctx context.Context, authorization string,
+		current *model.ClientResults, negotiateURL *url.URL,
+	) error {
+		current.Elapsed = 1
+		current.Received = rate * 1000 / 8
+		return nil
+	}
+	client.deps.Collect = func(ctx context.Context, authorization string, negotiateURL *url.URL) error {
+		return nil
+	}
+	return client
+}
+
+func TestRunPaired(t *testing.T) {
+	t.Run("common case", func(t *testing.T) {
+		control := newPairedClient("control.example.com", 4000, nil)
+		test := newPairedClient("test.example.com", 1000, nil)
+		results := RunPaired(context.Background(), control, test)
+		if results.Control.Failure != "" || results.Test.Failure != "" {
+			t.Fatal("expected no failures")
+		}
+		if len(results.Control.Client) != int(control.Iterations) {
+			t.Fatal("unexpected number of control results")
+		}
+		if results.Control.MedianRate != 4000 || results.Test.MedianRate != 1000 {
+			t.Fatal("unexpected median rates", results.Control.MedianRate, results.Test.MedianRate)
+		}
+		if results.RateRatio != 0.25 {
+			t.Fatal("unexpected rate ratio", results.RateRatio)
+		}
+		if test.HTTPClient == control.HTTPClient {
+			t.Fatal("expected separate HTTP clients")
+		}
+	})
+
+	t.Run("failure of one side", func(t *testing.T) {
+		control := newPairedClient("control.example.com", 4000, nil)
+		test := newPairedClient("test.example.com", 1000, errors.New("Mocked error"))
+		results := RunPaired(context.Background(), control, test)
+		if results.Test.Failure != "Mocked error" {
+			t.Fatal("not the failure we expected", results.Test.Failure)
+		}
+		if results.RateRatio != 0 {
+			t.Fatal("expected zero rate ratio")
+		}
+	})
+}
+
+func TestMedianRate(t *testing.T) {
+	results := []model.ClientResults{
+		{Elapsed: 1, Received: 1000},
+		{Elapsed: 1, Received: 3000},
+		{Failure: "Mocked error"},
+		{Elapsed: 1, Received: 2000},
+		{Elapsed: 1, Received: 4000},
+	}
+	if rate := MedianRate(results); rate != 20 {
+		t.Fatal("unexpected median rate", rate)
+	}
+	if rate := MedianRate(nil); rate != 0 {
+		t.Fatal("expected zero")
+	}
+}
+
+func TestSeparateHTTPClient(t *testing.T) {
+	t.Run("with custom round tripper", func(t *testing.T) {
+		httpClient := &http.Client{Transport: &mockableTransport{}}
+		if separateHTTPClient(httpClient) != httpClient {
+			t.Fatal("expected the same client")
+		}
+	})
+
+	t.Run("with default transport", func(t *testing.T) {
+		httpClient := &http.Client{}
+		separate := separateHTTPClient(httpClient)
+		if separate == httpClient || separate.Transport == http.DefaultTransport {
+			t.Fatal("expected a separate client")
+		}
+	})
+}
+
+// mockableTransport is a custom [http.RoundTripper].
+type mockableTransport struct{}
+
+func (*mockableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, errors.New("Mocked error")
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/neubot/dash/spec"
+)
+
+// These are the possible results of verifying the payload.
+const (
+	payloadCheckMismatch = "mismatch"
+	payloadCheckOK       = "ok"
+)
+
+// payloadVerifierBufferSize is the size of the buffer we use for generating
+// the expected payload, which bounds the memory used for verifying.
+const payloadVerifierBufferSize = 1 << 14
+
+// payloadVerifier is an [io.Reader] comparing the bytes it reads with the
+// deterministic payload the server derives from the session seed.
+type payloadVerifier struct {
+	// body is the response body.
+	body io.Reader
+
+	// buffer contains the expected payload.
+	buffer []byte
+
+	// expected generates the expected payload.
+	expected io.Reader
+
+	// mismatch indicates whether the payload differs from the expected one.
+	mismatch bool
+}
+
+// newPayloadVerifier creates a new [*payloadVerifier] for the segment with
+// the given iteration index of the session using the given seed.
+func newPayloadVerifier(body io.Reader, seed []byte, iteration int64) *payloadVerifier {
+	return &payloadVerifier{
+		body:     body,
+		buffer:   make([]byte, payloadVerifierBufferSize),
+		expected: spec.NewPayloadReader(seed, iteration),
+		mismatch: false,
+	}
+}
+
+// Read implements io.Reader.
+func (pv *payloadVerifier) Read(p []byte) (int, error) {
+	count, err := pv.body.Read(p)
+	for offset := 0; offset < count && !pv.mismatch; {
+		chunk := min(count-offset, len(pv.buffer))
+		_, _ = pv.expected.Read(pv.buffer[:chunk]) // never fails
+		pv.mismatch = !bytes.Equal(p[offset:offset+chunk], pv.buffer[:chunk])
+		offset += chunk
+	}
+	return count, err
+}
+
+// result returns the result of the verification.
+func (pv *payloadVerifier) result() string {
+	if pv.mismatch {
+		return payloadCheckMismatch
+	}
+	return payloadCheckOK
+}
@@ -0,0 +1,118 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+	"github.com/neubot/dash/spec"
+)
+
+func TestPayloadVerifier(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, spec.SeedSize)
+	const size = 3*payloadVerifierBufferSize + 17
+
+	t.Run("with the expected payload", func(t *testing.T) {
+		payload := make([]byte, size)
+		spec.FillPayload(seed, 3, payload)
+		verifier := newPayloadVerifier(bytes.NewReader(payload), seed, 3)
+		data, err := io.ReadAll(verifier)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, payload) {
+			t.Fatal("the verifier should not modify the payload")
+		}
+		if verifier.result() != payloadCheckOK {
+			t.Fatal("unexpected result", verifier.result())
+		}
+	})
+
+	t.Run("with the payload of another iteration", func(t *testing.T) {
+		payload := make([]byte, size)
+		spec.FillPayload(seed, 4, payload)
+		verifier := newPayloadVerifier(bytes.NewReader(payload), seed, 3)
+		if _, err := io.ReadAll(verifier); err != nil {
+			t.Fatal(err)
+		}
+		if verifier.result() != payloadCheckMismatch {
+			t.Fatal("unexpected result", verifier.result())
+		}
+	})
+
+	t.Run("with a byte flipped near the end", func(t *testing.T) {
+		payload := make([]byte, size)
+		spec.FillPayload(seed, 3, payload)
+		payload[size-2] ^= 0x01
+		verifier := newPayloadVerifier(bytes.NewReader(payload), seed, 3)
+		if _, err := io.ReadAll(verifier); err != nil {
+			t.Fatal(err)
+		}
+		if verifier.result() != payloadCheckMismatch {
+			t.Fatal("unexpected result", verifier.result())
+		}
+	})
+}
+
+func TestClientDownloadVerifiesPayload(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, spec.SeedSize)
+	newClient := func(corrupt bool) *Client {
+		client := New(softwareName, softwareVersion)
+		client.payloadSeed = seed
+		var iteration int64
+		client.deps.HTTPClientDo = func(req *http.Request) (*http.Response, error) {
+			payload := make([]byte, 1024)
+			spec.FillPayload(seed, iteration, payload)
+			iteration++
+			if corrupt {
+				payload[0] ^= 0xff
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(bytes.NewReader(payload)),
+			}, nil
+		}
+		return client
+	}
+
+	t.Run("common case", func(t *testing.T) {
+		client := newClient(false)
+		for range 3 {
+			current := new(model.ClientResults)
+			if err := client.download(context.Background(), "abc", current, &url.URL{}); err != nil {
+				t.Fatal(err)
+			}
+			if current.PayloadCheck != payloadCheckOK {
+				t.Fatal("unexpected payload check", current.PayloadCheck)
+			}
+		}
+	})
+
+	t.Run("with a corrupted payload", func(t *testing.T) {
+		client := newClient(true)
+		current := new(model.ClientResults)
+		if err := client.download(context.Background(), "abc", current, &url.URL{}); err != nil {
+			t.Fatal(err)
+		}
+		if current.PayloadCheck != payloadCheckMismatch {
+			t.Fatal("unexpected payload check", current.PayloadCheck)
+		}
+	})
+
+	t.Run("without a seed", func(t *testing.T) {
+		client := newClient(false)
+		client.payloadSeed = nil
+		current := new(model.ClientResults)
+		if err := client.download(context.Background(), "abc", current, &url.URL{}); err != nil {
+			t.Fatal(err)
+		}
+		if current.PayloadCheck != "" {
+			t.Fatal("unexpected payload check", current.PayloadCheck)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+)
+
+var (
+	// errConnectionNotPinned is returned when a request does not reuse
+	// the connection we are pinning the session to.
+	errConnectionNotPinned = errors.New("request did not reuse the pinned connection")
+
+	// errPinningNotSupported is returned when we cannot pin the session
+	// to a single connection because of a custom transport.
+	errPinningNotSupported = errors.New("pinning the connection is not supported")
+)
+
+// connPinner tracks the connections used by the session requests
+// using [httptrace.ClientTrace] hooks.
+type connPinner struct {
+	// conns is the number of new connections we used.
+	conns int
+
+	// mtx protects conns.
+	mtx sync.Mutex
+}
+
+// wrap returns a context configured to use the pinner hooks.
+func (cp *connPinner) wrap(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: cp.gotConn,
+	})
+}
+
+// gotConn is called when the request obtained a connection.
+func (cp *connPinner) gotConn(info httptrace.GotConnInfo) {
+	cp.mtx.Lock()
+	defer cp.mtx.Unlock()
+	if !info.Reused {
+		cp.conns++
+	}
+}
+
+// pinned returns whether all the requests used the same connection.
+func (cp *connPinner) pinned() bool {
+	cp.mtx.Lock()
+	defer cp.mtx.Unlock()
+	return cp.conns <= 1
+}
+
+// newPinnedHTTPClient returns a copy of the HTTPClient whose transport
+// uses a separate pool with at most a single connection to the server,
+// which is kept alive across the session requests.
+func (c *Client) newPinnedHTTPClient() (*http.Client, error) {
+	roundTripper := c.HTTPClient.Transport
+	if roundTripper == nil {
+		roundTripper = http.DefaultTransport
+	}
+	transport, ok := roundTripper.(*http.Transport)
+	if !ok {
+		return nil, errPinningNotSupported
+	}
+	transport = transport.Clone()
+	transport.DisableKeepAlives = false
+	transport.MaxConnsPerHost = 1
+	transport.MaxIdleConnsPerHost = 1
+	httpClient := *c.HTTPClient
+	httpClient.Transport = transport
+	return &httpClient, nil
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestClientPinConnection(t *testing.T) {
+	run := func(t *testing.T, closeConn bool) error {
+		srvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if closeConn {
+				w.Header().Set("Connection", "close")
+			}
+			w.Write([]byte("abc"))
+		}))
+		defer srvr.Close()
+		URL, err := url.Parse(srvr.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := New(softwareName, softwareVersion)
+		client.HTTPClient = &http.Client{Transport: &http.Transport{}}
+		client.PinConnection = true
+		httpClient, err := client.newPinnedHTTPClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.HTTPClient = httpClient
+		for idx := 0; idx < 3; idx++ {
+			current := &model.ClientResults{Rate: 100, ElapsedTarget: 2}
+			if err := client.download(context.Background(), "abc", current, URL); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	t.Run("when the server keeps the connection alive", func(t *testing.T) {
+		if err := run(t, false); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("when the server closes the connection", func(t *testing.T) {
+		if err := run(t, true); !errors.Is(err, errConnectionNotPinned) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+}
+
+func TestClientNewPinnedHTTPClient(t *testing.T) {
+	t.Run("with default transport", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		httpClient, err := client.newPinnedHTTPClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport := httpClient.Transport.(*http.Transport)
+		if transport == http.DefaultTransport || transport.MaxConnsPerHost != 1 {
+			t.Fatal("expected a separate single connection transport")
+		}
+	})
+
+	t.Run("with custom transport", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.HTTPClient = &http.Client{Transport: roundTripperFunc(nil)}
+		if _, err := client.newPinnedHTTPClient(); !errors.Is(err, errPinningNotSupported) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+}
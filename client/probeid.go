@@ -0,0 +1,50 @@
+package client
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// cacheDirProbeIDFile is the file inside the CacheDir containing
+// the persistent probe ID (see PersistProbeID).
+const cacheDirProbeIDFile = "probe-id.json"
+
+// persistentProbeID is the probe ID persisted inside the CacheDir.
+type persistentProbeID struct {
+	// Created is when we generated the probe ID.
+	Created time.Time `json:"created"`
+
+	// ID is the probe ID.
+	ID string `json:"id"`
+}
+
+// newProbeID returns a new random probe ID.
+func newProbeID() string {
+	data := make([]byte, 16)
+	_, _ = cryptorand.Read(data) // never fails on supported platforms
+	return hex.EncodeToString(data)
+}
+
+// loadProbeID sets the probeID and probeIDPersistent fields. By default, we
+// use a new random probe ID for each run. With PersistProbeID, we reuse the
+// probe ID saved inside the CacheDir, creating it if needed. When we cannot
+// save it, we log and use an ephemeral probe ID, so that the results do not
+// claim that we can link them with the results of future runs.
+func (c *Client) loadProbeID() {
+	c.probeID, c.probeIDPersistent = newProbeID(), false
+	if !c.PersistProbeID || c.CacheDir == "" {
+		return
+	}
+	var saved persistentProbeID
+	if err := readCacheFile(c.CacheDir, cacheDirProbeIDFile, &saved); err == nil && saved.ID != "" {
+		c.probeID, c.probeIDPersistent = saved.ID, true
+		return
+	}
+	saved = persistentProbeID{Created: c.TimeNow().UTC(), ID: c.probeID}
+	if !c.writeCache(cacheDirProbeIDFile, &saved) {
+		c.Logger.Warn("dash: cannot persist the probe ID; using an ephemeral one")
+		return
+	}
+	c.probeIDPersistent = true
+}
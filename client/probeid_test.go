@@ -0,0 +1,58 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientLoadProbeID(t *testing.T) {
+	t.Run("by default we use an ephemeral probe ID", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.CacheDir = t.TempDir()
+		client.loadProbeID()
+		first := client.probeID
+		client.loadProbeID()
+		if first == "" || len(first) != 32 || client.probeID == first || client.probeIDPersistent {
+			t.Fatal("expected a new ephemeral probe ID", first, client.probeID)
+		}
+		if _, err := os.Stat(filepath.Join(client.CacheDir, cacheDirProbeIDFile)); !os.IsNotExist(err) {
+			t.Fatal("expected not to persist the probe ID")
+		}
+	})
+
+	t.Run("with PersistProbeID we reuse the probe ID", func(t *testing.T) {
+		dir := t.TempDir()
+		var ids []string
+		for idx := 0; idx < 2; idx++ {
+			client := New(softwareName, softwareVersion)
+			client.CacheDir = dir
+			client.PersistProbeID = true
+			client.loadProbeID()
+			if !client.probeIDPersistent {
+				t.Fatal("expected a persistent probe ID")
+			}
+			if metadata := client.FinalResult().Metadata; metadata.ProbeID != client.probeID || !metadata.ProbeIDPersistent {
+				t.Fatalf("unexpected metadata: %+v", metadata)
+			}
+			ids = append(ids, client.probeID)
+		}
+		if ids[0] != ids[1] {
+			t.Fatal("expected the same probe ID")
+		}
+	})
+
+	t.Run("with PersistProbeID when we cannot persist", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(file, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		client := New(softwareName, softwareVersion)
+		client.CacheDir = filepath.Join(file, "cache") // cannot create a directory inside a file
+		client.PersistProbeID = true
+		client.loadProbeID()
+		if client.probeID == "" || client.probeIDPersistent {
+			t.Fatal("expected an ephemeral probe ID")
+		}
+	})
+}
@@ -0,0 +1,25 @@
+package client
+
+import (
+	"math"
+
+	"github.com/neubot/dash/model"
+)
+
+// recordQoE sets the BufferLevel, Stalls, and StallTime of the given result,
+// which we are about to append to the results, emulating the same player
+// used by bufferLevel. The player stalls when it has already started playing
+// and transferring the segment takes longer than playing the buffer.
+func (c *Client) recordQoE(current *model.ClientResults) {
+	buffer := bufferLevel(c.clientResults).Seconds()
+	_, playing := lastSuccess(c.clientResults)
+	current.Stalls, current.StallTime = 0, 0
+	if playing && current.Elapsed > buffer {
+		current.Stalls, current.StallTime = 1, current.Elapsed-buffer
+	}
+	buffer = math.Max(buffer-current.Elapsed, 0)
+	if current.Failure == "" {
+		buffer += float64(current.ElapsedTarget)
+	}
+	current.BufferLevel = buffer
+}
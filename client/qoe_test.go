@@ -0,0 +1,45 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestClientRecordQoE(t *testing.T) {
+	client := New(softwareName, softwareVersion)
+	for _, tc := range []struct {
+		current   model.ClientResults
+		buffer    float64
+		stalls    int64
+		stallTime float64
+	}{{
+		current: model.ClientResults{Elapsed: 3, ElapsedTarget: 2}, // the player starts
+		buffer:  2,
+	}, {
+		current: model.ClientResults{Elapsed: 1, ElapsedTarget: 2},
+		buffer:  3,
+	}, {
+		current:   model.ClientResults{Elapsed: 4, ElapsedTarget: 2, Failure: "mocked error"},
+		buffer:    0,
+		stalls:    1,
+		stallTime: 1,
+	}, {
+		current:   model.ClientResults{Elapsed: 0.5, ElapsedTarget: 2},
+		buffer:    2,
+		stalls:    1,
+		stallTime: 0.5,
+	}} {
+		current := tc.current
+		current.Stalls, current.StallTime = 7, 7 // must be overwritten
+		client.recordQoE(&current)
+		if current.BufferLevel != tc.buffer || current.Stalls != tc.stalls || current.StallTime != tc.stallTime {
+			t.Fatalf("unexpected QoE metrics: %+v", current)
+		}
+		client.clientResults = append(client.clientResults, current)
+	}
+	buffer := bufferLevel(client.clientResults).Seconds()
+	if last := client.clientResults[len(client.clientResults)-1]; last.BufferLevel != buffer {
+		t.Fatal("expected the same buffer level as bufferLevel", last.BufferLevel, buffer)
+	}
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RequestLimiter paces the requests sent by one or more [*Client]
+// instances, so that applications running many clients in parallel (e.g.,
+// load generators) stay within the rate limits of the servers and of
+// m-lab/locate/v2. You can share the same limiter among several clients
+// using their RequestLimiter field. Use NewRequestLimiter to create a
+// new instance.
+//
+// The limiter paces the locate, negotiate, download, and collect requests.
+// Because we wait before starting to measure each segment download, the
+// pacing delays the test but does not affect the measured rate.
+type RequestLimiter struct {
+	// burst is the number of requests we allow back to back.
+	burst int
+
+	// interval is the interval between requests at the maximum rate.
+	interval time.Duration
+
+	// mtx protects next.
+	mtx sync.Mutex
+
+	// next is the theoretical time of the next request at the maximum
+	// rate, which is in the future when we have used the burst.
+	next time.Time
+
+	// timeNow allows to override calling [time.Now].
+	timeNow func() time.Time
+}
+
+// NewRequestLimiter creates a new [*RequestLimiter] allowing at most the
+// given number of requests per second, with bursts of at most the given
+// number of requests. A burst smaller than one means one. A rate that
+// is not positive means that we do not limit requests.
+func NewRequestLimiter(rate float64, burst int) *RequestLimiter {
+	var interval time.Duration
+	if rate > 0 {
+		interval = time.Duration(float64(time.Second) / rate)
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &RequestLimiter{
+		burst:    burst,
+		interval: interval,
+		mtx:      sync.Mutex{},
+		next:     time.Time{},
+		timeNow:  time.Now,
+	}
+}
+
+// reserve reserves a slot for sending a request and returns for how
+// long the caller should wait before sending it.
+func (rl *RequestLimiter) reserve() time.Duration {
+	rl.mtx.Lock()
+	defer rl.mtx.Unlock()
+	now := rl.timeNow()
+	if rl.next.Before(now) {
+		rl.next = now
+	}
+	allowed := rl.next.Add(-time.Duration(rl.burst-1) * rl.interval)
+	rl.next = rl.next.Add(rl.interval)
+	if allowed.Before(now) {
+		return 0
+	}
+	return allowed.Sub(now)
+}
+
+// Wait blocks until we can send the next request or the context is done,
+// in which case it returns the context error.
+func (rl *RequestLimiter) Wait(ctx context.Context) error {
+	delay := rl.reserve()
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// pace waits until the RequestLimiter, if any, allows us to send the
+// next request, or until the context is done.
+func (c *Client) pace(ctx context.Context) error {
+	if c.RequestLimiter == nil {
+		return nil
+	}
+	return c.RequestLimiter.Wait(ctx)
+}
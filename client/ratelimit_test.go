@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRequestLimiter(t *testing.T) {
+	t.Run("we allow the burst and then pace requests", func(t *testing.T) {
+		now := time.Date(2024, time.January, 29, 20, 23, 0, 0, time.UTC)
+		limiter := NewRequestLimiter(2, 3)
+		limiter.timeNow = func() time.Time { return now }
+		var delays []time.Duration
+		for idx := 0; idx < 5; idx++ {
+			delays = append(delays, limiter.reserve())
+		}
+		expect := []time.Duration{0, 0, 0, 500 * time.Millisecond, time.Second}
+		for idx := range expect {
+			if delays[idx] != expect[idx] {
+				t.Fatal("unexpected delay at", idx, delays[idx])
+			}
+		}
+		now = now.Add(time.Minute)
+		if delay := limiter.reserve(); delay != 0 {
+			t.Fatal("expected the burst to be available again", delay)
+		}
+	})
+
+	t.Run("a non positive rate means no limit", func(t *testing.T) {
+		limiter := NewRequestLimiter(0, 0)
+		for idx := 0; idx < 100; idx++ {
+			if delay := limiter.reserve(); delay != 0 {
+				t.Fatal("unexpected delay", delay)
+			}
+		}
+	})
+
+	t.Run("Wait honours the context", func(t *testing.T) {
+		limiter := NewRequestLimiter(0.001, 1)
+		if err := limiter.Wait(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := limiter.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+}
+
+func TestClientPace(t *testing.T) {
+	t.Run("without a limiter", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := client.pace(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("with a shared limiter", func(t *testing.T) {
+		limiter := NewRequestLimiter(0.001, 1)
+		first, second := New(softwareName, softwareVersion), New(softwareName, softwareVersion)
+		first.RequestLimiter, second.RequestLimiter = limiter, limiter
+		if err := first.pace(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := second.pace(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"errors"
+
+	"github.com/neubot/dash/spec"
+)
+
+// maxRates is the maximum number of Rates, which is also the maximum
+// number of rates that the server saves with the results.
+const maxRates = 64
+
+// errInvalidRates is returned when the Rates are invalid.
+var errInvalidRates = errors.New("invalid rates")
+
+// validateRates returns an error when the Rates are not empty and are not
+// a list of at most maxRates positive rates in strictly increasing order.
+func (c *Client) validateRates() error {
+	if len(c.Rates) > maxRates {
+		return errInvalidRates
+	}
+	for idx, rate := range c.Rates {
+		if rate <= 0 || (idx > 0 && rate <= c.Rates[idx-1]) {
+			return errInvalidRates
+		}
+	}
+	return nil
+}
+
+// ladder returns the rates we send when negotiating, i.e., the
+// Rates, if any, and otherwise the spec.DefaultRates.
+func (c *Client) ladder() []int64 {
+	if len(c.Rates) > 0 {
+		return c.Rates
+	}
+	return spec.DefaultRates
+}
+
+// clampRate returns the largest of the Rates that is not above the given
+// rate, or the lowest of the Rates if there is none. When the Rates are
+// empty, we do not constrain the rate and return the given rate.
+func (c *Client) clampRate(rate int64) int64 {
+	if len(c.Rates) <= 0 {
+		return rate
+	}
+	clamped := c.Rates[0]
+	for _, candidate := range c.Rates {
+		if candidate <= rate {
+			clamped = candidate
+		}
+	}
+	return clamped
+}
+
+// stepDown is like lowerRate but uses the Rates, if any.
+func (c *Client) stepDown(rate int64) int64 {
+	if len(c.Rates) <= 0 {
+		return lowerRate(rate)
+	}
+	return c.clampRate(rate - 1)
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/neubot/dash/model"
+	"github.com/neubot/dash/spec"
+)
+
+func TestClientValidateRates(t *testing.T) {
+	for _, tc := range []struct {
+		rates []int64
+		valid bool
+	}{
+		{rates: nil, valid: true},
+		{rates: []int64{100, 200, 400}, valid: true},
+		{rates: []int64{0, 200}, valid: false},
+		{rates: []int64{200, 200}, valid: false},
+		{rates: []int64{400, 200}, valid: false},
+		{rates: make([]int64, maxRates+1), valid: false},
+	} {
+		client := New(softwareName, softwareVersion)
+		client.Rates = tc.rates
+		err := client.validateRates()
+		if tc.valid != (err == nil) {
+			t.Fatal("unexpected result", tc.rates, err)
+		}
+		if err != nil && !errors.Is(err, errInvalidRates) {
+			t.Fatal("not the error we expected", err)
+		}
+	}
+}
+
+func TestClientClampRate(t *testing.T) {
+	t.Run("without rates", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		if rate := client.clampRate(123456); rate != 123456 {
+			t.Fatal("unexpected rate", rate)
+		}
+		if rate := client.stepDown(3000); rate != lowerRate(3000) {
+			t.Fatal("unexpected rate", rate)
+		}
+		if !slices.Equal(client.ladder(), spec.DefaultRates) {
+			t.Fatal("unexpected ladder")
+		}
+	})
+
+	t.Run("with rates", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.Rates = []int64{500, 1000, 4000}
+		for _, tc := range []struct{ rate, clamped, lower int64 }{
+			{rate: 100, clamped: 500, lower: 500},
+			{rate: 1000, clamped: 1000, lower: 500},
+			{rate: 3999, clamped: 1000, lower: 1000},
+			{rate: 20000, clamped: 4000, lower: 4000},
+		} {
+			if rate := client.clampRate(tc.rate); rate != tc.clamped {
+				t.Fatal("unexpected clamped rate", tc.rate, rate)
+			}
+			if rate := client.stepDown(tc.rate); rate != tc.lower {
+				t.Fatal("unexpected lower rate", tc.rate, rate)
+			}
+		}
+	})
+}
+
+func TestClientNegotiateRates(t *testing.T) {
+	var request model.NegotiateRequest
+	srvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(data, &request)
+		w.Write([]byte(`{"authorization":"xx","unchoked":1}`))
+	}))
+	defer srvr.Close()
+	URL, err := url.Parse(srvr.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(softwareName, softwareVersion)
+	client.Rates = []int64{500, 1000}
+	if _, err := client.negotiate(context.Background(), URL); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(request.DASHRates, client.Rates) {
+		t.Fatal("unexpected rates", request.DASHRates)
+	}
+}
+
+func TestClientLoopRates(t *testing.T) {
+	ch := make(chan model.ClientResults)
+	client := New(softwareName, softwareVersion)
+	client.Rates = []int64{500, 1000}
+	client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+		return model.NegotiateResponse{}, nil
+	}
+	client.deps.Download = func(
+		ctx context.Context, authorization string,
+		current *model.ClientResults, negotiateURL *url.URL,
+	) error {
+		current.Elapsed = 1
+		current.Received = 1 << 20
+		return nil
+	}
+	client.deps.Collect = func(ctx context.Context, authorization string, negotiateURL *url.URL) error {
+		return nil
+	}
+	go client.loop(context.Background(), ch, &url.URL{})
+	for result := range ch {
+		if result.Rate != 1000 {
+			t.Fatal("unexpected rate", result.Rate)
+		}
+	}
+	if client.err != nil {
+		t.Fatal(client.err)
+	}
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrRedirect is returned when the server, or a middlebox such as a
+// misconfigured proxy, redirects a measurement request. We never follow
+// redirects, since following them silently would corrupt the timing and
+// the byte accounting of the measurement.
+var ErrRedirect = errors.New("unexpected redirect")
+
+// redirectError is the error returned when we receive a redirect that
+// does not look like a captive portal. It matches ErrRedirect.
+type redirectError struct {
+	// Location is the URL where we were redirected.
+	Location string
+
+	// StatusCode is the status code of the redirect.
+	StatusCode int
+}
+
+// Error implements error.
+func (err *redirectError) Error() string {
+	return ErrRedirect.Error()
+}
+
+// Is allows errors.Is to match ErrRedirect.
+func (err *redirectError) Is(target error) bool {
+	return target == ErrRedirect
+}
+
+// noRedirects is the CheckRedirect policy of the measurement requests,
+// which returns the redirect response to the caller rather than following it.
+func noRedirects(req *http.Request, via []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+// detectRedirect returns a non-nil error when the response is a redirect.
+// Call this function after detectCaptivePortal, which takes care of the
+// redirects to another host (i.e., to the login page of a portal).
+func detectRedirect(resp *http.Response) error {
+	if resp.StatusCode < 300 || resp.StatusCode >= 400 {
+		return nil
+	}
+	return &redirectError{
+		Location:   resp.Header.Get("Location"),
+		StatusCode: resp.StatusCode,
+	}
+}
+
+// isRedirected returns whether the given error indicates that a captive
+// portal or a middlebox is redirecting our requests, in which case retrying
+// is pointless because all the following requests will be redirected too.
+func isRedirected(err error) bool {
+	return errors.Is(err, ErrCaptivePortal) || errors.Is(err, ErrRedirect)
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestDetectRedirect(t *testing.T) {
+	t.Run("with success", func(t *testing.T) {
+		if err := detectRedirect(&http.Response{StatusCode: 200}); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("with redirect", func(t *testing.T) {
+		err := detectRedirect(&http.Response{
+			StatusCode: 307,
+			Header:     http.Header{"Location": {"/elsewhere"}},
+		})
+		if !errors.Is(err, ErrRedirect) || errors.Is(err, ErrCaptivePortal) {
+			t.Fatal("not the error we expected", err)
+		}
+		if isTransient(err) || !isRedirected(err) {
+			t.Fatal("unexpected classification")
+		}
+	})
+}
+
+func TestClientDownloadRedirect(t *testing.T) {
+	var followed bool
+	srvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/elsewhere" {
+			followed = true
+			return
+		}
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer srvr.Close()
+	URL, err := url.Parse(srvr.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(softwareName, softwareVersion)
+	current := &model.ClientResults{Rate: 100, ElapsedTarget: 2}
+	err = client.download(context.Background(), "abc", current, URL)
+	if !errors.Is(err, ErrRedirect) {
+		t.Fatal("not the error we expected", err)
+	}
+	if followed {
+		t.Fatal("we should not follow redirects")
+	}
+	client.fail(phaseDownload, URL, err)
+	report := client.FailureReport()
+	if report.HTTPStatus != http.StatusFound || report.Redirect != "/elsewhere" {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"context"
+	"maps"
+)
+
+// runMetadataKey is the context key for the run metadata.
+type runMetadataKey struct{}
+
+// WithRunMetadata returns a copy of ctx carrying the given run metadata
+// (e.g., {"run_id": "..."}), which allows applications embedding the client
+// to attach their identifiers to a run. When ctx already carries metadata,
+// we merge them, with the given values taking precedence. Passing the
+// returned context to [*Client.StartDownload] causes the metadata to flow
+// into the [FinalMetadata] of the [FinalResult]. We never submit the
+// metadata to the server.
+func WithRunMetadata(ctx context.Context, metadata map[string]string) context.Context {
+	merged := RunMetadata(ctx)
+	if merged == nil {
+		merged = make(map[string]string)
+	}
+	maps.Copy(merged, metadata)
+	return context.WithValue(ctx, runMetadataKey{}, merged)
+}
+
+// RunMetadata returns a copy of the run metadata carried by ctx
+// (see [WithRunMetadata]) or nil when there is no metadata.
+func RunMetadata(ctx context.Context) map[string]string {
+	metadata, _ := ctx.Value(runMetadataKey{}).(map[string]string)
+	return maps.Clone(metadata)
+}
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestRunMetadata(t *testing.T) {
+	t.Run("without metadata", func(t *testing.T) {
+		if RunMetadata(context.Background()) != nil {
+			t.Fatal("expected no metadata")
+		}
+	})
+
+	t.Run("merges metadata", func(t *testing.T) {
+		ctx := WithRunMetadata(context.Background(), map[string]string{"run_id": "a", "app": "x"})
+		ctx = WithRunMetadata(ctx, map[string]string{"run_id": "b"})
+		metadata := RunMetadata(ctx)
+		if len(metadata) != 2 || metadata["run_id"] != "b" || metadata["app"] != "x" {
+			t.Fatal("unexpected metadata", metadata)
+		}
+		metadata["run_id"] = "c"
+		if RunMetadata(ctx)["run_id"] != "b" {
+			t.Fatal("expected to return a copy")
+		}
+	})
+}
+
+func TestClientRunMetadata(t *testing.T) {
+	client := New(softwareName, softwareVersion)
+	client.FQDN = "dash.example.com"
+	var submitted []byte
+	client.deps.Loop = func(ctx context.Context, ch chan<- model.ClientResults, negotiateURL *url.URL) {
+		defer close(ch)
+		current := model.ClientResults{Iteration: 0}
+		client.clientResults = append(client.clientResults, current)
+		submitted, _ = json.Marshal(client.clientResults)
+		ch <- client.redact(current)
+	}
+	ctx := WithRunMetadata(context.Background(), map[string]string{"run_id": "abc"})
+	ch, err := client.StartDownload(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range ch {
+		// drain channel
+	}
+	final := client.FinalResult()
+	if final.Metadata.RunMetadata["run_id"] != "abc" {
+		t.Fatal("unexpected final metadata")
+	}
+	if strings.Contains(string(submitted), "run_metadata") {
+		t.Fatal("expected not to submit the metadata", string(submitted))
+	}
+}
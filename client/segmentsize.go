@@ -0,0 +1,24 @@
+package client
+
+import "github.com/neubot/dash/model"
+
+// segmentSize returns the number of bytes to request for the segment given
+// the current rate. When the server returned the segment size bounds during
+// the negotiation, we clamp the size to the bounds and update the rate to
+// be consistent with the size we request, because otherwise the server would
+// send a segment with a different size than the requested one.
+func (c *Client) segmentSize(current *model.ClientResults) int64 {
+	nbytes := (current.Rate * 1000 * current.ElapsedTarget) >> 3
+	clamped := nbytes
+	if c.segmentMinSize > 0 && clamped < c.segmentMinSize {
+		clamped = c.segmentMinSize
+	}
+	if c.segmentMaxSize > 0 && clamped > c.segmentMaxSize {
+		clamped = c.segmentMaxSize
+	}
+	if clamped != nbytes && current.ElapsedTarget > 0 {
+		c.Logger.Debugf("dash: clamping the segment size from %d to %d bytes", nbytes, clamped)
+		current.Rate = (clamped << 3) / (1000 * current.ElapsedTarget)
+	}
+	return clamped
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestClientSegmentSize(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		minSize    int64
+		maxSize    int64
+		rate       int64
+		expectSize int64
+		expectRate int64
+	}{{
+		name:       "without bounds",
+		rate:       50,
+		expectSize: 12500,
+		expectRate: 50,
+	}, {
+		name:       "within bounds",
+		minSize:    25000,
+		maxSize:    7500000,
+		rate:       3000,
+		expectSize: 750000,
+		expectRate: 3000,
+	}, {
+		name:       "below the minimum",
+		minSize:    25000,
+		maxSize:    7500000,
+		rate:       50,
+		expectSize: 25000,
+		expectRate: 100,
+	}, {
+		name:       "above the maximum",
+		minSize:    25000,
+		maxSize:    7500000,
+		rate:       100000,
+		expectSize: 7500000,
+		expectRate: 30000,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			client := New(softwareName, softwareVersion)
+			client.segmentMinSize, client.segmentMaxSize = tc.minSize, tc.maxSize
+			current := &model.ClientResults{ElapsedTarget: 2, Rate: tc.rate}
+			if size := client.segmentSize(current); size != tc.expectSize {
+				t.Fatal("unexpected size", size)
+			}
+			if current.Rate != tc.expectRate {
+				t.Fatal("unexpected rate", current.Rate)
+			}
+		})
+	}
+}
+
+func TestClientDownloadClamped(t *testing.T) {
+	run := func(bodySize int) *model.ClientResults {
+		client := New(softwareName, softwareVersion)
+		client.deps.HTTPClientDo = func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: 200,
+				Header:     make(http.Header),
+				Body:       io.NopCloser(bytes.NewReader(make([]byte, bodySize))),
+			}, nil
+		}
+		current := &model.ClientResults{ElapsedTarget: 2, Rate: 100}
+		if err := client.download(context.Background(), "abc", current, &url.URL{}); err != nil {
+			t.Fatal(err)
+		}
+		return current
+	}
+
+	t.Run("when we receive the requested size", func(t *testing.T) {
+		current := run(25000)
+		if current.Clamped || current.SizeDelta != 0 || current.Received != 25000 {
+			t.Fatalf("unexpected results: %+v", current)
+		}
+	})
+
+	t.Run("when the server clamps the size", func(t *testing.T) {
+		current := run(30000)
+		if !current.Clamped || current.SizeDelta != 5000 || current.Received != 30000 {
+			t.Fatalf("unexpected results: %+v", current)
+		}
+	})
+}
@@ -0,0 +1,27 @@
+package client
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/neubot/dash/spec"
+)
+
+// serverTiming returns the queueing delay and the send duration, in seconds,
+// that servers configured for server timing report in the trailers of the
+// download response, which are only available after reading the whole body.
+// We return zero for missing or invalid values.
+func serverTiming(resp *http.Response) (queueDelay, sendDuration float64) {
+	return parseServerTiming(resp.Trailer.Get(spec.ServerQueueDelayTrailer)),
+		parseServerTiming(resp.Trailer.Get(spec.ServerSendDurationTrailer))
+}
+
+// parseServerTiming parses a server timing trailer value.
+func parseServerTiming(value string) float64 {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil || seconds < 0 || math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+		return 0
+	}
+	return seconds
+}
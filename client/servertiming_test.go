@@ -0,0 +1,51 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+	"github.com/neubot/dash/spec"
+)
+
+func TestClientDownloadServerTiming(t *testing.T) {
+	srvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Trailer", spec.ServerQueueDelayTrailer)
+		w.Header().Add("Trailer", spec.ServerSendDurationTrailer)
+		w.Write([]byte("abc"))
+		w.Header().Set(spec.ServerQueueDelayTrailer, "0.001500")
+		w.Header().Set(spec.ServerSendDurationTrailer, "0.250000")
+	}))
+	defer srvr.Close()
+	URL, err := url.Parse(srvr.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(softwareName, softwareVersion)
+	current := &model.ClientResults{Rate: 100, ElapsedTarget: 2}
+	if err := client.download(context.Background(), "abc", current, URL); err != nil {
+		t.Fatal(err)
+	}
+	if current.ServerQueueDelay != 0.0015 || current.ServerSendDuration != 0.25 {
+		t.Fatalf("unexpected server timing: %+v", current)
+	}
+}
+
+func TestParseServerTiming(t *testing.T) {
+	for value, expect := range map[string]float64{
+		"":         0,
+		"0.5":      0.5,
+		"-1":       0,
+		"NaN":      0,
+		"+Inf":     0,
+		"garbage":  0,
+		"0.000001": 0.000001,
+	} {
+		if parseServerTiming(value) != expect {
+			t.Fatal("unexpected result for", value)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"net/url"
+
+	locatev2 "github.com/m-lab/locate/api/v2"
+	"github.com/neubot/dash/model"
+)
+
+// defaultLocateTargets is the default number of locate targets to try.
+const defaultLocateTargets = 1
+
+// locateNegotiateKey is the key of the negotiate URL in the URLs of
+// the targets returned by m-lab/locate/v2.
+const locateNegotiateKey = "https:///negotiate/dash"
+
+// alternateTargetURLs returns the negotiate URLs of the targets following
+// the first one, which we try when negotiating with the first one fails,
+// skipping invalid entries and trying at most LocateTargets targets.
+func (c *Client) alternateTargetURLs(targets []locatev2.Target) []*url.URL {
+	var out []*url.URL
+	for idx := 1; idx < len(targets) && idx < c.LocateTargets; idx++ {
+		parsed, err := url.Parse(targets[idx].URLs[locateNegotiateKey])
+		if err != nil || parsed.Host == "" {
+			c.Logger.Warnf("dash: invalid locate target: %s", targets[idx].Machine)
+			continue
+		}
+		out = append(out, parsed)
+	}
+	return out
+}
+
+// negotiateTargets negotiates with the given server and, when that fails
+// before the context is done, with the alternate locate targets, in order.
+// When TargetBudget is positive, we give up negotiating with each target
+// after that much time. It returns the negotiate response, the base URL
+// for download and collect, and the negotiate URL of the server we used.
+// Only after a successful negotiation, we save the server we used as the
+// last server in the CacheDir (see UseLastServer).
+func (c *Client) negotiateTargets(
+	ctx context.Context,
+	negotiateURL *url.URL,
+) (model.NegotiateResponse, *url.URL, *url.URL, error) {
+	candidates := append([]*url.URL{negotiateURL}, c.alternateTargets...)
+	var (
+		baseURL  *url.URL
+		err      error
+		response model.NegotiateResponse
+	)
+	for idx, candidate := range candidates {
+		if idx > 0 {
+			c.Logger.Warnf("dash: negotiate failed: %s; trying %s", err.Error(), candidate.Host)
+			c.server = candidate.String()
+		}
+		response, baseURL, err = c.negotiateWithBudget(ctx, candidate)
+		if err == nil {
+			c.writeCache(cacheDirLastServerFile, &LastServer{Stamp: c.TimeNow(), URL: candidate.String()})
+		}
+		if err == nil || ctx.Err() != nil {
+			return response, baseURL, candidate, err
+		}
+	}
+	return response, baseURL, candidates[len(candidates)-1], err
+}
+
+// negotiateWithBudget is like negotiateSession but gives up after
+// TargetBudget, when the TargetBudget is positive.
+func (c *Client) negotiateWithBudget(
+	ctx context.Context,
+	negotiateURL *url.URL,
+) (model.NegotiateResponse, *url.URL, error) {
+	if c.TargetBudget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.TargetBudget)
+		defer cancel()
+	}
+	return c.negotiateSession(ctx, negotiateURL)
+}
+
+// targetsToTry returns the maximum number of servers we may try.
+func (c *Client) targetsToTry() int {
+	if c.FQDN != "" {
+		return 1
+	}
+	return max(c.LocateTargets, 1)
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	locatev2 "github.com/m-lab/locate/api/v2"
+	"github.com/neubot/dash/model"
+)
+
+// multiLocator is a locator returning several targets.
+type multiLocator struct{}
+
+// Nearest implements locator.
+func (multiLocator) Nearest(ctx context.Context, service string) ([]locatev2.Target, error) {
+	var targets []locatev2.Target
+	for _, machine := range []string{"mlab1", "mlab2", "mlab3"} {
+		targets = append(targets, locatev2.Target{
+			Machine: machine,
+			URLs: map[string]string{
+				locateNegotiateKey: "https://" + machine + ".example.com/negotiate/dash",
+			},
+		})
+	}
+	return targets, nil
+}
+
+func TestClientLocateTargets(t *testing.T) {
+	t.Run("by default we do not save alternate targets", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.deps.Locator = multiLocator{}
+		URL, err := client.locate(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if URL.Host != "mlab1.example.com" || len(client.alternateTargets) != 0 {
+			t.Fatal("unexpected targets", URL.Host, client.alternateTargets)
+		}
+	})
+
+	t.Run("we save at most LocateTargets minus one alternate targets", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.LocateTargets = 2
+		client.deps.Locator = multiLocator{}
+		if _, err := client.locate(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if len(client.alternateTargets) != 1 || client.alternateTargets[0].Host != "mlab2.example.com" {
+			t.Fatal("unexpected alternate targets", client.alternateTargets)
+		}
+	})
+}
+
+func TestClientNegotiateTargets(t *testing.T) {
+	mustParse := func(s string) *url.URL {
+		URL, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return URL
+	}
+
+	t.Run("we move on to the next target on failure", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.alternateTargets = []*url.URL{mustParse("https://mlab2.example.com/negotiate/dash")}
+		var hosts []string
+		client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+			hosts = append(hosts, negotiateURL.Host)
+			if negotiateURL.Host == "mlab1.example.com" {
+				return model.NegotiateResponse{}, errors.New("mocked error")
+			}
+			return model.NegotiateResponse{Authorization: "deadbeef"}, nil
+		}
+		resp, _, used, err := client.negotiateTargets(
+			context.Background(), mustParse("https://mlab1.example.com/negotiate/dash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Authorization != "deadbeef" || used.Host != "mlab2.example.com" || len(hosts) != 2 {
+			t.Fatal("unexpected result", resp, used, hosts)
+		}
+		if client.server != "https://mlab2.example.com/negotiate/dash" {
+			t.Fatal("unexpected server", client.server)
+		}
+	})
+
+	t.Run("we save the last server after a successful negotiation", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.CacheDir = t.TempDir()
+		stamp := time.Date(2024, 1, 29, 10, 0, 0, 0, time.UTC)
+		client.TimeNow = func() time.Time { return stamp }
+		client.alternateTargets = []*url.URL{mustParse("https://mlab2.example.com/negotiate/dash")}
+		client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+			if negotiateURL.Host == "mlab1.example.com" {
+				return model.NegotiateResponse{}, errors.New("mocked error")
+			}
+			return model.NegotiateResponse{}, nil
+		}
+		if _, _, _, err := client.negotiateTargets(
+			context.Background(), mustParse("https://mlab1.example.com/negotiate/dash")); err != nil {
+			t.Fatal(err)
+		}
+		lastServer, err := ReadLastServer(client.CacheDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lastServer.URL != "https://mlab2.example.com/negotiate/dash" || !lastServer.Stamp.Equal(stamp) {
+			t.Fatalf("unexpected last server: %+v", lastServer)
+		}
+	})
+
+	t.Run("we do not save the last server when all targets fail", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.CacheDir = t.TempDir()
+		client.alternateTargets = []*url.URL{mustParse("https://mlab2.example.com/negotiate/dash")}
+		client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+			return model.NegotiateResponse{}, errors.New("mocked error")
+		}
+		if _, _, _, err := client.negotiateTargets(
+			context.Background(), mustParse("https://mlab1.example.com/negotiate/dash")); err == nil {
+			t.Fatal("expected an error")
+		}
+		if _, err := ReadLastServer(client.CacheDir); err == nil {
+			t.Fatal("expected no last server")
+		}
+	})
+
+	t.Run("we give up on a target after the budget", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.TargetBudget = 10 * time.Millisecond
+		client.alternateTargets = []*url.URL{mustParse("https://mlab2.example.com/negotiate/dash")}
+		client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+			if negotiateURL.Host == "mlab1.example.com" {
+				<-ctx.Done()
+				return model.NegotiateResponse{}, ctx.Err()
+			}
+			return model.NegotiateResponse{}, nil
+		}
+		_, _, used, err := client.negotiateTargets(
+			context.Background(), mustParse("https://mlab1.example.com/negotiate/dash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used.Host != "mlab2.example.com" {
+			t.Fatal("unexpected server", used.Host)
+		}
+	})
+
+	t.Run("we return the last error when all targets fail", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.alternateTargets = []*url.URL{mustParse("https://mlab2.example.com/negotiate/dash")}
+		expected := errors.New("mocked error")
+		client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+			return model.NegotiateResponse{}, expected
+		}
+		_, _, _, err := client.negotiateTargets(
+			context.Background(), mustParse("https://mlab1.example.com/negotiate/dash"))
+		if !errors.Is(err, expected) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package client
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/neubot/dash/model"
+)
+
+// lossLimitedThreshold is the fraction of the received segments arriving
+// out of order or needing retransmissions above which we consider a segment
+// download limited by loss recovery rather than by the available capacity.
+const lossLimitedThreshold = 0.01
+
+// errTCPInfoNotSupported is returned when we cannot sample TCP_INFO
+// either because of the platform or because of the connection type.
+var errTCPInfoNotSupported = errors.New("sampling TCP_INFO is not supported")
+
+// tcpInfoSample contains the TCP_INFO statistics we use.
+type tcpInfoSample struct {
+	// bytesRetrans is the number of bytes retransmitted.
+	bytesRetrans int64
+
+	// deliveryRate is the delivery rate in bytes per second.
+	deliveryRate int64
+
+	// outOfOrder is the number of packets received out of order.
+	outOfOrder int64
+
+	// retransmits is the number of segments retransmitted.
+	retransmits int64
+
+	// segmentsIn is the number of segments received.
+	segmentsIn int64
+}
+
+// tcpInfoTracer samples TCP_INFO when an HTTP request obtains its connection
+// (see the onConn field of [*connTracer]) and allows to sample it again later.
+type tcpInfoTracer struct {
+	// before is the sample taken when we obtained the connection or nil.
+	before *tcpInfoSample
+
+	// conn is the connection used by the request or nil.
+	conn net.Conn
+
+	// mtx protects before and conn.
+	mtx sync.Mutex
+}
+
+// gotConn is called when the request obtains a connection.
+func (tt *tcpInfoTracer) gotConn(conn net.Conn) {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	sample, err := sampleTCPInfo(conn)
+	if err != nil {
+		return
+	}
+	tt.mtx.Lock()
+	defer tt.mtx.Unlock()
+	tt.before, tt.conn = sample, conn
+}
+
+// delta samples TCP_INFO again and returns the changes since we obtained
+// the connection or nil when we could not sample TCP_INFO.
+func (tt *tcpInfoTracer) delta() *model.TCPInfoDelta {
+	tt.mtx.Lock()
+	before, conn := tt.before, tt.conn
+	tt.mtx.Unlock()
+	if before == nil {
+		return nil
+	}
+	after, err := sampleTCPInfo(conn)
+	if err != nil {
+		return nil
+	}
+	return newTCPInfoDelta(before, after)
+}
+
+// newTCPInfoDelta computes the changes between the two samples.
+func newTCPInfoDelta(before, after *tcpInfoSample) *model.TCPInfoDelta {
+	delta := &model.TCPInfoDelta{
+		BytesRetrans:      after.bytesRetrans - before.bytesRetrans,
+		DeliveryRate:      float64(after.deliveryRate) * 8 / 1000,
+		OutOfOrderPackets: after.outOfOrder - before.outOfOrder,
+		Retransmits:       after.retransmits - before.retransmits,
+		SegmentsIn:        after.segmentsIn - before.segmentsIn,
+	}
+	if delta.SegmentsIn > 0 {
+		recovered := float64(delta.OutOfOrderPackets + delta.Retransmits)
+		delta.LossLimited = recovered/float64(delta.SegmentsIn) > lossLimitedThreshold
+	}
+	return delta
+}
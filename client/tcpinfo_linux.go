@@ -0,0 +1,39 @@
+//go:build linux
+
+package client
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// sampleTCPInfo samples the TCP_INFO statistics of the given connection.
+func sampleTCPInfo(conn net.Conn) (*tcpInfoSample, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, errTCPInfoNotSupported
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var info *unix.TCPInfo
+	cerr := rc.Control(func(fd uintptr) {
+		info, err = unix.GetsockoptTCPInfo(int(fd), unix.IPPROTO_TCP, unix.TCP_INFO)
+	})
+	if cerr != nil {
+		return nil, cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &tcpInfoSample{
+		bytesRetrans: int64(info.Bytes_retrans),
+		deliveryRate: int64(info.Delivery_rate),
+		outOfOrder:   int64(info.Rcv_ooopack),
+		retransmits:  int64(info.Total_retrans),
+		segmentsIn:   int64(info.Segs_in),
+	}, nil
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestClientDownloadSamplesTCPInfo(t *testing.T) {
+	srvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 1<<16))
+	}))
+	defer srvr.Close()
+	URL, err := url.Parse(srvr.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := New(softwareName, softwareVersion)
+	client.HTTPClient = &http.Client{Transport: &http.Transport{}}
+	current := &model.ClientResults{Rate: 100, ElapsedTarget: 2}
+
+	t.Run("when sampling is disabled", func(t *testing.T) {
+		if err := client.download(context.Background(), "abc", current, URL); err != nil {
+			t.Fatal(err)
+		}
+		if current.TCPInfo != nil {
+			t.Fatal("expected no TCPInfo")
+		}
+	})
+
+	t.Run("when sampling is enabled", func(t *testing.T) {
+		client.SampleTCPInfo = true
+		if err := client.download(context.Background(), "abc", current, URL); err != nil {
+			t.Fatal(err)
+		}
+		if current.TCPInfo == nil || current.TCPInfo.SegmentsIn <= 0 {
+			t.Fatalf("unexpected TCPInfo: %+v", current.TCPInfo)
+		}
+	})
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package client
+
+import "net"
+
+// sampleTCPInfo always fails on this platform because we do not
+// know how to sample the TCP_INFO statistics.
+func sampleTCPInfo(conn net.Conn) (*tcpInfoSample, error) {
+	return nil, errTCPInfoNotSupported
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"net"
+	"testing"
+)
+
+func TestNewTCPInfoDelta(t *testing.T) {
+	before := &tcpInfoSample{bytesRetrans: 10, outOfOrder: 5, retransmits: 1, segmentsIn: 100}
+
+	t.Run("without significant losses", func(t *testing.T) {
+		after := &tcpInfoSample{
+			bytesRetrans: 10, deliveryRate: 125000, outOfOrder: 5, retransmits: 1, segmentsIn: 1100}
+		delta := newTCPInfoDelta(before, after)
+		if delta.SegmentsIn != 1000 || delta.OutOfOrderPackets != 0 || delta.Retransmits != 0 {
+			t.Fatalf("unexpected delta: %+v", delta)
+		}
+		if delta.DeliveryRate != 1000 {
+			t.Fatal("unexpected delivery rate", delta.DeliveryRate)
+		}
+		if delta.LossLimited {
+			t.Fatal("expected the download not to be loss limited")
+		}
+	})
+
+	t.Run("with significant losses", func(t *testing.T) {
+		after := &tcpInfoSample{
+			bytesRetrans: 1458, outOfOrder: 25, retransmits: 2, segmentsIn: 1100}
+		delta := newTCPInfoDelta(before, after)
+		if delta.BytesRetrans != 1448 || delta.OutOfOrderPackets != 20 || delta.Retransmits != 1 {
+			t.Fatalf("unexpected delta: %+v", delta)
+		}
+		if !delta.LossLimited {
+			t.Fatal("expected the download to be loss limited")
+		}
+	})
+
+	t.Run("without segments", func(t *testing.T) {
+		if newTCPInfoDelta(before, before).LossLimited {
+			t.Fatal("expected the download not to be loss limited")
+		}
+	})
+}
+
+func TestTCPInfoTracer(t *testing.T) {
+	t.Run("without a connection", func(t *testing.T) {
+		tracer := &tcpInfoTracer{}
+		if tracer.delta() != nil {
+			t.Fatal("expected no delta")
+		}
+	})
+
+	t.Run("with a connection not supporting TCP_INFO", func(t *testing.T) {
+		conn, peer := net.Pipe()
+		defer conn.Close()
+		defer peer.Close()
+		if _, err := sampleTCPInfo(conn); err != errTCPInfoNotSupported {
+			t.Fatal("expected an error", err)
+		}
+	})
+}
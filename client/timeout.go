@@ -0,0 +1,73 @@
+package client
+
+import "time"
+
+const (
+	// segmentDuration is the duration of the video segments, which is the
+	// time we expect each segment download to take.
+	segmentDuration = 2 * time.Second
+
+	// negotiateAllowance is the time we allow for negotiating.
+	negotiateAllowance = 5 * time.Second
+
+	// collectAllowance is the time we allow for each collect attempt.
+	collectAllowance = 5 * time.Second
+
+	// maxBackoffRetries bounds the number of retries we consider when
+	// computing the collect backoff, to avoid overflowing.
+	maxBackoffRetries = 10
+)
+
+// plannedIterations returns the number of segments we plan to download,
+// which depends on whether we are in stream emulation mode.
+func (c *Client) plannedIterations() int64 {
+	if c.StreamRate > 0 {
+		return c.streamDurationSeconds() / int64(segmentDuration/time.Second)
+	}
+	return c.Iterations
+}
+
+// DefaultTimeout returns a suitable timeout for the whole test given the
+// current configuration, i.e., the time for downloading all the segments,
+// plus allowances for locating the server, negotiating, and collecting
+// with retries, including when renegotiating (see Renegotiate). Because
+// we derive the timeout from the configuration (e.g., the number of
+// segments in stream emulation mode), changing it does not cause the test
+// to be interrupted prematurely. With the defaults, the timeout is
+// slightly longer than one minute.
+func (c *Client) DefaultTimeout() time.Duration {
+	// 1. downloading all the segments, where each download may take
+	// up to the SegmentTimeout, if it's configured.
+	timeout := time.Duration(c.plannedIterations()) * max(segmentDuration, c.SegmentTimeout)
+
+	// 2. locating the server, if needed, and negotiating, possibly
+	// with several servers, each within the TargetBudget
+	if c.FQDN == "" {
+		timeout += locateTimeout
+	}
+	perTarget := negotiateAllowance
+	if c.TargetBudget > 0 {
+		perTarget = c.TargetBudget
+	}
+	timeout += time.Duration(c.targetsToTry()) * perTarget
+
+	// 3. collecting, including retrying with exponential backoff
+	timeout += c.collectTimeout()
+
+	// 4. when renegotiating, collecting and negotiating again, where, in
+	// the worst case, we renegotiate as many times as we are willing to
+	if c.Renegotiate {
+		renegotiations := min(max(c.plannedIterations()-1, 0), maxRenegotiations)
+		timeout += time.Duration(renegotiations) * (negotiateAllowance + c.collectTimeout())
+	}
+	return timeout
+}
+
+// collectTimeout returns the time we allow for collecting, including
+// retrying with exponential backoff (see CollectRetries).
+func (c *Client) collectTimeout() time.Duration {
+	retries := min(max(c.CollectRetries, 0), maxBackoffRetries)
+	timeout := time.Duration(retries+1) * collectAllowance
+	timeout += c.collectDelay * time.Duration((1<<retries)-1)
+	return timeout
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClientDefaultTimeout(t *testing.T) {
+	t.Run("with the default configuration", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		// 15 segments, locate, negotiate, four collect attempts, and 1+2+4 seconds of backoff
+		expect := 30*time.Second + locateTimeout + negotiateAllowance + 4*collectAllowance + 7*time.Second
+		if timeout := client.DefaultTimeout(); timeout != expect {
+			t.Fatal("unexpected timeout", timeout)
+		}
+	})
+
+	t.Run("with explicit FQDN and no retries", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.FQDN = "dash.example.com"
+		client.CollectRetries = 0
+		expect := 30*time.Second + negotiateAllowance + collectAllowance
+		if timeout := client.DefaultTimeout(); timeout != expect {
+			t.Fatal("unexpected timeout", timeout)
+		}
+	})
+
+	t.Run("in stream emulation mode", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.FQDN = "dash.example.com"
+		client.CollectRetries = 0
+		client.StreamRate = 25000
+		client.StreamDuration = 5 * time.Minute
+		expect := 5*time.Minute + negotiateAllowance + collectAllowance
+		if timeout := client.DefaultTimeout(); timeout != expect {
+			t.Fatal("unexpected timeout", timeout)
+		}
+	})
+
+	t.Run("with several locate targets and a budget", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.CollectRetries = 0
+		client.LocateTargets = 3
+		client.TargetBudget = 2 * time.Second
+		expect := 30*time.Second + locateTimeout + 3*2*time.Second + collectAllowance
+		if timeout := client.DefaultTimeout(); timeout != expect {
+			t.Fatal("unexpected timeout", timeout)
+		}
+	})
+
+	t.Run("when renegotiating", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.FQDN = "dash.example.com"
+		client.CollectRetries = 0
+		client.Renegotiate = true
+		// up to maxRenegotiations, each collecting and negotiating again
+		expect := 30*time.Second + negotiateAllowance + collectAllowance
+		expect += maxRenegotiations * (negotiateAllowance + collectAllowance)
+		if timeout := client.DefaultTimeout(); timeout != expect {
+			t.Fatal("unexpected timeout", timeout)
+		}
+	})
+
+	t.Run("with segment timeout", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.FQDN = "dash.example.com"
+		client.CollectRetries = 0
+		client.SegmentTimeout = 4 * time.Second
+		expect := 60*time.Second + negotiateAllowance + collectAllowance
+		if timeout := client.DefaultTimeout(); timeout != expect {
+			t.Fatal("unexpected timeout", timeout)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// errCustomDialNotSupported is returned when we cannot use the configured
+// DialContext or DialTLSContext because of a custom transport.
+var errCustomDialNotSupported = errors.New("custom dial functions need an *http.Transport")
+
+// newCustomHTTPClient returns a copy of the HTTPClient using the configured
+// Transport, DialContext, and DialTLSContext.
+func (c *Client) newCustomHTTPClient() (*http.Client, error) {
+	roundTripper := c.HTTPClient.Transport
+	if c.Transport != nil {
+		roundTripper = c.Transport
+	}
+	if c.DialContext != nil || c.DialTLSContext != nil {
+		if roundTripper == nil {
+			roundTripper = http.DefaultTransport
+		}
+		transport, ok := roundTripper.(*http.Transport)
+		if !ok {
+			return nil, errCustomDialNotSupported
+		}
+		transport = transport.Clone()
+		if c.DialContext != nil {
+			transport.DialContext = c.DialContext
+		}
+		if c.DialTLSContext != nil {
+			transport.DialTLSContext = c.DialTLSContext
+		}
+		roundTripper = transport
+	}
+	httpClient := *c.HTTPClient
+	httpClient.Transport = roundTripper
+	return &httpClient, nil
+}
+
+// connectTracer measures the time to establish a new connection, including
+// the TLS handshake, using [httptrace.ClientTrace] hooks. Because we measure
+// the time between asking the transport for a connection and getting it, the
+// measurement does not depend on the dial functions used by the transport.
+type connectTracer struct {
+	// connect is the time to establish the connection or zero.
+	connect time.Duration
+
+	// getConn is when we asked the transport for a connection.
+	getConn time.Time
+
+	// mtx protects the fields above.
+	mtx sync.Mutex
+}
+
+// wrap returns a context configured to use the tracer hooks.
+func (ct *connectTracer) wrap(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GetConn: ct.getConnHook,
+		GotConn: ct.gotConnHook,
+	})
+}
+
+// getConnHook is called when we ask the transport for a connection.
+func (ct *connectTracer) getConnHook(hostPort string) {
+	ct.mtx.Lock()
+	defer ct.mtx.Unlock()
+	ct.getConn = time.Now()
+}
+
+// gotConnHook is called when the transport gives us a connection.
+func (ct *connectTracer) gotConnHook(info httptrace.GotConnInfo) {
+	ct.mtx.Lock()
+	defer ct.mtx.Unlock()
+	if !info.Reused && !ct.getConn.IsZero() {
+		ct.connect = time.Since(ct.getConn)
+	}
+}
+
+// get returns the time to establish a new connection or zero when
+// the request reused an existing connection.
+func (ct *connectTracer) get() time.Duration {
+	ct.mtx.Lock()
+	defer ct.mtx.Unlock()
+	return ct.connect
+}
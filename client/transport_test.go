@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestClientNewCustomHTTPClient(t *testing.T) {
+	t.Run("with custom transport", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.HTTPClient = &http.Client{Timeout: 10}
+		client.Transport = roundTripperFunc(nil)
+		httpClient, err := client.newCustomHTTPClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := httpClient.Transport.(roundTripperFunc); !ok || httpClient.Timeout != 10 {
+			t.Fatal("expected the custom transport and the original settings")
+		}
+	})
+
+	t.Run("with dial functions and custom transport", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.Transport = roundTripperFunc(nil)
+		client.DialContext = (&net.Dialer{}).DialContext
+		if _, err := client.newCustomHTTPClient(); !errors.Is(err, errCustomDialNotSupported) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+
+	t.Run("with dial functions and DSCP", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.DialContext = (&net.Dialer{}).DialContext
+		client.DSCP = 46
+		if _, err := client.newDSCPHTTPClient(); !errors.Is(err, errDSCPNotSupported) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+
+	t.Run("with dial functions", func(t *testing.T) {
+		srvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("abc"))
+		}))
+		defer srvr.Close()
+		URL, err := url.Parse(srvr.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := New(softwareName, softwareVersion)
+		client.HTTPClient = &http.Client{Transport: &http.Transport{}}
+		var dials int
+		client.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+			dials++
+			return (&net.Dialer{}).DialContext(ctx, network, address)
+		}
+		client.DialTLSContext = client.DialContext
+		httpClient, err := client.newCustomHTTPClient()
+		if err != nil {
+			t.Fatal(err)
+		}
+		client.HTTPClient = httpClient
+		current := &model.ClientResults{Rate: 100, ElapsedTarget: 2, Iteration: 1}
+		if err := client.download(context.Background(), "abc", current, URL); err != nil {
+			t.Fatal(err)
+		}
+		if dials != 1 {
+			t.Fatal("expected to use the custom dial function")
+		}
+		if current.ConnectTime <= 0 {
+			t.Fatal("expected to measure the connect time")
+		}
+		if err := client.download(context.Background(), "abc", current, URL); err != nil {
+			t.Fatal(err)
+		}
+		if current.ConnectTime != 0 {
+			t.Fatal("expected no connect time when reusing the connection")
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	cryptorand "crypto/rand"
+	"net/url"
+
+	"github.com/neubot/dash/model"
+	"github.com/neubot/dash/spec"
+)
+
+// directionUpload is the Direction of the results of the upload test.
+const directionUpload = "upload"
+
+// StartUpload is like StartDownload except that it measures the upload
+// direction by POSTing generated segments to the server. The segment
+// sizes follow the same rate adaptation used for downloading and each
+// result posted on the returned channel has Direction set to "upload" and
+// Received set to the number of body bytes we sent.
+func (c *Client) StartUpload(ctx context.Context) (<-chan model.ClientResults, error) {
+	c.begin = c.TimeNow()
+	c.loadProbeID()
+	c.runMetadata = RunMetadata(ctx)
+	c.saveTimeout(ctx)
+	ch, err := c.start(ctx, c.uploadLoop)
+	if err != nil {
+		c.saveLastRun() // otherwise the loop saves it
+		c.complete()
+	}
+	return ch, err
+}
+
+// uploadLoop is like loop but measures the upload direction.
+func (c *Client) uploadLoop(
+	ctx context.Context,
+	ch chan<- model.ClientResults,
+	negotiateURL *url.URL,
+) {
+	c.transferLoop(ctx, ch, negotiateURL, &transfer{
+		direction: directionUpload,
+		phase:     phaseUpload,
+		segment:   c.uploadSegment,
+	})
+}
+
+// uploadSegment calls the Upload dependency possibly limiting the
+// time it could take using the configured SegmentTimeout.
+func (c *Client) uploadSegment(
+	ctx context.Context,
+	authorization string,
+	current *model.ClientResults,
+	negotiateURL *url.URL,
+) error {
+	if c.SegmentTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.SegmentTimeout)
+		defer cancel()
+	}
+	return c.deps.Upload(ctx, authorization, current, negotiateURL)
+}
+
+// upload uploads a segment whose size depends on the current rate and
+// measures the time it takes for the server to receive the whole segment,
+// i.e., until the server responds after having read the body.
+func (c *Client) upload(
+	ctx context.Context,
+	authorization string,
+	current *model.ClientResults,
+	negotiateURL *url.URL,
+) error {
+	// 1. generate the segment and create the HTTP request
+	nbytes := c.segmentSize(current)
+	data := make([]byte, nbytes)
+	_, _ = cryptorand.Read(data) // never fails on supported platforms
+	URL := makeDownloadURL(negotiateURL, spec.UploadPath)
+	req, err := c.deps.HTTPNewRequest("POST", URL.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	c.Logger.Debugf("dash: POST %s", URL.String())
+	current.ServerURL = URL.String()
+	ua, err := c.segmentUserAgent()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", ua)
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	conn, connect := &connTracer{}, &connectTracer{}
+	req = req.WithContext(connect.wrap(conn.wrap(ctx)))
+	if err := c.pace(ctx); err != nil {
+		return err
+	}
+	savedUser, savedSys, cpuErr := c.deps.ProcessCPUTimes()
+	if cpuErr != nil {
+		c.Logger.Debugf("dash: cannot obtain CPU times: %s", cpuErr.Error())
+	}
+	savedTicks := c.TimeNow()
+
+	// 2. send the segment and wait for the server to acknowledge it
+	resp, err := c.deps.HTTPClientDo(req)
+	current.RemoteAddress = conn.remoteAddress()
+	current.ConnectTime = connect.get().Seconds()
+	if current.ConnectTime == 0 && current.Iteration == 0 {
+		current.ConnectTime = c.negotiateConnect.Seconds()
+	}
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 3. handle the case where the status code indicates failure, after
+	// making sure that a captive portal did not intercept the request
+	c.Logger.Debugf("dash: StatusCode: %d", resp.StatusCode)
+	if err := detectCaptivePortal(req, resp); err != nil {
+		return err
+	}
+	if err := detectRedirect(resp); err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return &httpStatusError{StatusCode: resp.StatusCode}
+	}
+	if _, err := c.deps.IOReadAll(resp.Body); err != nil {
+		return err
+	}
+
+	// 4. compute performance metrics and update current
+	current.Elapsed = c.TimeNow().Sub(savedTicks).Seconds()
+	current.Received = nbytes
+	current.RequestTicks = savedTicks.Sub(c.begin).Seconds()
+	current.Timestamp = c.TimeNow().Unix()
+	if cpuErr == nil {
+		if user, sys, err := c.deps.ProcessCPUTimes(); err == nil {
+			current.DeltaUserTime = (user - savedUser).Seconds()
+			current.DeltaSysTime = (sys - savedSys).Seconds()
+		}
+	}
+	return nil
+}
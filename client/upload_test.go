@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/neubot/dash/model"
+	"github.com/neubot/dash/spec"
+)
+
+func TestClientUploadLoop(t *testing.T) {
+	t.Run("upload failure", func(t *testing.T) {
+		ch := make(chan model.ClientResults)
+		client := New(softwareName, softwareVersion)
+		client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+			return model.NegotiateResponse{}, nil
+		}
+		client.deps.Upload = func(
+			ctx context.Context, authorization string,
+			current *model.ClientResults, negotiateURL *url.URL,
+		) error {
+			return errors.New("Mocked error")
+		}
+		client.uploadLoop(context.Background(), ch, &url.URL{})
+		if client.err == nil {
+			t.Fatal("Expected an error here")
+		}
+		if client.failureReport == nil || client.failureReport.Phase != phaseUpload {
+			t.Fatal("expected an upload failure")
+		}
+	})
+
+	t.Run("common case", func(t *testing.T) {
+		ch := make(chan model.ClientResults)
+		client := New(softwareName, softwareVersion)
+		client.deps.Negotiate = func(ctx context.Context, negotiateURL *url.URL) (model.NegotiateResponse, error) {
+			return model.NegotiateResponse{}, nil
+		}
+		client.deps.Download = func(
+			ctx context.Context, authorization string,
+			current *model.ClientResults, negotiateURL *url.URL,
+		) error {
+			t.Error("should not download")
+			return nil
+		}
+		client.deps.Upload = func(
+			ctx context.Context, authorization string,
+			current *model.ClientResults, negotiateURL *url.URL,
+		) error {
+			current.Elapsed = 1
+			current.Received = 1000
+			return nil
+		}
+		client.deps.Collect = func(ctx context.Context, authorization string, negotiateURL *url.URL) error {
+			return nil
+		}
+		go client.uploadLoop(context.Background(), ch, &url.URL{})
+		var results []model.ClientResults
+		for result := range ch {
+			results = append(results, result)
+		}
+		if client.err != nil {
+			t.Fatal(client.err)
+		}
+		if len(results) != int(client.plannedIterations()) {
+			t.Fatal("unexpected number of results", len(results))
+		}
+		for _, result := range results {
+			if result.Direction != directionUpload {
+				t.Fatal("unexpected direction", result.Direction)
+			}
+		}
+		if results[1].Rate != 8 { // 1000 bytes in 1 s
+			t.Fatal("unexpected rate", results[1].Rate)
+		}
+	})
+}
+
+func TestClientUpload(t *testing.T) {
+	t.Run("common case", func(t *testing.T) {
+		var received int64
+		srvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "POST" || r.URL.Path != spec.UploadPath {
+				w.WriteHeader(404)
+				return
+			}
+			if r.Header.Get("Content-Type") != "application/octet-stream" || r.Header.Get("Authorization") != "xx" {
+				w.WriteHeader(400)
+				return
+			}
+			data, _ := io.ReadAll(r.Body)
+			received = int64(len(data))
+		}))
+		defer srvr.Close()
+		URL, err := url.Parse(srvr.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := New(softwareName, softwareVersion)
+		current := &model.ClientResults{Rate: 100, ElapsedTarget: 2}
+		if err := client.upload(context.Background(), "xx", current, URL); err != nil {
+			t.Fatal(err)
+		}
+		if current.Received != client.segmentSize(current) || current.Received != received {
+			t.Fatal("unexpected number of bytes", current.Received, received)
+		}
+		if current.RemoteAddress == "" || current.ServerURL != srvr.URL+spec.UploadPath {
+			t.Fatal("unexpected results", current)
+		}
+	})
+
+	t.Run("status code failure", func(t *testing.T) {
+		srvr := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(429)
+		}))
+		defer srvr.Close()
+		URL, err := url.Parse(srvr.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := New(softwareName, softwareVersion)
+		err = client.upload(context.Background(), "xx", &model.ClientResults{}, URL)
+		var statusErr *httpStatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != 429 {
+			t.Fatal("unexpected error", err)
+		}
+	})
+}
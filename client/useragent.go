@@ -0,0 +1,36 @@
+package client
+
+import (
+	"errors"
+	"maps"
+	"slices"
+)
+
+// userAgentProfiles maps the name of each User-Agent profile to the
+// User-Agent header of the corresponding video player.
+var userAgentProfiles = map[string]string{
+	"avplayer":  "AppleCoreMedia/1.0.0.21E236 (iPhone; U; CPU OS 17_4 like Mac OS X; en_us)",
+	"exoplayer": "ExoPlayerLib/2.19.1",
+}
+
+// errUnknownUserAgentProfile is returned when the UserAgentProfile is unknown.
+var errUnknownUserAgentProfile = errors.New("unknown User-Agent profile")
+
+// UserAgentProfiles returns the sorted names of the User-Agent profiles
+// that one can use as the UserAgentProfile.
+func UserAgentProfiles() []string {
+	return slices.Sorted(maps.Keys(userAgentProfiles))
+}
+
+// segmentUserAgent returns the User-Agent header to use for downloading
+// segments according to the UserAgentProfile.
+func (c *Client) segmentUserAgent() (string, error) {
+	if c.UserAgentProfile == "" {
+		return c.userAgent, nil
+	}
+	ua, found := userAgentProfiles[c.UserAgentProfile]
+	if !found {
+		return "", errUnknownUserAgentProfile
+	}
+	return ua, nil
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestUserAgentProfiles(t *testing.T) {
+	profiles := UserAgentProfiles()
+	if !slices.Equal(profiles, []string{"avplayer", "exoplayer"}) {
+		t.Fatal("unexpected profiles", profiles)
+	}
+}
+
+func TestClientUserAgentProfile(t *testing.T) {
+	expected := errors.New("mocked error")
+	download := func(profile string) string {
+		client := New(softwareName, softwareVersion)
+		client.UserAgentProfile = profile
+		var ua string
+		client.deps.HTTPClientDo = func(req *http.Request) (*http.Response, error) {
+			ua = req.Header.Get("User-Agent")
+			return nil, expected
+		}
+		current := &model.ClientResults{}
+		err := client.download(context.Background(), "", current, &url.URL{Scheme: "https", Host: "dash.example.com"})
+		if !errors.Is(err, expected) {
+			t.Fatal("not the error we expected", err)
+		}
+		return ua
+	}
+
+	t.Run("by default", func(t *testing.T) {
+		if ua := download(""); ua != makeUserAgent(softwareName, softwareVersion) {
+			t.Fatal("unexpected User-Agent", ua)
+		}
+	})
+
+	t.Run("with a known profile", func(t *testing.T) {
+		if ua := download("exoplayer"); ua != userAgentProfiles["exoplayer"] {
+			t.Fatal("unexpected User-Agent", ua)
+		}
+	})
+
+	t.Run("with an unknown profile", func(t *testing.T) {
+		client := New(softwareName, softwareVersion)
+		client.UserAgentProfile = "nonexistent"
+		if _, err := client.StartDownload(context.Background()); !errors.Is(err, errUnknownUserAgentProfile) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/m-lab/go/rtx"
+	"github.com/neubot/dash/client"
+	"github.com/neubot/dash/model"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// daemonMedianRate is the median rate measured by the latest run.
+	daemonMedianRate = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dash_client_median_rate_kbits",
+		Help: "Median rate in kbit/s measured by the latest successful run.",
+	})
+
+	// daemonLastSuccess is the time of the latest successful run.
+	daemonLastSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dash_client_last_success_timestamp_seconds",
+		Help: "Unix time of the latest successful run.",
+	})
+
+	// daemonRuns counts the runs by result.
+	daemonRuns = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_client_runs_total",
+			Help: "Number of runs by result.",
+		},
+		[]string{"result"},
+	)
+
+	// daemonStalls counts the segments that would have caused a stall.
+	daemonStalls = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dash_client_stalls_total",
+		Help: "Number of segments that failed or took longer than their playback.",
+	})
+)
+
+// serveMetrics exposes the daemon metrics at the /metrics path of
+// the given local endpoint in the OpenMetrics format.
+func serveMetrics(address string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	}))
+	go func() {
+		rtx.Must(http.ListenAndServe(address, mux), "Can't start metrics server")
+	}()
+}
+
+// realdaemon runs a test using a new client every interval until the
+// context is done, in which case it returns the context error. We use
+// the given store to schedule runs (see [*daemonStore]), so we first wait
+// for the next run scheduled before a restart, if any, and we retry
+// with exponential backoff after failures.
+func realdaemon(
+	ctx context.Context, newClient func() *client.Client, interval, timeout time.Duration,
+	store *daemonStore,
+) error {
+	for {
+		if delay := store.delay(time.Now(), interval); delay > 0 {
+			store.logger.Infof("dash: next daemon run in %s", delay)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+		store.retryPending()
+		completed := make(chan *client.FinalResult, 1)
+		onComplete := func(result *client.FinalResult) {
+			completed <- result
+		}
+		client := newClient()
+		if store.upload != nil {
+			client.CompletionHook = onComplete
+		}
+		err := realmain(ctx, client, timeout, nil)
+		if err != nil {
+			client.Logger.Warnf("dash: daemon run failed: %s", err.Error())
+		}
+		if store.upload != nil {
+			// the hook may still be running after the channel is closed
+			store.complete(<-completed)
+		}
+		now := time.Now()
+		observeRun(client.ClientResults(), err, now)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == nil {
+			store.compare(summarizeRun(client.ClientResults(), now))
+		}
+		store.schedule(err, now, interval)
+	}
+}
+
+// observeRun updates the daemon metrics using the results of a run.
+func observeRun(results []model.ClientResults, err error, now time.Time) {
+	daemonStalls.Add(float64(countStalls(results)))
+	if err != nil {
+		daemonRuns.WithLabelValues("failure").Inc()
+		return
+	}
+	daemonRuns.WithLabelValues("success").Inc()
+	daemonMedianRate.Set(client.MedianRate(results))
+	daemonLastSuccess.Set(float64(now.Unix()))
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/m-lab/go/rtx"
+	"github.com/neubot/dash/client"
+	"github.com/neubot/dash/model"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRealdaemonCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel() // immediately hang up
+	var runs int
+	err := realdaemon(ctx, func() *client.Client {
+		runs++
+		client := client.New(clientName, clientVersion)
+		client.FQDN = "127.0.0.1:1"
+		return client
+	}, time.Hour, time.Second, newDaemonStore("", nil, log.Log))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatal("not the error we expected", err)
+	}
+	if runs != 1 {
+		t.Fatal("expected a single run")
+	}
+}
+
+// valueOf returns the value of the given counter or gauge.
+func valueOf(metric prometheus.Metric) float64 {
+	value := &dto.Metric{}
+	rtx.Must(metric.Write(value), "metric.Write should not fail")
+	if value.Counter != nil {
+		return value.Counter.GetValue()
+	}
+	return value.Gauge.GetValue()
+}
+
+func TestObserveRun(t *testing.T) {
+	results := []model.ClientResults{
+		{Elapsed: 1, ElapsedTarget: 2, Received: 1000},
+		{Elapsed: 3, ElapsedTarget: 2, Received: 3000},
+		{ElapsedTarget: 2, Failure: "Mocked error"},
+	}
+	stalls := valueOf(daemonStalls)
+	failures := valueOf(daemonRuns.WithLabelValues("failure"))
+	observeRun(results, errors.New("Mocked error"), time.Now())
+	if valueOf(daemonStalls)-stalls != 2 {
+		t.Fatal("unexpected number of stalls")
+	}
+	if valueOf(daemonRuns.WithLabelValues("failure"))-failures != 1 {
+		t.Fatal("unexpected number of failures")
+	}
+	now := time.Unix(1234567890, 0)
+	observeRun(results, nil, now)
+	if valueOf(daemonLastSuccess) != 1234567890 {
+		t.Fatal("unexpected last success timestamp")
+	}
+	if valueOf(daemonMedianRate) != 8 {
+		t.Fatal("unexpected median rate", valueOf(daemonMedianRate))
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+	serveMetrics(address)
+	var resp *http.Response
+	for idx := 0; idx < 10; idx++ {
+		if resp, err = http.Get("http://" + address + "/metrics"); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "dash_client_stalls_total") {
+		t.Fatal("expected the daemon metrics")
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/neubot/dash/client"
+	"github.com/neubot/dash/model"
+)
+
+// These are the files we use inside the -cache-dir in daemon mode.
+const (
+	// daemonStateFile contains the [daemonState].
+	daemonStateFile = "daemon-state.json"
+
+	// daemonPendingDir contains the copies of the results files
+	// that we could not upload using the -exec command.
+	daemonPendingDir = "pending"
+
+	// daemonResultFile contains the final result of the last run,
+	// which we pass to the -exec command.
+	daemonResultFile = "daemon-result.json"
+)
+
+const (
+	// daemonMinBackoff is the delay before retrying after the first
+	// failed run, which we double after each consecutive failure.
+	daemonMinBackoff = time.Minute
+
+	// daemonMaxPending is the maximum number of pending uploads, after
+	// which we drop the oldest ones, so we do not fill the disk.
+	daemonMaxPending = 64
+)
+
+// daemonState is the scheduling state that we persist in daemon mode, so
+// that restarts do not cause immediate runs or lose unsent results.
+type daemonState struct {
+	// Failures is the number of consecutive failed runs.
+	Failures int64 `json:"failures"`
+
+	// LastRun summarizes the last successful run or is nil.
+	LastRun *runSummary `json:"last_run,omitempty"`
+
+	// NextRun is when we should run the next test.
+	NextRun time.Time `json:"next_run"`
+
+	// Pending contains the paths of the results files that we should
+	// pass again to the -exec command, oldest first.
+	Pending []string `json:"pending,omitempty"`
+}
+
+// daemonStore manages the [daemonState].
+type daemonStore struct {
+	// dir is the -cache-dir or empty, in which case we do not persist.
+	dir string
+
+	// logger is the logger to use.
+	logger model.Logger
+
+	// state is the current state.
+	state daemonState
+
+	// upload is the -exec command or nil.
+	upload func(path string) error
+}
+
+// newDaemonStore creates a new [*daemonStore] loading the state from the
+// given directory, if not empty. We start from scratch when we cannot
+// load the state, since the state only prevents unneeded work.
+func newDaemonStore(dir string, upload func(path string) error, logger model.Logger) *daemonStore {
+	ds := &daemonStore{dir: dir, logger: logger, upload: upload}
+	if dir == "" {
+		return ds
+	}
+	data, err := os.ReadFile(filepath.Join(dir, daemonStateFile))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warnf("dash: cannot read the daemon state: %s", err.Error())
+		}
+		return ds
+	}
+	if err := json.Unmarshal(data, &ds.state); err != nil {
+		logger.Warnf("dash: cannot parse the daemon state: %s", err.Error())
+		ds.state = daemonState{}
+	}
+	return ds
+}
+
+// save atomically writes the state, if we have a directory.
+func (ds *daemonStore) save() {
+	if ds.dir == "" {
+		return
+	}
+	data, err := json.Marshal(&ds.state)
+	if err != nil {
+		ds.logger.Warnf("dash: cannot marshal the daemon state: %s", err.Error())
+		return
+	}
+	if err := os.MkdirAll(ds.dir, 0700); err != nil {
+		ds.logger.Warnf("dash: cannot create the cache directory: %s", err.Error())
+		return
+	}
+	filename := filepath.Join(ds.dir, daemonStateFile)
+	if err := os.WriteFile(filename+".tmp", data, 0600); err != nil {
+		ds.logger.Warnf("dash: cannot write the daemon state: %s", err.Error())
+		return
+	}
+	if err := os.Rename(filename+".tmp", filename); err != nil {
+		ds.logger.Warnf("dash: cannot rename the daemon state: %s", err.Error())
+	}
+}
+
+// delay returns how long we should wait before the next run, which we
+// bound to the interval in case the clock or the interval changed.
+func (ds *daemonStore) delay(now time.Time, interval time.Duration) time.Duration {
+	return min(max(ds.state.NextRun.Sub(now), 0), interval)
+}
+
+// schedule schedules the next run given the outcome of the run that just
+// finished. After failures, we retry with exponential backoff starting from
+// daemonMinBackoff, but never less often than every interval.
+func (ds *daemonStore) schedule(err error, now time.Time, interval time.Duration) {
+	next := interval
+	if err != nil {
+		ds.state.Failures++
+		next = daemonMinBackoff
+		for idx := int64(1); idx < ds.state.Failures && next < interval; idx++ {
+			next *= 2
+		}
+		next = min(next, interval)
+	} else {
+		ds.state.Failures = 0
+	}
+	ds.state.NextRun = now.Add(next)
+	ds.save()
+}
+
+// compare prints the [trendEvent] comparing the given successful run with
+// the previous successful one, if any, and then remembers the given run.
+func (ds *daemonStore) compare(current *runSummary) {
+	if ds.state.LastRun != nil {
+		printEvent(outputEvent{Trend: compareRuns(ds.state.LastRun, current)})
+	}
+	ds.state.LastRun = current
+}
+
+// complete handles the final result of a run in daemon mode, which we
+// receive using the CompletionHook, by writing it into the daemonResultFile
+// and passing the file to the -exec command (see uploadResults).
+func (ds *daemonStore) complete(result *client.FinalResult) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		ds.logger.Warnf("dash: cannot marshal the results: %s", err.Error())
+		return
+	}
+	path := filepath.Join(ds.dir, daemonResultFile)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		ds.logger.Warnf("dash: cannot write the results file: %s", err.Error())
+		return
+	}
+	ds.uploadResults(path)
+}
+
+// uploadResults runs the -exec command with the given results file and, on
+// failure, keeps a copy of the file, which the next run overwrites, for
+// retrying later (see retryPending).
+func (ds *daemonStore) uploadResults(path string) {
+	if ds.upload(path) == nil || ds.dir == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		ds.logger.Warnf("dash: cannot read the results file: %s", err.Error())
+		return
+	}
+	pending := filepath.Join(ds.dir, daemonPendingDir, fmt.Sprintf("result-%d.json", time.Now().UnixNano()))
+	if err := os.MkdirAll(filepath.Dir(pending), 0700); err != nil {
+		ds.logger.Warnf("dash: cannot create the pending directory: %s", err.Error())
+		return
+	}
+	if err := os.WriteFile(pending, data, 0600); err != nil {
+		ds.logger.Warnf("dash: cannot write the pending results file: %s", err.Error())
+		return
+	}
+	ds.state.Pending = append(ds.state.Pending, pending)
+	for len(ds.state.Pending) > daemonMaxPending {
+		ds.logger.Warnf("dash: dropping the pending results file %s", ds.state.Pending[0])
+		_ = os.Remove(ds.state.Pending[0])
+		ds.state.Pending = ds.state.Pending[1:]
+	}
+	ds.save()
+}
+
+// retryPending passes again the pending results files to the -exec command
+// and removes the ones it successfully handled.
+func (ds *daemonStore) retryPending() {
+	if ds.upload == nil || len(ds.state.Pending) <= 0 {
+		return
+	}
+	var failed []string
+	for _, path := range ds.state.Pending {
+		if _, err := os.Stat(path); err != nil {
+			ds.logger.Warnf("dash: dropping the pending results file %s: %s", path, err.Error())
+			continue
+		}
+		if err := ds.upload(path); err != nil {
+			failed = append(failed, path)
+			continue
+		}
+		_ = os.Remove(path)
+	}
+	ds.state.Pending = failed
+	ds.save()
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/client"
+)
+
+func TestDaemonStoreSchedule(t *testing.T) {
+	t.Run("we persist the next run", func(t *testing.T) {
+		dir := t.TempDir()
+		now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+		store := newDaemonStore(dir, nil, log.Log)
+		store.schedule(nil, now, time.Hour)
+		restarted := newDaemonStore(dir, nil, log.Log)
+		if delay := restarted.delay(now.Add(10*time.Minute), time.Hour); delay != 50*time.Minute {
+			t.Fatal("unexpected delay", delay)
+		}
+		if delay := restarted.delay(now.Add(2*time.Hour), time.Hour); delay != 0 {
+			t.Fatal("unexpected delay", delay)
+		}
+		if delay := restarted.delay(now.Add(-time.Hour), 30*time.Minute); delay != 30*time.Minute {
+			t.Fatal("expected the delay to be bounded by the interval", delay)
+		}
+	})
+
+	t.Run("we back off after failures", func(t *testing.T) {
+		now := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+		store := newDaemonStore("", nil, log.Log)
+		expected := []time.Duration{
+			time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute,
+		}
+		for _, delay := range expected {
+			store.schedule(errors.New("mocked error"), now, 10*time.Minute)
+			if store.state.NextRun.Sub(now) != delay {
+				t.Fatal("unexpected delay", store.state.NextRun.Sub(now), "expected", delay)
+			}
+		}
+		store.schedule(nil, now, 10*time.Minute)
+		if store.state.Failures != 0 {
+			t.Fatal("expected a success to reset the failures")
+		}
+	})
+
+	t.Run("we ignore a corrupt state", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, daemonStateFile), []byte("{"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		store := newDaemonStore(dir, nil, log.Log)
+		if delay := store.delay(time.Now(), time.Hour); delay != 0 {
+			t.Fatal("unexpected delay", delay)
+		}
+	})
+}
+
+func TestDaemonStorePending(t *testing.T) {
+	dir := t.TempDir()
+	results := filepath.Join(dir, "last-result.json")
+	if err := os.WriteFile(results, []byte(`{"summary":{}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var fail bool
+	var uploaded []string
+	upload := func(path string) error {
+		if fail {
+			return errors.New("mocked error")
+		}
+		uploaded = append(uploaded, path)
+		return nil
+	}
+
+	// 1. the upload fails, so we keep a copy of the results
+	fail = true
+	store := newDaemonStore(dir, upload, log.Log)
+	store.uploadResults(results)
+	if len(store.state.Pending) != 1 {
+		t.Fatal("expected a pending upload")
+	}
+	pending := store.state.Pending[0]
+	data, err := os.ReadFile(pending)
+	if err != nil || string(data) != `{"summary":{}}` {
+		t.Fatal("unexpected pending results file", err)
+	}
+
+	// 2. after a restart, retrying still fails
+	store = newDaemonStore(dir, upload, log.Log)
+	store.retryPending()
+	if len(store.state.Pending) != 1 {
+		t.Fatal("expected a pending upload")
+	}
+
+	// 3. eventually, retrying succeeds
+	fail = false
+	store.retryPending()
+	if len(store.state.Pending) != 0 || len(uploaded) != 1 || uploaded[0] != pending {
+		t.Fatal("expected to upload the pending results file")
+	}
+	if _, err := os.Stat(pending); !os.IsNotExist(err) {
+		t.Fatal("expected the pending results file to be removed")
+	}
+	if store = newDaemonStore(dir, upload, log.Log); len(store.state.Pending) != 0 {
+		t.Fatal("expected the state to be saved")
+	}
+}
+
+func TestDaemonStoreComplete(t *testing.T) {
+	dir := t.TempDir()
+	var uploaded []string
+	store := newDaemonStore(dir, func(path string) error {
+		uploaded = append(uploaded, path)
+		return nil
+	}, log.Log)
+	store.complete(&client.FinalResult{Summary: &client.FinalSummary{Iterations: 3}})
+	if len(uploaded) != 1 || uploaded[0] != filepath.Join(dir, daemonResultFile) {
+		t.Fatal("unexpected uploads", uploaded)
+	}
+	data, err := os.ReadFile(uploaded[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result client.FinalResult
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Summary == nil || result.Summary.Iterations != 3 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if len(store.state.Pending) != 0 {
+		t.Fatal("expected no pending uploads")
+	}
+}
+
+func TestDaemonStoreMaxPending(t *testing.T) {
+	dir := t.TempDir()
+	results := filepath.Join(dir, "last-result.json")
+	if err := os.WriteFile(results, []byte(`{}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store := newDaemonStore(dir, func(path string) error {
+		return errors.New("mocked error")
+	}, log.Log)
+	for idx := 0; idx < daemonMaxPending+2; idx++ {
+		store.uploadResults(results)
+	}
+	if len(store.state.Pending) != daemonMaxPending {
+		t.Fatal("unexpected number of pending uploads", len(store.state.Pending))
+	}
+	entries, err := os.ReadDir(filepath.Join(dir, daemonPendingDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != daemonMaxPending {
+		t.Fatal("unexpected number of pending files", len(entries))
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/neubot/dash/model"
+)
+
+// execHookTimeout is the maximum time we wait for the -exec command.
+const execHookTimeout = time.Minute
+
+// newExecHook returns a function running the given command, with the path
+// of the results file as the last argument, and logging and returning its
+// failures. We split the command into arguments on whitespace, without any
+// shell interpretation.
+func newExecHook(command string, logger model.Logger) func(path string) error {
+	argv := strings.Fields(command)
+	return func(path string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), execHookTimeout)
+		defer cancel()
+		args := append(append([]string{}, argv[1:]...), path)
+		output, err := exec.CommandContext(ctx, argv[0], args...).CombinedOutput()
+		if err != nil {
+			logger.Warnf("dash: -exec command failed: %s: %s", err.Error(), string(output))
+			return err
+		}
+		logger.Debugf("dash: -exec command output: %s", string(output))
+		return nil
+	}
+}
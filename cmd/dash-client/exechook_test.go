@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+// execHookHelperCommand returns the command running the current test binary
+// as the helper process implemented by TestExecHookHelperProcess.
+func execHookHelperCommand(t *testing.T, action string) string {
+	t.Setenv("DASH_WANT_HELPER_PROCESS", "1")
+	return fmt.Sprintf("%s -test.run=^TestExecHookHelperProcess$ -- %s", os.Args[0], action)
+}
+
+// TestExecHookHelperProcess is not a real test but the -exec command used by
+// TestNewExecHook, which creates the file passed as the last argument when
+// the action is "touch" and otherwise fails.
+func TestExecHookHelperProcess(t *testing.T) {
+	if os.Getenv("DASH_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	if len(args) != 3 || args[1] != "touch" {
+		fmt.Fprintf(os.Stderr, "helper: failing as requested\n")
+		os.Exit(1)
+	}
+	if err := os.WriteFile(args[2], nil, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "helper: %s\n", err.Error())
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+func TestNewExecHook(t *testing.T) {
+	t.Run("common case", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "touched")
+		if err := newExecHook(execHookHelperCommand(t, "touch"), log.Log)(path); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("with failing command", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "touched")
+		if err := newExecHook(execHookHelperCommand(t, "fail"), log.Log)(path); err == nil {
+			t.Fatal("expected an error")
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+}
@@ -3,6 +3,21 @@
 // Usage:
 //
 //	dash-client -y [-hostname <domain>] [-timeout <string>] [-scheme <scheme>]
+//	            [-accept-encoding <value>] [-adapter <name>] [-cache-busting]
+//	            [-dscp <value>] [-cache-dir <dirpath>] [-use-last-server]
+//	            [-persist-probe-id] [-correct-clock-skew]
+//	            [-fallback-server <URL>] [-iterations <count>] [-pin-connection]
+//	            [-locate-targets <count>] [-target-budget <string>]
+//	            [-renegotiate] [-resilient] [-segment-timeout <string>]
+//	            [-stream-rate <kbit/s>] [-stream-duration <string>]
+//	            [-strict-privacy] [-tcp-info] [-user-agent-profile <name>]
+//	            [-ws-listen <endpoint>]
+//	dash-client -y -paired-control <domain> -paired-test <domain> [...]
+//	dash-client -y -bidirectional [...]
+//	dash-client -y -daemon-interval <string> [-metrics-listen-address <endpoint>]
+//	            [-exec <command>] [...]
+//	dash-client install-service -y [-daemon-interval <string>] [...]
+//	dash-client -schema
 //
 // The `-y` flag indicates you have read the data policy and accept it.
 //
@@ -12,23 +27,195 @@
 //
 // The `-timeout <string>` flag specifies the time after which the
 // whole test is interrupted. The `<string>` is a string suitable to
-// be passed to time.ParseDuration, e.g., "15s". The default is a large
-// enough value that should be suitable for common conditions.
+// be passed to time.ParseDuration, e.g., "15s". The default is to derive
+// the timeout from the other flags (e.g., `-stream-duration`), allowing
+// enough time for downloading all the segments, negotiating, and collecting.
 //
 // The `-scheme <scheme>` flag allows to override the default scheme
 // used for the test, i.e. "http". All DASH servers support that,
 // future versions of the Go server will support "https".
 //
+// The `-accept-encoding <value>` flag sets the Accept-Encoding header
+// (e.g., "gzip, deflate") for segment requests, which allows to detect
+// intermediaries compressing or recoding the payload.
+//
+// The `-adapter <name>` flag selects the adaptation algorithm choosing the
+// rate of the next segment, i.e., "throughput", which uses the throughput
+// of the last segment, "buffer", which uses the level of the emulated
+// playback buffer, or "bola", which implements BOLA. The default is
+// "throughput", which is the original behavior of the DASH test.
+//
+// The `-cache-busting` flag adds a random token to each segment request,
+// so that transparent caches cannot serve segments and inflate the measured
+// rate, and marks the results when we detect that a cache served a segment.
+//
+// The `-cache-dir <dirpath>` flag specifies a directory where we persist
+// the locate response, the server we used, and the summary and the final
+// result of the last run. The default is not to persist anything.
+//
+// The `-use-last-server` flag causes the client to reuse the server used
+// by the last run, which requires `-cache-dir`, rather than using the
+// autodiscovery. We autodiscover a server if there is no last server.
+//
+// The `-correct-clock-skew` flag shifts the timestamps of the server
+// results in the final result by the estimated offset between the clocks
+// of the client and of the server, which we always report in the summary,
+// so that one can compare them with the timestamps of the client results.
+//
+// The `-daemon-interval <string>` flag enables the daemon mode where we
+// run a test every `<string>` (e.g., "1h") until interrupted. The default
+// is zero, which means that we run a single test. After a failed test, we
+// retry after a minute, doubling the delay after each consecutive failure,
+// but never less often than every `<string>`. With `-cache-dir`, we persist
+// the time of the next test in `daemon-state.json`, so that restarts do not
+// cause immediate runs. After each successful test, we also print a `trend`
+// event comparing it with the previous successful test, including the change
+// of the median rate in percent and the change of the number of stalls,
+// which allows simple local alerting.
+//
+// The `-dscp <value>` flag marks the measurement connections using the
+// given DSCP value (between 0 and 63). The default is not to mark them.
+//
+// The `-exec <command>` flag runs, in daemon mode, the given command after
+// each run, appending to its arguments the path of the file containing the
+// final result of the run, which is inside `-cache-dir` and hence requires
+// it. This allows simple automations (e.g., alerts or uploads). We split the
+// command on whitespace, without using a shell, and wait at most a minute
+// for it to complete. When the command fails, we keep a copy of the file in
+// the `pending` directory of `-cache-dir` and pass it again to the command
+// before the next runs, until it succeeds, keeping at most 64 files.
+//
+// The `-fallback-server <URL>` flag adds a server (e.g.,
+// "https://dash.example.com") to the list of servers to use, in random
+// order, when autodiscovery fails. You can use this flag many times.
+//
+// The `-iterations <count>` flag specifies the number of segments to
+// download, between 1 and 300, which allows to run long-form tests or short
+// smoke tests. We run fewer segments when the server does not allow that
+// many. The default is 15. We ignore this flag with `-stream-rate`.
+//
+// The `-locate-targets <count>` flag specifies how many of the servers
+// returned by the autodiscovery we try, in order, when negotiating fails.
+// The default is one, meaning that we only try the nearest server.
+//
+// The `-metrics-listen-address <endpoint>` flag exposes, in daemon mode,
+// the summary of the latest runs (median rate, stalls, time of the latest
+// success) at the /metrics path of the given endpoint (e.g., "127.0.0.1:9990")
+// in the OpenMetrics format, so Prometheus can scrape it.
+//
+// The `-paired-control <domain>` and `-paired-test <domain>` flags enable
+// the paired mode, where we run the test simultaneously, using separate
+// connections, against the control server (e.g., an off-net server) and
+// the test server (e.g., an on-net cache), and print a paired comparison
+// including the ratio between the test and control median rates, which
+// allows to detect differential treatment of streaming traffic. The other
+// flags, except `-hostname`, apply to both measurements.
+//
+// The `-bidirectional` flag enables the bidirectional mode, where we run the
+// download test alone, the upload test alone, and then both concurrently,
+// using separate sessions and connections, which resembles a video call while
+// streaming, and print the results of each direction including the ratio
+// between the concurrent and the alone median rates, which quantifies the
+// impact of the cross traffic. Because we run three tests, the default
+// timeout is three times the one of a single test. We suggest using
+// `-hostname`, otherwise each test may autodiscover a different server.
+//
+// The `-persist-probe-id` flag opts in using the same probe ID, which is
+// part of the metadata of the final result, for all the runs, by saving it
+// in `-cache-dir`, which allows the longitudinal analysis of your results.
+// By default, we use a new random probe ID for each run, so one cannot link
+// your runs using the probe ID.
+//
+// The `-pin-connection` flag uses a single connection for the whole test,
+// so that the connection stats collected by the server correspond exactly
+// to the measured traffic. The test fails if we cannot reuse the connection.
+//
+// The `-renegotiate` flag causes the client to negotiate a new session
+// and continue at a lower rate when the server refuses to serve more
+// segments as part of the current session, so long tests can span
+// several server sessions. We renegotiate at most three times per test.
+//
+// The `-resilient` flag enables the resilient mode where, when a segment
+// download fails, we record the failure, step the rate down, and continue.
+//
+// The `-schema` flag prints the JSON Schema of the lines of the output
+// and exits.
+//
+// The `-segment-timeout <string>` flag specifies the maximum time for
+// downloading a single segment. The default is to have no timeout.
+//
+// The `-stream-rate <kbit/s>` flag enables the stream emulation mode
+// where, rather than adapting the rate, we download back-to-back segments
+// at the given fixed rate and tell whether the network sustained it.
+//
+// The `-stream-duration <string>` flag specifies the duration of the
+// emulated stream when using the stream emulation mode. The `<string>`
+// is a string suitable to be passed to time.ParseDuration.
+//
+// The `-strict-privacy` flag omits the client and server addresses from
+// the results that we print, while still submitting them to the server,
+// which handles them according to the privacy policy.
+//
+// The `-tcp-info` flag samples the TCP_INFO statistics of the connection
+// around each segment download (only on Linux) and records the segment
+// retransmissions, out of order packets, and delivery rate, as well as a
+// `loss_limited` indicator, which helps to interpret low measured rates.
+//
+// The `-target-budget <string>` flag specifies the maximum time for
+// negotiating with each server before moving on to the next one (see
+// `-locate-targets`), e.g., "5s", which allows to bound the duration of
+// the run. The default is to have no budget.
+//
+// The `-user-agent-profile <name>` flag causes the client to download the
+// segments using the User-Agent of a common video player, i.e., "avplayer"
+// or "exoplayer", to study whether networks treat recognized players
+// differently. We record the profile in the results. By default, we
+// always use our own User-Agent.
+//
+// The `-ws-listen <endpoint>` flag streams the output events, while we
+// print them, to the WebSocket clients connected to the given loopback
+// endpoint (e.g., "127.0.0.1:9991"), so that a desktop GUI can visualize
+// the test while it runs. Each WebSocket message contains an event.
+//
+// We print on the standard output one JSON object per line (NDJSON), i.e.,
+// the results of each iteration, the results of the paired mode, or the
+// results of the bidirectional mode, as well as objects containing exactly
+// one of the following keys, besides "schema_version":
+//
+// - "final_result", which we print at the end of the test, containing the
+// results measured by the client and by the server, a summary, metadata,
+// and, when the test fails, a "failure" object describing the failed phase,
+// the server, the elapsed time, the HTTP status, the error, and the number
+// of iterations performed before failing;
+//
+// - "trend", which we print in daemon mode (see `-daemon-interval`).
+//
+// Each object also contains the "schema_version" key, which is the version
+// of the output format published by the `-schema` flag. We bump the version
+// only when we make backwards incompatible changes. Because we may add new
+// fields without bumping the version, parsers should ignore the fields they
+// do not know.
+//
+// The `install-service` subcommand writes a service running dash-client in
+// daemon mode with the flags following the subcommand, which allows to run
+// longitudinal measurements without keeping a terminal open. On Linux, we
+// write the `dash-client.service` systemd user unit, and on macOS we write
+// the `org.neubot.dash-client` launchd agent. We print the command to enable
+// the service. When `-daemon-interval` is missing, we use "6h". The service
+// runs in the current directory, so relative paths work as expected.
+//
 // Additionally, passing any unrecognized flag, such as `-help`, will
 // cause dash-client to print a brief help message.
 package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"runtime"
+	"strings"
 	"time"
 
 	"github.com/apex/log"
@@ -38,27 +225,116 @@ import (
 )
 
 const (
-	clientName     = "dash-client-go"
-	clientVersion  = "0.4.3"
-	defaultTimeout = 55 * time.Second
+	clientName    = "dash-client-go"
+	clientVersion = "0.4.3"
 )
 
 var (
+	flagAcceptEncoding = flag.String(
+		"accept-encoding", "", "optional Accept-Encoding header for segment requests")
+
+	flagAdapter = flag.String(
+		"adapter", "throughput", "adaptation algorithm: "+strings.Join(client.Adapters(), ", "))
+
+	flagBidirectional = flag.Bool(
+		"bidirectional", false, "run download and upload concurrently and measure the impact")
+
+	flagCacheBusting = flag.Bool(
+		"cache-busting", false, "add a random token to segment requests to bust caches")
+
+	flagCacheDir = flag.String(
+		"cache-dir", "", "optional directory where to persist the last server and run")
+
+	flagCorrectClockSkew = flag.Bool(
+		"correct-clock-skew", false, "shift server timestamps by the estimated clock offset")
+
+	flagDaemonInterval = flag.Duration(
+		"daemon-interval", 0, "interval between tests in daemon mode (0 means disabled)")
+
+	flagDSCP = flag.Int("dscp", 0, "optional DSCP value for marking connections")
+
+	flagExec = flag.String(
+		"exec", "", "optional command to run after each run in daemon mode")
+
+	flagFallbackServers flagx.StringArray
+
 	flagHostname = flag.String("hostname", "", "optional DASH server hostname")
 
+	flagIterations = flag.Int64(
+		"iterations", client.DefaultIterations, "number of segments to download")
+
+	flagLocateTargets = flag.Int(
+		"locate-targets", 1, "number of autodiscovered servers to try when negotiating fails")
+
+	flagMetricsListenAddress = flag.String(
+		"metrics-listen-address", "", "optional metrics endpoint in daemon mode")
+
+	flagPairedControl = flag.String(
+		"paired-control", "", "control server hostname for the paired mode")
+
+	flagPairedTest = flag.String(
+		"paired-test", "", "test server hostname for the paired mode")
+
+	flagPersistProbeID = flag.Bool(
+		"persist-probe-id", false, "reuse the same probe ID for all runs (requires -cache-dir)")
+
+	flagPinConnection = flag.Bool(
+		"pin-connection", false, "use a single connection for the whole test")
+
 	flagTimeout = flag.Duration(
-		"timeout", defaultTimeout, "time after which the test is aborted")
+		"timeout", 0, "time after which the test is aborted (0 means derived from the other flags)")
+
+	flagSchema = flag.Bool(
+		"schema", false, "print the JSON Schema of the output and exit")
 
 	flagScheme = flagx.Enum{
 		Options: []string{"https", "http"},
 		Value:   "https",
 	}
 
+	flagRenegotiate = flag.Bool(
+		"renegotiate", false, "negotiate a new session when the server returns 429")
+
+	flagResilient = flag.Bool(
+		"resilient", false, "continue at a lower rate when a segment download fails")
+
+	flagSegmentTimeout = flag.Duration(
+		"segment-timeout", 0, "time after which a segment download is aborted")
+
+	flagStreamDuration = flag.Duration(
+		"stream-duration", 60*time.Second, "duration of the emulated stream")
+
+	flagStreamRate = flag.Int64(
+		"stream-rate", 0, "fixed rate in kbit/s for stream emulation (0 means disabled)")
+
+	flagStrictPrivacy = flag.Bool(
+		"strict-privacy", false, "omit addresses from the printed results")
+
+	flagTargetBudget = flag.Duration(
+		"target-budget", 0, "maximum time for negotiating with each server (0 means no budget)")
+
+	flagTCPInfo = flag.Bool(
+		"tcp-info", false, "sample TCP_INFO around each segment download (Linux only)")
+
+	flagUseLastServer = flag.Bool(
+		"use-last-server", false, "reuse the server used by the last run (requires -cache-dir)")
+
+	flagUserAgentProfile = flag.String(
+		"user-agent-profile", "", "optional video player User-Agent for segment requests")
+
+	flagWSListen = flag.String(
+		"ws-listen", "", "optional loopback endpoint where to stream events over WebSocket")
+
 	flagY = flag.Bool("y", false,
 		"I have read and accept the privacy policy at https://github.com/neubot/dash/blob/master/PRIVACY.md")
 )
 
 func init() {
+	flag.Var(
+		&flagFallbackServers,
+		"fallback-server",
+		"server URL to use when autodiscovery fails (may be repeated)",
+	)
 	flag.Var(
 		&flagScheme,
 		"scheme",
@@ -67,26 +343,62 @@ func init() {
 }
 
 func realmain(ctx context.Context, client *client.Client, timeout time.Duration, onresult func()) error {
+	if timeout <= 0 {
+		timeout = client.DefaultTimeout()
+	}
 	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 	ch, err := client.StartDownload(ctx)
 	if err != nil {
+		printEvent(outputEvent{FinalResult: client.FinalResult()})
 		return err
 	}
 	for results := range ch {
 		if onresult != nil {
 			onresult() // this is an hook that we use for testing
 		}
-		data, err := json.Marshal(results)
-		rtx.PanicOnError(err, "json.Marshal should not fail")
-		fmt.Printf("%s\n", string(data))
+		printEvent(results)
 	}
+	printEvent(outputEvent{FinalResult: client.FinalResult()})
 	if client.Error() != nil {
 		return client.Error()
 	}
-	data, err := json.Marshal(client.ServerResults())
-	rtx.PanicOnError(err, "json.Marshal should not fail")
-	fmt.Printf("%s\n", string(data))
+	if client.StreamRate > 0 {
+		client.Logger.Infof("dash: stream emulation at %d kbit/s sustained: %v",
+			client.StreamRate, client.StreamSustained())
+	}
+	return nil
+}
+
+func realpaired(ctx context.Context, control, test *client.Client, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = max(control.DefaultTimeout(), test.DefaultTimeout())
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	results := client.RunPaired(ctx, control, test)
+	if results.Control.Failure != "" && results.Test.Failure != "" {
+		return errors.New(results.Test.Failure)
+	}
+	control.Logger.Infof("dash: paired median rates: control %f kbit/s, test %f kbit/s",
+		results.Control.MedianRate, results.Test.MedianRate)
+	printEvent(results)
+	return nil
+}
+
+func realbidirectional(ctx context.Context, newClient func() *client.Client, timeout time.Duration) error {
+	if timeout <= 0 {
+		timeout = 3 * newClient().DefaultTimeout()
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	results := client.RunBidirectional(ctx, newClient)
+	if results.Download.Concurrent.Failure != "" && results.Upload.Concurrent.Failure != "" {
+		return errors.New(results.Download.Concurrent.Failure)
+	}
+	log.Infof("dash: cross traffic impact: download %f, upload %f",
+		results.Download.RateRatio, results.Upload.RateRatio)
+	printEvent(results)
 	return nil
 }
 
@@ -95,7 +407,20 @@ func init() {
 }
 
 func internalmain(ctx context.Context) error {
-	flag.Parse()
+	args := os.Args[1:]
+	installService := len(args) > 0 && args[0] == installServiceCommand
+	if installService {
+		args = args[1:]
+	}
+	flag.CommandLine.Parse(args)
+	if installService && *flagDaemonInterval <= 0 {
+		args = serviceArgs(args)
+		*flagDaemonInterval = defaultServiceInterval
+	}
+	if *flagSchema {
+		printSchema()
+		return nil
+	}
 	if !*flagY {
 		fmt.Fprintf(os.Stderr, "\n")
 		fmt.Fprintf(os.Stderr, "Please, read the privacy policy at https://github.com/neubot/dash/blob/master/PRIVACY.md.\n")
@@ -104,11 +429,82 @@ func internalmain(ctx context.Context) error {
 		fmt.Fprintf(os.Stderr, "\n")
 		os.Exit(1)
 	}
+	if *flagUseLastServer && *flagCacheDir == "" {
+		return errors.New("-use-last-server needs -cache-dir")
+	}
+	if _, err := client.NewAdapter(*flagAdapter); err != nil {
+		return fmt.Errorf("-adapter: %w", err)
+	}
+	if *flagPersistProbeID && *flagCacheDir == "" {
+		return errors.New("-persist-probe-id needs -cache-dir")
+	}
+	if strings.TrimSpace(*flagExec) != "" && (*flagDaemonInterval <= 0 || *flagCacheDir == "") {
+		return errors.New("-exec needs -daemon-interval and -cache-dir")
+	}
+	if installService {
+		return runInstallService(runtime.GOOS, args)
+	}
+	if *flagWSListen != "" {
+		if err := serveEvents(*flagWSListen); err != nil {
+			return err
+		}
+	}
+	if *flagPairedControl != "" || *flagPairedTest != "" {
+		if *flagPairedControl == "" || *flagPairedTest == "" {
+			return errors.New("the paired mode needs both -paired-control and -paired-test")
+		}
+		return realpaired(ctx, newClient(*flagPairedControl), newClient(*flagPairedTest), *flagTimeout)
+	}
+	if *flagBidirectional {
+		return realbidirectional(ctx, func() *client.Client {
+			return newClient(*flagHostname)
+		}, *flagTimeout)
+	}
+	if *flagDaemonInterval > 0 {
+		if *flagMetricsListenAddress != "" {
+			serveMetrics(*flagMetricsListenAddress)
+		}
+		var upload func(path string) error
+		if strings.TrimSpace(*flagExec) != "" {
+			upload = newExecHook(*flagExec, log.Log)
+		}
+		store := newDaemonStore(*flagCacheDir, upload, log.Log)
+		return realdaemon(ctx, func() *client.Client {
+			return newClient(*flagHostname)
+		}, *flagDaemonInterval, *flagTimeout, store)
+	}
+	return realmain(ctx, newClient(*flagHostname), *flagTimeout, nil)
+}
+
+// newClient creates a new client for the given hostname using the flags.
+func newClient(hostname string) *client.Client {
+	adapter, _ := client.NewAdapter(*flagAdapter) // validated by internalmain
 	client := client.New(clientName, clientVersion)
 	client.Logger = log.Log
-	client.FQDN = *flagHostname
+	client.AcceptEncoding = *flagAcceptEncoding
+	client.Adapter = adapter
+	client.CacheBusting = *flagCacheBusting
+	client.CacheDir = *flagCacheDir
+	client.CorrectClockSkew = *flagCorrectClockSkew
+	client.DSCP = *flagDSCP
+	client.FQDN = hostname
+	client.FallbackServers = flagFallbackServers
+	client.Iterations = *flagIterations
+	client.LocateTargets = *flagLocateTargets
+	client.PersistProbeID = *flagPersistProbeID
+	client.PinConnection = *flagPinConnection
+	client.Renegotiate = *flagRenegotiate
+	client.Resilient = *flagResilient
+	client.SampleTCPInfo = *flagTCPInfo
 	client.Scheme = flagScheme.Value
-	return realmain(ctx, client, *flagTimeout, nil)
+	client.SegmentTimeout = *flagSegmentTimeout
+	client.StreamDuration = *flagStreamDuration
+	client.StreamRate = *flagStreamRate
+	client.StrictPrivacy = *flagStrictPrivacy
+	client.TargetBudget = *flagTargetBudget
+	client.UseLastServer = *flagUseLastServer
+	client.UserAgentProfile = *flagUserAgentProfile
+	return client
 }
 
 func fmain(f func(context.Context) error, e func(error, string, ...interface{})) {
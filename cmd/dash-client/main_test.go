@@ -63,6 +63,34 @@ func TestFailureBeforeEnd(t *testing.T) {
 	})
 }
 
+func TestRealpairedSuccessful(t *testing.T) {
+	testhelper(t, func(idx int, config testconfig) {
+		time.Sleep(time.Duration(idx) * 100 * time.Millisecond)
+		control := client.New(config.clientName, config.clientVersion)
+		control.FQDN = config.fqdn
+		control.Logger = log.Log
+		control.Scheme = "http" // we use httptest.NewServer
+		test := client.New(config.clientName, config.clientVersion)
+		test.FQDN = config.fqdn
+		test.Scheme = "http"
+		config.errors[idx] = realpaired(config.ctx, control, test, 55*time.Second)
+	})
+}
+
+func TestRealbidirectionalSuccessful(t *testing.T) {
+	testhelper(t, func(idx int, config testconfig) {
+		time.Sleep(time.Duration(idx) * 100 * time.Millisecond)
+		config.errors[idx] = realbidirectional(config.ctx, func() *client.Client {
+			client := client.New(config.clientName, config.clientVersion)
+			client.FQDN = config.fqdn
+			client.Iterations = 2
+			client.Logger = log.Log
+			client.Scheme = "http" // we use httptest.NewServer
+			return client
+		}, 55*time.Second)
+	})
+}
+
 type testconfig struct {
 	clientName    string
 	clientVersion string
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/apex/log"
+	"github.com/m-lab/go/rtx"
+	"github.com/neubot/dash/client"
+	"github.com/neubot/dash/model"
+)
+
+// outputSchemaVersion is the version of the output format, which we emit
+// as the "schema_version" key of each event. We bump it when we make backwards
+// incompatible changes (e.g., removing or renaming a field or changing its
+// type). Adding optional fields does not bump the version, hence parsers
+// should ignore the fields they do not know.
+const outputSchemaVersion = 1
+
+// outputEvent is a line of the NDJSON output containing exactly one of its
+// fields. We print the results of an iteration, of the paired mode, and of
+// the bidirectional mode as they are, and we use outputEvent for the other
+// events, which otherwise would be ambiguous.
+type outputEvent struct {
+	// FinalResult combines all the results of the test.
+	FinalResult *client.FinalResult `json:"final_result,omitempty"`
+
+	// Trend compares the current run with the previous one in daemon mode.
+	Trend *trendEvent `json:"trend,omitempty"`
+}
+
+// eventServer streams the output events over WebSocket when
+// the user specified -ws-listen and is nil otherwise.
+var eventServer *client.EventServer
+
+// printEvent prints the given event, i.e., an outputEvent, a
+// [model.ClientResults], a [*client.PairedResults], or a
+// [*client.BidirectionalResults], as a line of the NDJSON output
+// and publishes it using the eventServer, if any. We add the
+// "schema_version" key to each event, so that parsers can tell
+// which version of the output format each line uses.
+func printEvent(event any) {
+	data, err := json.Marshal(event)
+	rtx.PanicOnError(err, "json.Marshal should not fail")
+	data = withSchemaVersion(data)
+	fmt.Printf("%s\n", string(data))
+	if eventServer != nil {
+		if err := eventServer.Publish(json.RawMessage(data)); err != nil {
+			log.Warnf("dash: cannot publish event: %s", err.Error())
+		}
+	}
+}
+
+// withSchemaVersion adds the "schema_version" key to the given
+// serialized event, which is a JSON object.
+func withSchemaVersion(data []byte) []byte {
+	prefix := fmt.Sprintf(`{"schema_version":%d`, outputSchemaVersion)
+	if len(data) <= len("{}") {
+		return []byte(prefix + "}")
+	}
+	return append([]byte(prefix+","), data[1:]...)
+}
+
+// errNotLoopback indicates that -ws-listen is not a loopback endpoint.
+var errNotLoopback = errors.New("-ws-listen needs a loopback endpoint")
+
+// serveEvents creates the eventServer and serves it at the given local
+// endpoint, which must be a loopback endpoint because the events contain
+// the results, including the addresses. We listen synchronously, so that
+// we return the error when, e.g., the endpoint is already in use.
+func serveEvents(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if addr, err := netip.ParseAddr(host); host != "localhost" && (err != nil || !addr.IsLoopback()) {
+		return errNotLoopback
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	eventServer = client.NewEventServer()
+	go http.Serve(listener, eventServer)
+	return nil
+}
+
+// printSchema prints the JSON Schema of the output events.
+func printSchema() {
+	fmt.Printf("%s\n", string(outputSchema()))
+}
+
+// outputSchema returns the serialized JSON Schema of the output events,
+// where each event is one of the event types and also contains the
+// "schema_version" key, whose value is the outputSchemaVersion.
+func outputSchema() []byte {
+	schema := model.JSONSchema(
+		model.ClientResults{}, &client.PairedResults{}, &client.BidirectionalResults{}, outputEvent{})
+	schema["title"] = "dash-client output event"
+	schema["allOf"] = []any{map[string]any{
+		"type":     "object",
+		"required": []string{"schema_version"},
+		"properties": map[string]any{
+			"schema_version": map[string]any{"const": outputSchemaVersion},
+		},
+	}}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	rtx.PanicOnError(err, "json.MarshalIndent should not fail")
+	return data
+}
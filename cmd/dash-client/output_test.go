@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/neubot/dash/model"
+)
+
+func TestOutputEventSchema(t *testing.T) {
+	schema := model.JSONSchema(outputEvent{})
+	object := schema["$defs"].(map[string]any)["outputEvent"].(map[string]any)
+	if required := object["required"].([]string); len(required) != 0 {
+		t.Fatal("unexpected required properties", required)
+	}
+	properties := object["properties"].(map[string]any)
+	for _, key := range []string{"final_result", "trend"} {
+		if _, found := properties[key]; !found {
+			t.Fatal("missing property", key)
+		}
+	}
+}
+
+// captureStdout returns what the given function writes on the standard output.
+func captureStdout(t *testing.T, fn func()) string {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = writer
+	fn()
+	os.Stdout = saved
+	writer.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestPrintEventAddsSchemaVersion(t *testing.T) {
+	t.Run("with the results of an iteration", func(t *testing.T) {
+		results := model.ClientResults{Iteration: 1, Rate: 1000}
+		output := captureStdout(t, func() { printEvent(results) })
+		var event map[string]any
+		if err := json.Unmarshal([]byte(output), &event); err != nil {
+			t.Fatal(err)
+		}
+		if event["schema_version"] != float64(outputSchemaVersion) {
+			t.Fatal("unexpected schema version", event["schema_version"])
+		}
+		if event["iteration"] != float64(1) || event["rate"] != float64(1000) {
+			t.Fatal("unexpected event", event)
+		}
+	})
+
+	t.Run("with an empty event", func(t *testing.T) {
+		output := captureStdout(t, func() { printEvent(outputEvent{}) })
+		if output != `{"schema_version":1}`+"\n" {
+			t.Fatal("unexpected output", output)
+		}
+	})
+}
+
+func TestOutputSchemaVersion(t *testing.T) {
+	var schema map[string]any
+	if err := json.Unmarshal(outputSchema(), &schema); err != nil {
+		t.Fatal(err)
+	}
+	allOf := schema["allOf"].([]any)
+	if len(allOf) != 1 {
+		t.Fatal("unexpected allOf", allOf)
+	}
+	object := allOf[0].(map[string]any)
+	if required := object["required"].([]any); len(required) != 1 || required[0] != "schema_version" {
+		t.Fatal("unexpected required properties", required)
+	}
+	property := object["properties"].(map[string]any)["schema_version"].(map[string]any)
+	if property["const"] != float64(outputSchemaVersion) {
+		t.Fatal("unexpected schema version", property)
+	}
+}
+
+func TestServeEventsNotLoopback(t *testing.T) {
+	for _, address := range []string{"0.0.0.0:9991", ":9991", "example.com:9991"} {
+		if err := serveEvents(address); err != errNotLoopback {
+			t.Fatal("not the error we expected", address, err)
+		}
+	}
+	if err := serveEvents("127.0.0.1"); err == nil {
+		t.Fatal("expected an error for an endpoint without port")
+	}
+	if eventServer != nil {
+		t.Fatal("expected no event server")
+	}
+}
+
+func TestServeEventsInUse(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if err := serveEvents(listener.Addr().String()); err == nil {
+		t.Fatal("expected an error for an endpoint in use")
+	}
+	if eventServer != nil {
+		t.Fatal("expected no event server")
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/apex/log"
+)
+
+const (
+	// installServiceCommand is the subcommand installing the service.
+	installServiceCommand = "install-service"
+
+	// defaultServiceInterval is the -daemon-interval we use for the
+	// service when the user did not specify any interval.
+	defaultServiceInterval = 6 * time.Hour
+
+	// launchdLabel is the label of the launchd agent.
+	launchdLabel = "org.neubot.dash-client"
+
+	// systemdUnitName is the name of the systemd user unit.
+	systemdUnitName = "dash-client.service"
+)
+
+// errServiceNotSupported indicates that we cannot install a service on the
+// current platform. On Windows, in particular, dash-client does not implement
+// the protocol for talking with the service control manager.
+var errServiceNotSupported = errors.New("install-service: only systemd and launchd are supported")
+
+// serviceConfig contains the configuration of the service.
+type serviceConfig struct {
+	// Args contains the command line arguments of dash-client.
+	Args []string
+
+	// Executable is the absolute path of dash-client.
+	Executable string
+
+	// Home is the home directory of the user.
+	Home string
+
+	// WorkingDirectory is the directory where to run dash-client, which
+	// allows to use the relative paths passed on the command line.
+	WorkingDirectory string
+}
+
+// serviceArgs returns the command line arguments of the service given the
+// arguments following the subcommand, when the user did not configure the
+// daemon mode, by adding -daemon-interval with the default interval.
+func serviceArgs(args []string) []string {
+	return append(append([]string{}, args...), "-daemon-interval", defaultServiceInterval.String())
+}
+
+// servicePath returns the path of the service file for the given platform.
+func servicePath(goos, home string) (string, error) {
+	switch goos {
+	case "linux":
+		return filepath.Join(home, ".config", "systemd", "user", systemdUnitName), nil
+	case "darwin":
+		return filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist"), nil
+	default:
+		return "", errServiceNotSupported
+	}
+}
+
+// systemdQuote quotes an argument of the systemd ExecStart directive.
+func systemdQuote(arg string) string {
+	arg = strings.ReplaceAll(arg, `\`, `\\`)
+	arg = strings.ReplaceAll(arg, `"`, `\"`)
+	arg = strings.ReplaceAll(arg, "%", "%%")
+	arg = strings.ReplaceAll(arg, "$", "$$")
+	return `"` + arg + `"`
+}
+
+// systemdUnit returns the systemd user unit running the service.
+func systemdUnit(config *serviceConfig) string {
+	var exec []string
+	for _, arg := range append([]string{config.Executable}, config.Args...) {
+		exec = append(exec, systemdQuote(arg))
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "[Unit]\n")
+	fmt.Fprintf(&sb, "Description=Neubot DASH longitudinal measurements\n")
+	fmt.Fprintf(&sb, "Wants=network-online.target\n")
+	fmt.Fprintf(&sb, "After=network-online.target\n")
+	fmt.Fprintf(&sb, "\n")
+	fmt.Fprintf(&sb, "[Service]\n")
+	fmt.Fprintf(&sb, "ExecStart=%s\n", strings.Join(exec, " "))
+	fmt.Fprintf(&sb, "WorkingDirectory=%s\n", systemdQuote(config.WorkingDirectory))
+	fmt.Fprintf(&sb, "Restart=on-failure\n")
+	fmt.Fprintf(&sb, "RestartSec=60\n")
+	fmt.Fprintf(&sb, "\n")
+	fmt.Fprintf(&sb, "[Install]\n")
+	fmt.Fprintf(&sb, "WantedBy=default.target\n")
+	return sb.String()
+}
+
+// xmlEscape escapes the given string for including it into XML.
+func xmlEscape(value string) string {
+	var sb strings.Builder
+	_ = xml.EscapeText(&sb, []byte(value)) // cannot fail with a strings.Builder
+	return sb.String()
+}
+
+// launchdPlist returns the launchd agent property list running the service.
+func launchdPlist(config *serviceConfig) string {
+	logfile := filepath.Join(config.Home, "Library", "Logs", "dash-client.log")
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(&sb, "<!DOCTYPE plist PUBLIC \"-//Apple//DTD PLIST 1.0//EN\" ")
+	fmt.Fprintf(&sb, "\"http://www.apple.com/DTDs/PropertyList-1.0.dtd\">\n")
+	fmt.Fprintf(&sb, "<plist version=\"1.0\">\n")
+	fmt.Fprintf(&sb, "<dict>\n")
+	fmt.Fprintf(&sb, "\t<key>Label</key>\n\t<string>%s</string>\n", launchdLabel)
+	fmt.Fprintf(&sb, "\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range append([]string{config.Executable}, config.Args...) {
+		fmt.Fprintf(&sb, "\t\t<string>%s</string>\n", xmlEscape(arg))
+	}
+	fmt.Fprintf(&sb, "\t</array>\n")
+	fmt.Fprintf(&sb, "\t<key>WorkingDirectory</key>\n\t<string>%s</string>\n", xmlEscape(config.WorkingDirectory))
+	fmt.Fprintf(&sb, "\t<key>RunAtLoad</key>\n\t<true/>\n")
+	fmt.Fprintf(&sb, "\t<key>KeepAlive</key>\n\t<true/>\n")
+	fmt.Fprintf(&sb, "\t<key>StandardOutPath</key>\n\t<string>%s</string>\n", xmlEscape(logfile))
+	fmt.Fprintf(&sb, "\t<key>StandardErrorPath</key>\n\t<string>%s</string>\n", xmlEscape(logfile))
+	fmt.Fprintf(&sb, "</dict>\n")
+	fmt.Fprintf(&sb, "</plist>\n")
+	return sb.String()
+}
+
+// installService writes the service file for the given platform and
+// returns its path and the command the user should run to enable it.
+func installService(goos string, config *serviceConfig) (string, string, error) {
+	path, err := servicePath(goos, config.Home)
+	if err != nil {
+		return "", "", err
+	}
+	var data, enable string
+	switch goos {
+	case "linux":
+		data = systemdUnit(config)
+		enable = "systemctl --user daemon-reload && systemctl --user enable --now " + systemdUnitName
+	default: // darwin
+		data = launchdPlist(config)
+		enable = "launchctl load -w " + strconv.Quote(path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", "", err
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		return "", "", err
+	}
+	return path, enable, nil
+}
+
+// runInstallService implements the install-service subcommand given the
+// command line arguments following the subcommand.
+func runInstallService(goos string, args []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	path, enable, err := installService(goos, &serviceConfig{
+		Args:             args,
+		Executable:       executable,
+		Home:             home,
+		WorkingDirectory: cwd,
+	})
+	if err != nil {
+		return err
+	}
+	log.Infof("dash: wrote %s; enable the service using: %s", path, enable)
+	return nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestServiceArgs(t *testing.T) {
+	args := []string{"-y", "-resilient"}
+	got := serviceArgs(args)
+	expected := []string{"-y", "-resilient", "-daemon-interval", "6h0m0s"}
+	if !slices.Equal(got, expected) {
+		t.Fatal("unexpected args", got)
+	}
+	if len(args) != 2 {
+		t.Fatal("expected not to modify the original args")
+	}
+}
+
+func TestServicePath(t *testing.T) {
+	path, err := servicePath("linux", "/home/user")
+	if err != nil || path != "/home/user/.config/systemd/user/dash-client.service" {
+		t.Fatal("unexpected path", path, err)
+	}
+	path, err = servicePath("darwin", "/Users/user")
+	if err != nil || path != "/Users/user/Library/LaunchAgents/org.neubot.dash-client.plist" {
+		t.Fatal("unexpected path", path, err)
+	}
+	if _, err := servicePath("windows", `C:\Users\user`); err != errServiceNotSupported {
+		t.Fatal("expected an error", err)
+	}
+}
+
+func TestSystemdUnit(t *testing.T) {
+	unit := systemdUnit(&serviceConfig{
+		Args:             []string{"-y", "-exec", `notify "100%" $HOME`},
+		Executable:       "/usr/local/bin/dash-client",
+		WorkingDirectory: "/home/user",
+	})
+	expected := `ExecStart="/usr/local/bin/dash-client" "-y" "-exec" "notify \"100%%\" $$HOME"`
+	if !strings.Contains(unit, expected+"\n") {
+		t.Fatal("unexpected unit", unit)
+	}
+	if !strings.Contains(unit, `WorkingDirectory="/home/user"`) {
+		t.Fatal("unexpected unit", unit)
+	}
+}
+
+func TestLaunchdPlist(t *testing.T) {
+	plist := launchdPlist(&serviceConfig{
+		Args:             []string{"-y", "-exec", "notify <&>"},
+		Executable:       "/usr/local/bin/dash-client",
+		Home:             "/Users/user",
+		WorkingDirectory: "/Users/user",
+	})
+	decoder := xml.NewDecoder(strings.NewReader(plist))
+	var texts []string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, ok := token.(xml.CharData); ok && strings.TrimSpace(string(data)) != "" {
+			texts = append(texts, string(data))
+		}
+	}
+	if !slices.Contains(texts, "notify <&>") || !slices.Contains(texts, "/usr/local/bin/dash-client") {
+		t.Fatal("unexpected plist", plist)
+	}
+}
+
+func TestInstallService(t *testing.T) {
+	home := t.TempDir()
+	config := &serviceConfig{
+		Args:             []string{"-y", "-daemon-interval", "1h"},
+		Executable:       "/usr/local/bin/dash-client",
+		Home:             home,
+		WorkingDirectory: home,
+	}
+	for _, goos := range []string{"linux", "darwin"} {
+		path, enable, err := installService(goos, config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(path, home) || enable == "" {
+			t.Fatal("unexpected path or command", path, enable)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(data), "-daemon-interval") {
+			t.Fatal("unexpected service file", string(data))
+		}
+	}
+	if _, _, err := installService("windows", config); err != errServiceNotSupported {
+		t.Fatal("expected an error", err)
+	}
+	if _, err := os.Stat(filepath.Join(home, ".config", "systemd", "user")); err != nil {
+		t.Fatal(err)
+	}
+}
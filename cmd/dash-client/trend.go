@@ -0,0 +1,76 @@
+package main
+
+import (
+	"time"
+
+	"github.com/neubot/dash/client"
+	"github.com/neubot/dash/model"
+)
+
+// runSummary summarizes a successful daemon run for comparing it with
+// the next one (see [trendEvent]).
+type runSummary struct {
+	// MedianRate is the median rate in kbit/s.
+	MedianRate float64 `json:"median_rate"`
+
+	// Stalls is the number of segments that failed or that took
+	// longer than their playback time.
+	Stalls int64 `json:"stalls"`
+
+	// Timestamp is the Unix time when the run ended.
+	Timestamp int64 `json:"timestamp"`
+}
+
+// trendEvent compares two consecutive successful daemon runs, which
+// allows simple local alerting (e.g., when the rate drops by 40%).
+type trendEvent struct {
+	// Current summarizes the current run.
+	Current *runSummary `json:"current"`
+
+	// Previous summarizes the previous run.
+	Previous *runSummary `json:"previous"`
+
+	// RateChange is the change of the median rate in percent (e.g., -40
+	// when the rate dropped by 40%), omitted when the previous median
+	// rate is zero.
+	RateChange *float64 `json:"rate_change,omitempty"`
+
+	// StallsChange is the difference between the stalls of the current
+	// run and the stalls of the previous one.
+	StallsChange int64 `json:"stalls_change"`
+}
+
+// countStalls returns the number of segments that failed or that took
+// longer than their playback time.
+func countStalls(results []model.ClientResults) int64 {
+	var stalls int64
+	for _, current := range results {
+		if current.Failure != "" || current.Elapsed > float64(current.ElapsedTarget) {
+			stalls++
+		}
+	}
+	return stalls
+}
+
+// summarizeRun returns the [*runSummary] of a successful run.
+func summarizeRun(results []model.ClientResults, now time.Time) *runSummary {
+	return &runSummary{
+		MedianRate: client.MedianRate(results),
+		Stalls:     countStalls(results),
+		Timestamp:  now.Unix(),
+	}
+}
+
+// compareRuns returns the [*trendEvent] comparing the given runs.
+func compareRuns(previous, current *runSummary) *trendEvent {
+	trend := &trendEvent{
+		Current:      current,
+		Previous:     previous,
+		StallsChange: current.Stalls - previous.Stalls,
+	}
+	if previous.MedianRate > 0 {
+		change := (current.MedianRate - previous.MedianRate) / previous.MedianRate * 100
+		trend.RateChange = &change
+	}
+	return trend
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/model"
+)
+
+func TestSummarizeRun(t *testing.T) {
+	results := []model.ClientResults{
+		{Elapsed: 1, ElapsedTarget: 2, Received: 1000},
+		{Elapsed: 3, ElapsedTarget: 2, Received: 3000},
+	}
+	summary := summarizeRun(results, time.Unix(1234567890, 0))
+	if summary.MedianRate != 8 || summary.Stalls != 1 || summary.Timestamp != 1234567890 {
+		t.Fatalf("unexpected summary: %+v", summary)
+	}
+}
+
+func TestCompareRuns(t *testing.T) {
+	t.Run("common case", func(t *testing.T) {
+		previous := &runSummary{MedianRate: 10000, Stalls: 1}
+		current := &runSummary{MedianRate: 6000, Stalls: 3}
+		trend := compareRuns(previous, current)
+		if trend.RateChange == nil || *trend.RateChange != -40 {
+			t.Fatal("unexpected rate change", trend.RateChange)
+		}
+		if trend.StallsChange != 2 {
+			t.Fatal("unexpected stalls change", trend.StallsChange)
+		}
+	})
+
+	t.Run("when the previous rate is zero", func(t *testing.T) {
+		trend := compareRuns(&runSummary{}, &runSummary{MedianRate: 1000})
+		if trend.RateChange != nil {
+			t.Fatal("expected no rate change")
+		}
+	})
+}
+
+func TestDaemonStoreCompare(t *testing.T) {
+	dir := t.TempDir()
+	store := newDaemonStore(dir, nil, log.Log)
+	store.compare(&runSummary{MedianRate: 1000, Timestamp: 1})
+	store.schedule(nil, time.Now(), time.Hour)
+	restarted := newDaemonStore(dir, nil, log.Log)
+	if restarted.state.LastRun == nil || restarted.state.LastRun.MedianRate != 1000 {
+		t.Fatal("expected to persist the last run")
+	}
+	restarted.compare(&runSummary{MedianRate: 2000, Timestamp: 2})
+	if restarted.state.LastRun.Timestamp != 2 {
+		t.Fatal("expected to remember the current run")
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/m-lab/go/rtx"
+	"github.com/neubot/dash/server"
+)
+
+// configReport is the JSON printed by -check-config.
+type configReport struct {
+	// Config maps each flag name to its effective value.
+	Config map[string]string `json:"config"`
+
+	// Errors contains the problems we found or is empty.
+	Errors []string `json:"errors"`
+}
+
+// checkConfig validates the configuration without starting the server and
+// returns the effective configuration along with the problems we found.
+func checkConfig() *configReport {
+	report := &configReport{
+		Config: effectiveConfig(flag.CommandLine),
+		Errors: []string{},
+	}
+	if URL, err := url.Parse(*flagSessionStore); err == nil {
+		report.Config["session-store"] = URL.Redacted() // do not print the password
+	}
+	check := func(err error) {
+		if err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+
+	// 1. listen addresses
+	check(checkListenAddress("http-listen-address", *flagHTTPListenAddress))
+	check(checkListenAddress("https-listen-address", *flagHTTPSListenAddress))
+	if *flagAdminListenAddress != "" {
+		check(checkListenAddress("admin-listen-address", *flagAdminListenAddress))
+	}
+	if value, found := report.Config["prometheusx.listen-address"]; found {
+		check(checkListenAddress("prometheusx.listen-address", value))
+	}
+
+	// 2. limits
+	check(checkPositive("ban-duration", *flagBanDuration))
+	check(checkNonNegative("ban-threshold", *flagBanThreshold))
+	check(checkPositive("drain-timeout", *flagDrainTimeout))
+	check(checkNonNegative("idle-timeout", *flagIdleTimeout))
+	check(checkPositive("listeners", *flagListeners))
+	check(checkNonNegative("live-segment-duration", *flagLiveSegmentDuration))
+	check(checkNonNegative("max-conn-lifetime", *flagMaxConnLifetime))
+	check(checkNonNegative("max-session-bytes", *flagMaxSessionBytes))
+	check(checkNonNegative("min-download-rate", *flagMinDownloadRate))
+	check(checkNonNegative("read-header-timeout", *flagReadHeaderTimeout))
+	check(checkNonNegative("save-queue-size", *flagSaveQueueSize))
+	check(checkNonNegative("save-workers", *flagSaveWorkers))
+	check(checkNonNegative("send-buffer-size", *flagSendBufferSize))
+	check(checkNonNegative("tcp-notsent-lowat", *flagTCPNotSentLowat))
+
+	// 3. other values
+	if *flagBaseURL != "" {
+		check(checkBaseURL(*flagBaseURL))
+	}
+	check(server.ValidateContentType(*flagContentType))
+	if *flagMinClientVersion != "" {
+		check(server.ValidateClientVersion(*flagMinClientVersion))
+	}
+	if err := server.ValidateFaults(faultsFromFlags()); err != nil {
+		check(fmt.Errorf("fault-*: %w", err))
+	}
+	check(server.ValidateStorageLayout(*flagStorageLayout))
+	proxies, err := parseTrustedProxies()
+	if err != nil {
+		check(fmt.Errorf("trusted-proxy: %w", err))
+	}
+	if *flagMASQUEResearch && err == nil && len(proxies) <= 0 {
+		check(errMASQUEWithoutProxies)
+	}
+	if *flagExportTokenFile != "" {
+		if _, err := loadExportToken(); err != nil {
+			check(fmt.Errorf("export-token-file: %w", err))
+		}
+	}
+	if *flagIP2ASNDatabase != "" {
+		if _, err := server.LoadIP2ASNDatabase(*flagIP2ASNDatabase); err != nil {
+			check(fmt.Errorf("ip2asn-database: %w", err))
+		}
+	}
+	if *flagSessionStore != "" {
+		if _, err := server.NewRedisSessionStore(*flagSessionStore); err != nil {
+			check(fmt.Errorf("session-store: %w", err))
+		}
+	}
+	if *flagSigningKey != "" {
+		if _, err := server.LoadSigningKey(*flagSigningKey); err != nil {
+			check(fmt.Errorf("signing-key: %w", err))
+		}
+	}
+
+	// 4. datadir, TLS files, and clock
+	handler := server.NewHandler(*flagDatadir, log.Log)
+	err = handler.SelfCheck(&server.SelfCheckConfig{
+		DryRun:  true,
+		TLSCert: *flagTLSCert,
+		TLSKey:  *flagTLSKey,
+	})
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			check(err)
+		}
+	} else {
+		check(err)
+	}
+	return report
+}
+
+// runCheckConfig implements -check-config by printing the report on the
+// given writer and returning the process exit code.
+func runCheckConfig(w io.Writer) int {
+	report := checkConfig()
+	data, err := json.MarshalIndent(report, "", "  ")
+	rtx.PanicOnError(err, "json.MarshalIndent should not fail")
+	fmt.Fprintf(w, "%s\n", string(data))
+	if len(report.Errors) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// effectiveConfig returns the effective value of each flag in the set.
+func effectiveConfig(set *flag.FlagSet) map[string]string {
+	config := make(map[string]string)
+	set.VisitAll(func(f *flag.Flag) {
+		config[f.Name] = f.Value.String()
+	})
+	return config
+}
+
+// checkListenAddress checks whether the address is a valid TCP endpoint. We
+// do not attempt to listen, since the server may be already running.
+func checkListenAddress(name, address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// errInvalidBaseURL indicates that the -base-url is not valid.
+var errInvalidBaseURL = errors.New("expected an http or https URL with a host")
+
+// errMASQUEWithoutProxies indicates that -masque-research is useless
+// because there are no -trusted-proxy networks.
+var errMASQUEWithoutProxies = errors.New("masque-research: expected at least one -trusted-proxy")
+
+// errEmptyExportToken indicates that the -export-token-file is empty.
+var errEmptyExportToken = errors.New("the export token is empty")
+
+// checkBaseURL checks whether the base URL is valid.
+func checkBaseURL(value string) error {
+	URL, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("base-url: %w", err)
+	}
+	if (URL.Scheme != "http" && URL.Scheme != "https") || URL.Host == "" {
+		return fmt.Errorf("base-url: %w", errInvalidBaseURL)
+	}
+	return nil
+}
+
+// checkPositive checks whether the value of the given flag is positive.
+func checkPositive[T int | int64 | time.Duration](name string, value T) error {
+	if value <= 0 {
+		return fmt.Errorf("%s: expected a positive value, got %v", name, value)
+	}
+	return nil
+}
+
+// checkNonNegative checks whether the value of the given flag is not negative.
+func checkNonNegative[T int | int64 | time.Duration](name string, value T) error {
+	if value < 0 {
+		return fmt.Errorf("%s: expected a non-negative value, got %v", name, value)
+	}
+	return nil
+}
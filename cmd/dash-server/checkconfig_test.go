@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckListenAddress(t *testing.T) {
+	for _, address := range []string{":8080", "127.0.0.1:http", "[::1]:443"} {
+		if err := checkListenAddress("x", address); err != nil {
+			t.Fatal(address, err)
+		}
+	}
+	for _, address := range []string{"", "8080", "127.0.0.1:nonexistent-service"} {
+		if err := checkListenAddress("x", address); err == nil {
+			t.Fatal("expected an error for", address)
+		}
+	}
+}
+
+func TestCheckBaseURL(t *testing.T) {
+	if err := checkBaseURL("https://node.example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkBaseURL("ftp://node.example.com"); !errors.Is(err, errInvalidBaseURL) {
+		t.Fatal("not the error we expected", err)
+	}
+	if err := checkBaseURL("https://"); !errors.Is(err, errInvalidBaseURL) {
+		t.Fatal("not the error we expected", err)
+	}
+}
+
+func TestCheckLimits(t *testing.T) {
+	if checkPositive("x", 0) == nil || checkPositive("x", time.Second) != nil {
+		t.Fatal("unexpected checkPositive behavior")
+	}
+	if checkNonNegative("x", int64(-1)) == nil || checkNonNegative("x", 0) != nil {
+		t.Fatal("unexpected checkNonNegative behavior")
+	}
+}
+
+func TestEffectiveConfig(t *testing.T) {
+	set := flag.NewFlagSet("", flag.ContinueOnError)
+	set.String("datadir", ".", "")
+	set.Int("listeners", 1, "")
+	if err := set.Parse([]string{"-listeners", "4"}); err != nil {
+		t.Fatal(err)
+	}
+	config := effectiveConfig(set)
+	if len(config) != 2 || config["datadir"] != "." || config["listeners"] != "4" {
+		t.Fatal("unexpected config", config)
+	}
+}
+
+func TestLoadExportToken(t *testing.T) {
+	defer func(value string) { *flagExportTokenFile = value }(*flagExportTokenFile)
+	dir := t.TempDir()
+	*flagExportTokenFile = filepath.Join(dir, "token")
+	if err := os.WriteFile(*flagExportTokenFile, []byte("s3cr3t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if token, err := loadExportToken(); err != nil || token != "s3cr3t" {
+		t.Fatal("unexpected result", token, err)
+	}
+	if err := os.WriteFile(*flagExportTokenFile, []byte(" \n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadExportToken(); err != errEmptyExportToken {
+		t.Fatal("not the error we expected", err)
+	}
+	*flagExportTokenFile = filepath.Join(dir, "nonexistent")
+	if _, err := loadExportToken(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestCheckConfigDoesNotWriteDatadir(t *testing.T) {
+	defer func(value string) { *flagDatadir = value }(*flagDatadir)
+	*flagDatadir = filepath.Join(t.TempDir(), "datadir")
+	for _, err := range checkConfig().Errors {
+		if strings.HasPrefix(err, "datadir") {
+			t.Fatal("unexpected error", err)
+		}
+	}
+	if _, err := os.Stat(*flagDatadir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("expected not to create the datadir", err)
+	}
+}
+
+func TestCheckConfigSessionStore(t *testing.T) {
+	defer func(value string) { *flagSessionStore = value }(*flagSessionStore)
+	*flagSessionStore = "redis://:s3cr3t@127.0.0.1:6379/0"
+	report := checkConfig()
+	if strings.Contains(report.Config["session-store"], "s3cr3t") {
+		t.Fatal("the password was not redacted", report.Config["session-store"])
+	}
+	for _, err := range report.Errors {
+		if strings.HasPrefix(err, "session-store") {
+			t.Fatal("unexpected error", err)
+		}
+	}
+	*flagSessionStore = "http://127.0.0.1:6379"
+	var found bool
+	for _, err := range checkConfig().Errors {
+		found = found || strings.HasPrefix(err, "session-store")
+	}
+	if !found {
+		t.Fatal("expected a session-store error")
+	}
+}
@@ -2,12 +2,52 @@
 //
 // Usage:
 //
-//	dash-server [-datadir <dirpath>]
+//	dash-server [-admin-listen-address <endpoint>]
+//	            [-ban-duration <string>]
+//	            [-ban-threshold <count>]
+//	            [-base-url <URL>]
+//	            [-cache-busting]
+//	            [-capture-command <string>]
+//	            [-check-config]
+//	            [-content-type <string>]
+//	            [-datadir <dirpath>]
+//	            [-drain-timeout <string>]
+//	            [-export-token-file <filepath>]
+//	            [-fault-delay <string>]
+//	            [-fault-error-rate <probability>]
+//	            [-fault-jitter <string>]
+//	            [-fault-truncate-rate <probability>]
+//	            [-fragmented-mp4]
 //	            [-http-listen-address <endpoint>]
 //	            [-https-listen-address <endpoint>]
+//	            [-idle-timeout <string>]
+//	            [-index-max-bytes <count>]
+//	            [-ip2asn-database <filepath>]
+//	            [-legacy-schema]
+//	            [-listeners <count>]
+//	            [-live-segment-duration <string>]
+//	            [-masque-research]
+//	            [-max-conn-lifetime <string>]
+//	            [-max-session-bytes <count>]
+//	            [-min-client-version <version>]
+//	            [-min-download-rate <kbit/s>]
+//	            [-mirror-datadir <dirpath>]
 //	            [-prometheusx.listen-address <endpoint>]
+//	            [-read-header-timeout <string>]
+//	            [-realistic-headers]
+//	            [-save-queue-size <count>]
+//	            [-save-workers <count>]
+//	            [-send-buffer-size <bytes>]
+//	            [-server-timing]
+//	            [-session-binding <policy>]
+//	            [-session-store <URL>]
+//	            [-signing-key <filepath>]
+//	            [-storage-layout <template>]
+//	            [-sync-directory]
+//	            [-tcp-notsent-lowat <bytes>]
 //	            [-tls-cert <filepath>]
 //	            [-tls-key <filepath>]
+//	            [-trusted-proxy <network>]
 //
 // The server will listen for incoming DASH experiment requests and
 // will keep serving them until it is interrupted.
@@ -16,22 +56,258 @@
 // for HTTPS connections at `:8443`. It assumes the TLS certificate
 // is at `./cert.pem` and the TLS key is at `./key.pem`.
 //
+// The `-admin-listen-address <endpoint>` flag allows to set the TCP
+// endpoint where the server exposes administrative pages, such as the
+// /admin/dashboard page showing recent sessions and the /admin/failures
+// page showing recent failed requests along with the reason why they failed.
+// By default, the admin endpoint is disabled. You SHOULD NOT expose it publicly.
+// The admin endpoint uses a single listener and ignores the flags tuning the
+// connections that stream segments (e.g., `-listeners` and `-max-conn-lifetime`).
+//
+// The `-ban-duration <string>` flag specifies for how long we ban clients
+// reaching the `-ban-threshold`. The default is ten minutes.
+//
+// The `-ban-threshold <count>` flag enables abuse detection, where we ban
+// for `-ban-duration` the client addresses that cause `<count>` failures
+// (e.g., using invalid sessions, malformed sizes or malformed bodies)
+// within a minute. The default is zero, which means that abuse detection
+// is disabled.
+//
+// The `-base-url <URL>` flag specifies the base URL (e.g.,
+// "https://node.example.com") that clients should use for downloading
+// segments and collecting results. The default is to use this server.
+//
+// The `-cache-busting` flag adds headers preventing caching to download
+// responses and echoes the client's cache busting token, if any, so that
+// clients can detect segments served by transparent caches.
+//
+// The `-capture-command <string>` flag specifies a command (e.g.,
+// "tcpdump -i any -w /var/tmp/{id}.pcap port {remote_port}") to run for
+// each connection used by a session, which is killed when the session is
+// over. We split the command at whitespace and replace {id}, {local_ip},
+// {local_port}, {remote_ip}, and {remote_port} in each argument. The
+// connection IDs are saved in the results, so that one can correlate them
+// with the captures. By default, we do not capture.
+//
+// The `-check-config` flag causes the server to validate the configuration
+// (i.e., the listen addresses, the limits, the datadir, and the TLS files),
+// print a JSON containing the effective configuration and the problems it
+// found, and exit, with nonzero status in case of problems. This mode does
+// not write anything (e.g., it checks whether the server could write into
+// the datadir without writing) and is useful to validate the configuration
+// when deploying the server.
+//
+// The `-content-type <string>` flag specifies the Content-Type of download
+// responses (e.g., "video/iso.segment"). The default is "video/mp4".
+//
 // The `-datadir <dirpath>` flag specifies the directory where to write
 // measurement results. By default is the current working directory.
 //
+// The `-drain-timeout <string>` flag specifies the maximum time for which
+// we wait for existing sessions to terminate in drain mode. The default is
+// two minutes. See below for more information on the drain mode.
+//
+// The `-export-token-file <filepath>` flag specifies the file containing the
+// bearer token required by the /admin/export page of the admin endpoint,
+// which streams the results saved during the last hours, so that one can
+// pull them centrally without mounting the datadir. The export uses the
+// index, if enabled (see `-index-max-bytes`), and otherwise walks the results
+// directory of the datadir, which is slower. By default, the export is
+// disabled.
+//
+// The `-fault-delay <string>` flag specifies a delay (e.g., "200ms") that
+// the server adds before serving each negotiate, download, and collect
+// request, for testing how clients cope with adverse server behavior. The
+// `-fault-jitter <string>` flag specifies the maximum random delay that
+// the server adds to `-fault-delay`. The default for both is zero.
+//
+// The `-fault-error-rate <probability>` flag specifies the probability
+// (e.g., 0.1) of failing each negotiate, download, and collect request with
+// 429, 500, 502, or 503. The default is zero. These faults, as well as the
+// ones configured by the other `-fault-*` flags, can also be changed at
+// runtime using the /admin/faults page of the admin endpoint.
+//
+// The `-fault-truncate-rate <probability>` flag specifies the probability
+// of closing the connection after sending half of each download response
+// body. The default is zero.
+//
+// The `-fragmented-mp4` flag causes the server to send segments containing
+// syntactically valid CMAF media fragments (i.e., moof and mdat boxes with
+// a random sample), so that DPI-based traffic classifiers see real video
+// containers rather than opaque random bytes. In this mode, clients cannot
+// verify the payload of segments.
+//
 // The `-http-listen-address <endpoint>` flag allows to set the TCP endpoint
 // where the server should listen for HTTP clients.
 //
 // The `-https-listen-address <endpoint>` flag allows to set the TCP endpoint
 // where the server should listen for HTTPS clients.
 //
+// The `-idle-timeout <string>` flag specifies the time after which we
+// close idle keep-alive connections. The default is two minutes.
+//
+// The `-index-max-bytes <count>` flag specifies the size after which we
+// rotate the `dash/index.jsonl` file of the datadir, where we append a line
+// for each saved results file containing the session UUID, the time, the
+// client ASN, the median rate, and the path of the results file, so one
+// can list recent results without reading the results files. We keep three
+// rotated indexes. The default is zero, which disables the index, because
+// the pusher would upload the index along with the results (e.g., use 16 MiB
+// when the datadir is not uploaded).
+//
+// The `-ip2asn-database <filepath>` flag specifies the IP to ASN database
+// published by https://iptoasn.com/ (e.g., `ip2asn-combined.tsv.gz`), which
+// we use to annotate sessions with the client ASN and country (e.g., in the
+// dashboard, in the aggregates, and in the index). By default, the ASN and
+// the country of clients are unknown.
+//
+// The `-legacy-schema` flag causes the server to also save the results
+// using the schema of the original Neubot server (i.e., version 3) inside
+// the `dash-v3` directory of the datadir, so that existing pipelines can
+// parse them. These results do not include the extensions to the original
+// format. By default, we only save the results using the current schema.
+//
+// The `-listeners <count>` flag specifies how many listeners to open for
+// each endpoint. When larger than one, the server uses SO_REUSEPORT to open
+// several listeners bound to the same endpoint and runs independent accept
+// loops, which improves scalability on busy multi-core servers. The default
+// is to open a single listener for each endpoint.
+//
+// The `-live-segment-duration <string>` flag enables the live pacing mode,
+// where the server emulates a live stream origin producing a new segment
+// every `<string>` (e.g., "2s") and answers with 425 to requests for
+// segments that have not been produced yet. The default is zero, which
+// means that the live pacing mode is disabled.
+//
+// The `-masque-research` flag enables the research mode where clients reach
+// the server through a MASQUE (i.e., CONNECT-UDP) front, which MUST be in a
+// `-trusted-proxy` network, so that the server knows the client address. In
+// this mode, the server saves with the results the transport protocol that
+// the front reports using the X-Forwarded-Transport header.
+//
+// The `-max-conn-lifetime <string>` flag specifies the maximum lifetime
+// (e.g., "10m") of each connection, after which the server closes it as
+// soon as it is idle, or after a one minute grace period otherwise, which
+// prevents zombie clients from holding sockets and skewing per-connection
+// statistics. The default is zero, which means that there is no limit.
+//
+// The `-max-session-bytes <count>` flag sets the maximum number of bytes
+// that the server is willing to send as part of a single session. Once a
+// session exceeds this budget, the server stops serving it. The default is
+// zero, which means that there is no limit.
+//
+// The `-min-client-version <version>` flag specifies the minimum version
+// (e.g., "0.4.3") of the client library, which clients include in their
+// User-Agent as "neubot-dash/<version>". The server rejects negotiations
+// from older clients, and from clients not sending a version, using 426
+// (Upgrade Required), which allows to retire clients with known measurement
+// bugs. By default, the server accepts any client.
+//
+// The `-min-download-rate <kbit/s>` flag sets the minimum plausible rate
+// at which clients receive segments. The server sets a write deadline on
+// each download proportional to the requested size at this rate, plus a
+// grace period, so stalled receivers cannot hold resources indefinitely.
+// The default is 100 kbit/s. Zero means that there is no deadline.
+//
+// The `-mirror-datadir <dirpath>` flag specifies a second directory where
+// to save each results file, which protects against losing results on nodes
+// with flaky disks (e.g., by pointing to a network filesystem). Failing to
+// write into the mirror is not fatal. By default there is no mirror.
+//
 // The `-prometheusx.listen-address <endpoint>` flag controls the TCP
 // endpoint where the server will expose Prometheus metrics.
 //
+// The `-read-header-timeout <string>` flag specifies the maximum time
+// for reading the request headers. The default is ten seconds.
+//
+// The `-realistic-headers` flag causes the server to send, along with each
+// segment, the headers that origins send along with CMAF segments (e.g.,
+// Accept-Ranges, Cache-Control, ETag, and Last-Modified), so middleboxes
+// treat the traffic like genuine video segments. The server still ignores
+// Range requests and always sends whole segments.
+//
+// The `-save-queue-size <count>` flag specifies the number of collected
+// sessions waiting for the save workers (see `-save-workers`) after which
+// collect waits for room in the queue. The default is 64.
+//
+// The `-save-workers <count>` flag specifies the number of goroutines saving
+// the results in the background, so collect responds as soon as it queues
+// the results and slow disks do not inflate the collect latency. In this
+// mode, collect does not fail when we cannot save the results, and we save
+// the queued results before exiting. The default is zero, meaning that we
+// save the results before responding to collect.
+//
+// The `-send-buffer-size <bytes>` flag sets the SO_SNDBUF socket option
+// of accepted connections. The default is to use the kernel default.
+//
+// The `-server-timing` flag enables the experimental mode where we send,
+// as HTTP trailers of each download response, the time between receiving
+// the request and starting to send the segment and the time for sending
+// it, which clients record, so that one can decompose the delay observed
+// by clients. In this mode download responses use the chunked encoding.
+//
+// The `-session-binding <policy>` flag restricts who can use a session
+// token after the negotiation, which mitigates sharing or replaying tokens
+// across hosts. With "address", only the IP address that negotiated can
+// download and collect. With "connection", only the connection used for
+// negotiating can, which requires clients to keep it alive. The default
+// is "none", meaning that anyone knowing the token can use the session.
+//
+// The `-session-store <URL>` flag specifies the Redis server (e.g.,
+// "redis://:password@10.0.0.1:6379/0") where we share sessions with other
+// dash-server processes, possibly behind a load balancer, so that requests
+// landing on a process other than the one that negotiated still succeed.
+// The load balancer should route the requests of the same session to the
+// same process whenever possible, because a process that already knows a
+// session does not reload it. By default, sessions are not shared.
+//
+// The `-signing-key <filepath>` flag specifies the PEM file containing the
+// PKCS #8 Ed25519 private key (e.g., generated using `openssl genpkey
+// -algorithm ed25519`) for signing results. When set, we write a detached
+// signature of each results file alongside it, in a file with the same
+// name plus the ".sig" suffix. By default, we do not sign results.
+//
+// The `-storage-layout <template>` flag specifies the directory, relative to
+// the `dash` directory of the datadir, where to save results. The `{date}`
+// placeholder expands to the date (e.g., "2024/01/29"), `{asn}` to the client
+// ASN (e.g., "AS137"), and `{country}` to the client country (e.g., "IT"),
+// where we use "unknown" when we cannot tell. For example, "{asn}/{date}"
+// partitions results by ISP. The `{asn}` and `{country}` placeholders
+// require the `-ip2asn-database` flag. The default is "{date}".
+//
+// The `-sync-directory` flag causes the server to fsync the directory
+// after moving each results file into place, which makes the results
+// durable at the cost of additional I/O. In any case, we write results
+// into temporary files, fsync them, and link them into place, so a
+// crash never leaves truncated results files behind.
+//
+// The `-tcp-notsent-lowat <bytes>` flag sets the TCP_NOTSENT_LOWAT socket
+// option of accepted connections, which is only available on Linux and
+// macOS. The default is to use the kernel default.
+//
 // The `-tls-cert <filepath>` flag allows to set the TLS certificate path.
 //
 // The `-tls-key <filepath>` flag allows to set the TLS key path.
 //
+// The `-trusted-proxy <network>` flag adds a network (e.g., "10.0.0.0/8")
+// or an address to the list of proxies we trust to set X-Forwarded-Proto,
+// which we use to record whether the client used TLS when we are behind a
+// TLS terminating proxy, and X-Forwarded-For, which we use to determine the
+// client address for session binding, abuse detection, and the RealAddress
+// returned to the client. You can use this flag many times.
+//
+// At startup, the server checks that the datadir is writable, that the TLS
+// certificate is valid, and that the clock is sane. The server refuses to
+// start when a check fails, and warns when the certificate expires within
+// two weeks. The /admin/health page of the admin endpoint returns the results
+// of these checks and the status code is 503 on failure or when draining.
+//
+// Sending SIGUSR1 to the server, or POSTing to the /admin/drain page of
+// the admin endpoint, puts the server into drain mode for maintenance. In
+// this mode, the existing sessions continue, while new negotiations fail
+// with 503 and a Retry-After header. The server exits cleanly once there
+// are no sessions left or after the drain timeout.
+//
 // The server will emit access logs on the standard output using the
 // usual format. The server will emit error logging on the standard
 // error using github.com/apex/log's JSON format.
@@ -41,52 +317,359 @@ import (
 	"context"
 	"flag"
 	"net/http"
+	"net/netip"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/apex/log"
 	"github.com/apex/log/handlers/json"
 	"github.com/gorilla/handlers"
+	"github.com/m-lab/go/flagx"
 	"github.com/m-lab/go/prometheusx"
 	"github.com/m-lab/go/rtx"
 	"github.com/neubot/dash/server"
 )
 
 var (
+	flagAdminListenAddress = flag.String(
+		"admin-listen-address", "", "optional admin listening endpoint",
+	)
+	flagBanDuration = flag.Duration(
+		"ban-duration", 10*time.Minute, "duration of the temporary bans",
+	)
+	flagBanThreshold = flag.Int(
+		"ban-threshold", 0, "failures within a minute causing a temporary ban (0 means disabled)",
+	)
+	flagBaseURL = flag.String(
+		"base-url", "", "optional base URL for downloading and collecting",
+	)
+	flagCacheBusting = flag.Bool(
+		"cache-busting", false, "prevent caching of segments and echo cache busting tokens",
+	)
+	flagCaptureCommand = flag.String(
+		"capture-command", "", "optional command to capture each session connection",
+	)
+	flagCheckConfig = flag.Bool(
+		"check-config", false, "validate the configuration, print it as JSON, and exit",
+	)
+	flagContentType = flag.String(
+		"content-type", server.DefaultContentType, "Content-Type of the segments",
+	)
 	flagDatadir = flag.String(
 		"datadir", ".", "directory where to save results",
 	)
+	flagDrainTimeout = flag.Duration(
+		"drain-timeout", 120*time.Second, "maximum time to wait for sessions in drain mode",
+	)
+	flagExportTokenFile = flag.String(
+		"export-token-file", "", "optional file with the bearer token for /admin/export",
+	)
+	flagFaultDelay = flag.Duration(
+		"fault-delay", 0, "delay to inject before serving each request",
+	)
+	flagFaultErrorRate = flag.Float64(
+		"fault-error-rate", 0, "probability of failing each request with 429 or 5xx",
+	)
+	flagFaultJitter = flag.Duration(
+		"fault-jitter", 0, "maximum random delay to add to -fault-delay",
+	)
+	flagFaultTruncateRate = flag.Float64(
+		"fault-truncate-rate", 0, "probability of truncating each download body",
+	)
+	flagFragmentedMP4 = flag.Bool(
+		"fragmented-mp4", false, "send valid fragmented MP4 segments",
+	)
 	flagHTTPListenAddress = flag.String(
 		"http-listen-address", ":8080", "HTTP listening endpoint",
 	)
 	flagHTTPSListenAddress = flag.String(
 		"https-listen-address", ":8443", "HTTPS listening endpoint",
 	)
+	flagIdleTimeout = flag.Duration(
+		"idle-timeout", 120*time.Second, "time after which idle connections are closed",
+	)
+	flagIndexMaxBytes = flag.Int64(
+		"index-max-bytes", server.DefaultIndexMaxBytes, "size after which we rotate the index (0 means no index)",
+	)
+	flagIP2ASNDatabase = flag.String(
+		"ip2asn-database", "", "optional iptoasn.com database for annotating clients",
+	)
+	flagLegacySchema = flag.Bool(
+		"legacy-schema", false, "also save results using the legacy Neubot v3 schema",
+	)
+	flagListeners = flag.Int(
+		"listeners", 1, "number of SO_REUSEPORT listeners for each endpoint",
+	)
+	flagLiveSegmentDuration = flag.Duration(
+		"live-segment-duration", 0, "emulate a live origin producing a segment every duration (0 means disabled)",
+	)
+	flagMASQUEResearch = flag.Bool(
+		"masque-research", false, "accept measurements proxied by a trusted MASQUE front",
+	)
+	flagMaxConnLifetime = flag.Duration(
+		"max-conn-lifetime", 0, "maximum lifetime of each connection (0 means no limit)",
+	)
+	flagMaxSessionBytes = flag.Int64(
+		"max-session-bytes", 0, "maximum bytes sent per session (0 means no limit)",
+	)
+	flagMinClientVersion = flag.String(
+		"min-client-version", "", "optional minimum client library version",
+	)
+	flagMinDownloadRate = flag.Int64(
+		"min-download-rate", server.DefaultMinDownloadRate, "minimum plausible download rate in kbit/s (0 means no deadline)",
+	)
+	flagMirrorDatadir = flag.String(
+		"mirror-datadir", "", "optional second directory where to save results",
+	)
+	flagReadHeaderTimeout = flag.Duration(
+		"read-header-timeout", 10*time.Second, "maximum time for reading request headers",
+	)
+	flagRealisticHeaders = flag.Bool(
+		"realistic-headers", false, "emit realistic CMAF segment headers",
+	)
+	flagSaveQueueSize = flag.Int(
+		"save-queue-size", server.DefaultSaveQueueSize, "maximum number of sessions waiting to be saved",
+	)
+	flagSaveWorkers = flag.Int(
+		"save-workers", 0, "number of goroutines saving results in the background",
+	)
+	flagSendBufferSize = flag.Int(
+		"send-buffer-size", 0, "SO_SNDBUF for accepted connections (0 means kernel default)",
+	)
+	flagServerTiming = flag.Bool(
+		"server-timing", false, "send server timing trailers with download responses",
+	)
+	flagSessionStore = flag.String(
+		"session-store", "", "optional redis:// URL of the store of sessions shared with other servers",
+	)
+	flagSigningKey = flag.String(
+		"signing-key", "", "optional PEM file with the Ed25519 key for signing results",
+	)
+	flagStorageLayout = flag.String(
+		"storage-layout", server.DefaultStorageLayout, "template of the directory where to save results",
+	)
+	flagSyncDirectory = flag.Bool(
+		"sync-directory", false, "fsync the directory after saving each results file",
+	)
+	flagTCPNotSentLowat = flag.Int(
+		"tcp-notsent-lowat", 0, "TCP_NOTSENT_LOWAT for accepted connections (0 means kernel default)",
+	)
 	flagTLSCert = flag.String(
 		"tls-cert", "cert.pem", "path to the TLS certificate file to use",
 	)
 	flagTLSKey = flag.String(
 		"tls-key", "key.pem", "path to the TLS key to use",
 	)
+	flagSessionBinding = flagx.Enum{
+		Options: []string{"none", "address", "connection"},
+		Value:   "none",
+	}
+	flagTrustedProxies flagx.StringArray
 )
 
+func init() {
+	flag.Var(
+		&flagSessionBinding,
+		"session-binding",
+		"who can use a session token: none, address, or connection",
+	)
+	flag.Var(
+		&flagTrustedProxies,
+		"trusted-proxy",
+		"network or address of a proxy trusted to set X-Forwarded-For and X-Forwarded-Proto (may be repeated)",
+	)
+}
+
+// parseTrustedProxies parses the -trusted-proxy flags.
+func parseTrustedProxies() (out []netip.Prefix, err error) {
+	for _, value := range flagTrustedProxies {
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, err
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		out = append(out, prefix)
+	}
+	return
+}
+
+// loadExportToken loads the bearer token from the -export-token-file,
+// ignoring the leading and trailing whitespace (e.g., a final newline).
+func loadExportToken() (string, error) {
+	data, err := os.ReadFile(*flagExportTokenFile)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", errEmptyExportToken
+	}
+	return token, nil
+}
+
+// faultsFromFlags returns the faults configured using the -fault-* flags.
+func faultsFromFlags() server.Faults {
+	return server.Faults{
+		Delay:        *flagFaultDelay,
+		ErrorRate:    *flagFaultErrorRate,
+		Jitter:       *flagFaultJitter,
+		TruncateRate: *flagFaultTruncateRate,
+	}
+}
+
+// setupLookups configures the lookups of the handler using the
+// -ip2asn-database, if set, and otherwise leaves them unset.
+func setupLookups(handler *server.Handler) error {
+	if *flagIP2ASNDatabase == "" {
+		return nil
+	}
+	db, err := server.LoadIP2ASNDatabase(*flagIP2ASNDatabase)
+	if err != nil {
+		return err
+	}
+	handler.ASNLookup = db.LookupASN
+	handler.CountryLookup = db.LookupCountry
+	return nil
+}
+
+// mustParseTrustedProxies is like parseTrustedProxies but exits on error.
+func mustParseTrustedProxies() []netip.Prefix {
+	prefixes, err := parseTrustedProxies()
+	rtx.Must(err, "Invalid trusted proxy")
+	return prefixes
+}
+
 func main() {
 	log.Log = &log.Logger{
 		Handler: json.New(os.Stderr),
 		Level:   log.DebugLevel,
 	}
 	flag.Parse()
+	if *flagCheckConfig {
+		os.Exit(runCheckConfig(os.Stdout))
+	}
 	promServer := prometheusx.MustServeMetrics()
 	defer promServer.Close()
 	mux := http.NewServeMux()
 	handler := server.NewHandler(*flagDatadir, log.Log)
-	handler.StartReaper(context.Background())
+	handler.BanDuration = *flagBanDuration
+	handler.BanThreshold = *flagBanThreshold
+	handler.BaseURL = *flagBaseURL
+	handler.CacheBusting = *flagCacheBusting
+	if argv := strings.Fields(*flagCaptureCommand); len(argv) > 0 {
+		handler.CaptureHook = &server.CommandCaptureHook{Argv: argv}
+	}
+	rtx.Must(server.ValidateContentType(*flagContentType), "Invalid content type")
+	handler.ContentType = *flagContentType
+	if *flagExportTokenFile != "" {
+		token, err := loadExportToken()
+		rtx.Must(err, "Can't load export token")
+		handler.ExportToken = token
+	}
+	rtx.Must(handler.SetFaults(faultsFromFlags()), "Invalid faults")
+	handler.FragmentedMP4 = *flagFragmentedMP4
+	handler.IndexMaxBytes = *flagIndexMaxBytes
+	rtx.Must(setupLookups(handler), "Can't load the IP to ASN database")
+	handler.LegacySchema = *flagLegacySchema
+	handler.LiveSegmentDuration = *flagLiveSegmentDuration
+	handler.MASQUEResearch = *flagMASQUEResearch
+	handler.MaxSessionBytes = *flagMaxSessionBytes
+	if *flagMinClientVersion != "" {
+		rtx.Must(server.ValidateClientVersion(*flagMinClientVersion), "Invalid minimum client version")
+	}
+	handler.MinClientVersion = *flagMinClientVersion
+	handler.MinDownloadRate = *flagMinDownloadRate
+	handler.MirrorDatadir = *flagMirrorDatadir
+	handler.RealisticHeaders = *flagRealisticHeaders
+	handler.SaveQueueSize = *flagSaveQueueSize
+	handler.SaveWorkers = *flagSaveWorkers
+	handler.ServerTiming = *flagServerTiming
+	handler.SessionBinding = server.SessionBinding(flagSessionBinding.Value)
+	if *flagSessionStore != "" {
+		store, err := server.NewRedisSessionStore(*flagSessionStore)
+		rtx.Must(err, "Invalid session store")
+		handler.SessionStore = store
+	}
+	if *flagSigningKey != "" {
+		key, err := server.LoadSigningKey(*flagSigningKey)
+		rtx.Must(err, "Can't load signing key")
+		handler.SigningKey = key
+	}
+	rtx.Must(server.ValidateStorageLayout(*flagStorageLayout), "Invalid storage layout")
+	handler.StorageLayout = *flagStorageLayout
+	handler.SyncDirectory = *flagSyncDirectory
+	handler.TrustedProxies = mustParseTrustedProxies()
+	rtx.Must(handler.SelfCheck(&server.SelfCheckConfig{
+		TLSCert: *flagTLSCert,
+		TLSKey:  *flagTLSKey,
+	}), "Startup self-check failed")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler.StartReaper(ctx)
+	handler.StartSaveWorkers(ctx)
+	drainOnSignal(handler)
+	go waitDrained(handler, cancel)
 	handler.RegisterHandlers(mux)
 	rootHandler := handlers.LoggingHandler(os.Stdout, mux)
+	serveConfig := &server.ServeConfig{
+		BaseContext:       ctx,
+		IdleTimeout:       *flagIdleTimeout,
+		Listeners:         *flagListeners,
+		Logger:            log.Log,
+		ReadHeaderTimeout: *flagReadHeaderTimeout,
+		SocketOptions: server.SocketOptions{
+			MaxLifetime:    *flagMaxConnLifetime,
+			NotSentLowat:   *flagTCPNotSentLowat,
+			SendBufferSize: *flagSendBufferSize,
+		},
+	}
+	if *flagAdminListenAddress != "" {
+		adminMux := http.NewServeMux()
+		handler.RegisterAdminHandlers(adminMux)
+		// the admin endpoints do not stream segments, hence we do not use
+		// several listeners or tune the sockets, and we do not bound the
+		// lifetime of connections, which would cut off long exports
+		adminConfig := &server.ServeConfig{
+			BaseContext:       ctx,
+			IdleTimeout:       *flagIdleTimeout,
+			Logger:            log.Log,
+			ReadHeaderTimeout: *flagReadHeaderTimeout,
+		}
+		go func() {
+			err := server.ListenAndServe(*flagAdminListenAddress, adminMux, adminConfig)
+			if ctx.Err() == nil {
+				rtx.Must(err, "Can't start admin server")
+			}
+		}()
+	}
 	go func() {
-		rtx.Must(http.ListenAndServeTLS(
-			*flagHTTPSListenAddress, *flagTLSCert, *flagTLSKey, rootHandler,
-		), "Can't start HTTPS server")
+		err := server.ListenAndServeTLS(
+			*flagHTTPSListenAddress, *flagTLSCert, *flagTLSKey, rootHandler, serveConfig,
+		)
+		if ctx.Err() == nil {
+			rtx.Must(err, "Can't start HTTPS server")
+		}
 	}()
-	rtx.Must(http.ListenAndServe(
-		*flagHTTPListenAddress, rootHandler), "Can't start HTTP server")
+	err := server.ListenAndServe(*flagHTTPListenAddress, rootHandler, serveConfig)
+	if ctx.Err() == nil {
+		rtx.Must(err, "Can't start HTTP server")
+	}
+	handler.JoinReaper()
+	handler.JoinSaveWorkers()
+}
+
+// waitDrained waits for the handler to enter into drain mode and then
+// calls cancel once there are no sessions left or the timeout expires.
+func waitDrained(handler *server.Handler, cancel context.CancelFunc) {
+	<-handler.DrainStarted()
+	ctx, cancelTimeout := context.WithTimeout(context.Background(), *flagDrainTimeout)
+	defer cancelTimeout()
+	if err := handler.WaitDrained(ctx); err != nil {
+		log.Warnf("drain: %s", err.Error())
+	}
+	cancel()
 }
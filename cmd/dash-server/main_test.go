@@ -0,0 +1,47 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/server"
+)
+
+func TestSetupLookups(t *testing.T) {
+	defer func(value string) { *flagIP2ASNDatabase = value }(*flagIP2ASNDatabase)
+
+	t.Run("without a database", func(t *testing.T) {
+		*flagIP2ASNDatabase = ""
+		handler := server.NewHandler("", log.Log)
+		if err := setupLookups(handler); err != nil || handler.ASNLookup != nil || handler.CountryLookup != nil {
+			t.Fatal("expected no lookups", err)
+		}
+	})
+
+	t.Run("with a database", func(t *testing.T) {
+		*flagIP2ASNDatabase = filepath.Join(t.TempDir(), "ip2asn-combined.tsv")
+		data := []byte("130.192.0.0\t130.192.255.255\t137\tIT\tASGARR\n")
+		if err := os.WriteFile(*flagIP2ASNDatabase, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		handler := server.NewHandler("", log.Log)
+		if err := setupLookups(handler); err != nil {
+			t.Fatal(err)
+		}
+		if asn, err := handler.ASNLookup("130.192.91.211"); err != nil || asn != 137 {
+			t.Fatal("unexpected ASN", asn, err)
+		}
+		if country, err := handler.CountryLookup("130.192.91.211"); err != nil || country != "IT" {
+			t.Fatal("unexpected country", country, err)
+		}
+	})
+
+	t.Run("with a nonexistent database", func(t *testing.T) {
+		*flagIP2ASNDatabase = filepath.Join(t.TempDir(), "nonexistent")
+		if err := setupLookups(server.NewHandler("", log.Log)); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
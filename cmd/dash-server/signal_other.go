@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "github.com/neubot/dash/server"
+
+// drainOnSignal is a no-op on systems without SIGUSR1.
+func drainOnSignal(handler *server.Handler) {}
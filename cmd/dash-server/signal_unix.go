@@ -0,0 +1,21 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/neubot/dash/server"
+)
+
+// drainOnSignal puts the handler into drain mode on SIGUSR1.
+func drainOnSignal(handler *server.Handler) {
+	sigch := make(chan os.Signal, 1)
+	signal.Notify(sigch, syscall.SIGUSR1)
+	go func() {
+		<-sigch
+		handler.Drain()
+	}()
+}
@@ -5,55 +5,414 @@ package model
 // structure is sent to the server in the collection phase.
 //
 // All the fields listed here are part of the original specification
-// of DASH, except ServerURL, added in MK v0.10.6.
+// of DASH, except the following extensions:
+//
+//   - ServerURL, added in MK v0.10.6;
+//
+//   - BufferLevel, Stalls, and StallTime, containing the quality of
+//     experience metrics of the emulated player, i.e., the seconds of
+//     video in the playback buffer after downloading the segment and
+//     the number and total duration in seconds of the playback stalls
+//     during the iteration (omitted when zero);
+//
+//   - Clamped and SizeDelta, indicating whether the number of body bytes
+//     received differs from the number of bytes requested, e.g., because
+//     the server clamped the segment size, and the difference between the
+//     received and the requested bytes (omitted when false and zero);
+//
+//   - ContentEncoding and Via, containing the corresponding response
+//     headers, whose presence is evidence of intermediaries, given that
+//     the server never sets them (omitted when empty);
+//
+//   - DNS, containing the details of the DNS lookup performed by the
+//     client before this iteration (omitted when there was no lookup);
+//
+//   - DSCP, containing the DSCP marking used by the client (omitted
+//     when zero);
+//
+//   - Direction, containing "upload" when the client measured the upload
+//     direction by sending the segment to the server, in which case the
+//     Received field contains the body bytes that the client sent (omitted
+//     when the client measured the download direction);
+//
+//   - Failure, containing the error that occurred when the client is
+//     running in resilient mode and failed to download the segment
+//     (omitted on success);
+//
+//   - HappyEyeballs, containing the outcome of the race between the IPv4
+//     and the IPv6 connection attempts when the client performed a lookup
+//     returning both families before this iteration (omitted otherwise);
+//
+//   - Network, containing the metadata of the network interface used
+//     by the client (omitted when the client cannot determine it);
+//
+//   - PayloadCheck, containing the result of verifying the payload when
+//     the server derives it from a seed, i.e., "ok" or "mismatch" (omitted
+//     when the client did not verify the payload);
+//
+//   - ServerQueueDelay and ServerSendDuration, containing the seconds
+//     between the server receiving the request and starting to send the
+//     segment and the seconds the server took to send it, as reported by
+//     servers configured for server timing (omitted when not reported);
+//
+//   - Streams, containing the number of parallel streams approved by the
+//     server during the negotiation (omitted when zero);
+//
+//   - SuspectCached, true when the client detected evidence that an
+//     intermediary cache served the segment (omitted when false);
+//
+//   - TCPInfo, containing the changes of the TCP_INFO statistics of the
+//     connection while downloading the segment, including whether loss
+//     recovery dominated the download, when the client samples them
+//     (omitted otherwise);
+//
+//   - UserAgentProfile, containing the name of the video player whose
+//     User-Agent header the client used for downloading the segment (e.g.,
+//     "exoplayer"), rather than its own (omitted when empty);
+//
+//   - WireBytes, containing an estimate of the bytes received at the
+//     transport layer, i.e., Received plus the HTTP and TLS overhead.
 type ClientResults struct {
-	ConnectTime     float64 `json:"connect_time"`
-	DeltaSysTime    float64 `json:"delta_sys_time"`
-	DeltaUserTime   float64 `json:"delta_user_time"`
-	Elapsed         float64 `json:"elapsed"`
-	ElapsedTarget   int64   `json:"elapsed_target"`
-	InternalAddress string  `json:"internal_address"`
-	Iteration       int64   `json:"iteration"`
-	Platform        string  `json:"platform"`
-	Rate            int64   `json:"rate"`
-	RealAddress     string  `json:"real_address"`
-	Received        int64   `json:"received"`
-	RemoteAddress   string  `json:"remote_address"`
-	RequestTicks    float64 `json:"request_ticks"`
-	ServerURL       string  `json:"server_url"`
-	Timestamp       int64   `json:"timestamp"`
-	UUID            string  `json:"uuid"`
-	Version         string  `json:"version"`
+	BufferLevel        float64               `json:"buffer_level,omitempty"`
+	Clamped            bool                  `json:"clamped,omitempty"`
+	ConnectTime        float64               `json:"connect_time"`
+	ContentEncoding    string                `json:"content_encoding,omitempty"`
+	DNS                *DNSResults           `json:"dns,omitempty"`
+	DSCP               int                   `json:"dscp,omitempty"`
+	DeltaSysTime       float64               `json:"delta_sys_time"`
+	DeltaUserTime      float64               `json:"delta_user_time"`
+	Direction          string                `json:"direction,omitempty"`
+	Elapsed            float64               `json:"elapsed"`
+	ElapsedTarget      int64                 `json:"elapsed_target"`
+	Failure            string                `json:"failure,omitempty"`
+	HappyEyeballs      *HappyEyeballsResults `json:"happy_eyeballs,omitempty"`
+	InternalAddress    string                `json:"internal_address"`
+	Iteration          int64                 `json:"iteration"`
+	Network            *NetworkMetadata      `json:"network,omitempty"`
+	PayloadCheck       string                `json:"payload_check,omitempty"`
+	Platform           string                `json:"platform"`
+	Rate               int64                 `json:"rate"`
+	RealAddress        string                `json:"real_address"`
+	Received           int64                 `json:"received"`
+	RemoteAddress      string                `json:"remote_address"`
+	RequestTicks       float64               `json:"request_ticks"`
+	ServerQueueDelay   float64               `json:"server_queue_delay,omitempty"`
+	ServerSendDuration float64               `json:"server_send_duration,omitempty"`
+	ServerURL          string                `json:"server_url"`
+	SizeDelta          int64                 `json:"size_delta,omitempty"`
+	StallTime          float64               `json:"stall_time,omitempty"`
+	Stalls             int64                 `json:"stalls,omitempty"`
+	Streams            int64                 `json:"streams,omitempty"`
+	SuspectCached      bool                  `json:"suspect_cached,omitempty"`
+	TCPInfo            *TCPInfoDelta         `json:"tcp_info,omitempty"`
+	Timestamp          int64                 `json:"timestamp"`
+	UUID               string                `json:"uuid"`
+	UserAgentProfile   string                `json:"user_agent_profile,omitempty"`
+	Version            string                `json:"version"`
+	Via                string                `json:"via,omitempty"`
+	WireBytes          int64                 `json:"wire_bytes,omitempty"`
+}
+
+// DNSResults contains the details of a DNS lookup performed by the client.
+type DNSResults struct {
+	// Addresses contains all the addresses returned by the lookup.
+	Addresses []string `json:"addresses"`
+
+	// Failure is the lookup error or empty on success.
+	Failure string `json:"failure,omitempty"`
+
+	// Latency is the lookup latency in seconds.
+	Latency float64 `json:"latency"`
+
+	// Resolver identifies the resolver used for the lookup, i.e., the
+	// comma-separated nameservers configured for the system or "system"
+	// when we cannot tell which nameservers the system uses.
+	Resolver string `json:"resolver"`
+
+	// UsedAddress is the address that we actually connected to.
+	UsedAddress string `json:"used_address,omitempty"`
+}
+
+// HappyEyeballsResults describes the race between the IPv4 and the IPv6
+// connection attempts (see RFC 8305) when the lookup returned both families,
+// as observed by the dialer of the transport. Because the loser attempt is
+// usually canceled, or does not even start, when the winner connects, the
+// loser connect time is only available when the loser attempt completed.
+type HappyEyeballsResults struct {
+	// LoserConnectTime is the time in seconds to connect to the losing
+	// family during the race (omitted when the attempt did not succeed).
+	LoserConnectTime float64 `json:"loser_connect_time,omitempty"`
+
+	// LoserFailure is the error that occurred connecting to the losing
+	// family during the race, which is typically a cancellation error when
+	// the winner connected first (omitted on success).
+	LoserFailure string `json:"loser_failure,omitempty"`
+
+	// LoserRaced is true when the losing family started connecting during
+	// the race, i.e., when the first attempt took long enough for the
+	// dialer to start the fallback attempt (omitted when false).
+	LoserRaced bool `json:"loser_raced,omitempty"`
+
+	// Winner is the family that won the race: "ipv4" or "ipv6".
+	Winner string `json:"winner"`
+
+	// WinnerConnectTime is the time in seconds to connect to the
+	// address that won the race.
+	WinnerConnectTime float64 `json:"winner_connect_time"`
+}
+
+// TCPInfoDelta contains the changes of the TCP_INFO statistics of the
+// connection between before sending the request for a segment and after
+// receiving the whole segment, which help to interpret low measured rates.
+type TCPInfoDelta struct {
+	// BytesRetrans is the number of bytes that the client retransmitted.
+	BytesRetrans int64 `json:"bytes_retrans"`
+
+	// DeliveryRate is the most recent estimate of the delivery rate of
+	// the connection in kbit/s at the end of the download.
+	DeliveryRate float64 `json:"delivery_rate"`
+
+	// LossLimited indicates that a significant fraction of the segments
+	// received arrived out of order or needed retransmissions, meaning
+	// that loss recovery dominated the download.
+	LossLimited bool `json:"loss_limited"`
+
+	// OutOfOrderPackets is the number of packets received out of order,
+	// which, for the receiver, is the main evidence of losses.
+	OutOfOrderPackets int64 `json:"out_of_order_packets"`
+
+	// Retransmits is the number of segments the client retransmitted.
+	Retransmits int64 `json:"retransmits"`
+
+	// SegmentsIn is the number of segments received.
+	SegmentsIn int64 `json:"segments_in"`
+}
+
+// NetworkMetadata describes the network interface used by the client.
+type NetworkMetadata struct {
+	// Interface is the name of the outgoing interface (e.g., "wlan0").
+	Interface string `json:"interface"`
+
+	// LinkType is the link type of the interface: "wifi", "ethernet",
+	// "cellular", "loopback", or "unknown" when the client cannot
+	// determine it on the current platform.
+	LinkType string `json:"link_type"`
+}
+
+// FailureReport describes why the client failed. It is an extension to
+// the original specification of DASH, which clients do not send to the
+// server, that allows to process failures programmatically.
+type FailureReport struct {
+	// CaptivePortal contains the evidence of a captive portal intercepting
+	// the requests when the failure was caused by it and nil otherwise.
+	CaptivePortal *CaptivePortalEvidence `json:"captive_portal,omitempty"`
+
+	// Elapsed is the time in seconds since the client started.
+	Elapsed float64 `json:"elapsed"`
+
+	// Failure is the error that occurred.
+	Failure string `json:"failure"`
+
+	// HTTPStatus is the status code when the failure was caused by a
+	// non-successful HTTP response and zero otherwise.
+	HTTPStatus int `json:"http_status,omitempty"`
+
+	// Iterations is the number of iterations performed before failing.
+	Iterations int64 `json:"iterations"`
+
+	// Phase is the phase that failed: "setup", "locate", "negotiate",
+	// "download", "upload", or "collect".
+	Phase string `json:"phase"`
+
+	// Redirect is the Location of the redirect when the failure was caused
+	// by the server, or a middlebox, redirecting a measurement request.
+	Redirect string `json:"redirect,omitempty"`
+
+	// Server is the URL of the server we were using, if any.
+	Server string `json:"server,omitempty"`
+}
+
+// CaptivePortalEvidence contains the evidence of a captive portal, i.e.,
+// a middlebox intercepting the requests to show a login page.
+type CaptivePortalEvidence struct {
+	// ContentType is the Content-Type of the response.
+	ContentType string `json:"content_type,omitempty"`
+
+	// Location is the URL where we were redirected, if any.
+	Location string `json:"location,omitempty"`
+
+	// StatusCode is the status code of the response.
+	StatusCode int `json:"status_code"`
 }
 
 // ServerResults contains the server results. This data structure is sent
 // to the client during the collection phase of DASH.
+//
+// The WireBytes field is an extension to the original format containing
+// the bytes written on the connection for sending the segment, including
+// the HTTP and TLS overhead (omitted when the server cannot count them).
+// The Sent field is also an extension containing the body bytes that we
+// actually wrote, and the Aborted field is also an extension indicating
+// that the client aborted the transfer (e.g., by closing the connection)
+// before we could write the whole segment (omitted when false). The
+// Received field is also an extension containing the body bytes that we
+// received when the client uploaded the segment (omitted when zero). The
+// Truncated field is also an extension indicating that the server itself
+// truncated the body because of fault injection (omitted when false).
 type ServerResults struct {
+	Aborted   bool    `json:"aborted,omitempty"`
 	Iteration int64   `json:"iteration"`
+	Received  int64   `json:"received,omitempty"`
+	Sent      int64   `json:"sent,omitempty"`
 	Ticks     float64 `json:"ticks"`
 	Timestamp int64   `json:"timestamp"`
+	Truncated bool    `json:"truncated,omitempty"`
+	WireBytes int64   `json:"wire_bytes,omitempty"`
+}
+
+// ClientHeaders contains the headers of the negotiate request that the
+// server saves with the results, which are a subset of the headers.
+type ClientHeaders struct {
+	// Proto is the protocol version (e.g., "HTTP/1.1").
+	Proto string `json:"proto"`
+
+	// UserAgent is the possibly truncated User-Agent header.
+	UserAgent string `json:"user_agent"`
+}
+
+// NegotiatedParameters contains the test parameters of a session.
+type NegotiatedParameters struct {
+	// DASHRates contains the rates sent by the client.
+	DASHRates []int64 `json:"dash_rates"`
+
+	// MaxIterations is the maximum number of iterations allowed.
+	MaxIterations int64 `json:"max_iterations"`
+
+	// SegmentDuration is the segment duration in seconds.
+	SegmentDuration int64 `json:"segment_duration"`
+
+	// StreamDuration is the stream emulation duration in seconds or
+	// zero when the client did not negotiate this mode.
+	StreamDuration int64 `json:"stream_duration,omitempty"`
+}
+
+// ProxiedTransport describes how a MASQUE front proxied the client.
+type ProxiedTransport struct {
+	// Protocol is the transport protocol between the client and the
+	// proxy as reported by the proxy (e.g., "connect-udp").
+	Protocol string `json:"protocol"`
 }
 
 // ServerSchema is the data format traditionally used by the
 // original Neubot server for DASH experiments.
+//
+// The Scheme field is an extension to the original format containing
+// the effective scheme used by the client (i.e., "http" or "https"). The
+// ConnectionIDs field is also an extension containing the unique IDs of
+// the connections used by the session, which allow to correlate the
+// results with packet captures. The TLSResumed field is also an extension
+// indicating whether the client resumed a previous TLS session when
+// negotiating (omitted when the server did not terminate TLS). The Streams
+// field is also an extension containing the number of parallel streams
+// approved during the negotiation (omitted when zero). The ClientHeaders and
+// Negotiated fields are also extensions containing, respectively, a privacy
+// preserving subset of the headers sent by the client when negotiating and
+// the negotiated test parameters (omitted for sessions not negotiated). The
+// Proxied field is also an extension describing the proxy through which the
+// client reached the server in MASQUE research mode (omitted otherwise).
 type ServerSchema struct {
-	Client              []ClientResults `json:"client"`
-	ServerSchemaVersion int             `json:"srvr_schema_version"`
-	ServerTimestamp     int64           `json:"srvr_timestamp"`
-	Server              []ServerResults `json:"server"`
+	Client              []ClientResults       `json:"client"`
+	ClientHeaders       *ClientHeaders        `json:"client_headers,omitempty"`
+	ConnectionIDs       []string              `json:"connection_ids,omitempty"`
+	Negotiated          *NegotiatedParameters `json:"negotiated,omitempty"`
+	Proxied             *ProxiedTransport     `json:"proxied,omitempty"`
+	Scheme              string                `json:"scheme,omitempty"`
+	ServerSchemaVersion int                   `json:"srvr_schema_version"`
+	ServerTimestamp     int64                 `json:"srvr_timestamp"`
+	Server              []ServerResults       `json:"server"`
+	Streams             int64                 `json:"streams,omitempty"`
+	TLSResumed          *bool                 `json:"tls_resumed,omitempty"`
 }
 
 // NegotiateRequest contains the request of negotiation
+//
+// The StreamDuration field is an extension to the original specification
+// of DASH. It contains the duration in seconds of the emulated stream when
+// the client is running in stream emulation mode and is zero otherwise.
+//
+// The PinConnection field is an extension to the original specification
+// of DASH. When true, the client uses the negotiate connection for all the
+// session requests and the server rejects requests using other connections,
+// so that the per-connection stats correspond exactly to the session.
+//
+// The MultiConnection field is an extension to the original specification
+// of DASH. When true, the client may download several segments at the same
+// time using distinct connections. Otherwise, the server rejects downloads
+// overlapping with other downloads of the same session. Setting this field
+// is equivalent to requesting the maximum number of Streams.
+//
+// The Streams field is an extension to the original specification of
+// DASH. It contains the number of parallel streams (i.e., concurrent
+// downloads) requested by the client and is zero when the client does not
+// request any specific number, which means one stream.
+//
+// The Iterations and SegmentDuration fields are extensions to the original
+// specification of DASH. They contain the number of segments the client
+// plans to download and their duration in seconds, which the server uses to
+// compute the session lifetime, and are zero when not specified.
 type NegotiateRequest struct {
-	DASHRates []int64 `json:"dash_rates"`
+	DASHRates       []int64 `json:"dash_rates"`
+	Iterations      int64   `json:"iterations,omitempty"`
+	MultiConnection bool    `json:"multi_connection,omitempty"`
+	PinConnection   bool    `json:"pin_connection,omitempty"`
+	SegmentDuration int64   `json:"segment_duration,omitempty"`
+	StreamDuration  int64   `json:"stream_duration,omitempty"`
+	Streams         int64   `json:"streams,omitempty"`
 }
 
 // NegotiateResponse contains the response of negotiation
+//
+// The BaseURL field is an extension to the original specification of
+// DASH. When not empty, it contains the URL (e.g., "https://node.example.com")
+// whose scheme and host the client must use for downloading segments and
+// for collecting results, which allows to delegate serving segments to
+// other nodes than the one handling the negotiation.
+//
+// The Seed field is also an extension. When not empty, it contains the
+// hex encoded seed from which the server derives the payload of each segment
+// (see spec.FillPayload), which allows to verify the content offline.
+//
+// The Streams field is also an extension. It contains the number of
+// parallel streams approved by the server, which is lower than or equal to
+// the number requested by the client, and is omitted when the client did
+// not request multiple streams.
+//
+// The MinSize and MaxSize fields are also extensions. They contain the
+// minimum and maximum segment sizes in bytes that the server is willing to
+// send, such that clients can clamp the sizes they request rather than
+// receiving segments with a different size than the requested one.
+//
+// The MaxIterations field is also an extension. It contains the maximum
+// number of iterations that the server allows for the session, such that
+// clients can run fewer iterations rather than failing midway, and is
+// omitted by servers that do not advertise it.
+//
+// The ServerTime field is also an extension. It contains the time, in
+// seconds since the epoch with sub-second precision, when the server created
+// the response, which allows clients to estimate the clock offset between
+// the client and the server more precisely than using the Date header.
 type NegotiateResponse struct {
-	Authorization string `json:"authorization"`
-	QueuePos      int64  `json:"queue_pos"`
-	RealAddress   string `json:"real_address"`
-	Unchoked      int    `json:"unchoked"`
+	Authorization string  `json:"authorization"`
+	BaseURL       string  `json:"base_url,omitempty"`
+	MaxIterations int64   `json:"max_iterations,omitempty"`
+	MaxSize       int64   `json:"max_size,omitempty"`
+	MinSize       int64   `json:"min_size,omitempty"`
+	QueuePos      int64   `json:"queue_pos"`
+	RealAddress   string  `json:"real_address"`
+	Seed          string  `json:"seed,omitempty"`
+	ServerTime    float64 `json:"server_time,omitempty"`
+	Streams       int64   `json:"streams,omitempty"`
+	Unchoked      int     `json:"unchoked"`
 }
 
 // Logger defines the common interface that a logger should have. It is
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"reflect"
+	"strings"
+)
+
+// JSONSchemaDraft is the JSON Schema draft used by [JSONSchema].
+const JSONSchemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// JSONSchema returns the JSON Schema of the types of the given values, which
+// are types of the data model or types embedding them, using "oneOf" when
+// there is more than one value. The schema is suitable to be serialized using
+// encoding/json. Named struct types are in the "$defs" section and are
+// referenced using "$ref".
+//
+// We only support the subset of Go types used by the data model: booleans,
+// numbers, strings, pointers, slices, maps with string keys, and structs,
+// honoring the encoding/json struct tags. Fields tagged with omitempty are
+// optional, all the other fields are required. Because encoding/json
+// serializes nil pointers, slices, and maps as null, their schemas also
+// allow null. We allow additional properties, so that adding fields to the
+// data model does not break parsers validating using older schemas.
+func JSONSchema(values ...any) map[string]any {
+	g := &generator{defs: map[string]any{}}
+	var schema map[string]any
+	if len(values) == 1 {
+		schema = g.schema(reflect.TypeOf(values[0]))
+	} else {
+		var choices []any
+		for _, v := range values {
+			choices = append(choices, g.schema(reflect.TypeOf(v)))
+		}
+		schema = map[string]any{"oneOf": choices}
+	}
+	schema["$schema"] = JSONSchemaDraft
+	if len(g.defs) > 0 {
+		schema["$defs"] = g.defs
+	}
+	return schema
+}
+
+// generator keeps state while generating a JSON Schema.
+type generator struct {
+	// defs contains the definitions of the named struct types.
+	defs map[string]any
+}
+
+// schema returns the JSON Schema of the given type.
+func (g *generator) schema(t reflect.Type) map[string]any {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(g.schema(t.Elem()))
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice:
+		return nullable(map[string]any{"type": "array", "items": g.schema(t.Elem())})
+	case reflect.Array:
+		return map[string]any{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return nullable(map[string]any{"type": "object", "additionalProperties": g.schema(t.Elem())})
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, found := g.defs[t.Name()]; !found {
+			g.defs[t.Name()] = map[string]any{} // break recursive definitions
+			g.defs[t.Name()] = g.object(t)
+		}
+		return map[string]any{"$ref": "#/$defs/" + t.Name()}
+	default:
+		return map[string]any{}
+	}
+}
+
+// object returns the JSON Schema of the given struct type.
+func (g *generator) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	required := []string{}
+	for idx := 0; idx < t.NumField(); idx++ {
+		field := t.Field(idx)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = g.schema(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	return map[string]any{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+// nullable returns a JSON Schema also allowing null.
+func nullable(schema map[string]any) map[string]any {
+	return map[string]any{"anyOf": []any{schema, map[string]any{"type": "null"}}}
+}
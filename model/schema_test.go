@@ -0,0 +1,78 @@
+package model
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type inner struct {
+	Name string `json:"name"`
+}
+
+type outer struct {
+	Count    int64          `json:"count"`
+	Enabled  bool           `json:"enabled,omitempty"`
+	Ignored  string         `json:"-"`
+	Inner    *inner         `json:"inner,omitempty"`
+	Items    []inner        `json:"items"`
+	Labels   map[string]int `json:"labels"`
+	Rate     float64        `json:"rate"`
+	Untagged string
+	private  string
+	Self     *outer           `json:"self,omitempty"`
+	Values   [2]int           `json:"values"`
+	Anything any              `json:"anything"`
+	Nested   struct{ X bool } `json:"nested"`
+}
+
+func TestJSONSchema(t *testing.T) {
+	schema := JSONSchema(outer{})
+	if schema["$schema"] != JSONSchemaDraft || schema["$ref"] != "#/$defs/outer" {
+		t.Fatal("unexpected top-level schema", schema)
+	}
+	defs := schema["$defs"].(map[string]any)
+	object := defs["outer"].(map[string]any)
+	properties := object["properties"].(map[string]any)
+	expect := map[string]any{
+		"count":    map[string]any{"type": "integer"},
+		"enabled":  map[string]any{"type": "boolean"},
+		"inner":    nullable(map[string]any{"$ref": "#/$defs/inner"}),
+		"items":    nullable(map[string]any{"type": "array", "items": map[string]any{"$ref": "#/$defs/inner"}}),
+		"labels":   nullable(map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "integer"}}),
+		"rate":     map[string]any{"type": "number"},
+		"Untagged": map[string]any{"type": "string"},
+		"self":     nullable(map[string]any{"$ref": "#/$defs/outer"}),
+		"values":   map[string]any{"type": "array", "items": map[string]any{"type": "integer"}},
+		"anything": map[string]any{},
+		"nested": map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"X": map[string]any{"type": "boolean"}},
+			"required":   []string{"X"},
+		},
+	}
+	if !reflect.DeepEqual(properties, expect) {
+		t.Fatal("unexpected properties", properties)
+	}
+	required := []string{"count", "items", "labels", "rate", "Untagged", "values", "anything", "nested"}
+	if !reflect.DeepEqual(object["required"], required) {
+		t.Fatal("unexpected required properties", object["required"])
+	}
+	if _, err := json.Marshal(schema); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("with several values", func(t *testing.T) {
+		schema := JSONSchema(inner{}, []outer{})
+		expect := []any{
+			map[string]any{"$ref": "#/$defs/inner"},
+			nullable(map[string]any{"type": "array", "items": map[string]any{"$ref": "#/$defs/outer"}}),
+		}
+		if !reflect.DeepEqual(schema["oneOf"], expect) {
+			t.Fatal("unexpected choices", schema["oneOf"])
+		}
+		if defs := schema["$defs"].(map[string]any); len(defs) != 2 {
+			t.Fatal("unexpected definitions", defs)
+		}
+	})
+}
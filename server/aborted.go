@@ -0,0 +1,48 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+)
+
+// writeSegment writes the segment and flushes the response, which allows
+// to notice when the client aborts the transfer. It returns the number of
+// bytes written and the error, which is also non-nil when the context of
+// the request is done, since then the client is no longer interested.
+func (h *Handler) writeSegment(w http.ResponseWriter, r *http.Request, data []byte) (int64, error) {
+	count, err := w.Write(data)
+	if err != nil {
+		return int64(count), err
+	}
+	if err := http.NewResponseController(w).Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		return int64(count), err
+	}
+	return int64(count), r.Context().Err()
+}
+
+// recordSent SAFELY SETS the number of body bytes we sent and whether the
+// client aborted the transfer for the idx-th measurement result of the
+// session with the given UUID.
+func (h *Handler) recordSent(UUID string, idx int, count int64, aborted bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	session, ok := h.sessions[UUID]
+	if ok && idx >= 0 && idx < len(session.serverSchema.Server) {
+		session.serverSchema.Server[idx].Aborted = aborted
+		session.serverSchema.Server[idx].Sent = count
+	}
+}
+
+// recordTruncated SAFELY SETS the number of body bytes we sent before
+// truncating the body because of fault injection for the idx-th measurement
+// result of the session with the given UUID. Because the client did not
+// abort the transfer, we do not mark the result as aborted.
+func (h *Handler) recordTruncated(UUID string, idx int, count int64) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	session, ok := h.sessions[UUID]
+	if ok && idx >= 0 && idx < len(session.serverSchema.Server) {
+		session.serverSchema.Server[idx].Sent = count
+		session.serverSchema.Server[idx].Truncated = true
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// failingWriter is a response writer failing after writing limit bytes.
+type failingWriter struct {
+	*httptest.ResponseRecorder
+	limit int
+}
+
+func (fw *failingWriter) Write(data []byte) (int, error) {
+	if len(data) > fw.limit {
+		count, _ := fw.ResponseRecorder.Write(data[:fw.limit])
+		return count, errors.New("mocked error")
+	}
+	return fw.ResponseRecorder.Write(data)
+}
+
+func TestServerAbortedDownloads(t *testing.T) {
+	counter := func() float64 {
+		value := &dto.Metric{}
+		if err := abortedDownloads.WithLabelValues("http", "HTTP/1.1").Write(value); err != nil {
+			t.Fatal(err)
+		}
+		return value.Counter.GetValue()
+	}
+
+	t.Run("when the client reads the whole segment", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.createSession("deadbeef")
+		before := counter()
+		req := httptest.NewRequest("GET", "/dash/download/1000", nil)
+		req.Header.Set(authorization, "deadbeef")
+		w := httptest.NewRecorder()
+		handler.download(w, req)
+		if w.Code != 200 {
+			t.Fatal("Expected different status code")
+		}
+		results := handler.sessions["deadbeef"].serverSchema.Server
+		if len(results) != 1 || results[0].Aborted || results[0].Sent != int64(w.Body.Len()) {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+		if counter() != before {
+			t.Fatal("expected the counter not to change")
+		}
+	})
+
+	t.Run("when writing fails", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.createSession("deadbeef")
+		before := counter()
+		req := httptest.NewRequest("GET", "/dash/download/1000", nil)
+		req.Header.Set(authorization, "deadbeef")
+		handler.download(&failingWriter{httptest.NewRecorder(), 100}, req)
+		results := handler.sessions["deadbeef"].serverSchema.Server
+		if len(results) != 1 || !results[0].Aborted || results[0].Sent != 100 {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+		if counter() != before+1 {
+			t.Fatal("expected the counter to increase")
+		}
+	})
+
+	t.Run("when the client goes away", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.createSession("deadbeef")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest("GET", "/dash/download/1000", nil).WithContext(ctx)
+		req.Header.Set(authorization, "deadbeef")
+		handler.download(httptest.NewRecorder(), req)
+		results := handler.sessions["deadbeef"].serverSchema.Server
+		if len(results) != 1 || !results[0].Aborted {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+	})
+}
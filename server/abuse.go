@@ -0,0 +1,142 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// abuseWindow is the interval within which we count the failures of an
+// address: once BanThreshold failures occur within this interval, we ban.
+const abuseWindow = time.Minute
+
+// These are the reasons why we consider a request abusive.
+const (
+	abuseExpiredSession = "expired_session"
+	abuseInvalidSession = "invalid_session"
+	abuseMalformedBody  = "malformed_body"
+	abuseMalformedSize  = "malformed_size"
+	abuseOverBudget     = "over_budget"
+	abuseUnboundSession = "unbound_session"
+)
+
+// abuseRecord tracks the failures of an address.
+type abuseRecord struct {
+	// bannedUntil is when the ban expires or zero when not banned.
+	bannedUntil time.Time
+
+	// failures is the number of failures since windowStart.
+	failures int
+
+	// windowStart is when we started counting failures.
+	windowStart time.Time
+}
+
+// abuseTracker tracks per-address failures and temporary bans.
+type abuseTracker struct {
+	// mtx protects records.
+	mtx sync.Mutex
+
+	// records maps an address to its record.
+	records map[string]*abuseRecord
+}
+
+// newAbuseTracker creates a new [*abuseTracker].
+func newAbuseTracker() *abuseTracker {
+	return &abuseTracker{
+		mtx:     sync.Mutex{},
+		records: make(map[string]*abuseRecord),
+	}
+}
+
+// failure records a failure of the given address at the given time and
+// returns whether we banned the address for the given duration because
+// of this failure, given the threshold.
+func (at *abuseTracker) failure(address string, now time.Time, threshold int, duration time.Duration) bool {
+	at.mtx.Lock()
+	defer at.mtx.Unlock()
+	record, ok := at.records[address]
+	if !ok || now.Sub(record.windowStart) > abuseWindow {
+		if !ok {
+			record = &abuseRecord{}
+			at.records[address] = record
+		}
+		record.failures, record.windowStart = 0, now
+	}
+	record.failures++
+	if record.failures < threshold || now.Before(record.bannedUntil) {
+		return false
+	}
+	record.bannedUntil = now.Add(duration)
+	record.failures, record.windowStart = 0, now
+	return true
+}
+
+// banned returns how long the ban of the given address still lasts
+// at the given time or zero if the address is not banned.
+func (at *abuseTracker) banned(address string, now time.Time) time.Duration {
+	at.mtx.Lock()
+	defer at.mtx.Unlock()
+	if record, ok := at.records[address]; ok && now.Before(record.bannedUntil) {
+		return record.bannedUntil.Sub(now)
+	}
+	return 0
+}
+
+// reap removes the records that are neither banned nor within their
+// failures window at the given time and returns the number of bans.
+func (at *abuseTracker) reap(now time.Time) (bans int) {
+	at.mtx.Lock()
+	defer at.mtx.Unlock()
+	for address, record := range at.records {
+		switch {
+		case now.Before(record.bannedUntil):
+			bans++
+		case now.Sub(record.windowStart) > abuseWindow:
+			delete(at.records, address)
+		}
+	}
+	return
+}
+
+// clientAddress returns the address of the client that sent the request.
+func clientAddress(r *http.Request) string {
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return address
+}
+
+// reportAbuse records that the client that sent the request failed for
+// the given reason and possibly bans it. This is a no-op when BanThreshold
+// is zero or negative, i.e., when abuse detection is disabled.
+func (h *Handler) reportAbuse(r *http.Request, reason string) {
+	if h.BanThreshold <= 0 {
+		return
+	}
+	address := h.realAddress(r)
+	scheme, proto := h.metricLabels(r)
+	abuseFailures.WithLabelValues(reason, scheme, proto).Inc()
+	if h.abuse.failure(address, h.now(), h.BanThreshold, h.BanDuration) {
+		h.logger.Warnf("abuse: banning %s for %s (last reason: %s)", address, h.BanDuration, reason)
+		abuseBans.WithLabelValues(scheme, proto).Inc()
+	}
+}
+
+// unlessBanned wraps the given handler such that we refuse to serve
+// clients that we have temporarily banned with 403.
+func (h *Handler) unlessBanned(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if wait := h.abuse.banned(h.realAddress(r), h.now()); wait > 0 {
+			seconds := int64((wait + time.Second - 1) / time.Second)
+			w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+			setFailureReason(w, "client banned")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/spec"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestAbuseTracker(t *testing.T) {
+	t.Run("ban after threshold", func(t *testing.T) {
+		tracker := newAbuseTracker()
+		now := time.Now()
+		if tracker.failure("10.0.0.1", now, 2, time.Minute) {
+			t.Fatal("should not ban after the first failure")
+		}
+		if !tracker.failure("10.0.0.1", now, 2, time.Minute) {
+			t.Fatal("should ban after the second failure")
+		}
+		if tracker.banned("10.0.0.1", now.Add(30*time.Second)) != 30*time.Second {
+			t.Fatal("expected to be banned")
+		}
+		if tracker.banned("10.0.0.2", now) != 0 {
+			t.Fatal("expected other addresses not to be banned")
+		}
+		if tracker.banned("10.0.0.1", now.Add(2*time.Minute)) != 0 {
+			t.Fatal("expected the ban to expire")
+		}
+	})
+
+	t.Run("failures outside of the window", func(t *testing.T) {
+		tracker := newAbuseTracker()
+		now := time.Now()
+		tracker.failure("10.0.0.1", now, 2, time.Minute)
+		if tracker.failure("10.0.0.1", now.Add(2*abuseWindow), 2, time.Minute) {
+			t.Fatal("should not ban when failures are far apart")
+		}
+	})
+
+	t.Run("reap", func(t *testing.T) {
+		tracker := newAbuseTracker()
+		now := time.Now()
+		tracker.failure("10.0.0.1", now, 1, time.Hour)
+		tracker.failure("10.0.0.2", now, 2, time.Hour)
+		if bans := tracker.reap(now.Add(2 * abuseWindow)); bans != 1 {
+			t.Fatal("unexpected number of bans", bans)
+		}
+		if len(tracker.records) != 1 {
+			t.Fatal("expected to reap the record without ban")
+		}
+	})
+}
+
+func TestHandlerBans(t *testing.T) {
+	newRequest := func(method, path string) *http.Request {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = "10.0.0.1:54321"
+		return req
+	}
+
+	t.Run("abuse detection disabled", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		mux := http.NewServeMux()
+		handler.RegisterHandlers(mux)
+		for idx := 0; idx < 10; idx++ {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, newRequest("GET", spec.DownloadPath+"1000"))
+			if w.Result().StatusCode != 400 {
+				t.Fatal("Expected different status code")
+			}
+		}
+	})
+
+	t.Run("abuse detection enabled", func(t *testing.T) {
+		bans := func() float64 {
+			value := &dto.Metric{}
+			if err := abuseBans.WithLabelValues("http", "HTTP/1.1").Write(value); err != nil {
+				t.Fatal(err)
+			}
+			return value.Counter.GetValue()
+		}
+		before := bans()
+		handler := NewHandler("", log.Log)
+		handler.BanThreshold = 3
+		mux := http.NewServeMux()
+		handler.RegisterHandlers(mux)
+		for idx := 0; idx < 3; idx++ {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, newRequest("POST", spec.CollectPath))
+			if w.Result().StatusCode != 400 {
+				t.Fatal("Expected different status code")
+			}
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, newRequest("POST", spec.NegotiatePath))
+		resp := w.Result()
+		if resp.StatusCode != http.StatusForbidden {
+			t.Fatal("Expected different status code")
+		}
+		if resp.Header.Get("Retry-After") != "600" {
+			t.Fatal("unexpected Retry-After", resp.Header.Get("Retry-After"))
+		}
+		if bans()-before != 1 {
+			t.Fatal("unexpected number of bans")
+		}
+	})
+
+	t.Run("behind a trusted proxy", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.BanThreshold = 1
+		handler.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+		mux := http.NewServeMux()
+		handler.RegisterHandlers(mux)
+		forwardedRequest := func(method, path, forwardedFor string) *http.Request {
+			req := newRequest(method, path)
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+			return req
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, forwardedRequest("POST", spec.CollectPath, "130.192.91.211"))
+		if w.Result().StatusCode != 400 {
+			t.Fatal("Expected different status code")
+		}
+		// we ban the client rather than the proxy
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, forwardedRequest("POST", spec.NegotiatePath, "130.192.91.211"))
+		if w.Result().StatusCode != http.StatusForbidden {
+			t.Fatal("Expected different status code")
+		}
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, forwardedRequest("POST", spec.NegotiatePath, "130.192.91.212"))
+		if w.Result().StatusCode == http.StatusForbidden {
+			t.Fatal("expected other clients of the proxy not to be banned")
+		}
+	})
+}
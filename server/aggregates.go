@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// aggregateWindow is the rolling window over which we aggregate sessions.
+const aggregateWindow = time.Hour
+
+// aggregateKey is the key of the aggregate statistics.
+type aggregateKey struct {
+	// ASN is the client ASN or zero if unknown.
+	ASN uint32
+
+	// Country is the client country code or empty if unknown.
+	Country string
+}
+
+// aggregateSample is a completed session within an aggregate.
+type aggregateSample struct {
+	// medianRate is the median rate (in kbit/s) of the session.
+	medianRate float64
+
+	// stamp is when the session was created.
+	stamp time.Time
+}
+
+// AggregateStats contains the statistics of the sessions completed
+// within the last hour by clients in a given ASN and country.
+type AggregateStats struct {
+	// ASN is the client ASN or zero if unknown.
+	ASN uint32 `json:"asn"`
+
+	// Country is the client country code or empty if unknown.
+	Country string `json:"country"`
+
+	// MedianRate is the median of the sessions median rates (in kbit/s),
+	// excluding sessions for which we do not know the rate.
+	MedianRate float64 `json:"median_rate"`
+
+	// Sessions is the number of sessions.
+	Sessions int `json:"sessions"`
+}
+
+// aggregator is a goroutine-safe container of rolling aggregates.
+type aggregator struct {
+	// mtx protects samples.
+	mtx sync.Mutex
+
+	// samples maps each key to the samples within the window.
+	samples map[aggregateKey][]aggregateSample
+}
+
+// newAggregator creates a new [*aggregator].
+func newAggregator() *aggregator {
+	return &aggregator{
+		mtx:     sync.Mutex{},
+		samples: make(map[aggregateKey][]aggregateSample),
+	}
+}
+
+// add adds the given summary to the aggregates.
+func (ag *aggregator) add(summary sessionSummary) {
+	ag.mtx.Lock()
+	defer ag.mtx.Unlock()
+	key := aggregateKey{ASN: summary.ASN, Country: summary.Country}
+	ag.samples[key] = append(ag.samples[key], aggregateSample{
+		medianRate: summary.MedianRate,
+		stamp:      summary.Stamp,
+	})
+}
+
+// reap removes the samples older than the window and returns the
+// statistics of each key, sorted by decreasing number of sessions.
+func (ag *aggregator) reap(now time.Time) (out []AggregateStats) {
+	ag.mtx.Lock()
+	defer ag.mtx.Unlock()
+	out = []AggregateStats{}
+	for key, samples := range ag.samples {
+		var (
+			fresh []aggregateSample
+			rates []float64
+		)
+		for _, sample := range samples {
+			if now.Sub(sample.stamp) > aggregateWindow {
+				continue
+			}
+			fresh = append(fresh, sample)
+			if sample.medianRate > 0 {
+				rates = append(rates, sample.medianRate)
+			}
+		}
+		if len(fresh) <= 0 {
+			delete(ag.samples, key)
+			continue
+		}
+		ag.samples[key] = fresh
+		out = append(out, AggregateStats{
+			ASN:        key.ASN,
+			Country:    key.Country,
+			MedianRate: median(rates),
+			Sessions:   len(fresh),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Sessions != out[j].Sessions {
+			return out[i].Sessions > out[j].Sessions
+		}
+		if out[i].ASN != out[j].ASN {
+			return out[i].ASN < out[j].ASN
+		}
+		return out[i].Country < out[j].Country
+	})
+	return
+}
+
+// Aggregates returns the statistics of the sessions completed within the
+// last hour grouped by client ASN and country (see ASNLookup and
+// CountryLookup), sorted by decreasing number of sessions.
+func (h *Handler) Aggregates() []AggregateStats {
+	return h.aggregates.reap(h.now())
+}
+
+// updateAggregateMetrics exports the aggregates as Prometheus metrics.
+func (h *Handler) updateAggregateMetrics() {
+	aggregateSessions.Reset()
+	aggregateMedianRate.Reset()
+	for _, stats := range h.Aggregates() {
+		asn := strconv.FormatUint(uint64(stats.ASN), 10)
+		aggregateSessions.WithLabelValues(asn, stats.Country).Set(float64(stats.Sessions))
+		aggregateMedianRate.WithLabelValues(asn, stats.Country).Set(stats.MedianRate)
+	}
+}
+
+// aggregatesHandler implements the /admin/aggregates handler.
+func (h *Handler) aggregatesHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(h.Aggregates())
+	if err != nil {
+		h.logger.Warnf("aggregatesHandler: json.Marshal: %s", err.Error())
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, _ = w.Write(data)
+}
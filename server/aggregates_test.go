@@ -0,0 +1,93 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/model"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestAggregatorReap(t *testing.T) {
+	now := time.Now()
+	ag := newAggregator()
+	ag.add(sessionSummary{ASN: 137, Country: "IT", MedianRate: 10, Stamp: now})
+	ag.add(sessionSummary{ASN: 137, Country: "IT", MedianRate: 30, Stamp: now})
+	ag.add(sessionSummary{ASN: 137, Country: "IT", Stamp: now}) // unknown rate
+	ag.add(sessionSummary{ASN: 137, Country: "IT", MedianRate: 1000, Stamp: now.Add(-2 * aggregateWindow)})
+	ag.add(sessionSummary{ASN: 3269, Country: "IT", MedianRate: 50, Stamp: now})
+	ag.add(sessionSummary{Country: "FR", MedianRate: 70, Stamp: now.Add(-2 * aggregateWindow)})
+	stats := ag.reap(now)
+	expect := []AggregateStats{
+		{ASN: 137, Country: "IT", MedianRate: 20, Sessions: 3},
+		{ASN: 3269, Country: "IT", MedianRate: 50, Sessions: 1},
+	}
+	if len(stats) != len(expect) {
+		t.Fatal("unexpected number of aggregates", stats)
+	}
+	for idx := range expect {
+		if stats[idx] != expect[idx] {
+			t.Fatal("unexpected aggregate at", idx, stats[idx])
+		}
+	}
+	if len(ag.samples) != 2 || len(ag.samples[aggregateKey{ASN: 137, Country: "IT"}]) != 3 {
+		t.Fatal("expected to remove stale samples")
+	}
+}
+
+func TestServerAggregates(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	handler.ASNLookup = func(address string) (uint32, error) {
+		return 137, nil
+	}
+	handler.CountryLookup = func(address string) (string, error) {
+		if address == "130.192.91.211" {
+			return "IT", nil
+		}
+		return "", errors.New("mocked error")
+	}
+	handler.createNegotiatedSession("deadbeef", "130.192.91.211", "https", model.NegotiateRequest{}, nil)
+	handler.summarize(handler.popSession("deadbeef"))
+	handler.createNegotiatedSession("deadc0de", "10.0.0.1", "http", model.NegotiateRequest{}, nil)
+	handler.summarize(handler.popSession("deadc0de"))
+
+	t.Run("JSON endpoint", func(t *testing.T) {
+		mux := http.NewServeMux()
+		handler.RegisterAdminHandlers(mux)
+		req := httptest.NewRequest("GET", "/admin/aggregates", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		resp := w.Result()
+		if resp.StatusCode != 200 {
+			t.Fatal("Expected different status code")
+		}
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stats []AggregateStats
+		if err := json.Unmarshal(data, &stats); err != nil {
+			t.Fatal(err)
+		}
+		if len(stats) != 2 || stats[0].Country != "" || stats[1].Country != "IT" {
+			t.Fatal("unexpected aggregates", stats)
+		}
+	})
+
+	t.Run("Prometheus metrics", func(t *testing.T) {
+		handler.updateAggregateMetrics()
+		value := &dto.Metric{}
+		if err := aggregateSessions.WithLabelValues("137", "IT").Write(value); err != nil {
+			t.Fatal(err)
+		}
+		if value.Gauge.GetValue() != 1 {
+			t.Fatal("unexpected number of sessions")
+		}
+	})
+}
@@ -0,0 +1,40 @@
+package server
+
+import "net/http"
+
+// SessionBinding is the policy restricting who can use a session token
+// after the negotiation, which mitigates sharing or replaying tokens.
+type SessionBinding string
+
+const (
+	// SessionBindingNone allows anyone knowing the token to use the session.
+	SessionBindingNone = SessionBinding("none")
+
+	// SessionBindingAddress requires the download and collect requests to
+	// come from the same IP address that negotiated the session.
+	SessionBindingAddress = SessionBinding("address")
+
+	// SessionBindingConnection requires the download and collect requests
+	// to use the same connection that negotiated the session.
+	SessionBindingConnection = SessionBinding("connection")
+)
+
+// boundRequest returns whether the request using the session with the given
+// UUID complies with the SessionBinding policy. When we cannot determine
+// the identity of the connection, we allow the request.
+func (h *Handler) boundRequest(UUID string, r *http.Request) bool {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	session, found := h.sessions[UUID]
+	if !found {
+		return true
+	}
+	switch h.SessionBinding {
+	case SessionBindingAddress:
+		return session.address == "" || session.address == h.realAddress(r)
+	case SessionBindingConnection:
+		return negotiatedConn(session, r)
+	default:
+		return true
+	}
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/model"
+)
+
+func TestServerSessionBinding(t *testing.T) {
+	newRequest := func(remoteAddr, connID string) *http.Request {
+		ctx := context.WithValue(context.Background(), connInfoKey{}, &connInfo{ID: connID, Conn: &net.TCPConn{}})
+		req := httptest.NewRequest("GET", "/dash/download/1000", nil).WithContext(ctx)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set(authorization, "deadbeef")
+		return req
+	}
+	newHandler := func(binding SessionBinding) *Handler {
+		handler := NewHandler("", log.Log)
+		handler.SessionBinding = binding
+		handler.createNegotiatedSession("deadbeef", "130.192.91.211", "https", model.NegotiateRequest{}, nil)
+		handler.trackConn("deadbeef", newRequest("130.192.91.211:54321", "abc"))
+		return handler
+	}
+	cases := []struct {
+		name       string
+		binding    SessionBinding
+		remoteAddr string
+		connID     string
+		expect     int
+	}{{
+		name:       "without binding",
+		binding:    SessionBindingNone,
+		remoteAddr: "10.0.0.1:54321",
+		connID:     "def",
+		expect:     200,
+	}, {
+		name:       "with address binding and same address",
+		binding:    SessionBindingAddress,
+		remoteAddr: "130.192.91.211:12345",
+		connID:     "def",
+		expect:     200,
+	}, {
+		name:       "with address binding and another address",
+		binding:    SessionBindingAddress,
+		remoteAddr: "10.0.0.1:54321",
+		connID:     "abc",
+		expect:     403,
+	}, {
+		name:       "with connection binding and same connection",
+		binding:    SessionBindingConnection,
+		remoteAddr: "130.192.91.211:54321",
+		connID:     "abc",
+		expect:     200,
+	}, {
+		name:       "with connection binding and another connection",
+		binding:    SessionBindingConnection,
+		remoteAddr: "130.192.91.211:12345",
+		connID:     "def",
+		expect:     403,
+	}, {
+		name:       "with connection binding and unknown connection",
+		binding:    SessionBindingConnection,
+		remoteAddr: "130.192.91.211:12345",
+		connID:     "",
+		expect:     200,
+	}}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler := newHandler(tc.binding)
+			w := httptest.NewRecorder()
+			handler.download(w, newRequest(tc.remoteAddr, tc.connID))
+			if w.Code != tc.expect {
+				t.Fatal("Expected different status code", w.Code)
+			}
+		})
+	}
+
+	t.Run("collect from another address", func(t *testing.T) {
+		handler := newHandler(SessionBindingAddress)
+		req := newRequest("10.0.0.1:54321", "abc")
+		req.Method = "POST"
+		w := httptest.NewRecorder()
+		handler.collect(w, req)
+		if w.Code != 403 {
+			t.Fatal("Expected different status code")
+		}
+		if handler.CountSessions() != 1 {
+			t.Fatal("expected the session to still exist")
+		}
+	})
+
+	t.Run("with address binding behind a trusted proxy", func(t *testing.T) {
+		handler := newHandler(SessionBindingAddress)
+		handler.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+		for _, tc := range []struct {
+			forwardedFor string
+			expect       int
+		}{
+			{forwardedFor: "130.192.91.211", expect: 200},
+			{forwardedFor: "130.192.91.212", expect: 403},
+		} {
+			req := newRequest("10.0.0.1:54321", "abc")
+			req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			w := httptest.NewRecorder()
+			handler.download(w, req)
+			if w.Code != tc.expect {
+				t.Fatal("Expected different status code", tc.forwardedFor, w.Code)
+			}
+		}
+	})
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// errEmptyCaptureCommand is returned when the capture command is empty.
+var errEmptyCaptureCommand = errors.New("empty capture command")
+
+// CaptureHook allows to capture the packets, or the packets metadata, of the
+// connections used by each session, so that one can correlate the throughput
+// measured at application level with the on-the-wire traces.
+type CaptureHook interface {
+	// Start is called when the first session starts using the connection
+	// with the given unique ID and endpoints. The ID is also saved in the
+	// results of each session using the connection.
+	Start(connID string, localAddr, remoteAddr net.Addr) error
+
+	// Stop is called when all the sessions using the connection are over.
+	Stop(connID string)
+}
+
+// trackConn records the connection used by the request in the session with
+// the given UUID and possibly starts capturing it using the CaptureHook.
+func (h *Handler) trackConn(UUID string, r *http.Request) {
+	info, ok := connInfoFromContext(r.Context())
+	if !ok || info.ID == "" {
+		return
+	}
+	h.mtx.Lock()
+	session, found := h.sessions[UUID]
+	if !found || slices.Contains(session.serverSchema.ConnectionIDs, info.ID) {
+		h.mtx.Unlock()
+		return
+	}
+	session.serverSchema.ConnectionIDs = append(session.serverSchema.ConnectionIDs, info.ID)
+	h.mtx.Unlock()
+	h.startCapture(info)
+}
+
+// startCapture starts capturing the given connection using the CaptureHook,
+// unless we are already capturing it for another session. Because HTTP/1.1
+// and HTTP/2 clients may reuse a connection across sessions, we count the
+// sessions using each captured connection.
+func (h *Handler) startCapture(info *connInfo) {
+	if h.CaptureHook == nil {
+		return
+	}
+	h.capturesMtx.Lock()
+	defer h.capturesMtx.Unlock()
+	if count := h.captures[info.ID]; count > 0 {
+		h.captures[info.ID] = count + 1
+		return
+	}
+	if err := h.CaptureHook.Start(info.ID, info.Conn.LocalAddr(), info.Conn.RemoteAddr()); err != nil {
+		h.logger.Warnf("capture: cannot start: %s", err.Error())
+		return
+	}
+	h.captures[info.ID] = 1
+}
+
+// stopCapture stops capturing the connections used by the session, except
+// the ones that other sessions are still using (see startCapture).
+func (h *Handler) stopCapture(session *sessionInfo) {
+	if h.CaptureHook == nil {
+		return
+	}
+	h.capturesMtx.Lock()
+	defer h.capturesMtx.Unlock()
+	for _, connID := range session.serverSchema.ConnectionIDs {
+		count := h.captures[connID]
+		switch {
+		case count <= 0:
+			// we did not start capturing this connection
+		case count == 1:
+			delete(h.captures, connID)
+			h.CaptureHook.Stop(connID)
+		default:
+			h.captures[connID] = count - 1
+		}
+	}
+}
+
+// CommandCaptureHook is a [CaptureHook] running an external command (e.g.,
+// tcpdump) for the duration of the session. The command receives SIGKILL
+// when the session is over, so it should flush its output often.
+type CommandCaptureHook struct {
+	// Argv contains the command and its arguments. We replace the following
+	// placeholders in each argument: {id} with the connection ID, {local_ip}
+	// and {local_port} with the local endpoint, {remote_ip} and {remote_port}
+	// with the remote endpoint.
+	Argv []string
+
+	// cancels maps a connection ID to the function to stop the command.
+	cancels map[string]context.CancelFunc
+
+	// mtx protects cancels.
+	mtx sync.Mutex
+}
+
+var _ CaptureHook = &CommandCaptureHook{}
+
+// Start implements CaptureHook.
+func (ch *CommandCaptureHook) Start(connID string, localAddr, remoteAddr net.Addr) error {
+	localIP, localPort, _ := net.SplitHostPort(localAddr.String())
+	remoteIP, remotePort, _ := net.SplitHostPort(remoteAddr.String())
+	replacer := strings.NewReplacer(
+		"{id}", connID,
+		"{local_ip}", localIP,
+		"{local_port}", localPort,
+		"{remote_ip}", remoteIP,
+		"{remote_port}", remotePort,
+	)
+	var argv []string
+	for _, arg := range ch.Argv {
+		argv = append(argv, replacer.Replace(arg))
+	}
+	if len(argv) < 1 {
+		return errEmptyCaptureCommand
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return err
+	}
+	go cmd.Wait() // reap the child process once it terminates
+	ch.mtx.Lock()
+	defer ch.mtx.Unlock()
+	if ch.cancels == nil {
+		ch.cancels = make(map[string]context.CancelFunc)
+	}
+	ch.cancels[connID] = cancel
+	return nil
+}
+
+// Stop implements CaptureHook.
+func (ch *CommandCaptureHook) Stop(connID string) {
+	ch.mtx.Lock()
+	cancel, found := ch.cancels[connID]
+	delete(ch.cancels, connID)
+	ch.mtx.Unlock()
+	if found {
+		cancel()
+	}
+}
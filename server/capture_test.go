@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+// fakeCaptureHook is a CaptureHook recording its invocations.
+type fakeCaptureHook struct {
+	err     error
+	started []string
+	stopped []string
+}
+
+func (fch *fakeCaptureHook) Start(connID string, localAddr, remoteAddr net.Addr) error {
+	fch.started = append(fch.started, connID)
+	return fch.err
+}
+
+func (fch *fakeCaptureHook) Stop(connID string) {
+	fch.stopped = append(fch.stopped, connID)
+}
+
+func TestTrackConn(t *testing.T) {
+	conn := &net.TCPConn{}
+	ctx := context.WithValue(context.Background(), connInfoKey{}, &connInfo{ID: "abc", Conn: conn})
+
+	t.Run("without connection info", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		hook := &fakeCaptureHook{}
+		handler.CaptureHook = hook
+		handler.createSession("deadbeef")
+		handler.trackConn("deadbeef", httptest.NewRequest("GET", "/", nil))
+		if len(hook.started) != 0 {
+			t.Fatal("expected no capture")
+		}
+	})
+
+	t.Run("with missing session", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		hook := &fakeCaptureHook{}
+		handler.CaptureHook = hook
+		req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		handler.trackConn("deadbeef", req)
+		if len(hook.started) != 0 {
+			t.Fatal("expected no capture")
+		}
+	})
+
+	t.Run("without capture hook", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.createSession("deadbeef")
+		req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		handler.trackConn("deadbeef", req)
+		session := handler.popSession("deadbeef")
+		if len(session.serverSchema.ConnectionIDs) != 1 {
+			t.Fatal("expected to record the connection ID")
+		}
+	})
+
+	t.Run("with capture hook", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		hook := &fakeCaptureHook{}
+		handler.CaptureHook = hook
+		handler.createSession("deadbeef")
+		req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		handler.trackConn("deadbeef", req)
+		handler.trackConn("deadbeef", req) // should not start twice
+		if len(hook.started) != 1 || hook.started[0] != "abc" {
+			t.Fatal("unexpected started captures", hook.started)
+		}
+		session := handler.popSession("deadbeef")
+		if len(session.serverSchema.ConnectionIDs) != 1 {
+			t.Fatal("expected to record the connection ID once")
+		}
+		if len(hook.stopped) != 1 || hook.stopped[0] != "abc" {
+			t.Fatal("unexpected stopped captures", hook.stopped)
+		}
+	})
+
+	t.Run("when the capture hook fails", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		hook := &fakeCaptureHook{err: errors.New("Mocked error")}
+		handler.CaptureHook = hook
+		handler.createSession("deadbeef")
+		req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		handler.trackConn("deadbeef", req)
+		session := handler.popSession("deadbeef")
+		if len(session.serverSchema.ConnectionIDs) != 1 {
+			t.Fatal("expected to record the connection ID")
+		}
+		if len(hook.stopped) != 0 {
+			t.Fatal("expected not to stop a capture that did not start", hook.stopped)
+		}
+	})
+
+	t.Run("with a connection shared by two sessions", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		hook := &fakeCaptureHook{}
+		handler.CaptureHook = hook
+		handler.createSession("deadbeef")
+		handler.createSession("abad1dea")
+		req := httptest.NewRequest("GET", "/", nil).WithContext(ctx)
+		handler.trackConn("deadbeef", req)
+		handler.trackConn("abad1dea", req)
+		if len(hook.started) != 1 {
+			t.Fatal("unexpected started captures", hook.started)
+		}
+		handler.popSession("deadbeef")
+		if len(hook.stopped) != 0 {
+			t.Fatal("expected the other session to keep the capture", hook.stopped)
+		}
+		handler.popSession("abad1dea")
+		if len(hook.stopped) != 1 || hook.stopped[0] != "abc" {
+			t.Fatal("unexpected stopped captures", hook.stopped)
+		}
+	})
+}
+
+func TestReapStaleSessionsStopsCapture(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	hook := &fakeCaptureHook{}
+	handler.CaptureHook = hook
+	handler.createSession("deadbeef")
+	ctx := context.WithValue(context.Background(), connInfoKey{}, &connInfo{ID: "abc", Conn: &net.TCPConn{}})
+	handler.trackConn("deadbeef", httptest.NewRequest("GET", "/", nil).WithContext(ctx))
+	handler.sessions["deadbeef"].stamp = handler.sessions["deadbeef"].stamp.Add(-2 * sessionLifetime)
+	handler.reapStaleSessions()
+	if handler.CountSessions() != 0 {
+		t.Fatal("expected no sessions")
+	}
+	if len(hook.stopped) != 1 || hook.stopped[0] != "abc" {
+		t.Fatal("unexpected stopped captures", hook.stopped)
+	}
+}
+
+func TestCommandCaptureHook(t *testing.T) {
+	local := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8080}
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 54321}
+
+	t.Run("with empty command", func(t *testing.T) {
+		hook := &CommandCaptureHook{}
+		if err := hook.Start("abc", local, remote); !errors.Is(err, errEmptyCaptureCommand) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+
+	t.Run("with nonexistent command", func(t *testing.T) {
+		hook := &CommandCaptureHook{Argv: []string{"/nonexistent/{id}"}}
+		if err := hook.Start("abc", local, remote); err == nil {
+			t.Fatal("expected an error here")
+		}
+	})
+
+	t.Run("common case", func(t *testing.T) {
+		hook := &CommandCaptureHook{Argv: []string{"sleep", "30"}}
+		if err := hook.Start("abc", local, remote); err != nil {
+			t.Fatal(err)
+		}
+		if len(hook.cancels) != 1 {
+			t.Fatal("expected a running command")
+		}
+		hook.Stop("abc")
+		hook.Stop("abc") // should be idempotent
+		if len(hook.cancels) != 0 {
+			t.Fatal("expected no running commands")
+		}
+	})
+}
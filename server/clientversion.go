@@ -0,0 +1,112 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// clientLibraryName is the name of the client library in the User-Agent
+// header, which contains the "neubot-dash/<version>" product token.
+const clientLibraryName = "neubot-dash"
+
+// errInvalidClientVersion indicates that a client version is not valid.
+var errInvalidClientVersion = errors.New("invalid client version")
+
+// clientTooOldResponse is the JSON body of the 426 response we send to
+// clients older than the MinClientVersion.
+type clientTooOldResponse struct {
+	// Error is always "client_too_old".
+	Error string `json:"error"`
+
+	// MinVersion is the minimum client library version we accept.
+	MinVersion string `json:"min_version"`
+
+	// Version is the client library version or empty if unknown.
+	Version string `json:"version"`
+}
+
+// parseClientVersion parses a version consisting of dot separated
+// non-negative integers (e.g., "0.4.3") and returns its components.
+func parseClientVersion(value string) ([]int, error) {
+	var out []int
+	for _, part := range strings.Split(value, ".") {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 || strings.HasPrefix(part, "+") {
+			return nil, fmt.Errorf("%w: %q", errInvalidClientVersion, value)
+		}
+		out = append(out, number)
+	}
+	return out, nil
+}
+
+// ValidateClientVersion checks whether the given version (see the
+// Handler.MinClientVersion field) is a valid version.
+func ValidateClientVersion(value string) error {
+	_, err := parseClientVersion(value)
+	return err
+}
+
+// compareClientVersions returns a negative number, zero, or a positive
+// number when left is, respectively, older than, equal to, or newer than
+// right, where missing trailing components count as zero.
+func compareClientVersions(left, right []int) int {
+	for idx := 0; idx < max(len(left), len(right)); idx++ {
+		var lv, rv int
+		if idx < len(left) {
+			lv = left[idx]
+		}
+		if idx < len(right) {
+			rv = right[idx]
+		}
+		if lv != rv {
+			return lv - rv
+		}
+	}
+	return 0
+}
+
+// clientLibraryVersion returns the client library version contained
+// in the given User-Agent header or an empty string if there is none.
+func clientLibraryVersion(userAgent string) string {
+	for _, token := range strings.Fields(userAgent) {
+		if name, version, found := strings.Cut(token, "/"); found && name == clientLibraryName {
+			return version
+		}
+	}
+	return ""
+}
+
+// rejectOldClient returns true after responding with 426 when we require
+// a MinClientVersion and the client library is older or unknown.
+func (h *Handler) rejectOldClient(w http.ResponseWriter, r *http.Request) bool {
+	if h.MinClientVersion == "" {
+		return false
+	}
+	minimum, err := parseClientVersion(h.MinClientVersion)
+	if err != nil {
+		h.logger.Warnf("negotiate: %s", err.Error())
+		return false // we validate the configuration at startup
+	}
+	version := clientLibraryVersion(r.Header.Get("User-Agent"))
+	if current, err := parseClientVersion(version); err == nil && compareClientVersions(current, minimum) >= 0 {
+		return false
+	}
+	h.logger.Debugf("negotiate: rejecting client version %q", version)
+	scheme, proto := h.metricLabels(r)
+	rejectedClients.WithLabelValues(scheme, proto).Inc()
+	data, _ := json.Marshal(&clientTooOldResponse{ // cannot fail
+		Error:      "client_too_old",
+		MinVersion: h.MinClientVersion,
+		Version:    version,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	setFailureReason(w, "client too old: "+version)
+	w.WriteHeader(http.StatusUpgradeRequired)
+	_, _ = w.Write(data)
+	return true
+}
@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/spec"
+)
+
+func TestValidateClientVersion(t *testing.T) {
+	for _, value := range []string{"0.4.3", "1", "10.0.12"} {
+		if err := ValidateClientVersion(value); err != nil {
+			t.Fatal(value, err)
+		}
+	}
+	for _, value := range []string{"", "0.4.", "v0.4.3", "0.-1", "0.+1", "0.4.3-rc1"} {
+		if err := ValidateClientVersion(value); !errors.Is(err, errInvalidClientVersion) {
+			t.Fatal("not the error we expected", value, err)
+		}
+	}
+}
+
+func TestCompareClientVersions(t *testing.T) {
+	type testcase struct {
+		left, right string
+		expect      int
+	}
+	for _, tc := range []testcase{
+		{"0.4.3", "0.4.3", 0},
+		{"0.4", "0.4.0", 0},
+		{"0.4.2", "0.4.3", -1},
+		{"0.10.0", "0.9.9", 1},
+		{"1", "0.99", 1},
+	} {
+		left, _ := parseClientVersion(tc.left)
+		right, _ := parseClientVersion(tc.right)
+		result := compareClientVersions(left, right)
+		if (result < 0 && tc.expect >= 0) || (result == 0 && tc.expect != 0) || (result > 0 && tc.expect <= 0) {
+			t.Fatal("unexpected result", tc.left, tc.right, result)
+		}
+	}
+}
+
+func TestClientLibraryVersion(t *testing.T) {
+	if v := clientLibraryVersion("dash-client-go/0.4.3 neubot-dash/0.4.3"); v != "0.4.3" {
+		t.Fatal("unexpected version", v)
+	}
+	if v := clientLibraryVersion("Mozilla/5.0"); v != "" {
+		t.Fatal("unexpected version", v)
+	}
+}
+
+func TestServerNegotiateMinClientVersion(t *testing.T) {
+	negotiate := func(minVersion, userAgent string) *httptest.ResponseRecorder {
+		handler := NewHandler("", log.Log)
+		handler.MinClientVersion = minVersion
+		mux := http.NewServeMux()
+		handler.RegisterHandlers(mux)
+		req := httptest.NewRequest("POST", spec.NegotiatePath, strings.NewReader("{}"))
+		req.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("without a minimum version", func(t *testing.T) {
+		if w := negotiate("", "Mozilla/5.0"); w.Code != 200 {
+			t.Fatal("Expected different status code")
+		}
+	})
+
+	t.Run("with a recent enough client", func(t *testing.T) {
+		if w := negotiate("0.4.3", "dash-client-go/0.5.0 neubot-dash/0.4.3"); w.Code != 200 {
+			t.Fatal("Expected different status code")
+		}
+	})
+
+	for _, userAgent := range []string{"dash-client-go/0.4.3 neubot-dash/0.4.2", "Mozilla/5.0"} {
+		t.Run("with "+userAgent, func(t *testing.T) {
+			w := negotiate("0.4.3", userAgent)
+			if w.Code != http.StatusUpgradeRequired {
+				t.Fatal("Expected different status code")
+			}
+			var response clientTooOldResponse
+			if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+			if response.Error != "client_too_old" || response.MinVersion != "0.4.3" ||
+				response.Version != clientLibraryVersion(userAgent) {
+				t.Fatalf("unexpected response: %+v", response)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import "time"
+
+// Clock is the source of time of the [*Handler], which allows to write
+// fast and deterministic tests of the behavior depending on time (e.g., the
+// lifetime of sessions and the reaper) by simulating the passing of time.
+//
+// The deadlines of the connections (see MinDownloadRate) always use the
+// system time, because the kernel enforces them, hence they do not depend
+// on the Clock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After waits for the duration to elapse and then sends the
+	// current time on the returned channel, like [time.After].
+	After(d time.Duration) <-chan time.Time
+}
+
+// systemClock is the [Clock] using the system time.
+type systemClock struct{}
+
+var _ Clock = systemClock{}
+
+// Now implements Clock.
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// After implements Clock.
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// now returns the current time of the Clock using UTC.
+func (h *Handler) now() time.Time {
+	return h.Clock.Now().UTC()
+}
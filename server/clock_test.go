@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// fakeClock is a [Clock] whose time only passes when calling Advance.
+type fakeClock struct {
+	cond    *sync.Cond
+	mtx     sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+// fakeWaiter is a goroutine waiting on [*fakeClock.After].
+type fakeWaiter struct {
+	ch       chan time.Time
+	deadline time.Time
+}
+
+var _ Clock = &fakeClock{}
+
+func newFakeClock() *fakeClock {
+	clock := &fakeClock{now: time.Date(2024, 1, 29, 10, 0, 0, 0, time.UTC)}
+	clock.cond = sync.NewCond(&clock.mtx)
+	return clock
+}
+
+func (fc *fakeClock) Now() time.Time {
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+	return fc.now
+}
+
+func (fc *fakeClock) After(d time.Duration) <-chan time.Time {
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+	ch := make(chan time.Time, 1)
+	fc.waiters = append(fc.waiters, fakeWaiter{ch: ch, deadline: fc.now.Add(d)})
+	fc.cond.Broadcast()
+	return ch
+}
+
+// Advance moves the time forward, waking up the expired waiters.
+func (fc *fakeClock) Advance(d time.Duration) {
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+	fc.now = fc.now.Add(d)
+	var pending []fakeWaiter
+	for _, waiter := range fc.waiters {
+		if fc.now.Before(waiter.deadline) {
+			pending = append(pending, waiter)
+			continue
+		}
+		waiter.ch <- fc.now
+	}
+	fc.waiters = pending
+}
+
+// BlockUntil blocks until there are count waiters.
+func (fc *fakeClock) BlockUntil(count int) {
+	fc.mtx.Lock()
+	defer fc.mtx.Unlock()
+	for len(fc.waiters) != count {
+		fc.cond.Wait()
+	}
+}
+
+func TestHandlerClock(t *testing.T) {
+	t.Run("the reaper uses the clock", func(t *testing.T) {
+		clock := newFakeClock()
+		handler := NewHandler("", log.Log)
+		handler.Clock = clock
+		handler.createSession("deadbeef")
+		ctx, cancel := context.WithCancel(context.Background())
+		handler.StartReaper(ctx)
+		clock.BlockUntil(1) // the reaper is waiting
+		clock.Advance(time.Second)
+		if handler.CountSessions() != 1 {
+			t.Fatal("the reaper should not have run yet")
+		}
+		clock.Advance(sessionLifetime)
+		clock.BlockUntil(1) // the reaper has reaped and is waiting again
+		if handler.CountSessions() != 0 {
+			t.Fatal("the reaper should have reaped the stale session")
+		}
+		cancel()
+		handler.JoinReaper()
+	})
+
+	t.Run("draining uses the clock", func(t *testing.T) {
+		clock := newFakeClock()
+		handler := NewHandler("", log.Log)
+		handler.Clock = clock
+		handler.createSession("deadbeef")
+		done := make(chan error, 1)
+		go func() {
+			done <- handler.WaitDrained(context.Background())
+		}()
+		clock.BlockUntil(1) // we are waiting for the sessions to drain
+		handler.popSession("deadbeef")
+		clock.Advance(drainPollInterval)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("sessions use the clock", func(t *testing.T) {
+		clock := newFakeClock()
+		handler := NewHandler("", log.Log)
+		handler.Clock = clock
+		handler.createSession("deadbeef")
+		clock.Advance(3 * time.Second)
+		handler.updateSession("deadbeef", 1000)
+		server := handler.sessions["deadbeef"].serverSchema.Server
+		if len(server) != 1 || server[0].Ticks != 3 || server[0].Timestamp != clock.Now().Unix() {
+			t.Fatalf("unexpected server results: %+v", server)
+		}
+	})
+
+	t.Run("self-checks use the clock", func(t *testing.T) {
+		clock := newFakeClock()
+		clock.now = time.Unix(0, 0)
+		handler := NewHandler(t.TempDir(), log.Log)
+		handler.Clock = clock
+		if err := handler.SelfCheck(&SelfCheckConfig{}); err == nil {
+			t.Fatal("expected the clock self-check to fail")
+		}
+	})
+
+	t.Run("tombstones use the clock", func(t *testing.T) {
+		clock := newFakeClock()
+		handler := NewHandler("", log.Log)
+		handler.Clock = clock
+		handler.addTombstone("deadbeef", []byte("{}"))
+		if _, found := handler.getTombstone("deadbeef"); !found {
+			t.Fatal("expected to find the tombstone")
+		}
+		clock.Advance(tombstoneLifetime + time.Second)
+		if _, found := handler.getTombstone("deadbeef"); found {
+			t.Fatal("expected the tombstone to be expired")
+		}
+	})
+}
@@ -0,0 +1,57 @@
+package server
+
+import "github.com/neubot/dash/model"
+
+// maxStreams is the maximum number of parallel streams that we are
+// willing to approve for a session.
+const maxStreams = 4
+
+// approveStreams returns the number of parallel streams we approve given
+// the negotiate request, i.e., the number of requested streams bounded by
+// maxStreams, or zero when the client did not request multiple streams.
+func approveStreams(request model.NegotiateRequest) int64 {
+	streams := request.Streams
+	if request.MultiConnection && streams <= 0 {
+		streams = maxStreams
+	}
+	return max(0, min(streams, maxStreams))
+}
+
+// streams returns the number of downloads that the session may
+// perform at the same time, which is always at least one.
+func (s *sessionInfo) streams() int {
+	return max(1, int(s.request.Streams))
+}
+
+// beginDownload registers that the session with the given UUID started a
+// download and returns true, unless the session is already performing as many
+// downloads as the parallel streams approved during the negotiation (see the
+// Streams field of [model.NegotiateRequest]), in which case it returns false.
+// On success, the caller must call endDownload when the download is done.
+//
+// This method LOCKS and MUTATES the .sessions field.
+func (h *Handler) beginDownload(UUID string) bool {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	session, found := h.sessions[UUID]
+	if !found {
+		return true // nothing to account
+	}
+	if session.downloads >= session.streams() {
+		return false
+	}
+	session.downloads++
+	return true
+}
+
+// endDownload registers that the session with the given UUID finished
+// a download previously registered by beginDownload.
+//
+// This method LOCKS and MUTATES the .sessions field.
+func (h *Handler) endDownload(UUID string) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if session, found := h.sessions[UUID]; found && session.downloads > 0 {
+		session.downloads--
+	}
+}
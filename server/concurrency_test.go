@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/model"
+)
+
+func TestServerConcurrentDownloads(t *testing.T) {
+	download := func(handler *Handler) int {
+		req := httptest.NewRequest("GET", "/dash/download/1000", nil)
+		req.Header.Set(authorization, "deadbeef")
+		w := httptest.NewRecorder()
+		handler.download(w, req)
+		return w.Code
+	}
+
+	t.Run("with overlapping downloads", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.createSession("deadbeef")
+		if !handler.beginDownload("deadbeef") {
+			t.Fatal("expected to begin the first download")
+		}
+		if download(handler) != 409 {
+			t.Fatal("Expected different status code")
+		}
+		handler.endDownload("deadbeef")
+		if download(handler) != 200 {
+			t.Fatal("Expected different status code")
+		}
+		if handler.sessions["deadbeef"].downloads != 0 {
+			t.Fatal("expected no downloads in progress")
+		}
+	})
+
+	t.Run("with multiple streams", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		request := model.NegotiateRequest{Streams: 2}
+		handler.createNegotiatedSession("deadbeef", "", "", request, nil)
+		if !handler.beginDownload("deadbeef") {
+			t.Fatal("expected to begin the first download")
+		}
+		if download(handler) != 200 {
+			t.Fatal("Expected different status code")
+		}
+		if handler.sessions["deadbeef"].downloads != 1 {
+			t.Fatal("expected one download in progress")
+		}
+		if !handler.beginDownload("deadbeef") {
+			t.Fatal("expected to begin the second download")
+		}
+		if download(handler) != 409 {
+			t.Fatal("Expected different status code")
+		}
+	})
+
+	t.Run("with missing session", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		if !handler.beginDownload("deadbeef") {
+			t.Fatal("expected true for a missing session")
+		}
+		handler.endDownload("deadbeef") // should not panic
+	})
+}
+
+func TestApproveStreams(t *testing.T) {
+	for _, tc := range []struct {
+		request model.NegotiateRequest
+		expect  int64
+	}{
+		{model.NegotiateRequest{}, 0},
+		{model.NegotiateRequest{Streams: -1}, 0},
+		{model.NegotiateRequest{Streams: 2}, 2},
+		{model.NegotiateRequest{Streams: 100}, maxStreams},
+		{model.NegotiateRequest{MultiConnection: true}, maxStreams},
+		{model.NegotiateRequest{MultiConnection: true, Streams: 3}, 3},
+	} {
+		if streams := approveStreams(tc.request); streams != tc.expect {
+			t.Fatalf("approveStreams(%+v): expected %d, got %d", tc.request, tc.expect, streams)
+		}
+	}
+}
+
+func TestServerNegotiateStreams(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	data, err := json.Marshal(model.NegotiateRequest{Streams: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	handler.negotiate(w, httptest.NewRequest("POST", "/negotiate/dash", bytes.NewReader(data)))
+	if w.Code != 200 {
+		t.Fatal("Expected different status code")
+	}
+	var resp model.NegotiateResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Streams != maxStreams {
+		t.Fatal("unexpected number of approved streams", resp.Streams)
+	}
+	session := handler.popSession(resp.Authorization)
+	if session.serverSchema.Streams != maxStreams || session.streams() != maxStreams {
+		t.Fatal("unexpected number of streams in the session")
+	}
+}
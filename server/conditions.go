@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/neubot/dash/model"
+)
+
+const (
+	// maxDASHRates is the maximum number of rates negotiated by the
+	// client that we save with the results.
+	maxDASHRates = 64
+
+	// maxUserAgentLength is the maximum length of the User-Agent header
+	// that we save with the results.
+	maxUserAgentLength = 256
+)
+
+// recordTestConditions records in the given session the test parameters
+// negotiated by the client and a subset of the headers of the negotiate
+// request, so that the saved results capture the conditions of the test. To
+// protect the client privacy, we only save the User-Agent header, truncated
+// to maxUserAgentLength bytes, and the protocol version. The caller MUST own
+// the session, i.e., the session MUST NOT be inside .sessions yet.
+func (h *Handler) recordTestConditions(session *sessionInfo, r *http.Request) {
+	userAgent := r.Header.Get("User-Agent")
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = strings.ToValidUTF8(userAgent[:maxUserAgentLength], "")
+	}
+	session.serverSchema.ClientHeaders = &model.ClientHeaders{
+		Proto:     r.Proto,
+		UserAgent: userAgent,
+	}
+	session.serverSchema.Negotiated = &model.NegotiatedParameters{
+		DASHRates:       session.request.DASHRates,
+		MaxIterations:   session.maxIterations(h.maxIterations),
+		SegmentDuration: int64(session.segmentDuration() / time.Second),
+		StreamDuration:  session.request.StreamDuration,
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/model"
+)
+
+func TestRecordTestConditions(t *testing.T) {
+	t.Run("common case", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		request := model.NegotiateRequest{DASHRates: []int64{100, 200}, StreamDuration: 120}
+		req := httptest.NewRequest("POST", "/negotiate/dash", nil)
+		req.Header.Set("User-Agent", "dash-client-go/0.4.3 neubot-dash/0.4.3")
+		req.Header.Set("Cookie", "secret")
+		handler.createNegotiatedSession("deadbeef", "", "", request, req)
+		schema := handler.sessions["deadbeef"].serverSchema
+		headers := schema.ClientHeaders
+		if headers == nil || headers.Proto != "HTTP/1.1" || headers.UserAgent != "dash-client-go/0.4.3 neubot-dash/0.4.3" {
+			t.Fatalf("unexpected headers: %+v", headers)
+		}
+		negotiated := schema.Negotiated
+		if negotiated == nil || len(negotiated.DASHRates) != 2 || negotiated.StreamDuration != 120 {
+			t.Fatalf("unexpected negotiated parameters: %+v", negotiated)
+		}
+		if negotiated.MaxIterations != 60 || negotiated.SegmentDuration != 2 {
+			t.Fatalf("unexpected negotiated parameters: %+v", negotiated)
+		}
+	})
+
+	t.Run("with long User-Agent", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		session := &sessionInfo{}
+		req := httptest.NewRequest("POST", "/negotiate/dash", nil)
+		req.Header.Set("User-Agent", strings.Repeat("è", maxUserAgentLength))
+		handler.recordTestConditions(session, req)
+		userAgent := session.serverSchema.ClientHeaders.UserAgent
+		if len(userAgent) != maxUserAgentLength || !strings.HasPrefix(userAgent, "è") {
+			t.Fatal("unexpected User-Agent length", len(userAgent))
+		}
+	})
+
+	t.Run("when truncating splits a multi-byte rune", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		session := &sessionInfo{}
+		req := httptest.NewRequest("POST", "/negotiate/dash", nil)
+		// the first byte of the last "è" is the last byte we keep
+		req.Header.Set("User-Agent", "a"+strings.Repeat("è", maxUserAgentLength))
+		handler.recordTestConditions(session, req)
+		userAgent := session.serverSchema.ClientHeaders.UserAgent
+		if !utf8.ValidString(userAgent) || len(userAgent) != maxUserAgentLength-1 {
+			t.Fatal("unexpected User-Agent", len(userAgent), utf8.ValidString(userAgent))
+		}
+	})
+
+	t.Run("without the negotiate request", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.createSession("deadbeef")
+		schema := handler.sessions["deadbeef"].serverSchema
+		if schema.ClientHeaders != nil || schema.Negotiated != nil {
+			t.Fatalf("unexpected schema: %+v", schema)
+		}
+	})
+}
+
+func TestServerNegotiateTruncatesRates(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	body := "{\"dash_rates\": [" + strings.Repeat("100,", 2*maxDASHRates) + "100]}"
+	request, err := handler.readNegotiateRequest(
+		httptest.NewRecorder(), httptest.NewRequest("POST", "/negotiate/dash", strings.NewReader(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(request.DASHRates) != maxDASHRates {
+		t.Fatal("unexpected number of rates", len(request.DASHRates))
+	}
+}
+
+func TestServerNegotiateBodyTooLarge(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	body := strings.NewReader("{\"dash_rates\": [" + strings.Repeat("100,", maxNegotiateBodySize) + "100]}")
+	w := httptest.NewRecorder()
+	handler.negotiate(w, httptest.NewRequest("POST", "/negotiate/dash", body))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatal("Expected different status code", w.Code)
+	}
+	if handler.CountSessions() != 0 {
+		t.Fatal("expected no sessions")
+	}
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// connCounters contains the number of bytes read from and written to
+// a connection, including the TLS overhead, if any.
+type connCounters struct {
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+// Read returns the number of bytes read so far. This method returns zero
+// when the counters are nil, i.e., when we are not counting.
+func (cc *connCounters) Read() int64 {
+	if cc == nil {
+		return 0
+	}
+	return cc.read.Load()
+}
+
+// Written returns the number of bytes written so far. This method returns
+// zero when the counters are nil, i.e., when we are not counting.
+func (cc *connCounters) Written() int64 {
+	if cc == nil {
+		return 0
+	}
+	return cc.written.Load()
+}
+
+// countingConn is a [net.Conn] updating [connCounters].
+type countingConn struct {
+	net.Conn
+	counters *connCounters
+}
+
+// newCountingConn wraps the given conn to count the bytes it reads and writes.
+func newCountingConn(conn net.Conn) *countingConn {
+	return &countingConn{Conn: conn, counters: &connCounters{}}
+}
+
+// Read implements net.Conn.
+func (cc *countingConn) Read(data []byte) (int, error) {
+	count, err := cc.Conn.Read(data)
+	cc.counters.read.Add(int64(count))
+	return count, err
+}
+
+// Write implements net.Conn.
+func (cc *countingConn) Write(data []byte) (int, error) {
+	count, err := cc.Conn.Write(data)
+	cc.counters.written.Add(int64(count))
+	return count, err
+}
+
+// countersFromConn returns the counters of the [*countingConn] possibly
+// wrapped by the given conn (e.g., by a [*tls.Conn]) or nil.
+func countersFromConn(conn net.Conn) *connCounters {
+	for conn != nil {
+		switch value := conn.(type) {
+		case *countingConn:
+			return value.counters
+		case interface{ NetConn() net.Conn }:
+			conn = value.NetConn()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/spec"
+)
+
+func TestCountingConn(t *testing.T) {
+	left, right := net.Pipe()
+	defer left.Close()
+	defer right.Close()
+	conn := newCountingConn(left)
+	go func() {
+		buffer := make([]byte, 4)
+		right.Read(buffer)
+		right.Write([]byte("ab"))
+	}()
+	if _, err := conn.Write([]byte("abcd")); err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 4)
+	if _, err := conn.Read(buffer); err != nil {
+		t.Fatal(err)
+	}
+	if conn.counters.Written() != 4 {
+		t.Fatal("unexpected bytes written", conn.counters.Written())
+	}
+	if conn.counters.Read() != 2 {
+		t.Fatal("unexpected bytes read", conn.counters.Read())
+	}
+}
+
+func TestCountersFromConn(t *testing.T) {
+	t.Run("with nil counters", func(t *testing.T) {
+		var counters *connCounters
+		if counters.Read() != 0 || counters.Written() != 0 {
+			t.Fatal("expected zero")
+		}
+	})
+
+	t.Run("with a plain conn", func(t *testing.T) {
+		left, right := net.Pipe()
+		defer left.Close()
+		defer right.Close()
+		if countersFromConn(left) != nil {
+			t.Fatal("expected nil counters")
+		}
+	})
+
+	t.Run("with a counting conn", func(t *testing.T) {
+		left, right := net.Pipe()
+		defer left.Close()
+		defer right.Close()
+		conn := newCountingConn(left)
+		if countersFromConn(conn) != conn.counters {
+			t.Fatal("expected the conn counters")
+		}
+	})
+
+	t.Run("with a TLS conn", func(t *testing.T) {
+		left, right := net.Pipe()
+		defer left.Close()
+		defer right.Close()
+		conn := newCountingConn(left)
+		if countersFromConn(tls.Server(conn, &tls.Config{})) != conn.counters {
+			t.Fatal("expected the conn counters")
+		}
+	})
+}
+
+func TestDownloadRecordsWireBytes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := NewHandler("", log.Log)
+	mux := http.NewServeMux()
+	handler.RegisterHandlers(mux)
+	config := &ServeConfig{BaseContext: ctx, Logger: log.Log}
+	srvr, listeners, err := config.listen("127.0.0.1:0", mux)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go config.serve(srvr, listeners, srvr.Serve)
+	const session = "deadbeef"
+	handler.createSession(session)
+	req, err := http.NewRequest("GET", "http://"+listeners[0].Addr().String()+spec.DownloadPath+"1000", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", session)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := handler.popSession(session)
+	if len(info.serverSchema.Server) != 1 {
+		t.Fatal("expected a single result")
+	}
+	if info.serverSchema.Server[0].WireBytes <= int64(len(data)) {
+		t.Fatal("expected wire bytes to include headers", info.serverSchema.Server[0].WireBytes)
+	}
+}
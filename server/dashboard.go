@@ -0,0 +1,213 @@
+package server
+
+import (
+	_ "embed"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// recentSummaries is the number of recent sessions summaries we keep.
+const recentSummaries = 128
+
+// sessionSummary summarizes a completed session.
+type sessionSummary struct {
+	// ASN is the client ASN or zero if unknown.
+	ASN uint32
+
+	// Bytes is the number of bytes sent by the server.
+	Bytes int64
+
+	// Country is the client country code or empty if unknown.
+	Country string
+
+	// Iterations is the number of iterations performed.
+	Iterations int64
+
+	// MedianRate is the median rate (in kbit/s) measured by the client.
+	MedianRate float64
+
+	// Stamp is when the session was created.
+	Stamp time.Time
+}
+
+// summaryRing is a goroutine-safe ring buffer of [sessionSummary].
+type summaryRing struct {
+	// entries contains the summaries.
+	entries []sessionSummary
+
+	// mtx protects entries and next.
+	mtx sync.Mutex
+
+	// next is the index where to write the next summary.
+	next int
+}
+
+// newSummaryRing creates a new [*summaryRing] with the given capacity.
+func newSummaryRing(capacity int) *summaryRing {
+	return &summaryRing{
+		entries: make([]sessionSummary, 0, capacity),
+		mtx:     sync.Mutex{},
+		next:    0,
+	}
+}
+
+// add adds a summary possibly overwriting the oldest one.
+func (sr *summaryRing) add(summary sessionSummary) {
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+	if len(sr.entries) < cap(sr.entries) {
+		sr.entries = append(sr.entries, summary)
+		return
+	}
+	sr.entries[sr.next] = summary
+	sr.next = (sr.next + 1) % len(sr.entries)
+}
+
+// snapshot returns a copy of the summaries, the most recent first.
+func (sr *summaryRing) snapshot() []sessionSummary {
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+	out := make([]sessionSummary, 0, len(sr.entries))
+	for idx := len(sr.entries) - 1; idx >= 0; idx-- {
+		out = append(out, sr.entries[(sr.next+idx)%len(sr.entries)])
+	}
+	return out
+}
+
+// medianRate returns the median of the rates (in kbit/s) measured
+// by the client during the session, or zero if there are none.
+func (s *sessionInfo) medianRate() float64 {
+	var rates []float64
+	for _, result := range s.serverSchema.Client {
+		if result.Elapsed > 0 {
+			rates = append(rates, float64(result.Received)*8/1000/result.Elapsed)
+		}
+	}
+	return median(rates)
+}
+
+// median returns the median of the given values, or zero if there
+// are none. This function sorts the values in place.
+func median(values []float64) float64 {
+	if len(values) <= 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	if len(values)%2 == 0 {
+		return (values[len(values)/2-1] + values[len(values)/2]) / 2
+	}
+	return values[len(values)/2]
+}
+
+// summarize adds a summary of the given completed or reaped session to
+// the recent sessions shown by the dashboard and to the aggregates.
+func (h *Handler) summarize(session *sessionInfo) {
+	summary := sessionSummary{
+		Bytes:      session.bytes,
+		Iterations: session.iteration,
+		MedianRate: session.medianRate(),
+		Stamp:      session.stamp,
+	}
+	summary.ASN, summary.Country = h.lookupClient(session.address)
+	h.summaries.add(summary)
+	h.aggregates.add(summary)
+}
+
+// lookupClient returns the ASN and the country of the client with the given
+// address using ASNLookup and CountryLookup. The ASN is zero and the country
+// is empty when we do not know them.
+func (h *Handler) lookupClient(address string) (asn uint32, country string) {
+	if h.ASNLookup != nil && address != "" {
+		if value, err := h.ASNLookup(address); err == nil {
+			asn = value
+		}
+	}
+	if h.CountryLookup != nil && address != "" {
+		if value, err := h.CountryLookup(address); err == nil {
+			country = value
+		}
+	}
+	return
+}
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+// dashboardTemplate is the template of the dashboard page.
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(dashboardHTML))
+
+// dashboard implements the /admin/dashboard handler.
+func (h *Handler) dashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := dashboardTemplate.Execute(w, map[string]any{
+		"ActiveSessions": h.CountSessions(),
+		"Summaries":      h.summaries.snapshot(),
+	})
+	if err != nil {
+		h.logger.Warnf("dashboard: template.Execute: %s", err.Error())
+	}
+}
+
+// RegisterAdminHandlers registers the handlers meant to be used by the
+// server operators. You SHOULD NOT expose these handlers publicly. The
+// following prefixes are registered:
+//
+// - /admin/aggregates
+//
+// - /admin/dashboard
+//
+// - /admin/drain
+//
+// - /admin/export
+//
+// - /admin/failures
+//
+// - /admin/faults
+//
+// - /admin/health
+//
+// The /admin/aggregates prefix returns as JSON the number of sessions and
+// the median rate of the last hour grouped by client ASN and country
+// (see [*Handler.Aggregates]). We also export these statistics as the
+// dash_aggregate_sessions and dash_aggregate_median_rate_kbits metrics.
+//
+// The /admin/dashboard prefix is an HTML page showing the recent
+// sessions along with their median rate and client ASN.
+//
+// The /admin/drain prefix puts the server into drain mode when
+// invoked using POST (see [*Handler.Drain]).
+//
+// The /admin/export prefix streams the results saved during the last
+// 24 hours, or during the number of hours in the "hours" query parameter
+// (at most one week), which it finds using the index (see [IndexEntry]),
+// if enabled, and otherwise walking the "dash" directory of the datadir.
+// With the "format=ndjson" query parameter, which is the default, we stream
+// the uncompressed results, one per line. With "format=tar", we stream a
+// tar archive containing the results files and their signatures, if any.
+// This handler requires the ExportToken as a bearer token and returns 404
+// when the ExportToken is empty.
+//
+// The /admin/failures prefix returns as JSON the recent negotiate, download,
+// upload, and collect requests that failed with a 4xx or 5xx status, including
+// the path, the status, the session, and the reason (see [FailedRequest]).
+//
+// The /admin/faults prefix returns as JSON the faults we are injecting
+// when invoked using GET and replaces them with the JSON body when invoked
+// using PUT (e.g., `{"delay": 0.1, "jitter": 0.05, "error_rate": 0.1,
+// "truncate_rate": 0.05}`, where delays are in seconds). See [Faults].
+//
+// The /admin/health prefix returns as JSON the results of the startup
+// self-checks (see [*Handler.SelfCheck]) and whether we are draining. The
+// status code is 503 when a self-check failed or we are draining.
+func (h *Handler) RegisterAdminHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/admin/aggregates", h.aggregatesHandler)
+	mux.HandleFunc("/admin/dashboard", h.dashboard)
+	mux.HandleFunc("/admin/drain", h.drainHandler)
+	mux.HandleFunc("/admin/export", h.exportHandler)
+	mux.HandleFunc("/admin/failures", h.failuresHandler)
+	mux.HandleFunc("/admin/faults", h.faultsHandler)
+	mux.HandleFunc("/admin/health", h.healthHandler)
+}
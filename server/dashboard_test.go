@@ -0,0 +1,101 @@
+package server
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/model"
+)
+
+func TestSummaryRing(t *testing.T) {
+	ring := newSummaryRing(3)
+	for idx := int64(0); idx < 5; idx++ {
+		ring.add(sessionSummary{Iterations: idx})
+	}
+	summaries := ring.snapshot()
+	if len(summaries) != 3 {
+		t.Fatal("unexpected number of summaries", len(summaries))
+	}
+	for idx, expect := range []int64{4, 3, 2} {
+		if summaries[idx].Iterations != expect {
+			t.Fatal("unexpected summary at", idx, summaries[idx].Iterations)
+		}
+	}
+}
+
+func TestSessionMedianRate(t *testing.T) {
+	t.Run("without client results", func(t *testing.T) {
+		session := &sessionInfo{}
+		if session.medianRate() != 0 {
+			t.Fatal("expected zero")
+		}
+	})
+
+	t.Run("with client results", func(t *testing.T) {
+		session := &sessionInfo{}
+		for _, received := range []int64{1000, 3000, 2000, 4000} {
+			session.serverSchema.Client = append(session.serverSchema.Client, model.ClientResults{
+				Elapsed:  1,
+				Received: received,
+			})
+		}
+		if rate := session.medianRate(); rate != 20 {
+			t.Fatal("unexpected median rate", rate)
+		}
+	})
+}
+
+func TestServerDashboard(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	handler.ASNLookup = func(address string) (uint32, error) {
+		if address == "130.192.91.211" {
+			return 137, nil
+		}
+		return 0, errors.New("mocked error")
+	}
+	handler.createNegotiatedSession("deadbeef", "130.192.91.211", "https", model.NegotiateRequest{}, nil)
+	handler.summarize(handler.popSession("deadbeef"))
+	handler.createNegotiatedSession("deadc0de", "10.0.0.1", "http", model.NegotiateRequest{}, nil)
+	handler.summarize(handler.popSession("deadc0de"))
+	mux := http.NewServeMux()
+	handler.RegisterAdminHandlers(mux)
+	req := httptest.NewRequest("GET", "/admin/dashboard", nil)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, req)
+	resp := w.Result()
+	if resp.StatusCode != 200 {
+		t.Fatal("Expected different status code")
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "AS137") || !strings.Contains(string(data), "unknown") {
+		t.Fatal("unexpected dashboard", string(data))
+	}
+}
+
+func TestServerSummarizeReapedSessions(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	handler.CountryLookup = func(address string) (string, error) {
+		return "IT", nil
+	}
+	clock := newFakeClock()
+	handler.Clock = clock
+	handler.createNegotiatedSession("deadbeef", "130.192.91.211", "https", model.NegotiateRequest{}, nil)
+	clock.Advance(sessionLifetime + time.Second)
+	handler.reapStaleSessions()
+	summaries := handler.summaries.snapshot()
+	if len(summaries) != 1 || summaries[0].Country != "IT" {
+		t.Fatalf("unexpected summaries: %+v", summaries)
+	}
+	if stats := handler.Aggregates(); len(stats) != 1 || stats[0].Sessions != 1 || stats[0].Country != "IT" {
+		t.Fatalf("unexpected aggregates: %+v", stats)
+	}
+}
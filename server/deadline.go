@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+// DefaultMinDownloadRate is the default minimum plausible rate (in kbit/s)
+// at which clients receive segments (see [Handler.MinDownloadRate]), which
+// is the lowest rate in the default DASH rates vector.
+const DefaultMinDownloadRate = 100
+
+// downloadDeadlineGrace is the time we add to the time to send a segment
+// at the minimum plausible rate, which accounts for the TCP slow start
+// and for the client reading the response headers.
+const downloadDeadlineGrace = 10 * time.Second
+
+// downloadDeadline returns the maximum time to send a segment of the given
+// size at the MinDownloadRate, or zero if we should not set a deadline.
+func (h *Handler) downloadDeadline(count int) time.Duration {
+	if h.MinDownloadRate <= 0 {
+		return 0
+	}
+	bits := int64(count) * 8
+	return h.deadlineGrace + time.Duration(bits*int64(time.Millisecond)/h.MinDownloadRate)
+}
+
+// setDownloadDeadline sets the write deadline for sending a segment of the
+// given size, so that a stalled receiver cannot hold the handler goroutine
+// and the segment buffer indefinitely. The returned function clears the
+// deadline, which otherwise would also apply to the next requests using
+// the same HTTP/1.1 connection.
+func (h *Handler) setDownloadDeadline(w http.ResponseWriter, count int) func() {
+	timeout := h.downloadDeadline(count)
+	if timeout <= 0 {
+		return func() {}
+	}
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		if !errors.Is(err, http.ErrNotSupported) {
+			h.logger.Warnf("download: SetWriteDeadline: %s", err.Error())
+		}
+		return func() {}
+	}
+	return func() {
+		_ = rc.SetWriteDeadline(time.Time{})
+	}
+}
+
+// setUploadDeadline is like setDownloadDeadline but sets the read deadline
+// for receiving a segment of the given size, so that a stalled sender cannot
+// hold the handler goroutine indefinitely. Because the client adapts the
+// upload rate like it does when downloading, we use the same MinDownloadRate.
+func (h *Handler) setUploadDeadline(w http.ResponseWriter, count int) func() {
+	timeout := h.downloadDeadline(count)
+	if timeout <= 0 {
+		return func() {}
+	}
+	rc := http.NewResponseController(w)
+	if err := rc.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		if !errors.Is(err, http.ErrNotSupported) {
+			h.logger.Warnf("upload: SetReadDeadline: %s", err.Error())
+		}
+		return func() {}
+	}
+	return func() {
+		_ = rc.SetReadDeadline(time.Time{})
+	}
+}
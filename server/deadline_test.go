@@ -0,0 +1,111 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/spec"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestHandlerDownloadDeadline(t *testing.T) {
+	t.Run("with the default minimum rate", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		// 1250000 bytes are 10 Mbit, which take 100 s at 100 kbit/s
+		if deadline := handler.downloadDeadline(1250000); deadline != 100*time.Second+downloadDeadlineGrace {
+			t.Fatal("unexpected deadline", deadline)
+		}
+		if deadline := handler.downloadDeadline(0); deadline != downloadDeadlineGrace {
+			t.Fatal("unexpected deadline", deadline)
+		}
+	})
+
+	t.Run("without a minimum rate", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.MinDownloadRate = 0
+		if deadline := handler.downloadDeadline(maxSize); deadline != 0 {
+			t.Fatal("unexpected deadline", deadline)
+		}
+	})
+
+	t.Run("when the writer does not support deadlines", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		clear := handler.setDownloadDeadline(httptest.NewRecorder(), 1000)
+		clear() // should not panic
+	})
+
+	t.Run("we can download segments with the deadline", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		mux := http.NewServeMux()
+		handler.RegisterHandlers(mux)
+		srvr := httptest.NewServer(mux)
+		defer srvr.Close()
+		const session = "deadbeef"
+		handler.createSession(session)
+		for idx := 0; idx < 2; idx++ { // the second request reuses the connection
+			req, err := http.NewRequest("GET", srvr.URL+spec.DownloadPath+"1000", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", session)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != 200 || len(data) != minSize {
+				t.Fatal("Expected different status code")
+			}
+		}
+	})
+
+	t.Run("we cut off a stalled receiver", func(t *testing.T) {
+		expired := func() float64 {
+			value := &dto.Metric{}
+			if err := expiredDownloads.WithLabelValues("http", "HTTP/1.1").Write(value); err != nil {
+				t.Fatal(err)
+			}
+			return value.Counter.GetValue()
+		}
+		before := expired()
+		handler := NewHandler("", log.Log)
+		handler.MinDownloadRate = 1 << 40 // i.e., the deadline is about the grace
+		handler.deadlineGrace = 100 * time.Millisecond
+		mux := http.NewServeMux()
+		handler.RegisterHandlers(mux)
+		srvr := httptest.NewServer(mux)
+		defer srvr.Close()
+		const session = "deadbeef"
+		handler.createSession(session)
+		conn, err := net.Dial("tcp", srvr.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		// make sure that the segment cannot fit into the socket buffers
+		if err := conn.(*net.TCPConn).SetReadBuffer(4096); err != nil {
+			t.Fatal(err)
+		}
+		request := fmt.Sprintf("GET %s%d HTTP/1.1\r\nHost: %s\r\nAuthorization: %s\r\n\r\n",
+			spec.DownloadPath, maxSize, srvr.Listener.Addr().String(), session)
+		if _, err := io.WriteString(conn, request); err != nil {
+			t.Fatal(err)
+		}
+		// we never read the response, so the server should eventually give up
+		for started := time.Now(); expired()-before < 1; time.Sleep(10 * time.Millisecond) {
+			if time.Since(started) > 10*time.Second {
+				t.Fatal("the server did not cut off the stalled receiver")
+			}
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+const (
+	// drainRetryAfter is the Retry-After we send to clients that attempt
+	// to negotiate while we are draining. By then, either we have restarted
+	// or clients should use another server.
+	drainRetryAfter = sessionLifetime
+
+	// drainPollInterval is the interval for checking whether we drained.
+	drainPollInterval = 250 * time.Millisecond
+)
+
+// Drain puts the handler into drain mode for maintenance. In this mode, the
+// existing sessions continue, while new negotiations fail with 503 and a
+// Retry-After header. Use [*Handler.WaitDrained] to know when there are no
+// sessions left. It is safe to call this method more than once.
+func (h *Handler) Drain() {
+	h.drainOnce.Do(func() {
+		h.logger.Info("drain: entering into drain mode")
+		close(h.drain)
+	})
+}
+
+// Draining returns whether the handler is in drain mode.
+func (h *Handler) Draining() bool {
+	select {
+	case <-h.drain:
+		return true
+	default:
+		return false
+	}
+}
+
+// DrainStarted returns a channel closed when we enter into drain mode.
+func (h *Handler) DrainStarted() <-chan any {
+	return h.drain
+}
+
+// WaitDrained blocks until there are no sessions left or the context is
+// done, in which case it returns the context error. Because the reaper
+// removes stale sessions, you should make sure it's running.
+func (h *Handler) WaitDrained(ctx context.Context) error {
+	for h.CountSessions() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-h.Clock.After(drainPollInterval):
+		}
+	}
+	return nil
+}
+
+// drainHandler implements the /admin/drain handler.
+func (h *Handler) drainHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	h.Drain()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+func TestDrain(t *testing.T) {
+	t.Run("negotiate while draining", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		if handler.Draining() {
+			t.Fatal("expected not to be draining")
+		}
+		handler.Drain()
+		handler.Drain() // should be idempotent
+		if !handler.Draining() {
+			t.Fatal("expected to be draining")
+		}
+		req := httptest.NewRequest("POST", "/negotiate/dash", nil)
+		w := httptest.NewRecorder()
+		handler.negotiate(w, req)
+		resp := w.Result()
+		if resp.StatusCode != http.StatusServiceUnavailable {
+			t.Fatal("Expected different status code")
+		}
+		if resp.Header.Get("Retry-After") != "60" {
+			t.Fatal("unexpected Retry-After", resp.Header.Get("Retry-After"))
+		}
+	})
+
+	t.Run("admin handler", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		mux := http.NewServeMux()
+		handler.RegisterAdminHandlers(mux)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/admin/drain", nil))
+		if w.Result().StatusCode != http.StatusMethodNotAllowed {
+			t.Fatal("Expected different status code")
+		}
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/admin/drain", nil))
+		if w.Result().StatusCode != http.StatusNoContent {
+			t.Fatal("Expected different status code")
+		}
+		select {
+		case <-handler.DrainStarted():
+		default:
+			t.Fatal("expected to be draining")
+		}
+	})
+}
+
+func TestWaitDrained(t *testing.T) {
+	t.Run("with no sessions", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		if err := handler.WaitDrained(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("with sessions until the deadline", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.createSession("deadbeef")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := handler.WaitDrained(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+
+	t.Run("with sessions that terminate", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.createSession("deadbeef")
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			handler.popSession("deadbeef")
+		}()
+		if err := handler.WaitDrained(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultExportHours is the default number of hours of results
+	// returned by the /admin/export handler.
+	defaultExportHours = 24
+
+	// maxExportHours is the maximum number of hours of results
+	// returned by the /admin/export handler.
+	maxExportHours = 7 * 24
+)
+
+// These are the formats supported by the /admin/export handler.
+const (
+	exportFormatNDJSON = "ndjson"
+	exportFormatTar    = "tar"
+)
+
+// exportHandler implements the /admin/export handler.
+func (h *Handler) exportHandler(w http.ResponseWriter, r *http.Request) {
+	// 1. make sure the export is enabled and the request is authenticated
+	if h.ExportToken == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if !h.authenticatedExport(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="dash"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// 2. parse the parameters
+	hours := defaultExportHours
+	if value := r.URL.Query().Get("hours"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 || parsed > maxExportHours {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		hours = parsed
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = exportFormatNDJSON
+	}
+	if format != exportFormatNDJSON && format != exportFormatTar {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// 3. find the recent results using the index, if enabled, which
+	// is faster than walking the "dash" directory of the datadir
+	since := h.now().Add(-time.Duration(hours) * time.Hour)
+	lookup := h.walkResults
+	if h.IndexMaxBytes > 0 {
+		lookup = h.readIndex
+	}
+	entries, err := lookup(since)
+	if err != nil {
+		h.logger.Warnf("exportHandler: %s", err.Error())
+		w.WriteHeader(500)
+		return
+	}
+
+	// 4. stream the results, skipping the files that we cannot read
+	// (e.g., because the operator removed them)
+	filename := "dash-export-" + h.now().Format("20060102T150405Z") + "." + format
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if format == exportFormatTar {
+		w.Header().Set("Content-Type", "application/x-tar")
+		err = h.exportTar(w, entries)
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		err = h.exportNDJSON(w, entries)
+	}
+	if err != nil {
+		h.logger.Warnf("exportHandler: %s", err.Error())
+	}
+}
+
+// authenticatedExport returns whether the request contains the
+// ExportToken as a bearer token in the Authorization header.
+func (h *Handler) authenticatedExport(r *http.Request) bool {
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(token), []byte(h.ExportToken)) == 1
+}
+
+// exportNDJSON writes the uncompressed results of the given
+// entries, which are single line JSON documents, as NDJSON.
+func (h *Handler) exportNDJSON(w io.Writer, entries []IndexEntry) error {
+	for _, entry := range entries {
+		data, err := h.readExportFile(entry.Path)
+		if err != nil {
+			continue
+		}
+		zipper, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			h.logger.Warnf("exportNDJSON: gzip.NewReader: %s", err.Error())
+			continue
+		}
+		data, err = io.ReadAll(zipper)
+		if err != nil {
+			h.logger.Warnf("exportNDJSON: io.ReadAll: %s", err.Error())
+			continue
+		}
+		if _, err := w.Write(append(bytes.TrimSpace(data), '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exportTar writes a tar archive containing the results files of the given
+// entries, along with their signatures, if any, using the paths relative
+// to the datadir, so that one can extract the archive into a datadir.
+func (h *Handler) exportTar(w io.Writer, entries []IndexEntry) error {
+	archive := tar.NewWriter(w)
+	for _, entry := range entries {
+		for _, name := range []string{entry.Path, entry.Path + signatureSuffix} {
+			data, err := h.readExportFile(name)
+			if err != nil {
+				continue
+			}
+			header := &tar.Header{
+				ModTime:  entry.Timestamp,
+				Mode:     0644,
+				Name:     name,
+				Size:     int64(len(data)),
+				Typeflag: tar.TypeReg,
+			}
+			if err := archive.WriteHeader(header); err != nil {
+				return err
+			}
+			if _, err := archive.Write(data); err != nil {
+				return err
+			}
+		}
+	}
+	return archive.Close()
+}
+
+// walkResults is like readIndex but walks the "dash" directory of the
+// datadir, which we do when there is no index (see IndexMaxBytes), and
+// uses the time in the name of each results file (see savedata). The
+// returned entries only contain the path and the timestamp.
+func (h *Handler) walkResults(since time.Time) ([]IndexEntry, error) {
+	root := filepath.Join(h.datadir, "dash")
+	entries := []IndexEntry{}
+	err := filepath.WalkDir(root, func(name string, entry fs.DirEntry, err error) error {
+		if name == root && errors.Is(err, fs.ErrNotExist) {
+			return fs.SkipAll // we have not saved any results yet
+		}
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		stamp, found := resultsFileTime(entry.Name())
+		if !found || stamp.Before(since) {
+			return nil
+		}
+		relative, err := filepath.Rel(h.datadir, name)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, IndexEntry{Path: filepath.ToSlash(relative), Timestamp: stamp})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(entries, func(a, b IndexEntry) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return entries, nil
+}
+
+// resultsFileTime returns the time in the name of a results file (see
+// savedata) and whether the given name is the name of a results file.
+func resultsFileTime(name string) (time.Time, bool) {
+	value, found := strings.CutPrefix(name, resultsFilePrefix)
+	if !found {
+		return time.Time{}, false
+	}
+	value, found = strings.CutSuffix(value, resultsFileSuffix)
+	if !found {
+		return time.Time{}, false
+	}
+	stamp, err := time.Parse(resultsFileTimeFormat, value)
+	return stamp, err == nil
+}
+
+// errInvalidExportPath indicates that an index entry contains a path
+// that does not refer to a results file inside the datadir.
+var errInvalidExportPath = errors.New("invalid export path")
+
+// readExportFile reads the file with the given path relative to the datadir,
+// making sure that it is inside the "dash" directory, so that a corrupted
+// index cannot cause us to export other files.
+func (h *Handler) readExportFile(name string) ([]byte, error) {
+	if !filepath.IsLocal(name) || !strings.HasPrefix(path.Clean(name), "dash/") {
+		return nil, errInvalidExportPath
+	}
+	return os.ReadFile(filepath.Join(h.datadir, filepath.FromSlash(name)))
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"archive/tar"
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/model"
+)
+
+func TestServerExport(t *testing.T) {
+	datadir := t.TempDir()
+	handler := NewHandler(datadir, log.Log)
+	handler.ExportToken = "s3cr3t"
+	handler.IndexMaxBytes = 16 << 20
+	for idx, stamp := range []time.Time{timeNowUTC().Add(-48 * time.Hour), timeNowUTC()} {
+		UUID := []string{"old", "new"}[idx]
+		handler.createNegotiatedSession(UUID, "", "https", model.NegotiateRequest{}, nil)
+		session := handler.popSession(UUID)
+		session.stamp = stamp
+		if err := handler.savedata(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mux := http.NewServeMux()
+	handler.RegisterAdminHandlers(mux)
+	export := func(query, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/admin/export"+query, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("without authentication", func(t *testing.T) {
+		for _, token := range []string{"", "wrong"} {
+			if w := export("", token); w.Code != http.StatusUnauthorized {
+				t.Fatal("Expected different status code")
+			}
+		}
+	})
+
+	t.Run("with ndjson format", func(t *testing.T) {
+		for _, tc := range []struct {
+			query  string
+			expect int
+		}{{
+			query:  "",
+			expect: 1,
+		}, {
+			query:  "?hours=72&format=ndjson",
+			expect: 2,
+		}} {
+			w := export(tc.query, "s3cr3t")
+			if w.Code != http.StatusOK {
+				t.Fatal("Expected different status code")
+			}
+			var count int
+			scanner := bufio.NewScanner(w.Body)
+			for scanner.Scan() {
+				var schema model.ServerSchema
+				if err := json.Unmarshal(scanner.Bytes(), &schema); err != nil {
+					t.Fatal(err)
+				}
+				if schema.Scheme != "https" {
+					t.Fatal("unexpected scheme", schema.Scheme)
+				}
+				count++
+			}
+			if count != tc.expect {
+				t.Fatal("unexpected number of results", tc.query, count)
+			}
+		}
+	})
+
+	t.Run("with tar format", func(t *testing.T) {
+		w := export("?format=tar", "s3cr3t")
+		if w.Code != http.StatusOK {
+			t.Fatal("Expected different status code")
+		}
+		archive := tar.NewReader(w.Body)
+		header, err := archive.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Size <= 0 || len(header.Name) < len("dash/") || header.Name[:5] != "dash/" {
+			t.Fatalf("unexpected header: %+v", header)
+		}
+		if _, err := archive.Next(); err != io.EOF {
+			t.Fatal("expected a single file", err)
+		}
+	})
+
+	t.Run("with invalid parameters", func(t *testing.T) {
+		for _, query := range []string{"?hours=0", "?hours=1000", "?hours=x", "?format=zip"} {
+			if w := export(query, "s3cr3t"); w.Code != http.StatusBadRequest {
+				t.Fatal("Expected different status code", query)
+			}
+		}
+	})
+
+	t.Run("with index disabled", func(t *testing.T) {
+		handler.IndexMaxBytes = 0
+		defer func() { handler.IndexMaxBytes = 16 << 20 }()
+		for query, expect := range map[string]int{"": 1, "?hours=72": 2} {
+			w := export(query, "s3cr3t")
+			if w.Code != http.StatusOK {
+				t.Fatal("Expected different status code")
+			}
+			if count := strings.Count(w.Body.String(), "\n"); count != expect {
+				t.Fatal("unexpected number of results", query, count)
+			}
+		}
+	})
+
+	t.Run("with export disabled", func(t *testing.T) {
+		handler.ExportToken = ""
+		defer func() { handler.ExportToken = "s3cr3t" }()
+		if w := export("", "s3cr3t"); w.Code != http.StatusNotFound {
+			t.Fatal("Expected different status code")
+		}
+	})
+}
+
+func TestServerReadExportFile(t *testing.T) {
+	handler := NewHandler(t.TempDir(), log.Log)
+	for _, name := range []string{"../etc/passwd", "/etc/passwd", "other/file.json.gz", "dash/../other"} {
+		if _, err := handler.readExportFile(name); err != errInvalidExportPath {
+			t.Fatal("not the error we expected", name, err)
+		}
+	}
+}
+
+func TestServerWalkResults(t *testing.T) {
+	t.Run("without any results", func(t *testing.T) {
+		handler := NewHandler(t.TempDir(), log.Log)
+		entries, err := handler.walkResults(time.Time{})
+		if err != nil || len(entries) != 0 {
+			t.Fatal("unexpected result", entries, err)
+		}
+	})
+
+	t.Run("we only return the recent results files", func(t *testing.T) {
+		datadir := t.TempDir()
+		dirname := filepath.Join(datadir, "dash", "2024", "01", "29")
+		if err := os.MkdirAll(dirname, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{
+			"neubot-dash-20240129T110000.000000000Z.json.gz",
+			"neubot-dash-20240129T100000.000000000Z.json.gz",
+			"neubot-dash-20240129T100000.000000000Z.json.gz" + signatureSuffix,
+			"neubot-dash-20240129T090000.000000000Z.json.gz",
+			"neubot-dash-invalid.json.gz",
+			indexFileName,
+		} {
+			if err := os.WriteFile(filepath.Join(dirname, name), []byte("{}"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		handler := NewHandler(datadir, log.Log)
+		entries, err := handler.walkResults(time.Date(2024, 1, 29, 10, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 {
+			t.Fatal("unexpected number of entries", entries)
+		}
+		if entries[0].Path != "dash/2024/01/29/neubot-dash-20240129T100000.000000000Z.json.gz" ||
+			!entries[1].Timestamp.Equal(time.Date(2024, 1, 29, 11, 0, 0, 0, time.UTC)) {
+			t.Fatalf("unexpected entries: %+v", entries)
+		}
+	})
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// recentFailures is the number of recent failed requests we keep.
+const recentFailures = 128
+
+// FailedRequest describes a request that failed with a 4xx or 5xx status.
+type FailedRequest struct {
+	// Path is the URL path of the request.
+	Path string `json:"path"`
+
+	// Reason explains why the request failed.
+	Reason string `json:"reason"`
+
+	// Session is the session UUID or empty if the client did not send one.
+	Session string `json:"session"`
+
+	// Stamp is when the request failed.
+	Stamp time.Time `json:"stamp"`
+
+	// Status is the status code we sent to the client.
+	Status int `json:"status"`
+}
+
+// failureRing is a goroutine-safe ring buffer of [FailedRequest].
+type failureRing struct {
+	// entries contains the failed requests.
+	entries []FailedRequest
+
+	// mtx protects entries and next.
+	mtx sync.Mutex
+
+	// next is the index where to write the next failed request.
+	next int
+}
+
+// newFailureRing creates a new [*failureRing] with the given capacity.
+func newFailureRing(capacity int) *failureRing {
+	return &failureRing{
+		entries: make([]FailedRequest, 0, capacity),
+		mtx:     sync.Mutex{},
+		next:    0,
+	}
+}
+
+// add adds a failed request possibly overwriting the oldest one.
+func (fr *failureRing) add(failure FailedRequest) {
+	fr.mtx.Lock()
+	defer fr.mtx.Unlock()
+	if len(fr.entries) < cap(fr.entries) {
+		fr.entries = append(fr.entries, failure)
+		return
+	}
+	fr.entries[fr.next] = failure
+	fr.next = (fr.next + 1) % len(fr.entries)
+}
+
+// snapshot returns a copy of the failed requests, the most recent first.
+func (fr *failureRing) snapshot() []FailedRequest {
+	fr.mtx.Lock()
+	defer fr.mtx.Unlock()
+	out := make([]FailedRequest, 0, len(fr.entries))
+	for idx := len(fr.entries) - 1; idx >= 0; idx-- {
+		out = append(out, fr.entries[(fr.next+idx)%len(fr.entries)])
+	}
+	return out
+}
+
+// failureRecorder is an [http.ResponseWriter] that remembers the
+// status code and the reason of a failure (see setFailureReason).
+type failureRecorder struct {
+	http.ResponseWriter
+
+	// reason is the reason of the failure or empty.
+	reason string
+
+	// status is the status code or zero if we did not send headers.
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (fr *failureRecorder) WriteHeader(status int) {
+	if fr.status == 0 {
+		fr.status = status
+	}
+	fr.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter.
+func (fr *failureRecorder) Write(data []byte) (int, error) {
+	if fr.status == 0 {
+		fr.status = http.StatusOK
+	}
+	return fr.ResponseWriter.Write(data)
+}
+
+// Unwrap allows [http.ResponseController] to reach the real writer.
+func (fr *failureRecorder) Unwrap() http.ResponseWriter {
+	return fr.ResponseWriter
+}
+
+// setFailureReason saves the reason why we are failing the request, which
+// we show in the /admin/failures page. This function is a no-op when the
+// request is not wrapped by recordFailures.
+func setFailureReason(w http.ResponseWriter, reason string) {
+	if fr, ok := w.(*failureRecorder); ok {
+		fr.reason = reason
+	}
+}
+
+// recordFailures wraps the given handler to remember the requests that fail
+// with a 4xx or 5xx status, so operators can debug client incompatibilities
+// without enabling debug logging. When the handler does not explain why
+// the request failed, we use the status text as the reason.
+func (h *Handler) recordFailures(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recorder := &failureRecorder{ResponseWriter: w}
+		handler(recorder, r)
+		if recorder.status < 400 {
+			return
+		}
+		reason := recorder.reason
+		if reason == "" {
+			reason = http.StatusText(recorder.status)
+		}
+		h.failures.add(FailedRequest{
+			Path:    r.URL.Path,
+			Reason:  reason,
+			Session: r.Header.Get(authorization),
+			Stamp:   h.now(),
+			Status:  recorder.status,
+		})
+	}
+}
+
+// FailedRequests returns the recent requests that failed with a 4xx
+// or 5xx status, the most recent first.
+func (h *Handler) FailedRequests() []FailedRequest {
+	return h.failures.snapshot()
+}
+
+// failuresHandler implements the /admin/failures handler.
+func (h *Handler) failuresHandler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.Marshal(h.FailedRequests())
+	if err != nil {
+		h.logger.Warnf("failuresHandler: json.Marshal: %s", err.Error())
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+func TestFailureRing(t *testing.T) {
+	ring := newFailureRing(3)
+	for idx := 0; idx < 5; idx++ {
+		ring.add(FailedRequest{Status: 400 + idx})
+	}
+	failures := ring.snapshot()
+	if len(failures) != 3 {
+		t.Fatal("unexpected number of failures", len(failures))
+	}
+	for idx, expect := range []int{404, 403, 402} {
+		if failures[idx].Status != expect {
+			t.Fatal("unexpected failure at", idx, failures[idx].Status)
+		}
+	}
+}
+
+func TestServerFailedRequests(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	mux := http.NewServeMux()
+	handler.RegisterHandlers(mux)
+	handler.RegisterAdminHandlers(mux)
+
+	t.Run("we remember why a request failed", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/dash/download/1000", nil)
+		req.Header.Set(authorization, "nonexistent")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != 400 {
+			t.Fatal("Expected different status code")
+		}
+		failures := handler.FailedRequests()
+		if len(failures) != 1 {
+			t.Fatal("unexpected number of failures", len(failures))
+		}
+		if failures[0].Path != "/dash/download/1000" || failures[0].Reason != "session missing" ||
+			failures[0].Session != "nonexistent" || failures[0].Status != 400 {
+			t.Fatalf("unexpected failure: %+v", failures[0])
+		}
+	})
+
+	t.Run("we do not remember unknown paths", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		mux := http.NewServeMux()
+		handler.RegisterHandlers(mux)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/wp-login.php", nil))
+		if w.Code != 404 {
+			t.Fatal("Expected different status code")
+		}
+		if failures := handler.FailedRequests(); len(failures) != 0 {
+			t.Fatalf("unexpected failures: %+v", failures)
+		}
+	})
+
+	t.Run("we use the status text without a reason", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		wrapped := handler.recordFailures(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		})
+		wrapped(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		failures := handler.FailedRequests()
+		if len(failures) != 1 || failures[0].Reason != http.StatusText(http.StatusTeapot) {
+			t.Fatalf("unexpected failures: %+v", failures)
+		}
+	})
+
+	t.Run("we do not remember successful requests", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		wrapped := handler.recordFailures(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		})
+		wrapped(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+		if failures := handler.FailedRequests(); len(failures) != 0 {
+			t.Fatalf("unexpected failures: %+v", failures)
+		}
+	})
+
+	t.Run("the admin handler returns the failures", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/admin/failures", nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != 200 {
+			t.Fatal("Expected different status code")
+		}
+		var failures []FailedRequest
+		if err := json.Unmarshal(w.Body.Bytes(), &failures); err != nil {
+			t.Fatal(err)
+		}
+		if len(failures) != 1 || failures[0].Reason != "session missing" {
+			t.Fatalf("unexpected failures: %+v", failures)
+		}
+	})
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// faultStatusCodes contains the status codes we use when injecting errors.
+var faultStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+}
+
+// errInvalidFaults indicates that the [Faults] are not valid.
+var errInvalidFaults = errors.New("invalid faults: expected non-negative delays and rates between 0 and 1")
+
+// Faults configures the faults we inject for testing how clients (e.g.,
+// their ABR algorithms and their retry logic) cope with adverse server
+// behavior without using external network emulators. The zero value,
+// which is what NewHandler configures, does not inject any fault. See
+// [*Handler.SetFaults] and the /admin/faults handler.
+type Faults struct {
+	// Delay is the delay we add before serving each request.
+	Delay time.Duration
+
+	// ErrorRate is the probability, between 0 and 1, of failing each
+	// request with a status code randomly chosen among 429, 500, 502,
+	// and 503. With 429 we also send a one second Retry-After.
+	ErrorRate float64
+
+	// Jitter is the maximum random delay we add to Delay.
+	Jitter time.Duration
+
+	// TruncateRate is the probability, between 0 and 1, of closing the
+	// connection after sending half of each download response body.
+	TruncateRate float64
+}
+
+// faultsJSON is the JSON representation of [Faults] used by the
+// /admin/faults handler, where durations are in seconds.
+type faultsJSON struct {
+	Delay        float64 `json:"delay"`
+	ErrorRate    float64 `json:"error_rate"`
+	Jitter       float64 `json:"jitter"`
+	TruncateRate float64 `json:"truncate_rate"`
+}
+
+// ValidateFaults checks whether the given faults are valid, i.e., whether
+// the delays are not negative and the rates are between 0 and 1.
+func ValidateFaults(faults Faults) error {
+	if faults.Delay < 0 || faults.Jitter < 0 {
+		return errInvalidFaults
+	}
+	if !(faults.ErrorRate >= 0 && faults.ErrorRate <= 1) {
+		return errInvalidFaults
+	}
+	if !(faults.TruncateRate >= 0 && faults.TruncateRate <= 1) {
+		return errInvalidFaults
+	}
+	return nil
+}
+
+// SetFaults validates and configures the faults to inject. It is safe
+// to call this method while the handler is serving requests.
+func (h *Handler) SetFaults(faults Faults) error {
+	if err := ValidateFaults(faults); err != nil {
+		return err
+	}
+	h.faultsMtx.Lock()
+	defer h.faultsMtx.Unlock()
+	if faults != h.faults {
+		h.logger.Warnf("faults: injecting %+v", faults)
+	}
+	h.faults = faults
+	return nil
+}
+
+// Faults returns the faults we are currently injecting.
+func (h *Handler) Faults() Faults {
+	h.faultsMtx.Lock()
+	defer h.faultsMtx.Unlock()
+	return h.faults
+}
+
+// injectFaults wraps the given handler to delay the request and possibly
+// fail it according to the configured faults.
+func (h *Handler) injectFaults(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		faults := h.Faults()
+		scheme, proto := h.metricLabels(r)
+
+		// 1. delay the request
+		delay := faults.Delay
+		if faults.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(faults.Jitter) + 1))
+		}
+		if delay > 0 {
+			injectedFaults.WithLabelValues("delay", scheme, proto).Inc()
+			select {
+			case <-r.Context().Done():
+				return
+			case <-h.Clock.After(delay):
+			}
+		}
+
+		// 2. possibly fail the request
+		if faults.ErrorRate > 0 && rand.Float64() < faults.ErrorRate {
+			injectedFaults.WithLabelValues("error", scheme, proto).Inc()
+			code := faultStatusCodes[rand.Intn(len(faultStatusCodes))]
+			if code == http.StatusTooManyRequests {
+				w.Header().Set("Retry-After", "1")
+			}
+			setFailureReason(w, "injected fault")
+			w.WriteHeader(code)
+			return
+		}
+
+		// 3. serve the request
+		handler(w, r)
+	}
+}
+
+// shouldTruncate returns whether to truncate the body of the given download.
+func (h *Handler) shouldTruncate(r *http.Request) bool {
+	faults := h.Faults()
+	if faults.TruncateRate > 0 && rand.Float64() < faults.TruncateRate {
+		scheme, proto := h.metricLabels(r)
+		injectedFaults.WithLabelValues("truncate", scheme, proto).Inc()
+		return true
+	}
+	return false
+}
+
+// faultsHandler implements the /admin/faults handler.
+func (h *Handler) faultsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		faults := h.Faults()
+		data, err := json.Marshal(&faultsJSON{
+			Delay:        faults.Delay.Seconds(),
+			ErrorRate:    faults.ErrorRate,
+			Jitter:       faults.Jitter.Seconds(),
+			TruncateRate: faults.TruncateRate,
+		})
+		if err != nil {
+			h.logger.Warnf("faultsHandler: json.Marshal: %s", err.Error())
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+
+	case "PUT":
+		var value faultsJSON
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<12)).Decode(&value); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		err := h.SetFaults(Faults{
+			Delay:        time.Duration(value.Delay * float64(time.Second)),
+			ErrorRate:    value.ErrorRate,
+			Jitter:       time.Duration(value.Jitter * float64(time.Second)),
+			TruncateRate: value.TruncateRate,
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,214 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestValidateFaults(t *testing.T) {
+	valid := []Faults{
+		{},
+		{Delay: time.Second, ErrorRate: 1, Jitter: time.Second, TruncateRate: 0.5},
+	}
+	for _, faults := range valid {
+		if err := ValidateFaults(faults); err != nil {
+			t.Fatal(err)
+		}
+	}
+	invalid := []Faults{
+		{Delay: -1},
+		{Jitter: -1},
+		{ErrorRate: 1.1},
+		{ErrorRate: -0.1},
+		{TruncateRate: 2},
+	}
+	for _, faults := range invalid {
+		if err := ValidateFaults(faults); err != errInvalidFaults {
+			t.Fatal("expected an error for", faults)
+		}
+	}
+}
+
+func TestServerInjectFaults(t *testing.T) {
+	counter := func(kind string) float64 {
+		value := &dto.Metric{}
+		if err := injectedFaults.WithLabelValues(kind, "http", "HTTP/1.1").Write(value); err != nil {
+			t.Fatal(err)
+		}
+		return value.Counter.GetValue()
+	}
+	served := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	}
+
+	t.Run("without faults", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		w := httptest.NewRecorder()
+		handler.injectFaults(served)(w, httptest.NewRequest("POST", "/negotiate/dash", nil))
+		if w.Code != 204 {
+			t.Fatal("Expected different status code")
+		}
+	})
+
+	t.Run("with errors", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		if err := handler.SetFaults(Faults{ErrorRate: 1}); err != nil {
+			t.Fatal(err)
+		}
+		before := counter("error")
+		w := httptest.NewRecorder()
+		handler.injectFaults(served)(w, httptest.NewRequest("POST", "/negotiate/dash", nil))
+		if !slices.Contains(faultStatusCodes, w.Code) {
+			t.Fatal("Expected different status code")
+		}
+		if w.Code == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+			t.Fatal("expected a Retry-After header")
+		}
+		if counter("error") != before+1 {
+			t.Fatal("expected the counter to increase")
+		}
+	})
+
+	t.Run("with delay and jitter", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		faults := Faults{Delay: 50 * time.Millisecond, Jitter: 10 * time.Millisecond}
+		if err := handler.SetFaults(faults); err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		started := time.Now()
+		handler.injectFaults(served)(w, httptest.NewRequest("POST", "/negotiate/dash", nil))
+		if w.Code != 204 {
+			t.Fatal("Expected different status code")
+		}
+		if time.Since(started) < faults.Delay {
+			t.Fatal("expected the request to be delayed")
+		}
+	})
+
+	t.Run("when the client gives up during the delay", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		if err := handler.SetFaults(Faults{Delay: time.Hour}); err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		req := httptest.NewRequest("POST", "/negotiate/dash", nil).WithContext(ctx)
+		w := httptest.NewRecorder()
+		handler.injectFaults(func(w http.ResponseWriter, r *http.Request) {
+			t.Fatal("should not be called")
+		})(w, req)
+	})
+
+	t.Run("with invalid faults", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		if err := handler.SetFaults(Faults{ErrorRate: 2}); err != errInvalidFaults {
+			t.Fatal("expected an error")
+		}
+		if handler.Faults() != (Faults{}) {
+			t.Fatal("expected the faults not to change")
+		}
+	})
+}
+
+func TestServerTruncateDownload(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	if err := handler.SetFaults(Faults{TruncateRate: 1}); err != nil {
+		t.Fatal(err)
+	}
+	handler.createSession("deadbeef")
+	mux := http.NewServeMux()
+	handler.RegisterHandlers(mux)
+	srvr := httptest.NewServer(mux)
+	defer srvr.Close()
+	req, err := http.NewRequest("GET", srvr.URL+"/dash/download/2000", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(authorization, "deadbeef")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatal("Expected different status code")
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != io.ErrUnexpectedEOF {
+		t.Fatal("expected a truncated body, got", err)
+	}
+	handler.mtx.Lock()
+	defer handler.mtx.Unlock()
+	results := handler.sessions["deadbeef"].serverSchema.Server
+	if len(results) != 1 || !results[0].Truncated || results[0].Aborted || results[0].Sent != int64(len(data)) {
+		t.Fatalf("unexpected server results: %+v", results)
+	}
+}
+
+func TestServerFaultsHandler(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	mux := http.NewServeMux()
+	handler.RegisterAdminHandlers(mux)
+
+	t.Run("PUT with valid faults", func(t *testing.T) {
+		body := `{"delay": 0.1, "error_rate": 0.25, "jitter": 0.05, "truncate_rate": 0.5}`
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("PUT", "/admin/faults", strings.NewReader(body)))
+		if w.Code != http.StatusNoContent {
+			t.Fatal("Expected different status code")
+		}
+		expected := Faults{
+			Delay:        100 * time.Millisecond,
+			ErrorRate:    0.25,
+			Jitter:       50 * time.Millisecond,
+			TruncateRate: 0.5,
+		}
+		if handler.Faults() != expected {
+			t.Fatal("unexpected faults", handler.Faults())
+		}
+	})
+
+	t.Run("GET", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", "/admin/faults", nil))
+		if w.Code != 200 {
+			t.Fatal("Expected different status code")
+		}
+		var value faultsJSON
+		if err := json.Unmarshal(w.Body.Bytes(), &value); err != nil {
+			t.Fatal(err)
+		}
+		if value.Delay != 0.1 || value.ErrorRate != 0.25 || value.TruncateRate != 0.5 {
+			t.Fatalf("unexpected faults: %+v", value)
+		}
+	})
+
+	t.Run("PUT with invalid faults", func(t *testing.T) {
+		for _, body := range []string{`{"error_rate": 3}`, `{`} {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest("PUT", "/admin/faults", strings.NewReader(body)))
+			if w.Code != http.StatusBadRequest {
+				t.Fatal("Expected different status code")
+			}
+		}
+	})
+
+	t.Run("unsupported method", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", "/admin/faults", nil))
+		if w.Code != http.StatusMethodNotAllowed {
+			t.Fatal("Expected different status code")
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// fmp4Overhead is the size of the boxes preceding the mdat payload
+	// of the fragments we generate (see genfragment).
+	fmp4Overhead = 24 + 92 + 8
+
+	// fmp4Timescale is the timescale of the fragments (i.e., 90 kHz).
+	fmp4Timescale = 90000
+
+	// fmp4Duration is the duration of each fragment in fmp4Timescale
+	// units, i.e., the two seconds of the segments requested by clients.
+	fmp4Duration = 2 * fmp4Timescale
+)
+
+// errFragmentTooSmall indicates that the segment cannot contain a fragment.
+var errFragmentTooSmall = errors.New("segment too small for a fragment")
+
+// appendBox appends to data the header of a box of the given type.
+func appendBox(data []byte, kind string, size uint32) []byte {
+	data = binary.BigEndian.AppendUint32(data, size)
+	return append(data, kind...)
+}
+
+// appendFullBox is like appendBox but also appends version and flags.
+func appendFullBox(data []byte, kind string, size uint32, version uint8, flags uint32) []byte {
+	data = appendBox(data, kind, size)
+	return binary.BigEndian.AppendUint32(data, uint32(version)<<24|flags&0xffffff)
+}
+
+// genfragment generates a segment of the given size containing a CMAF media
+// fragment of a single track (ISO/IEC 14496-12 and 23000-19), i.e., a styp
+// box, followed by a moof box, followed by an mdat box containing a single
+// random sample. The fragment with the given iteration index starts where
+// the previous one ends, so that the fragments of a session form a sequence.
+// Because we do not send the initialization segment, one cannot decode the
+// fragments, but they are syntactically valid, which is enough for traffic
+// classifiers to see real video containers rather than opaque random bytes.
+func (h *Handler) genfragment(count *int, iteration int64) ([]byte, error) {
+	clampSize(count)
+	if *count < fmp4Overhead {
+		return nil, errFragmentTooSmall
+	}
+	sample := uint32(*count - fmp4Overhead)
+
+	// 1. the segment type box declaring a CMAF media segment
+	data := make([]byte, 0, *count)
+	data = appendBox(data, "styp", 24)
+	data = append(data, "msdh"...)
+	data = binary.BigEndian.AppendUint32(data, 0)
+	data = append(data, "msdh"...)
+	data = append(data, "cmfs"...)
+
+	// 2. the movie fragment box describing the sample
+	data = appendBox(data, "moof", 92)
+	data = appendFullBox(data, "mfhd", 16, 0, 0)
+	data = binary.BigEndian.AppendUint32(data, uint32(iteration+1))
+	data = appendBox(data, "traf", 68)
+	data = appendFullBox(data, "tfhd", 16, 0, 0x020000) // default-base-is-moof
+	data = binary.BigEndian.AppendUint32(data, 1)       // track ID
+	data = appendFullBox(data, "tfdt", 20, 1, 0)
+	data = binary.BigEndian.AppendUint64(data, uint64(iteration)*fmp4Duration)
+	data = appendFullBox(data, "trun", 24, 0, 0x000201) // data offset and sample size
+	data = binary.BigEndian.AppendUint32(data, 1)       // sample count
+	data = binary.BigEndian.AppendUint32(data, 92+8)    // offset of the sample from the moof
+	data = binary.BigEndian.AppendUint32(data, sample)
+
+	// 3. the media data box containing the random sample
+	data = appendBox(data, "mdat", 8+sample)
+	data = data[:*count]
+	if _, err := h.deps.RandRead(data[fmp4Overhead:]); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+// parseBoxes returns the types and sizes of the top-level boxes.
+func parseBoxes(t *testing.T, data []byte) ([]string, []int) {
+	var kinds []string
+	var sizes []int
+	for len(data) > 0 {
+		if len(data) < 8 {
+			t.Fatal("truncated box header")
+		}
+		size := int(binary.BigEndian.Uint32(data))
+		if size < 8 || size > len(data) {
+			t.Fatal("invalid box size", size)
+		}
+		kinds = append(kinds, string(data[4:8]))
+		sizes = append(sizes, size)
+		data = data[size:]
+	}
+	return kinds, sizes
+}
+
+func TestServerGenfragment(t *testing.T) {
+	t.Run("common case", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		count := 100000
+		data, err := handler.genfragment(&count, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data) != count {
+			t.Fatal("unexpected size", len(data))
+		}
+		kinds, sizes := parseBoxes(t, data)
+		if len(kinds) != 3 || kinds[0] != "styp" || kinds[1] != "moof" || kinds[2] != "mdat" {
+			t.Fatal("unexpected boxes", kinds)
+		}
+		moof := data[sizes[0] : sizes[0]+sizes[1]]
+		children, _ := parseBoxes(t, moof[8:])
+		if len(children) != 2 || children[0] != "mfhd" || children[1] != "traf" {
+			t.Fatal("unexpected moof children", children)
+		}
+		if sequence := binary.BigEndian.Uint32(moof[8+12:]); sequence != 3 {
+			t.Fatal("unexpected sequence number", sequence)
+		}
+		trun := moof[len(moof)-24:]
+		if string(trun[4:8]) != "trun" {
+			t.Fatal("expected trun to be the last box")
+		}
+		offset := int(binary.BigEndian.Uint32(trun[16:]))
+		sample := int(binary.BigEndian.Uint32(trun[20:]))
+		if sizes[0]+offset+sample != len(data) || sample != sizes[2]-8 {
+			t.Fatal("the sample is not the mdat payload", offset, sample)
+		}
+	})
+
+	t.Run("when the size is clamped", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		count := 10
+		data, err := handler.genfragment(&count, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != minSize || len(data) != minSize {
+			t.Fatal("unexpected size", len(data))
+		}
+	})
+
+	t.Run("when the random generator fails", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		expected := errors.New("mocked error")
+		handler.deps.RandRead = func(p []byte) (int, error) {
+			return 0, expected
+		}
+		count := minSize
+		if _, err := handler.genfragment(&count, 0); !errors.Is(err, expected) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+}
+
+func TestServerFragmentedMP4(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	handler.FragmentedMP4 = true
+	w := httptest.NewRecorder()
+	handler.negotiate(w, httptest.NewRequest("POST", "/negotiate/dash", nil))
+	if w.Code != 200 {
+		t.Fatal("Expected different status code")
+	}
+	if len(handler.sessions) != 1 {
+		t.Fatal("expected a session")
+	}
+	for UUID, session := range handler.sessions {
+		if session.seed != nil {
+			t.Fatal("expected no seed")
+		}
+		req := httptest.NewRequest("GET", "/dash/download/30000", nil)
+		req.Header.Set(authorization, UUID)
+		w := httptest.NewRecorder()
+		handler.download(w, req)
+		if w.Code != 200 {
+			t.Fatal("Expected different status code")
+		}
+		if kinds, _ := parseBoxes(t, w.Body.Bytes()); len(kinds) != 3 {
+			t.Fatal("expected a fragment", kinds)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// forwardedForHeader is the header where a trusted proxy (e.g., a reverse
+// proxy or a MASQUE front) puts the chain of addresses through which the
+// request was proxied.
+const forwardedForHeader = "X-Forwarded-For"
+
+// forwardedAddress returns the address of the client that a trusted proxy
+// forwarded. We walk the X-Forwarded-For header from right to left and
+// return the first address that is not a trusted proxy, because the entries
+// on the left are under the control of the client. The boolean is false when
+// the request does not come from a trusted proxy or the header does not
+// contain a valid address.
+func (h *Handler) forwardedAddress(r *http.Request) (string, bool) {
+	if !h.isTrustedProxy(r.RemoteAddr) {
+		return "", false
+	}
+	entries := strings.Split(r.Header.Get(forwardedForHeader), ",")
+	for idx := len(entries) - 1; idx >= 0; idx-- {
+		addr, err := parseForwardedAddr(strings.TrimSpace(entries[idx]))
+		if err != nil {
+			return "", false
+		}
+		if !h.isTrustedAddr(addr) {
+			return addr.String(), true
+		}
+	}
+	return "", false
+}
+
+// parseForwardedAddr parses an X-Forwarded-For entry, which some proxies
+// write including the port (e.g., "[2001:db8::1]:443").
+func parseForwardedAddr(entry string) (netip.Addr, error) {
+	if addrport, err := netip.ParseAddrPort(entry); err == nil {
+		return addrport.Addr().Unmap(), nil
+	}
+	addr, err := netip.ParseAddr(entry)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap(), nil
+}
+
+// realAddress returns the address of the client, taking into account
+// the trusted proxy, if any (see forwardedAddress).
+func (h *Handler) realAddress(r *http.Request) string {
+	if address, found := h.forwardedAddress(r); found {
+		return address
+	}
+	return clientAddress(r)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+func TestServerForwardedAddress(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	handler.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	for _, tc := range []struct {
+		name          string
+		remoteAddr    string
+		forwardedFor  string
+		expectAddress string
+	}{{
+		name:          "from untrusted client",
+		remoteAddr:    "130.192.91.211:54321",
+		forwardedFor:  "1.2.3.4",
+		expectAddress: "130.192.91.211",
+	}, {
+		name:          "from trusted proxy",
+		remoteAddr:    "10.1.2.3:54321",
+		forwardedFor:  "130.192.91.211",
+		expectAddress: "130.192.91.211",
+	}, {
+		name:          "with spoofed entries and chained proxies",
+		remoteAddr:    "10.1.2.3:54321",
+		forwardedFor:  "1.2.3.4, [2001:db8::1]:443, 10.4.5.6",
+		expectAddress: "2001:db8::1",
+	}, {
+		name:          "with invalid entry",
+		remoteAddr:    "10.1.2.3:54321",
+		forwardedFor:  "130.192.91.211, garbage",
+		expectAddress: "10.1.2.3",
+	}, {
+		name:          "without header",
+		remoteAddr:    "10.1.2.3:54321",
+		expectAddress: "10.1.2.3",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/negotiate/dash", nil)
+			req.RemoteAddr = tc.remoteAddr
+			if tc.forwardedFor != "" {
+				req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+			}
+			if address := handler.realAddress(req); address != tc.expectAddress {
+				t.Fatal("unexpected address", address)
+			}
+		})
+	}
+
+	t.Run("without trusted proxies", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		req := httptest.NewRequest("POST", "/negotiate/dash", nil)
+		req.RemoteAddr = "10.1.2.3:54321"
+		req.Header.Set("X-Forwarded-For", "130.192.91.211")
+		if address := handler.realAddress(req); address != "10.1.2.3" {
+			t.Fatal("unexpected address", address)
+		}
+	})
+}
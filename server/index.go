@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// DefaultIndexMaxBytes is the default value of Handler.IndexMaxBytes,
+	// i.e., no index, because the index is inside the datadir, hence the
+	// pusher would upload it along with the results.
+	DefaultIndexMaxBytes = 0
+
+	// indexFileName is the name of the index inside the "dash"
+	// directory of the datadir.
+	indexFileName = "index.jsonl"
+
+	// indexMaxRotated is the number of rotated index files we keep
+	// (e.g., "index.jsonl.1", "index.jsonl.2", ...).
+	indexMaxRotated = 3
+)
+
+// IndexEntry is a line of the JSONL index of completed sessions, which we
+// write into the "dash/index.jsonl" file of the datadir, so that one can list
+// the recent results without reading the results files. When the index grows
+// larger than Handler.IndexMaxBytes, we rename it to "index.jsonl.1", after
+// renaming older indexes to "index.jsonl.2" and so on, keeping at most three
+// rotated indexes. The newest entries are at the end of "index.jsonl".
+type IndexEntry struct {
+	// ASN is the client ASN or zero (and omitted) if unknown.
+	ASN uint32 `json:"asn,omitempty"`
+
+	// MedianRate is the median rate (in kbit/s) measured by the client.
+	MedianRate float64 `json:"median_rate"`
+
+	// Path is the path of the results file relative to the datadir.
+	Path string `json:"path"`
+
+	// Timestamp is when the session was created.
+	Timestamp time.Time `json:"timestamp"`
+
+	// UUID is the UUID of the session.
+	UUID string `json:"uuid"`
+}
+
+// appendIndex appends to the index the entry describing the given session
+// whose results we saved into the file with the given path relative to the
+// datadir. Because the index is only a convenience, we log errors rather
+// than returning them.
+func (h *Handler) appendIndex(session *sessionInfo, filename string) {
+	if h.IndexMaxBytes <= 0 {
+		return
+	}
+	entry := IndexEntry{
+		MedianRate: session.medianRate(),
+		Path:       filename,
+		Timestamp:  session.stamp,
+		UUID:       session.uuid,
+	}
+	entry.ASN, _ = h.lookupClient(session.address)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		h.logger.Warnf("appendIndex: json.Marshal: %s", err.Error())
+		return
+	}
+	h.indexMtx.Lock()
+	defer h.indexMtx.Unlock()
+	name := filepath.Join(h.datadir, "dash", indexFileName)
+	if err := h.rotateIndex(name); err != nil {
+		h.logger.Warnf("appendIndex: rotateIndex: %s", err.Error())
+		return
+	}
+	filep, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		h.logger.Warnf("appendIndex: os.OpenFile: %s", err.Error())
+		return
+	}
+	_, err = filep.Write(append(data, '\n'))
+	if closeErr := filep.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		h.logger.Warnf("appendIndex: %s", err.Error())
+	}
+}
+
+// rotateIndex rotates the index with the given name when it is
+// larger than IndexMaxBytes. The caller MUST hold indexMtx.
+func (h *Handler) rotateIndex(name string) error {
+	info, err := os.Stat(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() < h.IndexMaxBytes {
+		return nil
+	}
+	for idx := indexMaxRotated - 1; idx > 0; idx-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", name, idx), fmt.Sprintf("%s.%d", name, idx+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(name, name+".1")
+}
+
+// readIndex returns the entries of the index, including the rotated
+// indexes, created after the given time, from the oldest to the newest.
+func (h *Handler) readIndex(since time.Time) ([]IndexEntry, error) {
+	files, err := h.openIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer closeAll(files)
+	entries := []IndexEntry{}
+	for _, filep := range files {
+		scanner := bufio.NewScanner(filep)
+		for scanner.Scan() {
+			var entry IndexEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue // we may have crashed while appending
+			}
+			if !entry.Timestamp.Before(since) {
+				entries = append(entries, entry)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// openIndex opens the index, including the rotated indexes, from the
+// oldest to the newest. We only hold indexMtx while opening, so that a
+// concurrent rotation cannot cause us to miss indexes, and then we read
+// without holding it, which is safe because appendIndex only appends and
+// renaming does not affect the open files.
+func (h *Handler) openIndex() ([]*os.File, error) {
+	h.indexMtx.Lock()
+	defer h.indexMtx.Unlock()
+	name := filepath.Join(h.datadir, "dash", indexFileName)
+	var files []*os.File
+	for idx := indexMaxRotated; idx >= 0; idx-- {
+		current := name
+		if idx > 0 {
+			current = fmt.Sprintf("%s.%d", name, idx)
+		}
+		filep, err := os.Open(current)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			closeAll(files)
+			return nil, err
+		}
+		files = append(files, filep)
+	}
+	return files, nil
+}
+
+// closeAll closes all the given files.
+func closeAll(files []*os.File) {
+	for _, filep := range files {
+		filep.Close()
+	}
+}
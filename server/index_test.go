@@ -0,0 +1,100 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/model"
+)
+
+func TestServerIndex(t *testing.T) {
+	readIndex := func(t *testing.T, name string) (entries []IndexEntry) {
+		filep, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer filep.Close()
+		scanner := bufio.NewScanner(filep)
+		for scanner.Scan() {
+			var entry IndexEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, entry)
+		}
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	save := func(t *testing.T, handler *Handler, UUID string, stamp time.Time) {
+		handler.createNegotiatedSession(UUID, "130.192.91.211", "https", model.NegotiateRequest{}, nil)
+		session := handler.popSession(UUID)
+		session.stamp = stamp
+		if err := handler.savedata(session); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("appends an entry for each saved session", func(t *testing.T) {
+		datadir := t.TempDir()
+		handler := NewHandler(datadir, log.Log)
+		handler.IndexMaxBytes = 16 << 20
+		handler.ASNLookup = func(address string) (uint32, error) {
+			return 137, nil
+		}
+		stamp := time.Date(2024, time.January, 29, 20, 23, 0, 0, time.UTC)
+		save(t, handler, "deadbeef", stamp)
+		save(t, handler, "cafebabe", stamp.Add(time.Second))
+		entries := readIndex(t, filepath.Join(datadir, "dash", indexFileName))
+		if len(entries) != 2 || entries[0].UUID != "deadbeef" || entries[1].UUID != "cafebabe" {
+			t.Fatalf("unexpected entries: %+v", entries)
+		}
+		if entries[0].ASN != 137 || !entries[0].Timestamp.Equal(stamp) {
+			t.Fatalf("unexpected entry: %+v", entries[0])
+		}
+		expectPath := "dash/2024/01/29/neubot-dash-20240129T202300.000000000Z.json.gz"
+		if entries[0].Path != expectPath {
+			t.Fatal("unexpected path", entries[0].Path)
+		}
+		if _, err := os.Stat(filepath.Join(datadir, entries[0].Path)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("rotates the index", func(t *testing.T) {
+		datadir := t.TempDir()
+		handler := NewHandler(datadir, log.Log)
+		handler.IndexMaxBytes = 1 // rotate each time
+		stamp := time.Date(2024, time.January, 29, 20, 23, 0, 0, time.UTC)
+		for idx := 0; idx < indexMaxRotated+3; idx++ {
+			UUID := fmt.Sprintf("session-%d", idx)
+			save(t, handler, UUID, stamp.Add(time.Duration(idx)*time.Second))
+		}
+		name := filepath.Join(datadir, "dash", indexFileName)
+		if entries := readIndex(t, name); len(entries) != 1 || entries[0].UUID != "session-5" {
+			t.Fatalf("unexpected entries: %+v", entries)
+		}
+		if entries := readIndex(t, name+".3"); len(entries) != 1 || entries[0].UUID != "session-2" {
+			t.Fatalf("unexpected entries: %+v", entries)
+		}
+		if _, err := os.Stat(fmt.Sprintf("%s.%d", name, indexMaxRotated+1)); !os.IsNotExist(err) {
+			t.Fatal("expected to keep at most indexMaxRotated rotated indexes")
+		}
+	})
+
+	t.Run("when disabled", func(t *testing.T) {
+		datadir := t.TempDir()
+		handler := NewHandler(datadir, log.Log) // disabled by default
+		save(t, handler, "deadbeef", time.Now())
+		if _, err := os.Stat(filepath.Join(datadir, "dash", indexFileName)); !os.IsNotExist(err) {
+			t.Fatal("expected no index")
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrAddressNotFound indicates that the [*IP2ASNDatabase] does not
+// know the network containing the given address.
+var ErrAddressNotFound = errors.New("address not found")
+
+// IP2ASNDatabase maps IP addresses to autonomous system numbers and countries
+// using the database published by https://iptoasn.com/ (e.g., the IPv4 and
+// IPv6 ip2asn-combined.tsv.gz file). Each line of the database contains, using
+// tabs as separators, the first and the last address of a range, the ASN,
+// the country code, and the description of the autonomous system. Use its
+// LookupASN and LookupCountry methods as the ASNLookup and CountryLookup
+// of a [*Handler]. The zero value is an empty database.
+type IP2ASNDatabase struct {
+	// ranges contains the routed ranges sorted by first address.
+	ranges []ip2asnRange
+}
+
+// ip2asnRange is a range of [*IP2ASNDatabase].
+type ip2asnRange struct {
+	asn     uint32
+	country string
+	first   netip.Addr
+	last    netip.Addr
+}
+
+// LoadIP2ASNDatabase loads the [*IP2ASNDatabase] from the given file, which
+// we decompress on the fly when its name ends with the ".gz" suffix.
+func LoadIP2ASNDatabase(filename string) (*IP2ASNDatabase, error) {
+	filep, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer filep.Close()
+	var reader io.Reader = filep
+	if strings.HasSuffix(filename, ".gz") {
+		gzreader, err := gzip.NewReader(filep)
+		if err != nil {
+			return nil, err
+		}
+		defer gzreader.Close()
+		reader = gzreader
+	}
+	return ParseIP2ASNDatabase(reader)
+}
+
+// ParseIP2ASNDatabase parses the [*IP2ASNDatabase] from the given reader. We
+// skip the ranges that are not routed, i.e., whose ASN is zero.
+func ParseIP2ASNDatabase(reader io.Reader) (*IP2ASNDatabase, error) {
+	db := &IP2ASNDatabase{}
+	scanner := bufio.NewScanner(reader)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry, err := parseIP2ASNLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineno, err)
+		}
+		if entry.asn != 0 {
+			db.ranges = append(db.ranges, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return db.ranges[i].first.Less(db.ranges[j].first)
+	})
+	return db, nil
+}
+
+// errInvalidIP2ASNLine indicates that a line of the database is invalid.
+var errInvalidIP2ASNLine = errors.New("invalid ip2asn line")
+
+// parseIP2ASNLine parses a line of the database.
+func parseIP2ASNLine(line string) (ip2asnRange, error) {
+	fields := strings.Split(line, "\t")
+	if len(fields) < 4 {
+		return ip2asnRange{}, errInvalidIP2ASNLine
+	}
+	first, err := netip.ParseAddr(fields[0])
+	if err != nil {
+		return ip2asnRange{}, err
+	}
+	last, err := netip.ParseAddr(fields[1])
+	if err != nil {
+		return ip2asnRange{}, err
+	}
+	if first.Is4() != last.Is4() || last.Less(first) {
+		return ip2asnRange{}, errInvalidIP2ASNLine
+	}
+	asn, err := strconv.ParseUint(fields[2], 10, 32)
+	if err != nil {
+		return ip2asnRange{}, err
+	}
+	return ip2asnRange{
+		asn:     uint32(asn),
+		country: fields[3],
+		first:   first.Unmap(),
+		last:    last.Unmap(),
+	}, nil
+}
+
+// lookup returns the range containing the given address.
+func (db *IP2ASNDatabase) lookup(address string) (ip2asnRange, error) {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return ip2asnRange{}, err
+	}
+	addr = addr.Unmap().WithZone("")
+	idx := sort.Search(len(db.ranges), func(i int) bool {
+		return addr.Less(db.ranges[i].first)
+	}) - 1 // i.e., the last range whose first address is not after addr
+	if idx < 0 || db.ranges[idx].last.Less(addr) {
+		return ip2asnRange{}, ErrAddressNotFound
+	}
+	return db.ranges[idx], nil
+}
+
+// LookupASN returns the ASN of the given address or an error.
+func (db *IP2ASNDatabase) LookupASN(address string) (uint32, error) {
+	entry, err := db.lookup(address)
+	if err != nil {
+		return 0, err
+	}
+	return entry.asn, nil
+}
+
+// LookupCountry returns the country code of the given address or an error. The
+// database uses "None" for routed ranges whose country is unknown.
+func (db *IP2ASNDatabase) LookupCountry(address string) (string, error) {
+	entry, err := db.lookup(address)
+	if err != nil {
+		return "", err
+	}
+	if entry.country == "" || entry.country == "None" {
+		return "", ErrAddressNotFound
+	}
+	return entry.country, nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ip2asnTestData is a small excerpt of the ip2asn database.
+const ip2asnTestData = "1.0.0.0\t1.0.0.255\t13335\tUS\tCLOUDFLARENET\n" +
+	"1.0.1.0\t1.0.3.255\t0\tNone\tNot routed\n" +
+	"130.192.0.0\t130.192.255.255\t137\tIT\tASGARR\n" +
+	"2001:760::\t2001:760:ffff:ffff:ffff:ffff:ffff:ffff\t137\tIT\tASGARR\n" +
+	"192.0.2.0\t192.0.2.255\t64496\tNone\tDOCUMENTATION\n"
+
+func TestIP2ASNDatabase(t *testing.T) {
+	db, err := ParseIP2ASNDatabase(strings.NewReader(ip2asnTestData))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("LookupASN", func(t *testing.T) {
+		for address, expect := range map[string]uint32{
+			"1.0.0.1":            13335,
+			"130.192.91.211":     137,
+			"::ffff:130.192.0.1": 137,
+			"2001:760::1":        137,
+			"192.0.2.1":          64496,
+		} {
+			if asn, err := db.LookupASN(address); err != nil || asn != expect {
+				t.Fatal("unexpected result", address, asn, err)
+			}
+		}
+		for _, address := range []string{"1.0.1.1", "1.0.4.1", "0.0.0.1", "10.0.0.1", "2001:761::1", "x"} {
+			if _, err := db.LookupASN(address); err == nil {
+				t.Fatal("expected an error", address)
+			}
+		}
+	})
+
+	t.Run("LookupCountry", func(t *testing.T) {
+		if country, err := db.LookupCountry("130.192.91.211"); err != nil || country != "IT" {
+			t.Fatal("unexpected result", country, err)
+		}
+		if _, err := db.LookupCountry("192.0.2.1"); !errors.Is(err, ErrAddressNotFound) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+
+	t.Run("with invalid lines", func(t *testing.T) {
+		for _, line := range []string{
+			"1.0.0.0\t1.0.0.255\t13335",
+			"x\t1.0.0.255\t13335\tUS\tX",
+			"1.0.0.0\tx\t13335\tUS\tX",
+			"1.0.0.255\t1.0.0.0\t13335\tUS\tX",
+			"1.0.0.0\t::1\t13335\tUS\tX",
+			"1.0.0.0\t1.0.0.255\tAS13335\tUS\tX",
+		} {
+			if _, err := ParseIP2ASNDatabase(strings.NewReader(line)); err == nil {
+				t.Fatal("expected an error", line)
+			}
+		}
+	})
+}
+
+func TestLoadIP2ASNDatabase(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "ip2asn-combined.tsv")
+	if err := os.WriteFile(plain, []byte(ip2asnTestData), 0600); err != nil {
+		t.Fatal(err)
+	}
+	compressed := filepath.Join(dir, "ip2asn-combined.tsv.gz")
+	filep, err := os.Create(compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gzwriter := gzip.NewWriter(filep)
+	gzwriter.Write([]byte(ip2asnTestData))
+	gzwriter.Close()
+	filep.Close()
+	for _, filename := range []string{plain, compressed} {
+		db, err := LoadIP2ASNDatabase(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if asn, err := db.LookupASN("130.192.91.211"); err != nil || asn != 137 {
+			t.Fatal("unexpected result", asn, err)
+		}
+	}
+	if _, err := LoadIP2ASNDatabase(filepath.Join(dir, "nonexistent")); err == nil {
+		t.Fatal("expected an error")
+	}
+	notCompressed := filepath.Join(dir, "invalid.tsv.gz")
+	if err := os.WriteFile(notCompressed, []byte(ip2asnTestData), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadIP2ASNDatabase(notCompressed); err == nil {
+		t.Fatal("expected an error")
+	}
+}
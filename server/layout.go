@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultStorageLayout is the default value of Handler.StorageLayout, which
+// partitions the results files by date only.
+const DefaultStorageLayout = "{date}"
+
+// storageLayoutUnknown is the value of {asn} and {country} when we do
+// not know the ASN or the country of the client.
+const storageLayoutUnknown = "unknown"
+
+// errInvalidStorageLayout indicates that a storage layout is not valid.
+var errInvalidStorageLayout = errors.New("invalid storage layout")
+
+// storageLayoutValues contains the values of the storage layout placeholders.
+type storageLayoutValues struct {
+	// ASN is the value of {asn} (e.g., "AS137").
+	ASN string
+
+	// Country is the value of {country} (e.g., "IT").
+	Country string
+
+	// Date is the value of {date} (e.g., "2024/01/29").
+	Date string
+}
+
+// expandStorageLayout replaces the placeholders of the layout with the
+// given values. We use placeholders rather than text/template because they
+// are easier to write on the command line and cannot fail at runtime.
+func expandStorageLayout(layout string, values *storageLayoutValues) string {
+	return strings.NewReplacer(
+		"{asn}", values.ASN,
+		"{country}", values.Country,
+		"{date}", values.Date,
+	).Replace(layout)
+}
+
+// ValidateStorageLayout checks whether the given storage layout (see the
+// Handler.StorageLayout field) is valid, i.e., whether it only contains known
+// placeholders and always expands to a relative path inside the datadir.
+func ValidateStorageLayout(layout string) error {
+	expanded := expandStorageLayout(layout, &storageLayoutValues{
+		ASN:     storageLayoutUnknown,
+		Country: storageLayoutUnknown,
+		Date:    "2006/01/02",
+	})
+	if strings.ContainsAny(expanded, "{}") {
+		return fmt.Errorf("%w: unknown placeholder in %q", errInvalidStorageLayout, layout)
+	}
+	if !filepath.IsLocal(expanded) {
+		return fmt.Errorf("%w: %q is not a relative path inside the datadir", errInvalidStorageLayout, layout)
+	}
+	return nil
+}
+
+// storageDir returns the directory, relative to the "dash" directory of the
+// datadir, where to save the results of the given session according to the
+// StorageLayout. We sanitize the ASN and the country, since they come from
+// lookup functions we do not control, so they cannot escape the datadir, and
+// we fall back to the DefaultStorageLayout when the StorageLayout is invalid.
+func (h *Handler) storageDir(session *sessionInfo) string {
+	values := &storageLayoutValues{
+		ASN:     storageLayoutUnknown,
+		Country: storageLayoutUnknown,
+		Date:    session.stamp.Format("2006/01/02"),
+	}
+	asn, country := h.lookupClient(session.address)
+	if asn > 0 {
+		values.ASN = fmt.Sprintf("AS%d", asn)
+	}
+	if validCountry(country) {
+		values.Country = strings.ToUpper(country)
+	}
+	if err := ValidateStorageLayout(h.StorageLayout); err != nil {
+		h.logger.Warnf("storageDir: %s", err.Error())
+		return expandStorageLayout(DefaultStorageLayout, values)
+	}
+	return expandStorageLayout(h.StorageLayout, values)
+}
+
+// validCountry returns whether country is a two letters country code.
+func validCountry(country string) bool {
+	if len(country) != 2 {
+		return false
+	}
+	for _, c := range country {
+		if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/model"
+)
+
+func TestValidateStorageLayout(t *testing.T) {
+	for _, layout := range []string{DefaultStorageLayout, "{asn}/{date}", "{country}/{asn}/{date}", "static"} {
+		if err := ValidateStorageLayout(layout); err != nil {
+			t.Fatal(layout, err)
+		}
+	}
+	for _, layout := range []string{"", "{isp}/{date}", "/{date}", "../{date}", "{date}/../../../.."} {
+		if err := ValidateStorageLayout(layout); !errors.Is(err, errInvalidStorageLayout) {
+			t.Fatal("not the error we expected", layout, err)
+		}
+	}
+}
+
+func TestServerStorageDir(t *testing.T) {
+	newSession := func(handler *Handler) *sessionInfo {
+		handler.createNegotiatedSession("deadbeef", "130.192.91.211", "", model.NegotiateRequest{}, nil)
+		session := handler.popSession("deadbeef")
+		session.stamp = time.Date(2024, time.January, 29, 20, 23, 0, 0, time.UTC)
+		return session
+	}
+
+	t.Run("with known ASN and country", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.StorageLayout = "{country}/{asn}/{date}"
+		handler.ASNLookup = func(address string) (uint32, error) { return 137, nil }
+		handler.CountryLookup = func(address string) (string, error) { return "it", nil }
+		if dir := handler.storageDir(newSession(handler)); dir != "IT/AS137/2024/01/29" {
+			t.Fatal("unexpected dir", dir)
+		}
+	})
+
+	t.Run("with unknown ASN and country", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.StorageLayout = "{country}/{asn}/{date}"
+		handler.CountryLookup = func(address string) (string, error) { return "../..", nil }
+		if dir := handler.storageDir(newSession(handler)); dir != "unknown/unknown/2024/01/29" {
+			t.Fatal("unexpected dir", dir)
+		}
+	})
+
+	t.Run("with invalid layout", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.StorageLayout = "../{date}"
+		if dir := handler.storageDir(newSession(handler)); dir != "2024/01/29" {
+			t.Fatal("unexpected dir", dir)
+		}
+	})
+
+	t.Run("saving results", func(t *testing.T) {
+		datadir := t.TempDir()
+		handler := NewHandler(datadir, log.Log)
+		handler.StorageLayout = "{asn}/{date}"
+		handler.ASNLookup = func(address string) (uint32, error) { return 137, nil }
+		if err := handler.savedata(newSession(handler)); err != nil {
+			t.Fatal(err)
+		}
+		name := filepath.Join(datadir, "dash/AS137/2024/01/29/neubot-dash-20240129T202300.000000000Z.json.gz")
+		if _, err := os.Stat(name); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"path"
+
+	"github.com/neubot/dash/model"
+)
+
+// legacyServerSchemaVersion is the version of the server schema used
+// by the original Neubot server (see spec.CurrentServerSchemaVersion).
+const legacyServerSchemaVersion = 3
+
+// legacyDirname is the directory of the datadir where we save the results
+// using the legacy schema (see [Handler.LegacySchema]).
+const legacyDirname = "dash-v3"
+
+// legacyServerSchema is the server schema used by the original Neubot
+// server, which does not include any extension to the original format.
+type legacyServerSchema struct {
+	Client              []legacyClientResults `json:"client"`
+	ServerSchemaVersion int                   `json:"srvr_schema_version"`
+	ServerTimestamp     int64                 `json:"srvr_timestamp"`
+	Server              []legacyServerResults `json:"server"`
+}
+
+// legacyClientResults contains the client results of the original
+// Neubot format, i.e., the [model.ClientResults] without extensions.
+type legacyClientResults struct {
+	ConnectTime     float64 `json:"connect_time"`
+	DeltaSysTime    float64 `json:"delta_sys_time"`
+	DeltaUserTime   float64 `json:"delta_user_time"`
+	Elapsed         float64 `json:"elapsed"`
+	ElapsedTarget   int64   `json:"elapsed_target"`
+	InternalAddress string  `json:"internal_address"`
+	Iteration       int64   `json:"iteration"`
+	Platform        string  `json:"platform"`
+	Rate            int64   `json:"rate"`
+	RealAddress     string  `json:"real_address"`
+	Received        int64   `json:"received"`
+	RemoteAddress   string  `json:"remote_address"`
+	RequestTicks    float64 `json:"request_ticks"`
+	Timestamp       int64   `json:"timestamp"`
+	UUID            string  `json:"uuid"`
+	Version         string  `json:"version"`
+}
+
+// legacyServerResults contains the server results of the original Neubot
+// format, where Web100Snap is always empty because M-Lab does not run
+// Web100 anymore, which is why we bumped the schema version.
+type legacyServerResults struct {
+	Iteration  int64          `json:"iteration"`
+	Ticks      float64        `json:"ticks"`
+	Timestamp  int64          `json:"timestamp"`
+	Web100Snap map[string]any `json:"web100_snap"`
+}
+
+// newLegacyServerSchema converts the given results to the legacy schema.
+func newLegacyServerSchema(schema *model.ServerSchema) *legacyServerSchema {
+	out := &legacyServerSchema{
+		Client:              []legacyClientResults{},
+		ServerSchemaVersion: legacyServerSchemaVersion,
+		ServerTimestamp:     schema.ServerTimestamp,
+		Server:              []legacyServerResults{},
+	}
+	for _, entry := range schema.Client {
+		out.Client = append(out.Client, legacyClientResults{
+			ConnectTime:     entry.ConnectTime,
+			DeltaSysTime:    entry.DeltaSysTime,
+			DeltaUserTime:   entry.DeltaUserTime,
+			Elapsed:         entry.Elapsed,
+			ElapsedTarget:   entry.ElapsedTarget,
+			InternalAddress: entry.InternalAddress,
+			Iteration:       entry.Iteration,
+			Platform:        entry.Platform,
+			Rate:            entry.Rate,
+			RealAddress:     entry.RealAddress,
+			Received:        entry.Received,
+			RemoteAddress:   entry.RemoteAddress,
+			RequestTicks:    entry.RequestTicks,
+			Timestamp:       entry.Timestamp,
+			UUID:            entry.UUID,
+			Version:         entry.Version,
+		})
+	}
+	for _, entry := range schema.Server {
+		out.Server = append(out.Server, legacyServerResults{
+			Iteration:  entry.Iteration,
+			Ticks:      entry.Ticks,
+			Timestamp:  entry.Timestamp,
+			Web100Snap: map[string]any{},
+		})
+	}
+	return out
+}
+
+// saveLegacy saves the results of the given session using the legacy
+// schema into the legacyDirname directory of the datadir, using the same
+// layout and file name as the results we save using the current schema.
+// Failing to save the legacy results does not cause collect to fail.
+func (h *Handler) saveLegacy(session *sessionInfo, filename string) {
+	data, err := h.deps.JSONMarshal(newLegacyServerSchema(&session.serverSchema))
+	if err != nil {
+		h.logger.Warnf("saveLegacy: json.Marshal: %s", err.Error())
+		observeSavedResults(savedResultsLegacy, err)
+		return
+	}
+	compressed, err := h.compress(data)
+	if err != nil {
+		observeSavedResults(savedResultsLegacy, err)
+		return
+	}
+	dirname := path.Join(legacyDirname, h.storageDir(session))
+	err = h.writeResults(h.datadir, dirname, filename, compressed)
+	observeSavedResults(savedResultsLegacy, err)
+	if err != nil {
+		h.logger.Warnf("saveLegacy: cannot write legacy results: %s", err.Error())
+	}
+}
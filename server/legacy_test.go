@@ -0,0 +1,100 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/model"
+)
+
+func TestNewLegacyServerSchema(t *testing.T) {
+	schema := &model.ServerSchema{
+		Client: []model.ClientResults{{
+			Iteration:   1,
+			Rate:        3000,
+			Received:    1000,
+			Stalls:      2,
+			UUID:        "deadbeef",
+			WireBytes:   1100,
+			ConnectTime: 0.1,
+		}},
+		Scheme:              "https",
+		ServerSchemaVersion: 4,
+		ServerTimestamp:     1706559780,
+		Server:              []model.ServerResults{{Iteration: 1, Sent: 1000, Ticks: 0.5, Timestamp: 1706559781}},
+		Streams:             2,
+	}
+	data, err := json.Marshal(newLegacyServerSchema(schema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value map[string]any
+	if err := json.Unmarshal(data, &value); err != nil {
+		t.Fatal(err)
+	}
+	if len(value) != 4 || value["srvr_schema_version"] != float64(3) || value["srvr_timestamp"] != float64(1706559780) {
+		t.Fatal("unexpected top-level keys", value)
+	}
+	client := value["client"].([]any)[0].(map[string]any)
+	if _, found := client["stalls"]; found || client["uuid"] != "deadbeef" || client["rate"] != float64(3000) {
+		t.Fatal("unexpected client results", client)
+	}
+	server := value["server"].([]any)[0].(map[string]any)
+	if _, found := server["sent"]; found || server["ticks"] != 0.5 {
+		t.Fatal("unexpected server results", server)
+	}
+	if snap, ok := server["web100_snap"].(map[string]any); !ok || len(snap) != 0 {
+		t.Fatal("expected an empty web100_snap", server)
+	}
+}
+
+func TestServerSavedataLegacy(t *testing.T) {
+	const filename = "2024/01/29/neubot-dash-20240129T202300.000000000Z.json.gz"
+	save := func(t *testing.T, legacy bool) string {
+		datadir := t.TempDir()
+		handler := NewHandler(datadir, log.Log)
+		handler.LegacySchema = legacy
+		handler.createSession("deadbeef")
+		session := handler.popSession("deadbeef")
+		session.stamp = time.Date(2024, time.January, 29, 20, 23, 0, 0, time.UTC) // predictable
+		if err := handler.savedata(session); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(datadir, "dash", filename)); err != nil {
+			t.Fatal(err)
+		}
+		return datadir
+	}
+
+	t.Run("when enabled", func(t *testing.T) {
+		datadir := save(t, true)
+		filep, err := os.Open(filepath.Join(datadir, legacyDirname, filename))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer filep.Close()
+		reader, err := gzip.NewReader(filep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var schema legacyServerSchema
+		if err := json.NewDecoder(reader).Decode(&schema); err != nil {
+			t.Fatal(err)
+		}
+		if schema.ServerSchemaVersion != legacyServerSchemaVersion {
+			t.Fatal("unexpected schema version", schema.ServerSchemaVersion)
+		}
+	})
+
+	t.Run("when disabled", func(t *testing.T) {
+		datadir := save(t, false)
+		if _, err := os.Stat(filepath.Join(datadir, legacyDirname)); !os.IsNotExist(err) {
+			t.Fatal("expected no legacy results", err)
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// connLifetimeGrace is the additional time we allow to connections that
+// are not idle when their lifetime expires before closing them anyway.
+const connLifetimeGrace = time.Minute
+
+// lifetimeConn is a [net.Conn] with a maximum lifetime (see the MaxLifetime
+// field of [SocketOptions]). When the lifetime expires, we close the conn as
+// soon as it is idle or after connLifetimeGrace, whatever comes first.
+type lifetimeConn struct {
+	net.Conn
+
+	// closeOnce ensures we count the expired conn just once.
+	closeOnce sync.Once
+
+	// expired indicates that the lifetime expired.
+	expired bool
+
+	// idle indicates that the conn is idle.
+	idle bool
+
+	// mtx protects expired, idle, and timer.
+	mtx sync.Mutex
+
+	// timer is the timer for the lifetime or for the grace period.
+	timer *time.Timer
+}
+
+// newLifetimeConn wraps the given conn to close it after the given lifetime.
+func newLifetimeConn(conn net.Conn, lifetime time.Duration) *lifetimeConn {
+	lc := &lifetimeConn{Conn: conn}
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+	lc.timer = time.AfterFunc(lifetime, lc.expire)
+	return lc
+}
+
+// expire is called when the lifetime expires.
+func (lc *lifetimeConn) expire() {
+	lc.mtx.Lock()
+	lc.expired = true
+	idle := lc.idle
+	if !idle {
+		lc.timer = time.AfterFunc(connLifetimeGrace, lc.closeExpired)
+	}
+	lc.mtx.Unlock()
+	if idle {
+		lc.closeExpired()
+	}
+}
+
+// isExpired returns whether the lifetime expired.
+func (lc *lifetimeConn) isExpired() bool {
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+	return lc.expired
+}
+
+// setState is called when the [http.Server] changes the conn state.
+func (lc *lifetimeConn) setState(state http.ConnState) {
+	lc.mtx.Lock()
+	lc.idle = state == http.StateIdle
+	expired := lc.expired
+	lc.mtx.Unlock()
+	switch {
+	case state == http.StateHijacked || state == http.StateClosed:
+		lc.stopTimer()
+	case expired && state == http.StateIdle:
+		lc.closeExpired()
+	}
+}
+
+// closeExpired closes the conn because its lifetime expired.
+func (lc *lifetimeConn) closeExpired() {
+	lc.closeOnce.Do(func() {
+		expiredConnections.Inc()
+		lc.Close()
+	})
+}
+
+// stopTimer stops the lifetime or grace period timer.
+func (lc *lifetimeConn) stopTimer() {
+	lc.mtx.Lock()
+	defer lc.mtx.Unlock()
+	lc.timer.Stop()
+}
+
+// Close implements net.Conn.
+func (lc *lifetimeConn) Close() error {
+	lc.stopTimer()
+	return lc.Conn.Close()
+}
+
+// lifetimeConnFromConn returns the [*lifetimeConn] possibly wrapped
+// by the given conn (e.g., by a [*tls.Conn]) or nil.
+func lifetimeConnFromConn(conn net.Conn) *lifetimeConn {
+	for conn != nil {
+		switch value := conn.(type) {
+		case *lifetimeConn:
+			return value
+		case *countingConn:
+			conn = value.Conn
+		case interface{ NetConn() net.Conn }:
+			conn = value.NetConn()
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// trackConnState is the [http.Server] ConnState hook allowing to close the
+// expired conns as soon as they are idle.
+func trackConnState(conn net.Conn, state http.ConnState) {
+	if lc := lifetimeConnFromConn(conn); lc != nil {
+		lc.setState(state)
+	}
+}
+
+// closeExpiredConns wraps the given handler to ask the client to close
+// the connection, using the "Connection: close" header, when its lifetime
+// expired, so the client does not reuse a conn we are about to close.
+func closeExpiredConns(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info, ok := connInfoFromContext(r.Context()); ok {
+			if lc := lifetimeConnFromConn(info.Conn); lc != nil && lc.isExpired() {
+				w.Header().Set("Connection", "close")
+			}
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// waitClosed waits for the conn connected to the given peer to be closed.
+func waitClosed(t *testing.T, peer net.Conn) {
+	peer.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := peer.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatal("expected the conn to be closed, got", err)
+	}
+}
+
+func TestLifetimeConn(t *testing.T) {
+	counter := func() float64 {
+		value := &dto.Metric{}
+		if err := expiredConnections.Write(value); err != nil {
+			t.Fatal(err)
+		}
+		return value.Counter.GetValue()
+	}
+
+	t.Run("an idle conn is closed when the lifetime expires", func(t *testing.T) {
+		conn, peer := net.Pipe()
+		defer peer.Close()
+		before := counter()
+		lc := newLifetimeConn(conn, 10*time.Millisecond)
+		lc.setState(http.StateIdle)
+		waitClosed(t, peer)
+		if !lc.isExpired() {
+			t.Fatal("expected the conn to be expired")
+		}
+		if counter() != before+1 {
+			t.Fatal("expected the counter to increase")
+		}
+	})
+
+	t.Run("an active conn is closed when it becomes idle", func(t *testing.T) {
+		conn, peer := net.Pipe()
+		defer peer.Close()
+		lc := newLifetimeConn(conn, time.Millisecond)
+		lc.setState(http.StateActive)
+		for !lc.isExpired() {
+			time.Sleep(time.Millisecond)
+		}
+		go lc.setState(http.StateIdle)
+		waitClosed(t, peer)
+	})
+
+	t.Run("a hijacked conn is not closed", func(t *testing.T) {
+		conn, peer := net.Pipe()
+		defer peer.Close()
+		lc := newLifetimeConn(conn, 10*time.Millisecond)
+		defer lc.Close()
+		lc.setState(http.StateHijacked)
+		time.Sleep(50 * time.Millisecond)
+		if lc.isExpired() {
+			t.Fatal("expected the conn not to be expired")
+		}
+	})
+}
+
+func TestLifetimeConnFromConn(t *testing.T) {
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	lc := newLifetimeConn(conn, time.Hour)
+	defer lc.Close()
+	if lifetimeConnFromConn(newCountingConn(lc)) != lc {
+		t.Fatal("expected to find the lifetime conn")
+	}
+	if lifetimeConnFromConn(conn) != nil {
+		t.Fatal("expected not to find a lifetime conn")
+	}
+}
+
+func TestCloseExpiredConns(t *testing.T) {
+	handler := closeExpiredConns(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(204)
+	}))
+	serve := func(lc *lifetimeConn) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req = req.WithContext(withConnInfo(context.Background(), newCountingConn(lc)))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+	conn, peer := net.Pipe()
+	defer peer.Close()
+	lc := newLifetimeConn(conn, time.Hour)
+	defer lc.Close()
+	if w := serve(lc); w.Code != 204 || w.Header().Get("Connection") != "" {
+		t.Fatal("expected the client to be able to reuse the conn")
+	}
+	lc.expire()
+	if w := serve(lc); w.Code != 204 || w.Header().Get("Connection") != "close" {
+		t.Fatal("expected the client not to reuse the conn")
+	}
+}
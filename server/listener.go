@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neubot/dash/internal"
+	"github.com/neubot/dash/model"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// errNotSentLowatNotSupported is returned when we cannot set the
+	// TCP_NOTSENT_LOWAT socket option on the current platform.
+	errNotSentLowatNotSupported = errors.New("TCP_NOTSENT_LOWAT is not supported")
+
+	// errReusePortNotSupported is returned when we cannot set the
+	// SO_REUSEPORT socket option on the current platform.
+	errReusePortNotSupported = errors.New("SO_REUSEPORT is not supported")
+)
+
+// SocketOptions contains the socket options to apply to each connection
+// accepted by the listener returned by [Listen]. The zero value is valid
+// and means that we do not change the kernel defaults.
+type SocketOptions struct {
+	// MaxLifetime, when positive, is the maximum lifetime of each accepted
+	// connection, which prevents clients from holding connections forever
+	// and skewing the per-connection statistics. When the lifetime expires,
+	// we close the connection as soon as it is idle or, in any case, after
+	// a one minute grace period. Closing idle connections immediately and
+	// asking clients not to reuse them requires [ListenAndServe] or
+	// [ListenAndServeTLS], which track the state of connections.
+	MaxLifetime time.Duration
+
+	// NotSentLowat, when positive, sets the TCP_NOTSENT_LOWAT socket
+	// option, i.e., the amount of unsent bytes in the send buffer above
+	// which the socket is not writable. This option is only available
+	// on Linux and macOS.
+	NotSentLowat int
+
+	// SendBufferSize, when positive, sets the SO_SNDBUF socket option.
+	SendBufferSize int
+}
+
+// apply applies the socket options to the given connection.
+func (opts *SocketOptions) apply(conn *net.TCPConn) error {
+	if opts.SendBufferSize > 0 {
+		if err := conn.SetWriteBuffer(opts.SendBufferSize); err != nil {
+			return err
+		}
+	}
+	if opts.NotSentLowat > 0 {
+		rc, err := conn.SyscallConn()
+		if err != nil {
+			return err
+		}
+		if err := setNotSentLowat(rc, opts.NotSentLowat); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Listen creates a TCP listener bound to the given address that applies
+// the given socket options to each accepted connection. Failing to apply
+// the options is not fatal: we emit a warning and use the connection.
+func Listen(ctx context.Context, address string, opts SocketOptions, logger model.Logger) (net.Listener, error) {
+	return listen(ctx, &net.ListenConfig{}, address, 0, opts, logger)
+}
+
+// ListenReusePort is like [Listen] but creates count listeners bound to
+// the same address using the SO_REUSEPORT socket option. The kernel will
+// then distribute incoming connections among the listeners, which allows
+// running independent accept loops on busy multi-core servers. The number
+// of connections accepted by each listener is exported as a metric.
+func ListenReusePort(
+	ctx context.Context, address string, count int, opts SocketOptions, logger model.Logger,
+) ([]net.Listener, error) {
+	config := &net.ListenConfig{
+		Control: func(network, address string, rc syscall.RawConn) error {
+			return setReusePort(rc)
+		},
+	}
+	var listeners []net.Listener
+	for idx := 0; idx < count; idx++ {
+		listener, err := listen(ctx, config, address, idx, opts, logger)
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, listener)
+		// Bind the next listeners to the same port, which matters when
+		// the caller asks the kernel to choose the port (e.g., ":0").
+		address = listener.Addr().String()
+	}
+	return listeners, nil
+}
+
+// listen creates the idx-th [*tunedListener] using the given config.
+func listen(
+	ctx context.Context, config *net.ListenConfig, address string,
+	idx int, opts SocketOptions, logger model.Logger,
+) (net.Listener, error) {
+	listener, err := config.Listen(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	accepted := listenerAcceptedConnections.With(prometheus.Labels{
+		"address":  listener.Addr().String(),
+		"listener": strconv.Itoa(idx),
+	})
+	return &tunedListener{Listener: listener, accepted: accepted, logger: logger, opts: opts}, nil
+}
+
+// tunedListener is a [net.Listener] applying [SocketOptions] and wrapping
+// each accepted connection using a [*countingConn].
+type tunedListener struct {
+	net.Listener
+	accepted prometheus.Counter
+	logger   model.Logger
+	opts     SocketOptions
+}
+
+// Accept implements net.Listener.
+func (tl *tunedListener) Accept() (net.Conn, error) {
+	conn, err := tl.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tl.accepted.Inc()
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := tl.opts.apply(tcpConn); err != nil {
+			tl.logger.Warnf("listener: cannot apply socket options: %s", err.Error())
+		}
+	}
+	if tl.opts.MaxLifetime > 0 {
+		conn = newLifetimeConn(conn, tl.opts.MaxLifetime)
+	}
+	return newCountingConn(conn), nil
+}
+
+// ServeConfig contains the configuration for [ListenAndServe] and
+// [ListenAndServeTLS]. The zero value is valid and uses the defaults
+// of the standard library and of [Listen].
+type ServeConfig struct {
+	// BaseContext is the optional base context for incoming requests. When
+	// this context is done, we close the listeners and the connections.
+	BaseContext context.Context
+
+	// IdleTimeout is like [http.Server] IdleTimeout.
+	IdleTimeout time.Duration
+
+	// Listeners is the number of listeners to open. When larger than
+	// one, we use [ListenReusePort] rather than [Listen].
+	Listeners int
+
+	// Logger is the optional logger to use.
+	Logger model.Logger
+
+	// ReadHeaderTimeout is like [http.Server] ReadHeaderTimeout.
+	ReadHeaderTimeout time.Duration
+
+	// ReadTimeout is like [http.Server] ReadTimeout.
+	ReadTimeout time.Duration
+
+	// SocketOptions contains the options for accepted connections.
+	SocketOptions SocketOptions
+
+	// TLSConfig is the optional TLS configuration for [ListenAndServeTLS].
+	TLSConfig *tls.Config
+
+	// WriteTimeout is like [http.Server] WriteTimeout.
+	WriteTimeout time.Duration
+}
+
+// ListenAndServe is like [http.ListenAndServe] but uses the listeners
+// created according to the given config and makes information about the
+// underlying connection available to handlers through the request context.
+func ListenAndServe(address string, handler http.Handler, config *ServeConfig) error {
+	srvr, listeners, err := config.listen(address, handler)
+	if err != nil {
+		return err
+	}
+	return config.serve(srvr, listeners, srvr.Serve)
+}
+
+// ListenAndServeTLS is like [ListenAndServe] but for HTTPS.
+func ListenAndServeTLS(address, certFile, keyFile string, handler http.Handler, config *ServeConfig) error {
+	srvr, listeners, err := config.listen(address, handler)
+	if err != nil {
+		return err
+	}
+	return config.serve(srvr, listeners, func(listener net.Listener) error {
+		return srvr.ServeTLS(listener, certFile, keyFile)
+	})
+}
+
+// context returns the base context to use.
+func (config *ServeConfig) context() context.Context {
+	if config.BaseContext != nil {
+		return config.BaseContext
+	}
+	return context.Background()
+}
+
+// logger returns the logger to use.
+func (config *ServeConfig) logger() model.Logger {
+	if config.Logger != nil {
+		return config.Logger
+	}
+	return internal.NoLogger{}
+}
+
+// listen creates the [*http.Server] and the listeners.
+func (config *ServeConfig) listen(address string, handler http.Handler) (*http.Server, []net.Listener, error) {
+	ctx := config.context()
+	srvr := &http.Server{
+		Handler:           closeExpiredConns(handler),
+		TLSConfig:         config.TLSConfig,
+		ReadTimeout:       config.ReadTimeout,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return ctx
+		},
+		ConnContext: withConnInfo,
+		ConnState:   trackConnState,
+	}
+	if config.Listeners > 1 {
+		listeners, err := ListenReusePort(ctx, address, config.Listeners, config.SocketOptions, config.logger())
+		return srvr, listeners, err
+	}
+	listener, err := Listen(ctx, address, config.SocketOptions, config.logger())
+	if err != nil {
+		return nil, nil, err
+	}
+	return srvr, []net.Listener{listener}, nil
+}
+
+// serve runs an accept loop for each listener using the given function
+// and returns the first error that occurred. We close the server when
+// the base context is done or when any accept loop fails.
+func (config *ServeConfig) serve(
+	srvr *http.Server, listeners []net.Listener, serveFunc func(listener net.Listener) error,
+) error {
+	errch := make(chan error, len(listeners))
+	for _, listener := range listeners {
+		go func(listener net.Listener) {
+			errch <- serveFunc(listener)
+		}(listener)
+	}
+	var err error
+	select {
+	case err = <-errch:
+	case <-config.context().Done():
+		err = config.context().Err()
+	}
+	srvr.Close()
+	return err
+}
+
+// connInfo contains information about the connection used by a request.
+type connInfo struct {
+	// ID uniquely identifies the connection.
+	ID string
+
+	// Conn is the underlying connection.
+	Conn net.Conn
+
+	// Counters contains the bytes read and written by the connection or
+	// nil when the connection was not accepted by a [*tunedListener].
+	Counters *connCounters
+}
+
+// connInfoKey is the context key for [*connInfo].
+type connInfoKey struct{}
+
+// withConnInfo returns a context containing information about the given
+// connection. We use this function as the [http.Server] ConnContext.
+func withConnInfo(ctx context.Context, conn net.Conn) context.Context {
+	var ID string
+	if value, err := uuid.NewRandom(); err == nil {
+		ID = value.String()
+	}
+	info := &connInfo{ID: ID, Conn: conn, Counters: countersFromConn(conn)}
+	return context.WithValue(ctx, connInfoKey{}, info)
+}
+
+// connInfoFromContext returns the [*connInfo] saved in the context, if any.
+func connInfoFromContext(ctx context.Context) (*connInfo, bool) {
+	info, ok := ctx.Value(connInfoKey{}).(*connInfo)
+	return info, ok
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"runtime"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+func TestListen(t *testing.T) {
+	t.Run("net.ListenConfig.Listen failure", func(t *testing.T) {
+		listener, err := Listen(context.Background(), "127.0.0.1:-1", SocketOptions{}, log.Log)
+		if err == nil {
+			t.Fatal("Expected an error here")
+		}
+		if listener != nil {
+			t.Fatal("Expected nil listener here")
+		}
+	})
+
+	t.Run("common case", func(t *testing.T) {
+		opts := SocketOptions{SendBufferSize: 1 << 16}
+		if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
+			opts.NotSentLowat = 1 << 14
+		}
+		listener, err := Listen(context.Background(), "127.0.0.1:0", opts, log.Log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		go func() {
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}()
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		checkSocketOptions(t, conn.(*countingConn).Conn.(*net.TCPConn), opts)
+	})
+}
+
+func TestListenReusePort(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("SO_REUSEPORT is not supported on this platform")
+	}
+
+	t.Run("net.ListenConfig.Listen failure", func(t *testing.T) {
+		listeners, err := ListenReusePort(context.Background(), "127.0.0.1:-1", 2, SocketOptions{}, log.Log)
+		if err == nil {
+			t.Fatal("Expected an error here")
+		}
+		if listeners != nil {
+			t.Fatal("Expected nil listeners here")
+		}
+	})
+
+	t.Run("common case", func(t *testing.T) {
+		listeners, err := ListenReusePort(context.Background(), "127.0.0.1:0", 4, SocketOptions{}, log.Log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(listeners) != 4 {
+			t.Fatal("Expected four listeners")
+		}
+		for _, listener := range listeners {
+			defer listener.Close()
+			if listener.Addr().String() != listeners[0].Addr().String() {
+				t.Fatal("Expected all listeners to use the same address")
+			}
+		}
+	})
+}
+
+func TestListenAndServe(t *testing.T) {
+	t.Run("listen failure", func(t *testing.T) {
+		err := ListenAndServe("127.0.0.1:-1", http.NewServeMux(), &ServeConfig{})
+		if err == nil {
+			t.Fatal("Expected an error here")
+		}
+		err = ListenAndServeTLS("127.0.0.1:-1", "cert.pem", "key.pem", http.NewServeMux(), &ServeConfig{})
+		if err == nil {
+			t.Fatal("Expected an error here")
+		}
+	})
+
+	t.Run("common case", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		config := &ServeConfig{BaseContext: ctx, Listeners: 1, Logger: log.Log}
+		infos := make(chan *connInfo, 2)
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			info, _ := connInfoFromContext(r.Context())
+			infos <- info
+		})
+		srvr, listeners, err := config.listen("127.0.0.1:0", handler)
+		if err != nil {
+			t.Fatal(err)
+		}
+		errch := make(chan error)
+		go func() {
+			errch <- config.serve(srvr, listeners, srvr.Serve)
+		}()
+		URL := "http://" + listeners[0].Addr().String() + "/"
+		for idx := 0; idx < 2; idx++ {
+			resp, err := http.Get(URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		first, second := <-infos, <-infos
+		if first == nil || first.ID == "" || first.Conn == nil || first.Counters == nil {
+			t.Fatal("expected connection info")
+		}
+		if first.ID != second.ID {
+			t.Fatal("expected the same connection to be reused")
+		}
+		cancel()
+		if err := <-errch; !errors.Is(err, context.Canceled) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/neubot/dash/model"
+)
+
+const (
+	// masqueTransportHeader is the header where a MASQUE front puts the
+	// transport protocol used by the client (e.g., "connect-udp").
+	masqueTransportHeader = "X-Forwarded-Transport"
+
+	// maxTransportLength is the maximum length of the transport protocol
+	// reported by a MASQUE front that we save with the results.
+	maxTransportLength = 32
+)
+
+// forwardedTransport returns the transport protocol that a trusted MASQUE
+// front reports for the client. We only accept short lowercase tokens, since
+// we save the value with the results. The boolean is false when we are not
+// in MASQUE research mode, the request does not come from a trusted proxy,
+// or the proxy did not report a valid protocol.
+func (h *Handler) forwardedTransport(r *http.Request) (string, bool) {
+	if !h.MASQUEResearch || !h.isTrustedProxy(r.RemoteAddr) {
+		return "", false
+	}
+	value := strings.ToLower(strings.TrimSpace(r.Header.Get(masqueTransportHeader)))
+	if value == "" || len(value) > maxTransportLength {
+		return "", false
+	}
+	for _, c := range value {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return "", false
+		}
+	}
+	return value, true
+}
+
+// recordProxiedTransport records in the given session the transport protocol
+// reported by the MASQUE front, if any. The caller MUST own the session,
+// i.e., the session MUST NOT be inside .sessions yet.
+func (h *Handler) recordProxiedTransport(session *sessionInfo, r *http.Request) {
+	protocol, found := h.forwardedTransport(r)
+	if !found {
+		return
+	}
+	session.serverSchema.Proxied = &model.ProxiedTransport{Protocol: protocol}
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/model"
+)
+
+func TestServerForwardedTransport(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	handler.MASQUEResearch = true
+	handler.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	for _, tc := range []struct {
+		name       string
+		remoteAddr string
+		transport  string
+		expect     string
+	}{{
+		name:       "from trusted proxy",
+		remoteAddr: "10.1.2.3:54321",
+		transport:  "CONNECT-UDP",
+		expect:     "connect-udp",
+	}, {
+		name:       "from untrusted client",
+		remoteAddr: "130.192.91.211:54321",
+		transport:  "connect-udp",
+	}, {
+		name:       "with invalid value",
+		remoteAddr: "10.1.2.3:54321",
+		transport:  "connect udp; evil",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/negotiate/dash", nil)
+			req.RemoteAddr = tc.remoteAddr
+			req.Header.Set("X-Forwarded-Transport", tc.transport)
+			protocol, _ := handler.forwardedTransport(req)
+			if protocol != tc.expect {
+				t.Fatal("unexpected protocol", protocol)
+			}
+		})
+	}
+}
+
+func TestServerNegotiateMASQUE(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	handler.MASQUEResearch = true
+	handler.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	req := httptest.NewRequest("POST", "/negotiate/dash", nil)
+	req.RemoteAddr = "10.1.2.3:54321"
+	req.Header.Set("X-Forwarded-For", "130.192.91.211")
+	req.Header.Set("X-Forwarded-Transport", "connect-udp")
+	w := httptest.NewRecorder()
+	handler.negotiate(w, req)
+	if w.Code != 200 {
+		t.Fatal("Expected different status code")
+	}
+	var resp model.NegotiateResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.RealAddress != "130.192.91.211" {
+		t.Fatal("unexpected real address", resp.RealAddress)
+	}
+	session := handler.sessions[resp.Authorization]
+	if session.address != "130.192.91.211" {
+		t.Fatal("unexpected session address", session.address)
+	}
+	proxied := session.serverSchema.Proxied
+	if proxied == nil || proxied.Protocol != "connect-udp" {
+		t.Fatalf("unexpected proxied transport: %+v", proxied)
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import "net/http"
+
+// protocolLabel returns the HTTP version of the request for labeling metrics,
+// i.e., "HTTP/1.0", "HTTP/1.1", "HTTP/2.0", "HTTP/3.0", or "other", which
+// bounds the cardinality of the metrics. When we are behind a proxy, this
+// is the version used by the proxy rather than by the client.
+func protocolLabel(r *http.Request) string {
+	switch r.Proto {
+	case "HTTP/1.0", "HTTP/1.1", "HTTP/2.0", "HTTP/3.0":
+		return r.Proto
+	default:
+		return "other"
+	}
+}
+
+// metricLabels returns the scheme used by the client (see effectiveScheme)
+// and the HTTP version of the request (see protocolLabel), which we use to
+// label the metrics of requests, so that operators can quantify the TLS and
+// HTTP/2 overhead on their hardware.
+func (h *Handler) metricLabels(r *http.Request) (scheme, proto string) {
+	return h.effectiveScheme(r), protocolLabel(r)
+}
+
+// measureRequests wraps the given handler to observe the time to serve each
+// request in the requestDuration histogram. The name identifies the handler
+// in the metrics (i.e., "negotiate", "download", "upload", or "collect").
+func (h *Handler) measureRequests(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scheme, proto := h.metricLabels(r)
+		started := h.Clock.Now()
+		defer func() {
+			requestDuration.WithLabelValues(name, scheme, proto).Observe(h.Clock.Now().Sub(started).Seconds())
+		}()
+		handler(w, r)
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestServerMetricLabels(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	cases := []struct {
+		proto  string
+		tls    bool
+		scheme string
+		label  string
+	}{
+		{proto: "HTTP/1.1", scheme: "http", label: "HTTP/1.1"},
+		{proto: "HTTP/2.0", tls: true, scheme: "https", label: "HTTP/2.0"},
+		{proto: "HTTP/1.0", scheme: "http", label: "HTTP/1.0"},
+		{proto: "HTTP/7.3", tls: true, scheme: "https", label: "other"},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Proto = tc.proto
+		if tc.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		scheme, proto := handler.metricLabels(req)
+		if scheme != tc.scheme || proto != tc.label {
+			t.Fatal("unexpected labels", scheme, proto)
+		}
+	}
+}
+
+func TestServerMeasureRequests(t *testing.T) {
+	histogram := func() *dto.Histogram {
+		value := &dto.Metric{}
+		histogram := requestDuration.WithLabelValues("negotiate", "https", "HTTP/2.0")
+		if err := histogram.(prometheus.Metric).Write(value); err != nil {
+			t.Fatal(err)
+		}
+		return value.Histogram
+	}
+	clock := newFakeClock()
+	handler := NewHandler("", log.Log)
+	handler.Clock = clock
+	before := histogram()
+	req := httptest.NewRequest("POST", "/negotiate/dash", nil)
+	req.Proto = "HTTP/2.0"
+	req.TLS = &tls.ConnectionState{}
+	w := httptest.NewRecorder()
+	handler.measureRequests("negotiate", func(w http.ResponseWriter, r *http.Request) {
+		clock.Advance(2 * time.Second)
+		w.WriteHeader(204)
+	})(w, req)
+	if w.Code != 204 {
+		t.Fatal("Expected different status code")
+	}
+	after := histogram()
+	if after.GetSampleCount() != before.GetSampleCount()+1 {
+		t.Fatal("expected the histogram to observe the request")
+	}
+	if after.GetSampleSum()-before.GetSampleSum() != 2 {
+		t.Fatal("expected the histogram to use the clock", after.GetSampleSum()-before.GetSampleSum())
+	}
+}
+
+func TestServerSentBytes(t *testing.T) {
+	counter := func() float64 {
+		value := &dto.Metric{}
+		if err := sentBytes.WithLabelValues("http", "HTTP/1.1").Write(value); err != nil {
+			t.Fatal(err)
+		}
+		return value.Counter.GetValue()
+	}
+	handler := NewHandler("", log.Log)
+	handler.createSession("deadbeef")
+	before := counter()
+	req := httptest.NewRequest("GET", "/dash/download/1000", nil)
+	req.Header.Set(authorization, "deadbeef")
+	w := httptest.NewRecorder()
+	handler.download(w, req)
+	if w.Code != 200 {
+		t.Fatal("Expected different status code")
+	}
+	if counter() != before+float64(w.Body.Len()) {
+		t.Fatal("expected the counter to include the segment")
+	}
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// listenerAcceptedConnections counts the connections accepted by
+	// each listener created using [Listen] or [ListenReusePort].
+	listenerAcceptedConnections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_listener_accepted_connections_total",
+			Help: "Number of connections accepted by each listener.",
+		},
+		[]string{"address", "listener"},
+	)
+
+	// abortedDownloads counts the downloads that the client aborted
+	// before we could write the whole segment, by scheme and protocol.
+	abortedDownloads = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_aborted_downloads_total",
+			Help: "Number of downloads aborted by the client mid-transfer.",
+		},
+		[]string{"scheme", "proto"},
+	)
+
+	// rejectedClients counts the negotiate requests that we rejected because
+	// the client is older than the MinClientVersion, by scheme and protocol.
+	rejectedClients = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_rejected_clients_total",
+			Help: "Number of negotiations rejected because the client is too old.",
+		},
+		[]string{"scheme", "proto"},
+	)
+
+	// expiredDownloads counts the downloads that we aborted because the
+	// client did not receive the segment at the minimum plausible rate
+	// (see MinDownloadRate), by scheme and protocol.
+	expiredDownloads = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_expired_downloads_total",
+			Help: "Number of downloads aborted because the write deadline expired.",
+		},
+		[]string{"scheme", "proto"},
+	)
+
+	// expiredUploads counts the uploads that we aborted because the
+	// client did not send the segment at the minimum plausible rate
+	// (see MinDownloadRate), by scheme and protocol.
+	expiredUploads = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_expired_uploads_total",
+			Help: "Number of uploads aborted because the read deadline expired.",
+		},
+		[]string{"scheme", "proto"},
+	)
+
+	// saveQueueLength is the number of collected sessions waiting
+	// for the save workers (see SaveWorkers).
+	saveQueueLength = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dash_save_queue_length",
+		Help: "Number of collected sessions waiting for the save workers.",
+	})
+
+	// saveQueueFull counts the times we applied backpressure to
+	// collect because the queue of the save workers was full.
+	saveQueueFull = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dash_save_queue_full_total",
+		Help: "Number of collects that waited because the save queue was full.",
+	})
+
+	// expiredConnections counts the connections we closed because
+	// they exceeded their maximum lifetime. Unlike the request metrics,
+	// we do not label it by scheme and protocol, because we close the
+	// connection below the [http.Server], which has no request at hand
+	// and may multiplex several requests on an HTTP/2 connection.
+	expiredConnections = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "dash_expired_connections_total",
+		Help: "Number of connections closed because they exceeded their maximum lifetime.",
+	})
+
+	// injectedFaults counts the faults we injected by kind (i.e., "delay",
+	// "error", or "truncate"; see [Faults]), scheme, and protocol.
+	injectedFaults = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_injected_faults_total",
+			Help: "Number of injected faults by kind.",
+		},
+		[]string{"kind", "scheme", "proto"},
+	)
+
+	// malformedSizes counts the download requests whose size is malformed
+	// by reason (e.g., "too_large"; see parseSize), scheme, and protocol.
+	malformedSizes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_malformed_sizes_total",
+			Help: "Number of download requests with malformed sizes by reason.",
+		},
+		[]string{"reason", "scheme", "proto"},
+	)
+
+	// recoveredPanics counts the panics that we recovered while serving
+	// requests, by handler (i.e., "negotiate", "download", "upload", or
+	// "collect"), scheme, and protocol.
+	recoveredPanics = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_recovered_panics_total",
+			Help: "Number of panics recovered while serving requests by handler.",
+		},
+		[]string{"handler", "scheme", "proto"},
+	)
+
+	// requestDuration observes the time to serve the requests by handler
+	// (i.e., "negotiate", "download", "upload", or "collect"), scheme (i.e.,
+	// "http" or "https"), and protocol (e.g., "HTTP/2.0"; see protocolLabel).
+	requestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "dash_request_duration_seconds",
+			Help:    "Time to serve requests by handler, scheme, and protocol.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+		},
+		[]string{"handler", "scheme", "proto"},
+	)
+
+	// sentBytes counts the segment bytes we sent by scheme and protocol.
+	sentBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_sent_bytes_total",
+			Help: "Number of segment bytes sent by scheme and protocol.",
+		},
+		[]string{"scheme", "proto"},
+	)
+
+	// unknownPathRequests counts the requests for unknown paths by
+	// scheme and protocol.
+	unknownPathRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_unknown_path_requests_total",
+			Help: "Number of requests for unknown paths.",
+		},
+		[]string{"scheme", "proto"},
+	)
+
+	// tlsHandshakes counts the TLS connections used for negotiating
+	// depending on whether the client resumed a previous TLS session,
+	// by scheme and protocol.
+	tlsHandshakes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_tls_negotiate_handshakes_total",
+			Help: "Number of TLS connections used for negotiating by resumption.",
+		},
+		[]string{"resumed", "scheme", "proto"},
+	)
+
+	// savedResults counts the attempts to save results by destination
+	// (i.e., "datadir", "mirror", or "legacy") and result (i.e., "success" or "failure").
+	savedResults = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_saved_results_total",
+			Help: "Number of attempts to save results by destination and result.",
+		},
+		[]string{"destination", "result"},
+	)
+
+	// clampedQoEValues counts the QoE values reported by clients that
+	// we clamped because they were out of range, by field.
+	clampedQoEValues = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_clamped_qoe_values_total",
+			Help: "Number of out of range QoE values reported by clients.",
+		},
+		[]string{"field"},
+	)
+
+	// abuseFailures counts the failures we consider abusive by reason,
+	// scheme, and protocol.
+	abuseFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_abuse_failures_total",
+			Help: "Number of abusive failures by reason.",
+		},
+		[]string{"reason", "scheme", "proto"},
+	)
+
+	// abuseBans counts the temporary bans by the scheme and protocol
+	// of the request that triggered the ban.
+	abuseBans = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dash_abuse_bans_total",
+			Help: "Number of temporary bans.",
+		},
+		[]string{"scheme", "proto"},
+	)
+
+	// abuseBannedAddresses is the number of currently banned addresses
+	// as of the last time the reaper ran.
+	abuseBannedAddresses = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "dash_abuse_banned_addresses",
+		Help: "Number of currently banned addresses.",
+	})
+
+	// aggregateSessions is the number of sessions completed within the
+	// last hour by client ASN and country as of the last reaper run.
+	aggregateSessions = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dash_aggregate_sessions",
+			Help: "Number of sessions completed within the last hour.",
+		},
+		[]string{"asn", "country"},
+	)
+
+	// aggregateMedianRate is the median rate of the sessions completed within
+	// the last hour by client ASN and country as of the last reaper run.
+	aggregateMedianRate = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dash_aggregate_median_rate_kbits",
+			Help: "Median rate of the sessions completed within the last hour.",
+		},
+		[]string{"asn", "country"},
+	)
+)
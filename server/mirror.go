@@ -0,0 +1,18 @@
+package server
+
+// These are the destinations of the results files.
+const (
+	savedResultsDatadir = "datadir"
+	savedResultsLegacy  = "legacy"
+	savedResultsMirror  = "mirror"
+)
+
+// observeSavedResults counts the attempt to save results into the
+// given destination, which failed when err is not nil.
+func observeSavedResults(destination string, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	savedResults.WithLabelValues(destination, result).Inc()
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestServerSavedataMirror(t *testing.T) {
+	counter := func(destination, result string) float64 {
+		value := &dto.Metric{}
+		if err := savedResults.WithLabelValues(destination, result).Write(value); err != nil {
+			t.Fatal(err)
+		}
+		return value.Counter.GetValue()
+	}
+	const name = "dash/2024/01/29/neubot-dash-20240129T202300.000000000Z.json.gz"
+	save := func(datadir, mirror string) error {
+		handler := NewHandler(datadir, log.Log)
+		handler.MirrorDatadir = mirror
+		handler.createSession("deadbeef")
+		session := handler.popSession("deadbeef")
+		session.stamp = time.Date(2024, time.January, 29, 20, 23, 0, 0, time.UTC) // predictable
+		return handler.savedata(session)
+	}
+
+	t.Run("common case", func(t *testing.T) {
+		datadir, mirror := t.TempDir(), t.TempDir()
+		before := counter(savedResultsMirror, "success")
+		if err := save(datadir, mirror); err != nil {
+			t.Fatal(err)
+		}
+		for _, dir := range []string{datadir, mirror} {
+			if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if counter(savedResultsMirror, "success") != before+1 {
+			t.Fatal("expected the mirror success to be counted")
+		}
+	})
+
+	t.Run("with failing mirror", func(t *testing.T) {
+		datadir := t.TempDir()
+		mirror := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(mirror, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		before := counter(savedResultsMirror, "failure")
+		if err := save(datadir, mirror); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(filepath.Join(datadir, name)); err != nil {
+			t.Fatal(err)
+		}
+		if counter(savedResultsMirror, "failure") != before+1 {
+			t.Fatal("expected the mirror failure to be counted")
+		}
+	})
+
+	t.Run("with failing datadir", func(t *testing.T) {
+		datadir := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(datadir, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		mirror := t.TempDir()
+		if err := save(datadir, mirror); err == nil {
+			t.Fatal("Expected an error here")
+		}
+		if _, err := os.Stat(filepath.Join(mirror, name)); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,31 @@
+package server
+
+import "net/http"
+
+// unpinnedConn returns whether the session with the given UUID asked to pin
+// all its requests to the connection used for negotiating (see the
+// PinConnection field of [model.NegotiateRequest]) and the request uses a
+// different connection. We cannot enforce pinning, hence we always return
+// false, when we do not know which connection the request uses.
+func (h *Handler) unpinnedConn(UUID string, r *http.Request) bool {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	session, found := h.sessions[UUID]
+	if !found || !session.request.PinConnection {
+		return false
+	}
+	return !negotiatedConn(session, r)
+}
+
+// negotiatedConn returns whether the request uses the connection that
+// negotiated the given session, i.e., the first connection of the session.
+// When we do not know which connection the request or the negotiation used,
+// we return true, because we cannot tell. The caller MUST hold the lock
+// protecting the session, if the session is inside .sessions.
+func negotiatedConn(session *sessionInfo, r *http.Request) bool {
+	info, ok := connInfoFromContext(r.Context())
+	if !ok || info.ID == "" || len(session.serverSchema.ConnectionIDs) <= 0 {
+		return true
+	}
+	return session.serverSchema.ConnectionIDs[0] == info.ID
+}
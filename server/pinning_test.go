@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/model"
+)
+
+func TestServerPinConnection(t *testing.T) {
+	withConn := func(ID string) context.Context {
+		return context.WithValue(context.Background(), connInfoKey{}, &connInfo{ID: ID, Conn: &net.TCPConn{}})
+	}
+	negotiate := func(t *testing.T, handler *Handler, pin bool) string {
+		data, err := json.Marshal(model.NegotiateRequest{PinConnection: pin})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest("POST", "/negotiate/dash", bytes.NewReader(data)).WithContext(withConn("abc"))
+		w := httptest.NewRecorder()
+		handler.negotiate(w, req)
+		if w.Code != 200 {
+			t.Fatal("Expected different status code")
+		}
+		var resp model.NegotiateResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if pin && resp.BaseURL != "" {
+			t.Fatal("expected no base URL when pinning")
+		}
+		return resp.Authorization
+	}
+	request := func(method, URL, UUID, connID string) *http.Request {
+		req := httptest.NewRequest(method, URL, bytes.NewReader([]byte("[]"))).WithContext(withConn(connID))
+		req.Header.Set(authorization, UUID)
+		return req
+	}
+
+	t.Run("download using another connection", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.BaseURL = "https://node.example.com"
+		UUID := negotiate(t, handler, true)
+		w := httptest.NewRecorder()
+		handler.download(w, request("GET", "/dash/download/1000", UUID, "def"))
+		if w.Code != 409 {
+			t.Fatal("Expected different status code")
+		}
+		w = httptest.NewRecorder()
+		handler.download(w, request("GET", "/dash/download/1000", UUID, "abc"))
+		if w.Code != 200 {
+			t.Fatal("Expected different status code")
+		}
+	})
+
+	t.Run("collect using another connection", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.deps.Savedata = func(session *sessionInfo) error {
+			return nil
+		}
+		UUID := negotiate(t, handler, true)
+		w := httptest.NewRecorder()
+		handler.collect(w, request("POST", "/collect/dash", UUID, "def"))
+		if w.Code != 409 {
+			t.Fatal("Expected different status code")
+		}
+		w = httptest.NewRecorder()
+		handler.collect(w, request("POST", "/collect/dash", UUID, "abc"))
+		if w.Code != 200 {
+			t.Fatal("Expected different status code")
+		}
+	})
+
+	t.Run("without pinning", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		UUID := negotiate(t, handler, false)
+		w := httptest.NewRecorder()
+		handler.download(w, request("GET", "/dash/download/1000", UUID, "def"))
+		if w.Code != 200 {
+			t.Fatal("Expected different status code")
+		}
+	})
+}
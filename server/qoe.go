@@ -0,0 +1,45 @@
+package server
+
+import (
+	"time"
+
+	"github.com/neubot/dash/model"
+)
+
+const (
+	// maxBufferLevel is the maximum playback buffer level in seconds that
+	// we accept from clients, i.e., the longest test we are willing to run.
+	maxBufferLevel = float64(maxStreamDuration / time.Second)
+
+	// maxStallTime is the maximum stall time in seconds that we accept
+	// from clients for a single iteration.
+	maxStallTime = float64(maxStreamDuration / time.Second)
+
+	// maxStalls is the maximum number of stalls that we accept from
+	// clients for a single iteration.
+	maxStalls = 1000
+)
+
+// clampQoE clamps the out of range QoE metrics reported by the client (i.e.,
+// BufferLevel, Stalls, and StallTime) to the range we consider reasonable,
+// so that buggy or malicious clients do not pollute the archived results.
+func clampQoE(results []model.ClientResults) {
+	for idx := range results {
+		current := &results[idx]
+		current.BufferLevel = clampQoEFloat("buffer_level", current.BufferLevel, maxBufferLevel)
+		current.StallTime = clampQoEFloat("stall_time", current.StallTime, maxStallTime)
+		if current.Stalls < 0 || current.Stalls > maxStalls {
+			clampedQoEValues.WithLabelValues("stalls").Inc()
+			current.Stalls = min(max(current.Stalls, 0), maxStalls)
+		}
+	}
+}
+
+// clampQoEFloat clamps the value of the given field to [0, maximum].
+func clampQoEFloat(field string, value, maximum float64) float64 {
+	if value >= 0 && value <= maximum {
+		return value
+	}
+	clampedQoEValues.WithLabelValues(field).Inc()
+	return min(max(value, 0), maximum)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/model"
+)
+
+func TestClampQoE(t *testing.T) {
+	results := []model.ClientResults{{
+		BufferLevel: 4,
+		StallTime:   0.5,
+		Stalls:      1,
+	}, {
+		BufferLevel: -1,
+		StallTime:   1e12,
+		Stalls:      -3,
+	}, {
+		BufferLevel: 1e12,
+		StallTime:   -1,
+		Stalls:      1 << 40,
+	}}
+	clampQoE(results)
+	if results[0].BufferLevel != 4 || results[0].StallTime != 0.5 || results[0].Stalls != 1 {
+		t.Fatalf("unexpected results: %+v", results[0])
+	}
+	if results[1].BufferLevel != 0 || results[1].StallTime != maxStallTime || results[1].Stalls != 0 {
+		t.Fatalf("unexpected results: %+v", results[1])
+	}
+	if results[2].BufferLevel != maxBufferLevel || results[2].StallTime != 0 || results[2].Stalls != maxStalls {
+		t.Fatalf("unexpected results: %+v", results[2])
+	}
+}
+
+func TestServerCollectQoE(t *testing.T) {
+	const session = "deadbeef"
+	handler := NewHandler("", log.Log)
+	handler.createSession(session)
+	var saved []model.ClientResults
+	handler.deps.Savedata = func(session *sessionInfo) error {
+		saved = session.serverSchema.Client
+		return nil
+	}
+	body := `[{"buffer_level": 6.5, "stalls": 2, "stall_time": 1.25}, {"buffer_level": -7}]`
+	req := httptest.NewRequest("POST", "/collect/dash", strings.NewReader(body))
+	req.Header.Add(authorization, session)
+	w := httptest.NewRecorder()
+	handler.collect(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected different status code")
+	}
+	if len(saved) != 2 || saved[0].BufferLevel != 6.5 || saved[0].Stalls != 2 || saved[0].StallTime != 1.25 {
+		t.Fatalf("unexpected saved results: %+v", saved)
+	}
+	if saved[1].BufferLevel != 0 {
+		t.Fatal("expected the buffer level to be clamped", saved[1].BufferLevel)
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// recoverPanics wraps the given handler such that a panic while serving a
+// request becomes a 500 response, a logged stack trace, and an increment of
+// the recoveredPanics counter, rather than crashing the whole process. The
+// name identifies the handler in the logs and in the metrics. We do not
+// recover http.ErrAbortHandler, which the standard library uses to abort
+// the response on purpose and handles without logging.
+func (h *Handler) recoverPanics(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			value := recover()
+			if value == nil {
+				return
+			}
+			if err, ok := value.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+				panic(value)
+			}
+			scheme, proto := h.metricLabels(r)
+			recoveredPanics.WithLabelValues(name, scheme, proto).Inc()
+			h.logger.Warnf("recoverPanics: %s: %v\n%s", name, value, debug.Stack())
+			// If the handler already sent the headers, this is a no-op and
+			// the client will see a truncated response, which is fine.
+			w.Header().Set("Content-Length", "0")
+			setFailureReason(w, fmt.Sprintf("panic: %v", value))
+			w.WriteHeader(http.StatusInternalServerError)
+		}()
+		handler(w, r)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestServerRecoverPanics(t *testing.T) {
+	counter := func() float64 {
+		value := &dto.Metric{}
+		if err := recoveredPanics.WithLabelValues("negotiate", "http", "HTTP/1.1").Write(value); err != nil {
+			t.Fatal(err)
+		}
+		return value.Counter.GetValue()
+	}
+	handler := NewHandler("", log.Log)
+
+	t.Run("when the handler panics", func(t *testing.T) {
+		before := counter()
+		wrapped := handler.recoverPanics("negotiate", func(w http.ResponseWriter, r *http.Request) {
+			panic("Mocked panic")
+		})
+		w := httptest.NewRecorder()
+		wrapped(w, httptest.NewRequest("POST", "/negotiate/dash", nil))
+		if w.Code != 500 {
+			t.Fatal("Expected different status code")
+		}
+		if counter() != before+1 {
+			t.Fatal("expected the counter to increase")
+		}
+	})
+
+	t.Run("when the handler does not panic", func(t *testing.T) {
+		before := counter()
+		wrapped := handler.recoverPanics("negotiate", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(204)
+		})
+		w := httptest.NewRecorder()
+		wrapped(w, httptest.NewRequest("POST", "/negotiate/dash", nil))
+		if w.Code != 204 {
+			t.Fatal("Expected different status code")
+		}
+		if counter() != before {
+			t.Fatal("expected the counter not to change")
+		}
+	})
+
+	t.Run("when the handler aborts", func(t *testing.T) {
+		wrapped := handler.recoverPanics("negotiate", func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		})
+		defer func() {
+			if recover() != http.ErrAbortHandler {
+				t.Fatal("expected to see http.ErrAbortHandler")
+			}
+		}()
+		wrapped(httptest.NewRecorder(), httptest.NewRequest("POST", "/negotiate/dash", nil))
+	})
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisKeyPrefix is the prefix of the keys of the sessions in Redis.
+const redisKeyPrefix = "dash:session:"
+
+// redisMaxIdleConns is the maximum number of idle connections we keep.
+const redisMaxIdleConns = 8
+
+// errInvalidRedisURL indicates that the URL of the Redis server is invalid.
+var errInvalidRedisURL = errors.New("expected redis://[:password@]host:port[/db]")
+
+// errRedisProtocol indicates that the Redis server sent an unexpected reply.
+var errRedisProtocol = errors.New("unexpected reply from the Redis server")
+
+// RedisSessionStore is a [SessionStore] using a Redis server, which allows
+// several dash-server processes, possibly running on different hosts, to
+// share sessions. We speak the Redis protocol (RESP) directly, using the
+// GET, SET with PX, and DEL commands, and we keep a small pool of idle
+// connections. The zero value is invalid; use [NewRedisSessionStore].
+type RedisSessionStore struct {
+	// address is the TCP endpoint of the Redis server.
+	address string
+
+	// db is the database number or zero.
+	db int
+
+	// dialer is the dialer we use.
+	dialer *net.Dialer
+
+	// idle contains the idle connections.
+	idle []*redisConn
+
+	// mtx protects idle.
+	mtx sync.Mutex
+
+	// password is the optional password.
+	password string
+}
+
+// redisConn is a connection to the Redis server.
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+var _ SessionStore = &RedisSessionStore{}
+
+// NewRedisSessionStore creates a new [*RedisSessionStore] given the URL of
+// the Redis server, i.e., redis://[:password@]host:port[/db]. We do not
+// connect until we need to, so this function only fails if the URL is invalid.
+func NewRedisSessionStore(URL string) (*RedisSessionStore, error) {
+	parsed, err := url.Parse(URL)
+	if err != nil {
+		return nil, err
+	}
+	if parsed.Scheme != "redis" || parsed.Host == "" {
+		return nil, errInvalidRedisURL
+	}
+	if _, _, err := net.SplitHostPort(parsed.Host); err != nil {
+		return nil, errInvalidRedisURL
+	}
+	store := &RedisSessionStore{
+		address:  parsed.Host,
+		db:       0,
+		dialer:   &net.Dialer{},
+		idle:     []*redisConn{},
+		mtx:      sync.Mutex{},
+		password: "",
+	}
+	if password, found := parsed.User.Password(); found {
+		store.password = password
+	}
+	if path := strings.TrimPrefix(parsed.Path, "/"); path != "" {
+		db, err := strconv.Atoi(path)
+		if err != nil || db < 0 {
+			return nil, errInvalidRedisURL
+		}
+		store.db = db
+	}
+	return store, nil
+}
+
+// Delete implements [SessionStore].
+func (rs *RedisSessionStore) Delete(ctx context.Context, UUID string) error {
+	_, err := rs.do(ctx, "DEL", redisKeyPrefix+UUID)
+	return err
+}
+
+// Load implements [SessionStore].
+func (rs *RedisSessionStore) Load(ctx context.Context, UUID string) ([]byte, error) {
+	reply, err := rs.do(ctx, "GET", redisKeyPrefix+UUID)
+	if err != nil {
+		return nil, err
+	}
+	if reply == nil {
+		return nil, ErrSessionNotFound
+	}
+	return reply, nil
+}
+
+// Store implements [SessionStore].
+func (rs *RedisSessionStore) Store(ctx context.Context, UUID string, data []byte, ttl time.Duration) error {
+	millis := max(ttl.Milliseconds(), 1) // PX requires a positive value
+	_, err := rs.do(ctx, "SET", redisKeyPrefix+UUID, string(data), "PX", strconv.FormatInt(millis, 10))
+	return err
+}
+
+// do sends the given command and returns the reply, which is nil when
+// the server returns the null bulk string (e.g., GET of a missing key).
+func (rs *RedisSessionStore) do(ctx context.Context, args ...string) ([]byte, error) {
+	conn, err := rs.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := conn.roundTrip(ctx, args...)
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		conn.Close() // the connection is in an unknown state
+		return nil, err
+	}
+	rs.putConn(conn)
+	return reply, err
+}
+
+// getConn returns an idle connection or a new connection.
+func (rs *RedisSessionStore) getConn(ctx context.Context) (*redisConn, error) {
+	rs.mtx.Lock()
+	if count := len(rs.idle); count > 0 {
+		conn := rs.idle[count-1]
+		rs.idle = rs.idle[:count-1]
+		rs.mtx.Unlock()
+		return conn, nil
+	}
+	rs.mtx.Unlock()
+	netConn, err := rs.dialer.DialContext(ctx, "tcp", rs.address)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: netConn, reader: bufio.NewReader(netConn)}
+	if rs.password != "" {
+		if _, err := conn.roundTrip(ctx, "AUTH", rs.password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if rs.db != 0 {
+		if _, err := conn.roundTrip(ctx, "SELECT", strconv.Itoa(rs.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// putConn returns the connection to the pool or closes it.
+func (rs *RedisSessionStore) putConn(conn *redisConn) {
+	rs.mtx.Lock()
+	defer rs.mtx.Unlock()
+	if len(rs.idle) >= redisMaxIdleConns {
+		conn.Close()
+		return
+	}
+	rs.idle = append(rs.idle, conn)
+}
+
+// redisError is an error reply sent by the Redis server.
+type redisError string
+
+// Error implements error.
+func (err redisError) Error() string {
+	return "redis: " + string(err)
+}
+
+// roundTrip sends the command and reads the reply honoring the
+// deadline of the context, if any.
+func (conn *redisConn) roundTrip(ctx context.Context, args ...string) ([]byte, error) {
+	deadline, _ := ctx.Deadline() // the zero value means no deadline
+	if err := conn.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&builder, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(conn, builder.String()); err != nil {
+		return nil, err
+	}
+	return conn.readReply()
+}
+
+// readReply reads a simple string, error, integer, or bulk string reply.
+func (conn *redisConn) readReply() ([]byte, error) {
+	line, err := conn.reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errRedisProtocol
+	}
+	switch line[0] {
+	case '+', ':':
+		return []byte(line[1:]), nil
+	case '-':
+		return nil, redisError(line[1:])
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil || length < -1 || length > maxSessionSnapshotSize {
+			return nil, errRedisProtocol
+		}
+		if length == -1 {
+			return nil, nil
+		}
+		data := make([]byte, length+2) // include the final CRLF
+		if _, err := io.ReadFull(conn.reader, data); err != nil {
+			return nil, err
+		}
+		return data[:length], nil
+	default:
+		return nil, errRedisProtocol
+	}
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is a minimal Redis server supporting the commands we use.
+type fakeRedis struct {
+	commands []string
+	entries  map[string]string
+	listener net.Listener
+	mtx      sync.Mutex
+	password string
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := &fakeRedis{entries: map[string]string{}, listener: listener, password: password}
+	go fr.serve()
+	t.Cleanup(func() { listener.Close() })
+	return fr
+}
+
+func (fr *fakeRedis) serve() {
+	for {
+		conn, err := fr.listener.Accept()
+		if err != nil {
+			return
+		}
+		go fr.handle(conn)
+	}
+}
+
+func (fr *fakeRedis) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := fr.password == ""
+	for {
+		args, err := readFakeRedisCommand(reader)
+		if err != nil {
+			return
+		}
+		fr.mtx.Lock()
+		fr.commands = append(fr.commands, args[0])
+		switch {
+		case args[0] == "AUTH" && len(args) == 2 && args[1] == fr.password:
+			authenticated = true
+			fmt.Fprint(conn, "+OK\r\n")
+		case args[0] == "AUTH":
+			fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+		case !authenticated:
+			fmt.Fprint(conn, "-NOAUTH Authentication required\r\n")
+		case args[0] == "SELECT":
+			fmt.Fprint(conn, "+OK\r\n")
+		case args[0] == "SET" && len(args) == 5 && args[3] == "PX":
+			fr.entries[args[1]] = args[2]
+			fmt.Fprint(conn, "+OK\r\n")
+		case args[0] == "GET" && len(args) == 2:
+			if value, found := fr.entries[args[1]]; found {
+				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(value), value)
+			} else {
+				fmt.Fprint(conn, "$-1\r\n")
+			}
+		case args[0] == "DEL" && len(args) == 2:
+			delete(fr.entries, args[1])
+			fmt.Fprint(conn, ":1\r\n")
+		default:
+			fmt.Fprint(conn, "-ERR unknown command\r\n")
+		}
+		fr.mtx.Unlock()
+	}
+}
+
+func readFakeRedisCommand(reader *bufio.Reader) ([]string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil || count <= 0 {
+		return nil, errors.New("invalid command")
+	}
+	var args []string
+	for idx := 0; idx < count; idx++ {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "$")))
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, length+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		args = append(args, string(data[:length]))
+	}
+	return args, nil
+}
+
+func TestNewRedisSessionStore(t *testing.T) {
+	for _, URL := range []string{
+		"", "redis://", "http://127.0.0.1:6379", "redis://127.0.0.1", "redis://127.0.0.1:6379/x",
+	} {
+		if _, err := NewRedisSessionStore(URL); err == nil {
+			t.Fatal("expected an error for", URL)
+		}
+	}
+	store, err := NewRedisSessionStore("redis://:secret@127.0.0.1:6379/3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.address != "127.0.0.1:6379" || store.password != "secret" || store.db != 3 {
+		t.Fatalf("unexpected store: %+v", store)
+	}
+}
+
+func TestRedisSessionStore(t *testing.T) {
+	t.Run("common case", func(t *testing.T) {
+		fr := newFakeRedis(t, "secret")
+		store, err := NewRedisSessionStore("redis://:secret@" + fr.listener.Addr().String() + "/1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := context.Background()
+		if _, err := store.Load(ctx, "deadbeef"); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatal("not the error we expected", err)
+		}
+		if err := store.Store(ctx, "deadbeef", []byte("a\r\nb"), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		data, err := store.Load(ctx, "deadbeef")
+		if err != nil || string(data) != "a\r\nb" {
+			t.Fatal("unexpected result", string(data), err)
+		}
+		if err := store.Delete(ctx, "deadbeef"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Load(ctx, "deadbeef"); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatal("not the error we expected", err)
+		}
+		fr.mtx.Lock()
+		defer fr.mtx.Unlock()
+		// we expect to reuse the same connection
+		expect := "AUTH SELECT GET SET GET DEL GET"
+		if got := strings.Join(fr.commands, " "); got != expect {
+			t.Fatal("unexpected commands", got)
+		}
+		if _, found := fr.entries[redisKeyPrefix+"deadbeef"]; found {
+			t.Fatal("expected the entry to be deleted")
+		}
+	})
+
+	t.Run("with the wrong password", func(t *testing.T) {
+		fr := newFakeRedis(t, "secret")
+		store, err := NewRedisSessionStore("redis://:wrong@" + fr.listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.Load(context.Background(), "deadbeef")
+		var redisErr redisError
+		if !errors.As(err, &redisErr) || !strings.HasPrefix(string(redisErr), "WRONGPASS") {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+
+	t.Run("when the server is down", func(t *testing.T) {
+		fr := newFakeRedis(t, "")
+		fr.listener.Close()
+		store, err := NewRedisSessionStore("redis://" + fr.listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Load(context.Background(), "deadbeef"); err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultSaveQueueSize is the default SaveQueueSize.
+const DefaultSaveQueueSize = 64
+
+// saveQueue is the queue of the sessions whose results the save
+// workers save in the background (see SaveWorkers).
+type saveQueue struct {
+	// closed indicates that we closed sessions.
+	closed bool
+
+	// mtx protects closed and sending on sessions.
+	mtx sync.RWMutex
+
+	// sessions contains the sessions to save.
+	sessions chan *sessionInfo
+
+	// wg allows to wait for the workers to terminate.
+	wg sync.WaitGroup
+}
+
+// StartSaveWorkers starts the SaveWorkers goroutines saving the results of
+// the collected sessions in the background, so that slow disks do not inflate
+// the collect latency perceived by clients. When SaveWorkers is zero or
+// negative, this function does nothing and we save on the request goroutine.
+// The goroutines terminate after the |ctx| context becomes expired, once they
+// have saved all the queued results. Call this function at most once and
+// before serving requests.
+func (h *Handler) StartSaveWorkers(ctx context.Context) {
+	if h.SaveWorkers <= 0 {
+		return
+	}
+	queue := &saveQueue{sessions: make(chan *sessionInfo, max(h.SaveQueueSize, 0))}
+	for idx := 0; idx < h.SaveWorkers; idx++ {
+		queue.wg.Add(1)
+		go h.saveWorker(queue)
+	}
+	go func() {
+		<-ctx.Done()
+		queue.mtx.Lock()
+		defer queue.mtx.Unlock()
+		queue.closed = true
+		close(queue.sessions)
+	}()
+	h.saves = queue
+}
+
+// JoinSaveWorkers blocks until the save workers have saved all the
+// queued results and terminated.
+func (h *Handler) JoinSaveWorkers() {
+	if h.saves != nil {
+		h.saves.wg.Wait()
+	}
+}
+
+// saveWorker is a goroutine saving the results of the queued sessions.
+func (h *Handler) saveWorker(queue *saveQueue) {
+	h.logger.Debug("saveWorker: start")
+	defer h.logger.Debug("saveWorker: done")
+	defer queue.wg.Done()
+	for session := range queue.sessions {
+		saveQueueLength.Dec()
+		_ = h.deps.Savedata(session) // error already printed by h.savedata()
+	}
+}
+
+// save saves the results of the given session using the save workers, when
+// they are running, and otherwise on the current goroutine. When the queue is
+// full, we block until there is room, which applies backpressure to clients,
+// or until |ctx| is done, in which case we save on the current goroutine.
+// Because the workers save in the background, we cannot report their errors
+// and we return nil when we have queued the session.
+func (h *Handler) save(ctx context.Context, session *sessionInfo) error {
+	if h.enqueueSave(ctx, session) {
+		return nil
+	}
+	return h.deps.Savedata(session)
+}
+
+// enqueueSave returns whether it could queue the given session.
+func (h *Handler) enqueueSave(ctx context.Context, session *sessionInfo) bool {
+	queue := h.saves
+	if queue == nil {
+		return false
+	}
+	queue.mtx.RLock()
+	defer queue.mtx.RUnlock()
+	if queue.closed {
+		return false
+	}
+	saveQueueLength.Inc()
+	select {
+	case queue.sessions <- session:
+		return true
+	default:
+	}
+	h.logger.Warn("save: queue full; waiting for the save workers")
+	saveQueueFull.Inc()
+	select {
+	case queue.sessions <- session:
+		return true
+	case <-ctx.Done():
+		saveQueueLength.Dec()
+		return false
+	}
+}
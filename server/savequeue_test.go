@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+func TestHandlerSaveWorkers(t *testing.T) {
+	t.Run("without workers we save on the current goroutine", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		expected := errors.New("mocked error")
+		handler.deps.Savedata = func(session *sessionInfo) error {
+			return expected
+		}
+		handler.StartSaveWorkers(context.Background())
+		if err := handler.save(context.Background(), &sessionInfo{}); !errors.Is(err, expected) {
+			t.Fatal("not the error we expected", err)
+		}
+		handler.JoinSaveWorkers() // should not block
+	})
+
+	t.Run("with workers we save in the background", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.SaveWorkers = 2
+		unblock := make(chan any)
+		var saved atomic.Int64
+		handler.deps.Savedata = func(session *sessionInfo) error {
+			<-unblock
+			saved.Add(1)
+			return errors.New("mocked error")
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		handler.StartSaveWorkers(ctx)
+		for idx := 0; idx < 4; idx++ {
+			if err := handler.save(context.Background(), &sessionInfo{}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		close(unblock)
+		cancel()
+		handler.JoinSaveWorkers()
+		if saved.Load() != 4 {
+			t.Fatal("we did not save all the queued sessions", saved.Load())
+		}
+	})
+
+	t.Run("when the queue is full we apply backpressure", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.SaveQueueSize = 0
+		handler.SaveWorkers = 1
+		started, unblock := make(chan any), make(chan any)
+		handler.deps.Savedata = func(session *sessionInfo) error {
+			if session.iteration == 0 {
+				close(started)
+				<-unblock
+			}
+			return nil
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		handler.StartSaveWorkers(ctx)
+		if err := handler.save(context.Background(), &sessionInfo{}); err != nil {
+			t.Fatal(err)
+		}
+		<-started // the worker is now busy
+		expired, cancelExpired := context.WithCancel(context.Background())
+		cancelExpired()
+		// with an expired context we save on the current goroutine
+		if handler.enqueueSave(expired, &sessionInfo{iteration: 1}) {
+			t.Fatal("expected to fail to enqueue")
+		}
+		close(unblock)
+	})
+
+	t.Run("after the workers terminate we save on the current goroutine", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.SaveWorkers = 1
+		var saved atomic.Int64
+		handler.deps.Savedata = func(session *sessionInfo) error {
+			saved.Add(1)
+			return nil
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		handler.StartSaveWorkers(ctx)
+		cancel()
+		handler.JoinSaveWorkers()
+		if err := handler.save(context.Background(), &sessionInfo{}); err != nil {
+			t.Fatal(err)
+		}
+		if saved.Load() != 1 {
+			t.Fatal("we did not save the session")
+		}
+	})
+}
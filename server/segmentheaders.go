@@ -0,0 +1,49 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultContentType is the default value of Handler.ContentType.
+const DefaultContentType = "video/mp4"
+
+// etagPrefixSize is the size of the segment prefix we hash for the ETag,
+// which avoids hashing the whole segment for each response.
+const etagPrefixSize = 4096
+
+// errInvalidContentType indicates that a content type is not valid.
+var errInvalidContentType = errors.New("invalid content type")
+
+// ValidateContentType checks whether the given content type (see the
+// Handler.ContentType field) is a valid media type.
+func ValidateContentType(value string) error {
+	if _, _, err := mime.ParseMediaType(value); err != nil {
+		return fmt.Errorf("%w: %q: %s", errInvalidContentType, value, err.Error())
+	}
+	return nil
+}
+
+// setSegmentHeaders sets the headers describing the segment we are about to
+// send. With RealisticHeaders, we also emulate the headers that origins send
+// along with CMAF segments, so that middleboxes treat the response like a
+// genuine video segment. We advertise byte ranges like origins do but we
+// ignore Range requests and send the whole segment, which RFC 9110 allows.
+func (h *Handler) setSegmentHeaders(w http.ResponseWriter, data []byte, now time.Time) {
+	w.Header().Set("Content-Type", h.ContentType)
+	if !h.RealisticHeaders {
+		return
+	}
+	prefix := data[:min(len(data), etagPrefixSize)]
+	etag := strconv.FormatUint(uint64(crc32.ChecksumIEEE(prefix)), 16) + "-" + strconv.Itoa(len(data))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", strconv.Quote(etag))
+	w.Header().Set("Last-Modified", now.UTC().Format(http.TimeFormat))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+)
+
+func TestValidateContentType(t *testing.T) {
+	for _, value := range []string{DefaultContentType, "video/iso.segment", "audio/mp4; codecs=mp4a.40.2"} {
+		if err := ValidateContentType(value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, value := range []string{"", "video/", "video/mp4; codecs"} {
+		if err := ValidateContentType(value); err == nil {
+			t.Fatal("expected an error for", value)
+		}
+	}
+}
+
+func TestServerSegmentHeaders(t *testing.T) {
+	download := func(handler *Handler) *httptest.ResponseRecorder {
+		handler.createSession("deadbeef")
+		req := httptest.NewRequest("GET", "/dash/download/1000", nil)
+		req.Header.Set(authorization, "deadbeef")
+		w := httptest.NewRecorder()
+		handler.download(w, req)
+		if w.Code != 200 {
+			t.Fatal("Expected different status code")
+		}
+		return w
+	}
+
+	t.Run("by default", func(t *testing.T) {
+		w := download(NewHandler("", log.Log))
+		if w.Header().Get("Content-Type") != DefaultContentType {
+			t.Fatal("unexpected Content-Type")
+		}
+		if w.Header().Get("Accept-Ranges") != "" || w.Header().Get("ETag") != "" {
+			t.Fatal("expected no realistic headers")
+		}
+	})
+
+	t.Run("with a custom content type and realistic headers", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.ContentType = "video/iso.segment"
+		handler.RealisticHeaders = true
+		w := download(handler)
+		if w.Header().Get("Content-Type") != "video/iso.segment" {
+			t.Fatal("unexpected Content-Type")
+		}
+		if w.Header().Get("Accept-Ranges") != "bytes" || w.Header().Get("Cache-Control") != "no-cache" {
+			t.Fatal("expected realistic headers")
+		}
+		if w.Header().Get("ETag") == "" || w.Header().Get("Last-Modified") == "" {
+			t.Fatal("expected validators")
+		}
+	})
+
+	t.Run("cache busting takes precedence", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.CacheBusting = true
+		handler.RealisticHeaders = true
+		w := download(handler)
+		if w.Header().Get("Cache-Control") != "no-store, no-cache, must-revalidate, private" {
+			t.Fatal("unexpected Cache-Control")
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// These are the possible statuses of a self-check.
+const (
+	SelfCheckError   = "error"
+	SelfCheckOK      = "ok"
+	SelfCheckWarning = "warning"
+)
+
+const (
+	// selfCheckCertExpiry is the remaining certificate validity below
+	// which we warn that the certificate is about to expire.
+	selfCheckCertExpiry = 14 * 24 * time.Hour
+
+	// selfCheckCertTimeLayout is the layout for printing certificate
+	// validity times in self-check messages.
+	selfCheckCertTimeLayout = time.RFC3339
+)
+
+// selfCheckMinTime is the minimum time we consider sane. A clock before
+// this time is certainly wrong and would cause us to save results with
+// bogus timestamps and to reject valid TLS certificates.
+var selfCheckMinTime = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// SelfCheckConfig contains the configuration of the startup self-checks.
+type SelfCheckConfig struct {
+	// DryRun indicates that we MUST NOT modify the filesystem, hence we
+	// check whether we could write into the datadir without writing.
+	DryRun bool
+
+	// TLSCert is the path of the TLS certificate. When empty, we do
+	// not check the TLS certificate and key.
+	TLSCert string
+
+	// TLSKey is the path of the TLS key.
+	TLSKey string
+}
+
+// SelfCheck is the result of a startup self-check.
+type SelfCheck struct {
+	// Message explains how to fix the problem or is empty when
+	// the status is SelfCheckOK.
+	Message string `json:"message,omitempty"`
+
+	// Name is the name of the self-check (e.g., "datadir").
+	Name string `json:"name"`
+
+	// Status is one of SelfCheckOK, SelfCheckWarning, and SelfCheckError.
+	Status string `json:"status"`
+}
+
+// SelfCheck runs the startup self-checks, which verify that the datadir is
+// writable, that the TLS certificate is valid and not about to expire, and that
+// the clock is sane. We log the problems we find and save the results, which the
+// /admin/health page returns. The return value is an error joining the messages
+// of the failed checks, meaning that the server should not start, or nil.
+//
+// Running self-checks at startup allows to fail fast with actionable errors
+// rather than, e.g., with 500s when the first client collects.
+func (h *Handler) SelfCheck(config *SelfCheckConfig) error {
+	now := h.now()
+	datadirCheck := checkDatadir
+	if config.DryRun {
+		datadirCheck = checkDatadirDryRun
+	}
+	checks := []SelfCheck{datadirCheck(h.datadir), checkClock(now)}
+	if config.TLSCert != "" {
+		checks = append(checks, checkCertificate(config.TLSCert, config.TLSKey, now))
+	}
+	var errs []error
+	for _, check := range checks {
+		switch check.Status {
+		case SelfCheckError:
+			h.logger.Warnf("selfcheck: %s: %s", check.Name, check.Message)
+			errs = append(errs, fmt.Errorf("%s: %s", check.Name, check.Message))
+		case SelfCheckWarning:
+			h.logger.Warnf("selfcheck: %s: %s", check.Name, check.Message)
+		default:
+			h.logger.Debugf("selfcheck: %s: %s", check.Name, check.Status)
+		}
+	}
+	h.mtx.Lock()
+	h.selfChecks = checks
+	h.mtx.Unlock()
+	return errors.Join(errs...)
+}
+
+// datadirFailure returns the failed datadir self-check.
+func datadirFailure(dirname string, err error) SelfCheck {
+	return SelfCheck{
+		Message: fmt.Sprintf(
+			"cannot write into %s (%s): make sure that the -datadir exists and is writable by the server user",
+			dirname, err.Error()),
+		Name:   "datadir",
+		Status: SelfCheckError,
+	}
+}
+
+// checkDatadir checks whether we can write results into the datadir.
+func checkDatadir(datadir string) SelfCheck {
+	check := SelfCheck{Name: "datadir", Status: SelfCheckOK}
+	dirname := filepath.Join(datadir, "dash")
+	fail := func(err error) SelfCheck {
+		return datadirFailure(dirname, err)
+	}
+	if err := os.MkdirAll(dirname, 0755); err != nil {
+		return fail(err)
+	}
+	filep, err := os.CreateTemp(dirname, "selfcheck-*"+tempSuffix)
+	if err != nil {
+		return fail(err)
+	}
+	_, err = filep.Write([]byte("{}"))
+	if err == nil {
+		err = filep.Sync()
+	}
+	if closeErr := filep.Close(); err == nil {
+		err = closeErr
+	}
+	if removeErr := os.Remove(filep.Name()); err == nil {
+		err = removeErr
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return check
+}
+
+// errNotDirectory indicates that the datadir is not a directory.
+var errNotDirectory = errors.New("not a directory")
+
+// checkDatadirDryRun is like checkDatadir but does not write into the
+// datadir. Because checkDatadir creates the missing directories, we check
+// whether we could write into the nearest existing one.
+func checkDatadirDryRun(datadir string) SelfCheck {
+	dirname := filepath.Join(datadir, "dash")
+	for current := dirname; ; current = filepath.Dir(current) {
+		info, err := os.Stat(current)
+		switch {
+		case err == nil && !info.IsDir():
+			return datadirFailure(dirname, fmt.Errorf("%s: %w", current, errNotDirectory))
+		case err == nil:
+			if err := checkWritableDir(current); err != nil {
+				return datadirFailure(dirname, err)
+			}
+			return SelfCheck{Name: "datadir", Status: SelfCheckOK}
+		case !errors.Is(err, fs.ErrNotExist) || filepath.Dir(current) == current:
+			return datadirFailure(dirname, err)
+		}
+	}
+}
+
+// checkClock checks whether the clock is sane.
+func checkClock(now time.Time) SelfCheck {
+	check := SelfCheck{Name: "clock", Status: SelfCheckOK}
+	if now.Before(selfCheckMinTime) {
+		check.Status = SelfCheckError
+		check.Message = fmt.Sprintf(
+			"the clock (%s) is before %s: make sure the clock is synchronized (e.g., using NTP)",
+			now.Format(time.RFC3339), selfCheckMinTime.Format(time.RFC3339))
+	}
+	return check
+}
+
+// checkCertificate checks whether the TLS certificate is valid at the given
+// time and warns when it is about to expire.
+func checkCertificate(certFile, keyFile string, now time.Time) SelfCheck {
+	check := SelfCheck{Name: "certificate", Status: SelfCheckOK}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		check.Status = SelfCheckError
+		check.Message = fmt.Sprintf(
+			"cannot load %s and %s (%s): make sure that -tls-cert and -tls-key point to a matching PEM certificate and key",
+			certFile, keyFile, err.Error())
+		return check
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		check.Status = SelfCheckError
+		check.Message = fmt.Sprintf("cannot parse %s (%s): make sure it is a valid X.509 certificate", certFile, err.Error())
+		return check
+	}
+	switch {
+	case now.Before(leaf.NotBefore):
+		check.Status = SelfCheckError
+		check.Message = fmt.Sprintf(
+			"%s is not valid before %s: make sure the clock is synchronized or wait for the certificate to become valid",
+			certFile, leaf.NotBefore.Format(selfCheckCertTimeLayout))
+	case now.After(leaf.NotAfter):
+		check.Status = SelfCheckError
+		check.Message = fmt.Sprintf("%s expired on %s: renew the certificate",
+			certFile, leaf.NotAfter.Format(selfCheckCertTimeLayout))
+	case leaf.NotAfter.Sub(now) < selfCheckCertExpiry:
+		check.Status = SelfCheckWarning
+		check.Message = fmt.Sprintf("%s expires on %s: renew the certificate soon",
+			certFile, leaf.NotAfter.Format(selfCheckCertTimeLayout))
+	}
+	return check
+}
+
+// healthResponse is the response returned by the /admin/health page.
+type healthResponse struct {
+	// Checks contains the results of the startup self-checks.
+	Checks []SelfCheck `json:"checks"`
+
+	// Draining indicates whether we are in drain mode.
+	Draining bool `json:"draining"`
+}
+
+// healthHandler implements the /admin/health handler.
+func (h *Handler) healthHandler(w http.ResponseWriter, r *http.Request) {
+	h.mtx.Lock()
+	resp := healthResponse{Checks: h.selfChecks, Draining: h.Draining()}
+	h.mtx.Unlock()
+	data, err := json.Marshal(resp)
+	if err != nil {
+		h.logger.Warnf("healthHandler: json.Marshal: %s", err.Error())
+		w.WriteHeader(500)
+		return
+	}
+	status := http.StatusOK
+	for _, check := range resp.Checks {
+		if check.Status == SelfCheckError {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	if resp.Draining {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	_, _ = w.Write(data)
+}
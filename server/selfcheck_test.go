@@ -0,0 +1,202 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/fs"
+	"math/big"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// writeTestCertificate writes a self-signed certificate valid between
+// notBefore and notAfter and the corresponding key into dir.
+func writeTestCertificate(t *testing.T, dir string, notBefore, notAfter time.Time) (string, string) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, publicKey, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certData := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyData := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(certFile, certData, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyData, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestCheckDatadir(t *testing.T) {
+	t.Run("with writable datadir", func(t *testing.T) {
+		dir := t.TempDir()
+		if check := checkDatadir(dir); check.Status != SelfCheckOK {
+			t.Fatal("unexpected check", check)
+		}
+		entries, err := os.ReadDir(filepath.Join(dir, "dash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Fatal("expected no leftover files")
+		}
+	})
+
+	t.Run("when datadir is a file", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(name, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if check := checkDatadir(name); check.Status != SelfCheckError || check.Message == "" {
+			t.Fatal("unexpected check", check)
+		}
+	})
+}
+
+func TestCheckDatadirDryRun(t *testing.T) {
+	t.Run("with missing datadir", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "missing")
+		if check := checkDatadirDryRun(dir); check.Status != SelfCheckOK {
+			t.Fatal("unexpected check", check)
+		}
+		if _, err := os.Stat(dir); !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal("expected not to create the datadir", err)
+		}
+	})
+
+	t.Run("when datadir is a file", func(t *testing.T) {
+		name := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(name, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		check := checkDatadirDryRun(name)
+		if check.Status != SelfCheckError || !strings.Contains(check.Message, errNotDirectory.Error()) {
+			t.Fatal("unexpected check", check)
+		}
+	})
+}
+
+func TestCheckClock(t *testing.T) {
+	if check := checkClock(time.Now()); check.Status != SelfCheckOK {
+		t.Fatal("unexpected check", check)
+	}
+	if check := checkClock(time.Unix(0, 0)); check.Status != SelfCheckError {
+		t.Fatal("unexpected check", check)
+	}
+}
+
+func TestCheckCertificate(t *testing.T) {
+	now := time.Now()
+	for _, tc := range []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		expect    string
+	}{{
+		name:      "with valid certificate",
+		notBefore: now.Add(-time.Hour),
+		notAfter:  now.Add(90 * 24 * time.Hour),
+		expect:    SelfCheckOK,
+	}, {
+		name:      "with certificate about to expire",
+		notBefore: now.Add(-time.Hour),
+		notAfter:  now.Add(24 * time.Hour),
+		expect:    SelfCheckWarning,
+	}, {
+		name:      "with expired certificate",
+		notBefore: now.Add(-48 * time.Hour),
+		notAfter:  now.Add(-24 * time.Hour),
+		expect:    SelfCheckError,
+	}, {
+		name:      "with certificate not valid yet",
+		notBefore: now.Add(24 * time.Hour),
+		notAfter:  now.Add(90 * 24 * time.Hour),
+		expect:    SelfCheckError,
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			certFile, keyFile := writeTestCertificate(t, t.TempDir(), tc.notBefore, tc.notAfter)
+			if check := checkCertificate(certFile, keyFile, now); check.Status != tc.expect {
+				t.Fatal("unexpected check", check)
+			}
+		})
+	}
+
+	t.Run("with missing files", func(t *testing.T) {
+		dir := t.TempDir()
+		check := checkCertificate(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), now)
+		if check.Status != SelfCheckError {
+			t.Fatal("unexpected check", check)
+		}
+	})
+}
+
+func TestServerSelfCheck(t *testing.T) {
+	health := func(handler *Handler) (int, healthResponse) {
+		w := httptest.NewRecorder()
+		handler.healthHandler(w, httptest.NewRequest("GET", "/admin/health", nil))
+		var resp healthResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, resp
+	}
+
+	t.Run("common case", func(t *testing.T) {
+		dir := t.TempDir()
+		certFile, keyFile := writeTestCertificate(t, dir, time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour))
+		handler := NewHandler(dir, log.Log)
+		if err := handler.SelfCheck(&SelfCheckConfig{TLSCert: certFile, TLSKey: keyFile}); err != nil {
+			t.Fatal(err)
+		}
+		code, resp := health(handler)
+		if code != 200 {
+			t.Fatal("Expected different status code")
+		}
+		if len(resp.Checks) != 3 || resp.Checks[2].Status != SelfCheckWarning {
+			t.Fatal("unexpected checks", resp.Checks)
+		}
+		handler.Drain()
+		if code, _ := health(handler); code != 503 {
+			t.Fatal("Expected different status code")
+		}
+	})
+
+	t.Run("with failing check", func(t *testing.T) {
+		dir := t.TempDir()
+		handler := NewHandler(dir, log.Log)
+		err := handler.SelfCheck(&SelfCheckConfig{
+			TLSCert: filepath.Join(dir, "cert.pem"),
+			TLSKey:  filepath.Join(dir, "key.pem"),
+		})
+		if err == nil {
+			t.Fatal("expected an error here")
+		}
+		if code, _ := health(handler); code != 503 {
+			t.Fatal("Expected different status code")
+		}
+	})
+}
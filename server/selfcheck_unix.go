@@ -0,0 +1,11 @@
+//go:build linux || darwin
+
+package server
+
+import "golang.org/x/sys/unix"
+
+// checkWritableDir checks whether we could create files in the given
+// directory without actually creating them.
+func checkWritableDir(dirname string) error {
+	return unix.Access(dirname, unix.W_OK|unix.X_OK)
+}
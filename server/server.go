@@ -2,14 +2,19 @@
 package server
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/ed25519"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"path"
 	"path/filepath"
@@ -25,14 +30,33 @@ import (
 
 // sessionInfo contains information about an active session.
 type sessionInfo struct {
+	// address is the client IP address.
+	address string
+
+	// bytes is the number of bytes sent as part of this session.
+	bytes int64
+
+	// downloads is the number of downloads in progress.
+	downloads int
+
 	// iteration is the number of iterations done by the active session.
 	iteration int64
 
+	// request contains the parameters negotiated by the client.
+	request model.NegotiateRequest
+
+	// seed is the seed of the payload (see spec.FillPayload) or nil,
+	// in which case we send random payloads.
+	seed []byte
+
 	// serverSchema contains the server schema for the given session.
 	serverSchema model.ServerSchema
 
 	// stamp is when we created this struct.
 	stamp time.Time
+
+	// uuid is the UUID of the session.
+	uuid string
 }
 
 // timeNowUTC returns the current time using UTC.
@@ -46,6 +70,7 @@ type dependencies struct {
 	IOReadAll          func(r io.Reader) ([]byte, error)
 	JSONMarshal        func(v interface{}) ([]byte, error)
 	OSMkdirAll         func(path string, perm os.FileMode) error
+	OSLink             func(oldname, newname string) error
 	OSOpenFile         func(name string, flag int, perm os.FileMode) (*os.File, error)
 	RandRead           func(p []byte) (n int, err error)
 	Savedata           func(session *sessionInfo) error
@@ -60,44 +85,366 @@ type dependencies struct {
 // get rid of sessions that have been running for too much. If you don't
 // call StartReaper, you will eventually run out of RAM.
 type Handler struct {
+	// ASNLookup is an optional function mapping the client IP address to
+	// the autonomous system number. When nil, which is the default set by
+	// NewHandler, we do not know the ASN of clients.
+	ASNLookup func(address string) (uint32, error)
+
+	// BanDuration is the duration of the temporary ban of clients that
+	// reach BanThreshold. This field is initialized by NewHandler to ten
+	// minutes.
+	BanDuration time.Duration
+
+	// BanThreshold is the number of abusive failures (e.g., requests using
+	// invalid sessions or malformed bodies) within a minute after which we
+	// temporarily ban the client address for BanDuration. Zero or negative
+	// means that abuse detection is disabled. This field is initialized
+	// by NewHandler to zero.
+	BanThreshold int
+
+	// BaseURL is the optional base URL (e.g., "https://node.example.com")
+	// that we return to clients during the negotiation, instructing them to
+	// use its scheme and host for downloading and collecting. This allows
+	// to centralize negotiation and delegate serving segments to other nodes,
+	// which MUST have access to the same sessions. This field is initialized
+	// by NewHandler to an empty string, meaning that clients should use the
+	// same server for negotiating, downloading, and collecting.
+	BaseURL string
+
+	// CacheBusting indicates whether to include headers preventing caching
+	// in download responses and to echo the client's cache busting token
+	// (see spec.CacheBustingQuery) in the spec.CacheBustingHeader, so that
+	// clients can detect segments served by transparent caches. This field
+	// is initialized by NewHandler to false.
+	CacheBusting bool
+
+	// CaptureHook is the optional hook for capturing the packets or the
+	// packets metadata of the connections used by each session. When nil,
+	// which is what NewHandler configures, we do not capture.
+	CaptureHook CaptureHook
+
+	// Clock is the source of time that we use for the lifetime of sessions,
+	// the reaper, draining, and the other behavior depending on time, which
+	// allows tests to simulate the passing of time (see [Clock]). This field
+	// is initialized by NewHandler to the system clock.
+	Clock Clock
+
+	// ContentType is the Content-Type of download responses, which allows
+	// to emulate other kinds of segments (e.g., "video/iso.segment" or
+	// "audio/mp4"). See also ValidateContentType. This field is initialized
+	// by NewHandler to DefaultContentType.
+	ContentType string
+
+	// CountryLookup is an optional function mapping the client IP address
+	// to the country code. When nil, which is the default set by NewHandler,
+	// we do not know the country of clients.
+	CountryLookup func(address string) (string, error)
+
+	// ExportToken is the bearer token that clients of the /admin/export
+	// handler must send in the Authorization header (see RegisterAdminHandlers).
+	// This field is initialized by NewHandler to an empty string, meaning
+	// that the export is disabled.
+	ExportToken string
+
+	// FragmentedMP4 enables generating segments containing syntactically
+	// valid CMAF media fragments (i.e., moof and mdat boxes with a random
+	// sample) rather than opaque random bytes, so that traffic classifiers
+	// based on DPI see real video containers. Because the boxes cannot be
+	// derived from the seed, in this mode we do not give clients a seed for
+	// verifying the payload. This field is initialized by NewHandler to false.
+	FragmentedMP4 bool
+
+	// IndexMaxBytes is the size in bytes after which we rotate the JSONL
+	// index of completed sessions (see [IndexEntry]). Zero or negative means
+	// that we do not write the index. This field is initialized by NewHandler
+	// to DefaultIndexMaxBytes.
+	IndexMaxBytes int64
+
+	// LegacySchema indicates whether to also save the results using the
+	// legacy schema of the original Neubot server (i.e., version 3), which
+	// allows operators with existing pipelines to adopt this server without
+	// rewriting their parsers. We save these results, which do not include
+	// any extension to the original format, inside the dash-v3 directory
+	// of the datadir using the same layout and file names. Failing to save
+	// them does not cause collect to fail. This field is initialized by
+	// NewHandler to false.
+	LegacySchema bool
+
+	// LiveSegmentDuration enables the live pacing mode when positive. In
+	// this mode we emulate a live stream origin where a new segment is
+	// produced every LiveSegmentDuration: the first segment is available
+	// immediately and requests for segments that have not been produced
+	// yet fail with 425 (Too Early). This field is initialized by NewHandler
+	// to zero, meaning that segments are always available.
+	LiveSegmentDuration time.Duration
+
+	// MASQUEResearch enables the research mode where clients reach us
+	// through a MASQUE (i.e., CONNECT-UDP) front, which allows to study
+	// the effect of proxied transports on streaming performance. In this
+	// mode, for requests coming from TrustedProxies, we save with the
+	// results the transport protocol that the front reports using the
+	// X-Forwarded-Transport header. The front MUST be in TrustedProxies,
+	// so that we also know the client address. This field is initialized
+	// by NewHandler to false.
+	MASQUEResearch bool
+
+	// MaxSessionBytes is the maximum number of bytes that we are willing
+	// to send or receive as part of a single session. We shorten the segment
+	// that would exceed this budget, except for fragmented MP4 segments (see
+	// FragmentedMP4), which we cannot shorten without corrupting them, and we
+	// refuse with 429 the uploaded segment that would exceed it. Once a
+	// session has exhausted this budget, further download and upload requests
+	// fail with 429 and we save and remove the session. Zero or negative means
+	// no limit. This field is initialized by NewHandler to zero.
+	MaxSessionBytes int64
+
+	// MinClientVersion is the minimum version of the client library (i.e.,
+	// the version in the "neubot-dash/<version>" token of the User-Agent
+	// header) that we accept, which allows to retire clients with known
+	// measurement bugs. We reject negotiations from older clients, and from
+	// clients without a valid version, with 426 and a JSON body containing
+	// the minimum version. This field is initialized by NewHandler to an
+	// empty string, meaning that we accept any client.
+	MinClientVersion string
+
+	// MinDownloadRate is the minimum plausible rate (in kbit/s) at which
+	// clients receive segments. We use it to set a write deadline on each
+	// download response proportional to the segment size, so that stalled
+	// receivers cannot hold handler goroutines and segment buffers forever.
+	// Zero or negative means no deadline. This field is initialized by
+	// NewHandler to DefaultMinDownloadRate.
+	MinDownloadRate int64
+
+	// MirrorDatadir is the optional second directory where we save each
+	// results file, using the same layout as the datadir, which protects
+	// against losing results on nodes with flaky disks before they have
+	// been uploaded (e.g., by using a network filesystem). Failing to write
+	// into the mirror does not cause collect to fail, and we count the
+	// outcome of writing into each directory. This field is initialized by
+	// NewHandler to an empty string, meaning that there is no mirror.
+	MirrorDatadir string
+
+	// RealisticHeaders enables emitting, along with download responses, the
+	// headers that origins send along with CMAF segments (e.g., Accept-Ranges,
+	// ETag, and Last-Modified), so that middleboxes treat our traffic like
+	// genuine video segments, which improves the ecological validity of the
+	// measurements. When CacheBusting is also enabled, its headers take
+	// precedence. This field is initialized by NewHandler to false.
+	RealisticHeaders bool
+
+	// SaveQueueSize is the number of collected sessions waiting for the
+	// save workers (see SaveWorkers) after which collect blocks until there
+	// is room in the queue, which applies backpressure to clients. This field
+	// is initialized by NewHandler to DefaultSaveQueueSize.
+	SaveQueueSize int
+
+	// SaveWorkers is the number of goroutines saving the results in the
+	// background (see StartSaveWorkers), so that collect responds as soon
+	// as it has queued the results and slow disks do not inflate the collect
+	// latency perceived by clients. Because of that, collect does not fail
+	// when we cannot save the results. This field is initialized by NewHandler
+	// to zero, meaning that we save the results on the request goroutine.
+	SaveWorkers int
+
+	// ServerTiming enables the experimental mode where we send, as trailers
+	// of each download response, the time between receiving the request and
+	// starting to send the segment and the time for sending it, so that
+	// clients can decompose the delay they observe. Because trailers require
+	// the chunked encoding with HTTP/1.1, we do not send the Content-Length
+	// in this mode. This field is initialized by NewHandler to false.
+	ServerTiming bool
+
+	// SessionStore is the optional store of sessions shared with other
+	// handlers (e.g., other replicas behind a load balancer), so that
+	// requests landing on a handler other than the one that negotiated
+	// still succeed. We save each session into the store after negotiating
+	// and after each download or upload, and we load it at the beginning of
+	// each download, upload, and collect when we do not know the session.
+	// Because we prefer our copy of the session, when we have it, and the
+	// store keeps the last saved session, requests of the same session served
+	// by different handlers may lose updates, so the load balancer SHOULD
+	// route them to the same handler whenever possible (e.g., using the
+	// Authorization header as the affinity key), and, because collecting
+	// twice relies on local state, clients retrying collect SHOULD reach
+	// the same handler. Connection pinning and captures also only work
+	// within a single handler. See [RedisSessionStore] for sharing sessions
+	// among processes. This field is initialized by NewHandler to nil,
+	// meaning that we only use local sessions.
+	SessionStore SessionStore
+
+	// SessionBinding is the policy restricting who can use a session token
+	// after the negotiation. With SessionBindingAddress, only the IP address
+	// that negotiated can download and collect. With SessionBindingConnection,
+	// only the connection used for negotiating can, which requires clients to
+	// keep it alive (see the PinConnection client option). We reject with
+	// 403 the requests violating the policy. This field is initialized by
+	// NewHandler to SessionBindingNone.
+	SessionBinding SessionBinding
+
+	// SigningKey is the optional key for signing the results. When not nil,
+	// for each saved results file we also write a detached Ed25519 signature
+	// of the file content in a file with the same name plus the ".sig"
+	// suffix, so one can verify that a trusted server produced the file and
+	// nobody tampered with it. This field is initialized by NewHandler
+	// to nil (i.e., we do not sign results).
+	SigningKey ed25519.PrivateKey
+
+	// StorageLayout is the template of the directory, relative to the "dash"
+	// directory of the datadir, where we save the results files. The {date}
+	// placeholder expands to the date of the session (e.g., "2024/01/29"), the
+	// {asn} placeholder to the client ASN (e.g., "AS137"), and the {country}
+	// placeholder to the client country (e.g., "IT"), where we use "unknown"
+	// when ASNLookup or CountryLookup cannot tell. For example, with the
+	// "{asn}/{date}" layout, one can easily analyze the results of each ISP
+	// on the filesystem. See also ValidateStorageLayout. This field is
+	// initialized by NewHandler to DefaultStorageLayout.
+	StorageLayout string
+
+	// SyncDirectory indicates whether to fsync the directory after moving
+	// each results file into place, which makes the results durable at the
+	// cost of additional I/O. This field is initialized by NewHandler to
+	// false, in which case we only fsync the results files.
+	SyncDirectory bool
+
+	// TrustedProxies contains the networks of the proxies that we trust to
+	// set the X-Forwarded-Proto header, which we use to determine the scheme
+	// used by the client when we are behind a TLS terminating proxy, and the
+	// X-Forwarded-For header, which we use to determine the client address,
+	// which we return as RealAddress and use for session binding and abuse
+	// detection. This field is initialized by NewHandler to an empty list.
+	TrustedProxies []netip.Prefix
+
+	// abuse tracks the abusive failures and the bans.
+	abuse *abuseTracker
+
+	// aggregates contains the rolling aggregates of completed sessions.
+	aggregates *aggregator
+
+	// captures maps the ID of each captured connection to the number of
+	// sessions using it, because sessions may share a connection.
+	captures map[string]int
+
+	// capturesMtx protects captures and serializes the CaptureHook calls.
+	capturesMtx sync.Mutex
+
 	// datadir is the directory where to save measurements.
 	datadir string
 
+	// deadlineGrace is the time we add to the time to transfer a
+	// segment at the MinDownloadRate (see downloadDeadlineGrace).
+	deadlineGrace time.Duration
+
 	// deps contains the [*Handler] dependencies.
 	deps dependencies
 
+	// drain is closed when we enter into the drain mode.
+	drain chan any
+
+	// drainOnce ensures we close drain just once.
+	drainOnce sync.Once
+
+	// failures contains the recent failed requests.
+	failures *failureRing
+
+	// faults contains the faults to inject (see [*Handler.SetFaults]).
+	faults Faults
+
+	// faultsMtx protects faults.
+	faultsMtx sync.Mutex
+
+	// indexMtx serializes writing the index.
+	indexMtx sync.Mutex
+
 	// logger is the logger to use.
 	logger model.Logger
 
 	// maxIterations is the maximum allowed number of iterations.
 	maxIterations int64
 
-	// mtx protects the sessions map.
+	// mtx protects the sessions and tombstones maps and selfChecks.
 	mtx sync.Mutex
 
+	// saves is the queue of the save workers or nil when they are
+	// not running (see StartSaveWorkers).
+	saves *saveQueue
+
+	// selfChecks contains the results of the startup self-checks.
+	selfChecks []SelfCheck
+
 	// sessions maps a session UUID to session info.
 	sessions map[string]*sessionInfo
 
 	// stop is closed when the reaper goroutine is stopped.
 	stop chan any
+
+	// summaries contains the summaries of the recent sessions.
+	summaries *summaryRing
+
+	// tombstones maps the UUID of recently collected sessions to
+	// the response we sent, which makes collect idempotent.
+	tombstones map[string]*tombstone
 }
 
 // NewHandler creates a new [*Handler] instance.
 func NewHandler(datadir string, logger model.Logger) *Handler {
 	handler := &Handler{
-		datadir:       datadir,
-		deps:          dependencies{}, // initialized later
-		logger:        logger,
-		maxIterations: 17,
-		mtx:           sync.Mutex{},
-		sessions:      make(map[string]*sessionInfo),
-		stop:          make(chan interface{}),
+		ASNLookup:           nil,
+		BanDuration:         10 * time.Minute,
+		BanThreshold:        0,
+		BaseURL:             "",
+		CacheBusting:        false,
+		CaptureHook:         nil,
+		Clock:               systemClock{},
+		ContentType:         DefaultContentType,
+		CountryLookup:       nil,
+		ExportToken:         "",
+		FragmentedMP4:       false,
+		IndexMaxBytes:       DefaultIndexMaxBytes,
+		LegacySchema:        false,
+		LiveSegmentDuration: 0,
+		MASQUEResearch:      false,
+		MaxSessionBytes:     0,
+		MinClientVersion:    "",
+		MinDownloadRate:     DefaultMinDownloadRate,
+		MirrorDatadir:       "",
+		RealisticHeaders:    false,
+		SaveQueueSize:       DefaultSaveQueueSize,
+		SaveWorkers:         0,
+		ServerTiming:        false,
+		SessionStore:        nil,
+		SessionBinding:      SessionBindingNone,
+		SigningKey:          nil,
+		StorageLayout:       DefaultStorageLayout,
+		SyncDirectory:       false,
+		TrustedProxies:      []netip.Prefix{},
+		abuse:               newAbuseTracker(),
+		aggregates:          newAggregator(),
+		captures:            map[string]int{},
+		datadir:             datadir,
+		deadlineGrace:       downloadDeadlineGrace,
+		deps:                dependencies{}, // initialized later
+		drain:               make(chan any),
+		drainOnce:           sync.Once{},
+		failures:            newFailureRing(recentFailures),
+		faults:              Faults{},
+		faultsMtx:           sync.Mutex{},
+		indexMtx:            sync.Mutex{},
+		logger:              logger,
+		maxIterations:       17,
+		mtx:                 sync.Mutex{},
+		saves:               nil,
+		selfChecks:          []SelfCheck{},
+		sessions:            make(map[string]*sessionInfo),
+		stop:                make(chan interface{}),
+		summaries:           newSummaryRing(recentSummaries),
+		tombstones:          make(map[string]*tombstone),
 	}
 	handler.deps = dependencies{
 		GzipNewWriterLevel: gzip.NewWriterLevel,
 		IOReadAll:          io.ReadAll,
 		JSONMarshal:        json.Marshal,
 		OSMkdirAll:         os.MkdirAll,
+		OSLink:             os.Link,
 		OSOpenFile:         os.OpenFile,
 		RandRead:           rand.Read, // math/rand is okay to use here
 		Savedata:           handler.savedata,
@@ -110,19 +457,99 @@ func NewHandler(datadir string, logger model.Logger) *Handler {
 //
 // This method LOCKS and MUTATES the .sessions field.
 func (h *Handler) createSession(UUID string) {
-	now := timeNowUTC()
+	h.createNegotiatedSession(UUID, "", "", model.NegotiateRequest{}, nil)
+}
+
+// createNegotiatedSession is like createSession but also saves the client
+// address, the effective scheme used by the client, and the parameters that
+// the client sent during the negotiation. When the negotiate request r is
+// not nil, we also record the test conditions, whether the client resumed
+// a TLS session, and the transport reported by a MASQUE front, if any.
+//
+// This method LOCKS and MUTATES the .sessions field.
+func (h *Handler) createNegotiatedSession(
+	UUID, address, scheme string, request model.NegotiateRequest, r *http.Request) {
+	now := h.now()
 	session := &sessionInfo{
-		stamp: now,
+		address: address,
+		request: request,
+		stamp:   now,
+		uuid:    UUID,
 		serverSchema: model.ServerSchema{
+			Scheme:              scheme,
 			ServerSchemaVersion: spec.CurrentServerSchemaVersion,
 			ServerTimestamp:     now.Unix(),
+			Streams:             request.Streams,
 		},
 	}
+	if r != nil {
+		h.recordTestConditions(session, r)
+		h.recordTLSResumption(session, r)
+		h.recordProxiedTransport(session, r)
+	}
 	h.mtx.Lock()
 	defer h.mtx.Unlock()
 	h.sessions[UUID] = session
 }
 
+const (
+	// streamSegmentDuration is the duration of the segments requested
+	// by clients that do not negotiate the segment duration.
+	streamSegmentDuration = 2 * time.Second
+
+	// maxSegmentDuration is the maximum segment duration that we
+	// are willing to accept from clients.
+	maxSegmentDuration = 10 * time.Second
+
+	// maxStreamDuration is the maximum stream emulation duration, and
+	// more in general the maximum test duration, that we are willing to
+	// accept from clients.
+	maxStreamDuration = 10 * time.Minute
+
+	// sessionLifetime is the lifetime of a session that did not
+	// negotiate the test parameters. Otherwise, it is the slack we
+	// add to the duration of the negotiated test.
+	sessionLifetime = 60 * time.Second
+)
+
+// streamDuration returns the stream emulation duration negotiated by
+// the client or zero if the client did not ask for this mode.
+func (s *sessionInfo) streamDuration() time.Duration {
+	return time.Duration(s.request.StreamDuration) * time.Second
+}
+
+// segmentDuration returns the segment duration negotiated by the
+// client or the default segment duration.
+func (s *sessionInfo) segmentDuration() time.Duration {
+	if s.request.SegmentDuration <= 0 {
+		return streamSegmentDuration
+	}
+	return time.Duration(s.request.SegmentDuration) * time.Second
+}
+
+// negotiatedIterations returns the number of iterations implied by the
+// negotiated test parameters or zero when the client did not negotiate
+// them. In stream emulation mode the client may need more iterations
+// than the ones it explicitly asked for.
+func (s *sessionInfo) negotiatedIterations() int64 {
+	return max(s.request.Iterations, int64(s.streamDuration()/s.segmentDuration()))
+}
+
+// maxIterations returns the maximum number of iterations allowed for
+// this session given the default number of iterations.
+func (s *sessionInfo) maxIterations(defaultIterations int64) int64 {
+	return max(defaultIterations, s.negotiatedIterations())
+}
+
+// lifetime returns the maximum lifetime of this session given the live
+// segment duration (see Handler.LiveSegmentDuration). When the client has
+// negotiated the test parameters, the lifetime is the duration of the test
+// plus some slack, such that we do not reap longer tests midway.
+func (s *sessionInfo) lifetime(liveSegmentDuration time.Duration) time.Duration {
+	segmentDuration := max(s.segmentDuration(), liveSegmentDuration)
+	return sessionLifetime + time.Duration(s.negotiatedIterations())*segmentDuration
+}
+
 // sessionState is the state of a measurement session.
 type sessionState int
 
@@ -136,6 +563,10 @@ const (
 
 	// sessionExpired is a session that performed all the possible iterations.
 	sessionExpired
+
+	// sessionOverBudget is a session that has sent more bytes than
+	// allowed by the configured MaxSessionBytes.
+	sessionOverBudget
 )
 
 // getSessionState returns the state of the session with the given UUID.
@@ -146,12 +577,74 @@ func (h *Handler) getSessionState(UUID string) sessionState {
 	if !ok {
 		return sessionMissing
 	}
-	if session.iteration >= h.maxIterations {
+	if session.iteration >= session.maxIterations(h.maxIterations) {
 		return sessionExpired
 	}
+	if h.MaxSessionBytes > 0 && session.bytes >= h.MaxSessionBytes {
+		return sessionOverBudget
+	}
 	return sessionActive
 }
 
+// remainingBytes returns the number of bytes that the session with the
+// given UUID may still send or receive (see MaxSessionBytes), or a negative
+// value when there is no limit or the session does not exist.
+func (h *Handler) remainingBytes(UUID string) int64 {
+	if h.MaxSessionBytes <= 0 {
+		return -1
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	session, ok := h.sessions[UUID]
+	if !ok {
+		return -1
+	}
+	return max(h.MaxSessionBytes-session.bytes, 0)
+}
+
+// liveSegmentWait returns how much time the session with the given UUID
+// should wait before the next segment is produced when using the live pacing
+// mode. A zero return value means that the next segment is available.
+func (h *Handler) liveSegmentWait(UUID string) time.Duration {
+	if h.LiveSegmentDuration <= 0 {
+		return 0
+	}
+	now := h.now()
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	session, ok := h.sessions[UUID]
+	if !ok {
+		return 0
+	}
+	available := session.stamp.Add(time.Duration(session.iteration) * h.LiveSegmentDuration)
+	if wait := available.Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+// setSessionSeed SAFELY SETS the payload seed of the session with the
+// given UUID, if such a session exists.
+func (h *Handler) setSessionSeed(UUID string, seed []byte) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if session, ok := h.sessions[UUID]; ok {
+		session.seed = seed
+	}
+}
+
+// getSessionSeed SAFELY RETURNS the payload seed of the session with the
+// given UUID, or nil, along with the index of the next iteration.
+func (h *Handler) getSessionSeed(UUID string) ([]byte, int64) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	session, ok := h.sessions[UUID]
+	if !ok {
+		return nil, 0
+	}
+	return session.seed, session.iteration
+}
+
 // updateSession updates the state of the session with the given UUID after
 // we successfully performed a new iteration.
 //
@@ -159,35 +652,60 @@ func (h *Handler) getSessionState(UUID string) sessionState {
 // session's serverSchema by adding a new measurement result and by
 // incrementing the number of iterations.
 //
-// The integer argument, currently ignored, contains the number of bytes
-// that were sent as part of the current DASH iteration.
-func (h *Handler) updateSession(UUID string, _ int) {
-	now := timeNowUTC()
+// The integer argument contains the number of bytes that were sent as
+// part of the current DASH iteration and is added to the session's bytes.
+//
+// This method returns the index of the new measurement result, which
+// allows to update it later, or -1 if the session does not exist.
+func (h *Handler) updateSession(UUID string, count int) int {
+	now := h.now()
 	h.mtx.Lock()
 	defer h.mtx.Unlock()
 	session, ok := h.sessions[UUID]
-	if ok {
-		session.serverSchema.Server = append(
-			session.serverSchema.Server, model.ServerResults{
-				Iteration: session.iteration,
-				Ticks:     now.Sub(session.stamp).Seconds(),
-				Timestamp: now.Unix(),
-			},
-		)
-		session.iteration++
+	if !ok {
+		return -1
+	}
+	session.serverSchema.Server = append(
+		session.serverSchema.Server, model.ServerResults{
+			Iteration: session.iteration,
+			Ticks:     now.Sub(session.stamp).Seconds(),
+			Timestamp: now.Unix(),
+		},
+	)
+	session.iteration++
+	session.bytes += int64(count)
+	return len(session.serverSchema.Server) - 1
+}
+
+// updateWireBytes SAFELY SETS the number of bytes written on the wire
+// for the idx-th measurement result of the session with the given UUID.
+func (h *Handler) updateWireBytes(UUID string, idx int, count int64) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	session, ok := h.sessions[UUID]
+	if ok && idx >= 0 && idx < len(session.serverSchema.Server) {
+		session.serverSchema.Server[idx].WireBytes = count
 	}
 }
 
 // popSession returns nil if a session with the given UUID does not exist, otherwise
 // is SAFELY REMOVES and returns the corresponding [*sessionInfo].
+//
+// Because the session is over, this method also stops capturing the
+// connections used by the session, if a CaptureHook is configured, and
+// deletes the session from the SessionStore, if configured.
 func (h *Handler) popSession(UUID string) *sessionInfo {
 	h.mtx.Lock()
-	defer h.mtx.Unlock()
 	session, ok := h.sessions[UUID]
+	if ok {
+		delete(h.sessions, UUID)
+	}
+	h.mtx.Unlock()
 	if !ok {
 		return nil
 	}
-	delete(h.sessions, UUID)
+	h.forgetSharedSession(UUID)
+	h.stopCapture(session)
 	return session
 }
 
@@ -199,23 +717,34 @@ func (h *Handler) CountSessions() (count int) {
 	return
 }
 
-// reapStaleSessions SAFELY REMOVES all the sessions created more than 60 seconds ago.
+// reapStaleSessions SAFELY REMOVES all the sessions that have been
+// alive for more than their lifetime (typically 60 seconds), which we
+// also summarize, like collected sessions, for the dashboard and the
+// aggregates, noting that we do not know their median rate. We also
+// delete the stale sessions from the SessionStore, if configured, such
+// that a later request cannot load them again.
 func (h *Handler) reapStaleSessions() {
+	for _, session := range h.popStaleSessions() {
+		h.forgetSharedSession(session.uuid)
+		h.stopCapture(session)
+		h.summarize(session)
+	}
+}
+
+// popStaleSessions SAFELY REMOVES and returns the stale sessions.
+func (h *Handler) popStaleSessions() (stale []*sessionInfo) {
 	h.mtx.Lock()
 	defer h.mtx.Unlock()
 	h.logger.Debugf("reapStaleSessions: inspecting %d sessions", len(h.sessions))
-	now := timeNowUTC()
-	var stale []string
+	now := h.now()
 	for UUID, session := range h.sessions {
-		const toomuch = 60 * time.Second
-		if now.Sub(session.stamp) > toomuch {
-			stale = append(stale, UUID)
+		if now.Sub(session.stamp) > session.lifetime(h.LiveSegmentDuration) {
+			stale = append(stale, session)
+			delete(h.sessions, UUID)
 		}
 	}
 	h.logger.Debugf("reapStaleSessions: reaping %d stale sessions", len(stale))
-	for _, UUID := range stale {
-		delete(h.sessions, UUID)
-	}
+	return
 }
 
 // negotiate implements the /negotiate/dash handler.
@@ -230,13 +759,30 @@ func (h *Handler) reapStaleSessions() {
 // clients do not call this method first, measurements will fail for lack of a valid
 // session UUID.
 func (h *Handler) negotiate(w http.ResponseWriter, r *http.Request) {
+	// Refuse new sessions when we are draining for maintenance.
+	if h.Draining() {
+		w.Header().Set("Retry-After", strconv.Itoa(int(drainRetryAfter.Seconds())))
+		setFailureReason(w, "draining")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	// Refuse clients older than the minimum version, if any.
+	if h.rejectOldClient(w, r) {
+		return
+	}
+
 	// Obtain the client's remote address.
 	address, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		h.logger.Warnf("negotiate: net.SplitHostPort: %s", err.Error())
+		setFailureReason(w, "cannot parse remote address")
 		w.WriteHeader(500)
 		return
 	}
+	if proxied, found := h.forwardedAddress(r); found {
+		address = proxied
+	}
 
 	// Create a new random UUID for the session.
 	//
@@ -244,10 +790,40 @@ func (h *Handler) negotiate(w http.ResponseWriter, r *http.Request) {
 	UUID, err := h.deps.UUIDNewRandom()
 	if err != nil {
 		h.logger.Warnf("negotiate: uuid.NewRandom: %s", err.Error())
+		setFailureReason(w, "cannot create session UUID")
 		w.WriteHeader(500)
 		return
 	}
 
+	// Read the parameters requested by the client.
+	request, err := h.readNegotiateRequest(w, r)
+	if err != nil {
+		h.logger.Warnf("negotiate: %s", err.Error())
+		setFailureReason(w, "request body too large")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Create the seed from which we derive the session payload, unless we
+	// generate fragments, whose boxes we cannot derive from the seed.
+	var seed []byte
+	if !h.FragmentedMP4 {
+		seed = make([]byte, spec.SeedSize)
+		if _, err := h.deps.RandRead(seed); err != nil {
+			h.logger.Warnf("negotiate: rand.Read: %s", err.Error())
+			setFailureReason(w, "cannot create seed")
+			w.WriteHeader(500)
+			return
+		}
+	}
+
+	// Do not delegate downloading and collecting to other nodes when the
+	// client wants to pin the whole session to this connection.
+	baseURL := h.BaseURL
+	if request.PinConnection {
+		baseURL = ""
+	}
+
 	// Prepare the response.
 	//
 	// Implementation note: we do not include any vector of speeds
@@ -261,24 +837,122 @@ func (h *Handler) negotiate(w http.ResponseWriter, r *http.Request) {
 	// tolerating incoming requests that do not contain any body.
 	data, err := h.deps.JSONMarshal(model.NegotiateResponse{
 		Authorization: UUID.String(),
+		BaseURL:       baseURL,
+		MaxIterations: (&sessionInfo{request: request}).maxIterations(h.maxIterations),
+		MaxSize:       maxSize,
+		MinSize:       minSize,
 		QueuePos:      0,
 		RealAddress:   address,
+		Seed:          hex.EncodeToString(seed),
+		ServerTime:    float64(h.now().UnixNano()) / 1e9,
+		Streams:       request.Streams,
 		Unchoked:      1,
 	})
 
 	// Make sure we can properly marshal the response.
 	if err != nil {
 		h.logger.Warnf("negotiate: json.Marshal: %s", err.Error())
+		setFailureReason(w, "cannot marshal response")
 		w.WriteHeader(500)
 		return
 	}
 
 	// Send the response.
 	w.Header().Set("Content-Type", "application/json")
-	h.createSession(UUID.String())
+	h.createNegotiatedSession(UUID.String(), address, h.effectiveScheme(r), request, r)
+	h.setSessionSeed(UUID.String(), seed)
+	h.trackConn(UUID.String(), r)
+	h.publishSession(r.Context(), UUID.String())
 	_, _ = w.Write(data)
 }
 
+// effectiveScheme returns the scheme used by the client. When the request
+// comes from a trusted proxy terminating TLS, we honor X-Forwarded-Proto.
+func (h *Handler) effectiveScheme(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if !h.isTrustedProxy(r.RemoteAddr) {
+		return scheme
+	}
+	switch value := strings.ToLower(r.Header.Get("X-Forwarded-Proto")); value {
+	case "http", "https":
+		return value
+	default:
+		return scheme
+	}
+}
+
+// isTrustedProxy returns whether the given endpoint belongs to a trusted proxy.
+func (h *Handler) isTrustedProxy(endpoint string) bool {
+	addrport, err := netip.ParseAddrPort(endpoint)
+	if err != nil {
+		return false
+	}
+	return h.isTrustedAddr(addrport.Addr().Unmap())
+}
+
+// isTrustedAddr returns whether the given address belongs to a trusted proxy.
+func (h *Handler) isTrustedAddr(addr netip.Addr) bool {
+	for _, prefix := range h.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxNegotiateBodySize is the maximum size of the negotiate request body.
+const maxNegotiateBodySize = 1 << 16
+
+// readNegotiateRequest reads and returns the optional request body sent
+// by the client as part of the negotiation. Because we tolerate requests
+// without a body, we return the default parameters on failure, except
+// that we return an error when the body is larger than maxNegotiateBodySize.
+//
+// This function also makes sure that the parameters requested by the
+// client are within the bounds that we are willing to accept.
+func (h *Handler) readNegotiateRequest(
+	w http.ResponseWriter, r *http.Request) (request model.NegotiateRequest, err error) {
+	if r.Body == nil {
+		return
+	}
+	data, err := h.deps.IOReadAll(http.MaxBytesReader(w, r.Body, maxNegotiateBodySize))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return request, err
+	}
+	if err != nil {
+		h.logger.Debugf("negotiate: io.ReadAll: %s", err.Error())
+		return request, nil
+	}
+	if len(data) <= 0 {
+		return
+	}
+	if err := json.Unmarshal(data, &request); err != nil {
+		h.logger.Debugf("negotiate: json.Unmarshal: %s", err.Error())
+		return model.NegotiateRequest{}, nil
+	}
+	if request.StreamDuration < 0 {
+		request.StreamDuration = 0
+	}
+	if limit := int64(maxStreamDuration / time.Second); request.StreamDuration > limit {
+		request.StreamDuration = limit
+	}
+	request.SegmentDuration = max(0, min(request.SegmentDuration, int64(maxSegmentDuration/time.Second)))
+	segmentDuration := request.SegmentDuration
+	if segmentDuration <= 0 {
+		segmentDuration = int64(streamSegmentDuration / time.Second)
+	}
+	request.Iterations = max(0, min(request.Iterations, int64(maxStreamDuration/time.Second)/segmentDuration))
+	request.Streams = approveStreams(request)
+	if len(request.DASHRates) > maxDASHRates {
+		request.DASHRates = request.DASHRates[:maxDASHRates]
+	}
+	return
+}
+
 const (
 	// minSize is the minimum segment size that this server can return.
 	//
@@ -294,6 +968,9 @@ const (
 
 	// authorization is the key for the Authorization header.
 	authorization = "Authorization"
+
+	// tempSuffix is the suffix of the files we are writing.
+	tempSuffix = ".tmp"
 )
 
 // minSize string is the string representation of the minSize constant.
@@ -307,24 +984,56 @@ var minSizeString = fmt.Sprintf("%d", minSize)
 // count may be way bigger than the real data length, I've changed
 // this function to _also_ update count to the real value.
 func (h *Handler) genbody(count *int) (data []byte, err error) {
+	clampSize(count)
+	data = make([]byte, *count)
+	_, err = h.deps.RandRead(data)
+	return
+}
+
+// gensegment is like genbody but generates the deterministic payload of the
+// next segment of the session with the given UUID, when the session has a
+// seed, and otherwise falls back to generating a random body. With the
+// FragmentedMP4 mode, we instead generate a fragment (see genfragment).
+func (h *Handler) gensegment(UUID string, count *int) ([]byte, error) {
+	seed, iteration := h.getSessionSeed(UUID)
+	if h.FragmentedMP4 {
+		return h.genfragment(count, iteration)
+	}
+	if seed == nil {
+		return h.genbody(count)
+	}
+	clampSize(count)
+	data := make([]byte, *count)
+	spec.FillPayload(seed, iteration, data)
+	return data, nil
+}
+
+// clampSize updates count to be within the acceptable bounds
+// allowed by the protocol for the response size.
+func clampSize(count *int) {
 	if *count < minSize {
 		*count = minSize
 	}
 	if *count > maxSize {
 		*count = maxSize
 	}
-	data = make([]byte, *count)
-	_, err = h.deps.RandRead(data)
-	return
 }
 
 // download implements the /dash/download handler.
 func (h *Handler) download(w http.ResponseWriter, r *http.Request) {
-	// make sure we have a valid session
+	// record when we received the request for the server timing
+	received := time.Now()
+
+	// make sure we have a valid session, possibly loading it from the
+	// shared session store and saving it back when we are done
 	sessionID := r.Header.Get(authorization)
+	h.loadSharedSession(r.Context(), sessionID)
+	defer h.publishSession(context.Background(), sessionID)
 	state := h.getSessionState(sessionID)
 	if state == sessionMissing {
 		h.logger.Warn("download: session missing")
+		h.reportAbuse(r, abuseInvalidSession)
+		setFailureReason(w, "session missing")
 		w.WriteHeader(400)
 		return
 	}
@@ -338,10 +1047,73 @@ func (h *Handler) download(w http.ResponseWriter, r *http.Request) {
 	// more useful and actionable to the client.
 	if state == sessionExpired {
 		h.logger.Warn("download: session expired")
+		h.reportAbuse(r, abuseExpiredSession)
+		setFailureReason(w, "session expired")
+		w.WriteHeader(429)
+		return
+	}
+
+	// Make sure the session did not exceed its byte budget. In such a
+	// case we finalize the session by saving what we have measured so far
+	// and removing it, so that a client with modified rate logic cannot
+	// use us as an unlimited speed-test backend.
+	if state == sessionOverBudget {
+		h.logger.Warn("download: session over budget")
+		h.reportAbuse(r, abuseOverBudget)
+		if session := h.popSession(sessionID); session != nil {
+			_ = h.save(r.Context(), session) // error already printed by h.savedata()
+			h.summarize(session)
+		}
+		setFailureReason(w, "session over budget")
 		w.WriteHeader(429)
 		return
 	}
 
+	// Make sure the request complies with the SessionBinding policy, so
+	// that other hosts cannot use shared or replayed session tokens.
+	if !h.boundRequest(sessionID, r) {
+		h.logger.Warn("download: session bound to another client")
+		h.reportAbuse(r, abuseUnboundSession)
+		setFailureReason(w, "session bound to another client")
+		w.WriteHeader(403)
+		return
+	}
+
+	// Make sure a session pinned to a connection does not use another one,
+	// so that the connection stats correspond exactly to the session.
+	if h.unpinnedConn(sessionID, r) {
+		h.logger.Warn("download: session pinned to another connection")
+		setFailureReason(w, "session pinned to another connection")
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	// Make sure the session is not already downloading, unless it negotiated
+	// the multi-connection mode, since overlapping downloads would otherwise
+	// corrupt the iteration accounting.
+	if !h.beginDownload(sessionID) {
+		h.logger.Warn("download: session already downloading")
+		setFailureReason(w, "session already downloading")
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	defer h.endDownload(sessionID)
+
+	// Keep track of the connection used by this request.
+	h.trackConn(sessionID, r)
+
+	// When emulating a live stream origin, make sure the next segment
+	// has already been produced. Otherwise, tell the client when to
+	// retry, so it can measure the latency to segment availability.
+	if wait := h.liveSegmentWait(sessionID); wait > 0 {
+		h.logger.Debugf("download: segment not produced yet; wait %s", wait)
+		seconds := int64((wait + time.Second - 1) / time.Second)
+		w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+		setFailureReason(w, "segment not produced yet")
+		w.WriteHeader(http.StatusTooEarly)
+		return
+	}
+
 	// obtain the number of bytes we should send to the client according
 	// to what the client would like to receive.
 	siz := strings.Replace(r.URL.Path, "/dash/download", "", -1)
@@ -349,83 +1121,259 @@ func (h *Handler) download(w http.ResponseWriter, r *http.Request) {
 	if siz == "" {
 		siz = minSizeString
 	}
-	count, err := strconv.Atoi(siz)
+	count, err := parseSize(siz)
 	if err != nil {
-		h.logger.Warnf("download: strconv.Atoi: %s", err.Error())
-		w.WriteHeader(400)
+		h.rejectMalformedSize(w, r, err)
 		return
 	}
 
 	// generate body possibly adjusting the count if it falls out of
 	// the acceptable bounds for the response size.
-	data, err := h.genbody(&count)
+	data, err := h.gensegment(sessionID, &count)
 	if err != nil {
-		h.logger.Warnf("download: genbody: %s", err.Error())
+		h.logger.Warnf("download: gensegment: %s", err.Error())
+		setFailureReason(w, "cannot generate segment")
 		w.WriteHeader(500)
 		return
 	}
 
+	// Make sure the last segment does not exceed the byte budget.
+	if remaining := h.remainingBytes(sessionID); remaining >= 0 && remaining < int64(len(data)) && !h.FragmentedMP4 {
+		data = data[:remaining]
+	}
+
 	// Register that the session has done an iteration.
-	h.updateSession(sessionID, len(data))
+	idx := h.updateSession(sessionID, len(data))
 
-	// Send the response.
-	w.Header().Set("Content-Type", "video/mp4")
-	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
-	_, _ = w.Write(data)
+	// Send the response and flush it, so that the connection counters, if
+	// any, include all the bytes we sent, including headers and TLS overhead.
+	//
+	// Note that with HTTP/2 several streams may share the connection, in
+	// which case the bytes written also include other streams' bytes.
+	var counters *connCounters
+	if info, ok := connInfoFromContext(r.Context()); ok {
+		counters = info.Counters
+	}
+	before := counters.Written()
+	h.setSegmentHeaders(w, data, received)
+	if h.ServerTiming {
+		declareServerTiming(w)
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	}
+	if h.CacheBusting {
+		w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate, private")
+		w.Header().Set("Pragma", "no-cache")
+		if token := r.URL.Query().Get(spec.CacheBustingQuery); token != "" {
+			w.Header().Set(spec.CacheBustingHeader, token)
+		}
+	}
+	clearDeadline := h.setDownloadDeadline(w, len(data))
+	defer clearDeadline()
+	sending := time.Now()
+	if h.shouldTruncate(r) {
+		// Abort the response after half of the body, which closes the
+		// connection, so the client sees a truncated body in any case.
+		sent, _ := h.writeSegment(w, r, data[:len(data)/2])
+		h.recordTruncated(sessionID, idx, sent)
+		panic(http.ErrAbortHandler)
+	}
+	sent, err := h.writeSegment(w, r, data)
+	h.recordSent(sessionID, idx, sent, err != nil)
+	scheme, proto := h.metricLabels(r)
+	sentBytes.WithLabelValues(scheme, proto).Add(float64(sent))
+	if err != nil {
+		h.logger.Warnf("download: aborted after %d of %d bytes: %s", sent, len(data), err.Error())
+		abortedDownloads.WithLabelValues(scheme, proto).Inc()
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			expiredDownloads.WithLabelValues(scheme, proto).Inc()
+		}
+		return
+	}
+	if counters != nil {
+		h.updateWireBytes(sessionID, idx, counters.Written()-before)
+	}
+	if h.ServerTiming {
+		setServerTiming(w, sending.Sub(received), time.Since(sending))
+	}
 }
 
+// These constants define the name of the results files (e.g.,
+// "neubot-dash-20240129T100000.000000000Z.json.gz").
+const (
+	resultsFilePrefix     = "neubot-dash-"
+	resultsFileTimeFormat = "20060102T150405.000000000Z"
+	resultsFileSuffix     = ".json.gz"
+)
+
 // savedata is an utility function saving information about this session.
 func (h *Handler) savedata(session *sessionInfo) error {
-	// obtain the directory path where to write
-	name := path.Join(h.datadir, "dash", session.stamp.Format("2006/01/02"))
+	// marshal the measurement to JSON
+	data, err := h.deps.JSONMarshal(session.serverSchema)
+	if err != nil {
+		h.logger.Warnf("savedata: json.Marshal: %s", err.Error())
+		return err
+	}
 
-	// make sure we have the correct directory hierarchy
-	err := h.deps.OSMkdirAll(name, 0755)
+	// compress the measurement in memory
+	compressed, err := h.compress(data)
 	if err != nil {
+		return err
+	}
+
+	// write the results into the datadir and into the mirror, if any,
+	// where failing to write into the mirror is not fatal
+	dirname := path.Join("dash", h.storageDir(session))
+	filename := resultsFilePrefix + session.stamp.Format(resultsFileTimeFormat) + resultsFileSuffix
+	err = h.writeResults(h.datadir, dirname, filename, compressed)
+	observeSavedResults(savedResultsDatadir, err)
+	if err == nil {
+		h.appendIndex(session, path.Join(dirname, filename))
+	}
+	if h.MirrorDatadir != "" {
+		mirrorErr := h.writeResults(h.MirrorDatadir, dirname, filename, compressed)
+		observeSavedResults(savedResultsMirror, mirrorErr)
+		if mirrorErr != nil {
+			h.logger.Warnf("savedata: cannot write into the mirror: %s", mirrorErr.Error())
+		}
+	}
+
+	// possibly also save the results using the legacy schema
+	if err == nil && h.LegacySchema {
+		h.saveLegacy(session, filename)
+	}
+	return err
+}
+
+// compress compresses the given measurement in memory using gzip.
+func (h *Handler) compress(data []byte) ([]byte, error) {
+	compressed := &bytes.Buffer{}
+	zipper, err := h.deps.GzipNewWriterLevel(compressed, gzip.BestSpeed)
+	if err != nil {
+		h.logger.Warnf("savedata: gzip.NewWriterLevel: %s", err.Error())
+		return nil, err
+	}
+	if _, err := zipper.Write(data); err != nil {
+		h.logger.Warnf("savedata: gzip.Writer.Write: %s", err.Error())
+		return nil, err
+	}
+	if err := zipper.Close(); err != nil {
+		h.logger.Warnf("savedata: gzip.Writer.Close: %s", err.Error())
+		return nil, err
+	}
+	return compressed.Bytes(), nil
+}
+
+// writeResults writes the results file with the given name inside the given
+// directory of the given datadir and possibly signs it.
+func (h *Handler) writeResults(datadir, dirname, filename string, data []byte) error {
+	// make sure we have the correct directory hierarchy
+	name := path.Join(datadir, dirname)
+	if err := h.deps.OSMkdirAll(name, 0755); err != nil {
 		h.logger.Warnf("savedata: os.MkdirAll: %s", err.Error())
 		return err
 	}
 
-	// append the file name to the path
-	name = filepath.Join(name, "neubot-dash-"+session.stamp.Format("20060102T150405.000000000Z")+".json.gz")
+	// write the results file and possibly sign it
+	name = filepath.Join(name, filename)
+	if err := h.writeFileAtomic(name, data); err != nil {
+		return err
+	}
+	if h.SigningKey == nil {
+		return nil
+	}
+	return h.sign(name, data)
+}
 
-	// open the results file
+// writeFileAtomic writes the given data into the file with the given name
+// such that crashes or power losses never leave truncated files behind. To
+// this end, we write into a temporary file, fsync it, and link it into place,
+// which, unlike renaming, fails when the file already exists, so we never
+// overwrite results. When SyncDirectory is true, we also fsync the directory.
+func (h *Handler) writeFileAtomic(name string, data []byte) error {
+	// open the temporary file
 	//
 	// My assumption here is that we have nanosecond precision and hence it's
 	// unlikely to have conflicts. If I'm wrong, O_EXCL will let us know.
-	filep, err := h.deps.OSOpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	tempName := name + tempSuffix
+	filep, err := h.deps.OSOpenFile(tempName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
 	if err != nil {
-		h.logger.Warnf("savedata: os.OpenFile: %s", err.Error())
+		h.logger.Warnf("writeFileAtomic: os.OpenFile: %s", err.Error())
 		return err
 	}
-	defer filep.Close()
 
-	// wrap the output file with a gzipper
-	zipper, err := h.deps.GzipNewWriterLevel(filep, gzip.BestSpeed)
+	// write and fsync the temporary file
+	_, err = filep.Write(data)
+	if err == nil {
+		err = filep.Sync()
+	}
+	if closeErr := filep.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
-		h.logger.Warnf("savedata: gzip.NewWriterLevel: %s", err.Error())
+		h.logger.Warnf("writeFileAtomic: %s", err.Error())
+		_ = os.Remove(tempName)
 		return err
 	}
-	defer zipper.Close()
 
-	// marshal the measurement to JSON
-	data, err := h.deps.JSONMarshal(session.serverSchema)
+	// move the temporary file into place
+	err = h.deps.OSLink(tempName, name)
+	_ = os.Remove(tempName)
 	if err != nil {
-		h.logger.Warnf("savedata: json.Marshal: %s", err.Error())
+		h.logger.Warnf("writeFileAtomic: os.Link: %s", err.Error())
 		return err
 	}
+	if !h.SyncDirectory {
+		return nil
+	}
 
-	// write compressed data into the file
-	_, err = zipper.Write(data)
-	return err
+	// make sure the link itself is durable
+	dirp, err := os.Open(filepath.Dir(name))
+	if err != nil {
+		h.logger.Warnf("writeFileAtomic: os.Open: %s", err.Error())
+		return err
+	}
+	defer dirp.Close()
+	return dirp.Sync()
 }
 
 // collect implements the /collect/dash handler.
 func (h *Handler) collect(w http.ResponseWriter, r *http.Request) {
+	// make sure the request complies with the session binding policy, after
+	// possibly loading the session from the shared session store
+	sessionID := r.Header.Get(authorization)
+	h.loadSharedSession(r.Context(), sessionID)
+	if !h.boundRequest(sessionID, r) {
+		h.logger.Warn("collect: session bound to another client")
+		h.reportAbuse(r, abuseUnboundSession)
+		setFailureReason(w, "session bound to another client")
+		w.WriteHeader(403)
+		return
+	}
+
+	// make sure a session pinned to a connection does not use another one
+	if h.unpinnedConn(sessionID, r) {
+		h.logger.Warn("collect: session pinned to another connection")
+		setFailureReason(w, "session pinned to another connection")
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
 	// make sure we have a session
-	session := h.popSession(r.Header.Get(authorization))
+	session := h.popSession(sessionID)
 	if session == nil {
+		// when the client retries after a network error, we have already
+		// collected, so we respond again with the same data
+		if data, ok := h.getTombstone(sessionID); ok {
+			h.logger.Debug("collect: session already collected")
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			_, _ = w.Write(data)
+			return
+		}
 		h.logger.Warn("collect: session missing")
+		h.reportAbuse(r, abuseInvalidSession)
+		setFailureReason(w, "session missing")
 		w.WriteHeader(400)
 		return
 	}
@@ -434,6 +1382,7 @@ func (h *Handler) collect(w http.ResponseWriter, r *http.Request) {
 	data, err := h.deps.IOReadAll(r.Body)
 	if err != nil {
 		h.logger.Warnf("collect: io.ReadAll: %s", err.Error())
+		setFailureReason(w, "cannot read body")
 		w.WriteHeader(400)
 		return
 	}
@@ -442,25 +1391,32 @@ func (h *Handler) collect(w http.ResponseWriter, r *http.Request) {
 	err = json.Unmarshal(data, &session.serverSchema.Client)
 	if err != nil {
 		h.logger.Warnf("collect: json.Unmarshal: %s", err.Error())
+		h.reportAbuse(r, abuseMalformedBody)
+		setFailureReason(w, "malformed body")
 		w.WriteHeader(400)
 		return
 	}
+	clampQoE(session.serverSchema.Client)
 
 	// serialize all
 	data, err = h.deps.JSONMarshal(session.serverSchema.Server)
 	if err != nil {
 		h.logger.Warnf("collect: json.Marshal: %s", err.Error())
+		setFailureReason(w, "cannot marshal response")
 		w.WriteHeader(500)
 		return
 	}
 
-	// save on disk
-	err = h.deps.Savedata(session)
+	// save on disk, possibly using the save workers
+	err = h.save(r.Context(), session)
 	if err != nil {
 		// Error already printed by h.savedata()
+		setFailureReason(w, "cannot save results")
 		w.WriteHeader(500)
 		return
 	}
+	h.summarize(session)
+	h.addTombstone(sessionID, data)
 
 	// tell the client we're all good
 	w.Header().Set("Content-Type", "application/json")
@@ -473,31 +1429,76 @@ func (h *Handler) collect(w http.ResponseWriter, r *http.Request) {
 //
 // - /negotiate/dash
 // - /dash/download/{size}
+// - /dash/upload
 // - /collect/dash
 //
 // The /negotiate/dash prefix is used to create a measurement
 // context for a dash client. The /download/dash prefix is
-// used by clients to request data segments. The /collect/dash
-// prefix is used to submit client measurements.
+// used by clients to request data segments. The /dash/upload
+// prefix is used by clients to upload data segments when they
+// measure the upload direction. The /collect/dash prefix is
+// used to submit client measurements.
 //
 // For historical reasons /dash/download is an alias for
 // using the /dash/download/ prefix.
+//
+// We also register a catch-all handler that quickly returns 404 with an
+// empty body for any other path and counts these requests.
+//
+// All these handlers refuse to serve temporarily banned clients
+// with 403 (see BanThreshold). The negotiate, download, upload, and
+// collect handlers also inject the configured faults (see [Faults]), observe
+// the time to serve each request by scheme and protocol, and remember
+// the recent failed requests (see [*Handler.FailedRequests]).
+//
+// The catch-all handler intentionally does none of that, because it must
+// stay cheap and scanners probing random paths would otherwise pollute the
+// request duration metrics and evict the interesting failed requests.
 func (h *Handler) RegisterHandlers(mux *http.ServeMux) {
-	mux.HandleFunc(spec.NegotiatePath, h.negotiate)
-	mux.HandleFunc(spec.DownloadPath, h.download)
-	mux.HandleFunc(spec.DownloadPathNoTrailingSlash, h.download)
-	mux.HandleFunc(spec.CollectPath, h.collect)
+	mux.HandleFunc(spec.NegotiatePath, h.wrap("negotiate", h.negotiate))
+	mux.HandleFunc(spec.DownloadPath, h.wrap("download", h.download))
+	mux.HandleFunc(spec.DownloadPathNoTrailingSlash, h.wrap("download", h.download))
+	mux.HandleFunc(spec.UploadPath, h.wrap("upload", h.upload))
+	mux.HandleFunc(spec.CollectPath, h.wrap("collect", h.collect))
+	mux.HandleFunc("/", h.unlessBanned(h.notFound))
+}
+
+// wrap wraps the given measurement handler, whose name identifies it in the
+// metrics and in the logs, such that, from the outermost to the innermost, we
+// measure the request, record failures, recover from panics, refuse to serve
+// banned clients, and inject faults.
+func (h *Handler) wrap(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return h.measureRequests(name, h.recordFailures(h.recoverPanics(name, h.unlessBanned(h.injectFaults(handler)))))
+}
+
+// notFound implements the catch-all handler for unknown paths.
+func (h *Handler) notFound(w http.ResponseWriter, r *http.Request) {
+	scheme, proto := h.metricLabels(r)
+	unknownPathRequests.WithLabelValues(scheme, proto).Inc()
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(http.StatusNotFound)
 }
 
 // reaperLoop is the goroutine that periodically reaps expired sessions.
+//
+// We intentionally do not reap when the context is done, because reaping
+// does not save results, it only discards the stale sessions and updates
+// statistics kept in memory, which are lost anyway when the process exits.
 func (h *Handler) reaperLoop(ctx context.Context) {
 	h.logger.Debug("reaperLoop: start")
 	defer h.logger.Debug("reaperLoop: done")
 	defer close(h.stop)
-	for ctx.Err() == nil {
+	for {
 		const reapInterval = 14 * time.Second
-		time.Sleep(reapInterval)
+		select {
+		case <-ctx.Done():
+			return
+		case <-h.Clock.After(reapInterval):
+		}
 		h.reapStaleSessions()
+		h.reapTombstones()
+		abuseBannedAddresses.Set(float64(h.abuse.reap(h.now())))
+		h.updateAggregateMetrics()
 	}
 }
 
@@ -1,22 +1,30 @@
 package server
 
 import (
+	"bytes"
 	"compress/gzip"
 	"context"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/apex/log"
 	"github.com/google/uuid"
 	"github.com/neubot/dash/model"
+	"github.com/neubot/dash/spec"
+	dto "github.com/prometheus/client_model/go"
 )
 
 func TestServerNegotiate(t *testing.T) {
@@ -46,6 +54,21 @@ func TestServerNegotiate(t *testing.T) {
 		}
 	})
 
+	t.Run("rand.Read failure", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.deps.RandRead = func(p []byte) (n int, err error) {
+			return 0, errors.New("Mocked error")
+		}
+		req := new(http.Request)
+		req.RemoteAddr = "127.0.0.1:8080"
+		w := httptest.NewRecorder()
+		handler.negotiate(w, req)
+		resp := w.Result()
+		if resp.StatusCode != 500 {
+			t.Fatal("Expected different status code")
+		}
+	})
+
 	t.Run("json.Marshal failure", func(t *testing.T) {
 		handler := NewHandler("", log.Log)
 		handler.deps.JSONMarshal = func(v interface{}) ([]byte, error) {
@@ -95,6 +118,181 @@ func TestServerNegotiate(t *testing.T) {
 		if handler.getSessionState(msg.Authorization) != sessionActive {
 			t.Fatal("Unexpected session state")
 		}
+		if msg.BaseURL != "" {
+			t.Fatal("BaseURL is not empty")
+		}
+		if msg.MinSize != minSize || msg.MaxSize != maxSize {
+			t.Fatal("unexpected segment size bounds", msg.MinSize, msg.MaxSize)
+		}
+		if msg.ServerTime <= 0 {
+			t.Fatal("expected the server time")
+		}
+	})
+
+	t.Run("with base URL", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.BaseURL = "https://node.example.com"
+		req := new(http.Request)
+		req.RemoteAddr = "127.0.0.1:8080"
+		w := httptest.NewRecorder()
+		handler.negotiate(w, req)
+		resp := w.Result()
+		if resp.StatusCode != 200 {
+			t.Fatal("Expected different status code")
+		}
+		var msg model.NegotiateResponse
+		if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.BaseURL != "https://node.example.com" {
+			t.Fatal("unexpected BaseURL", msg.BaseURL)
+		}
+	})
+}
+
+func TestServerNegotiateMaxIterations(t *testing.T) {
+	for _, tc := range []struct {
+		body     string
+		expected int64
+	}{
+		{body: `{"dash_rates": [100]}`, expected: 17},
+		{body: `{"iterations": 60}`, expected: 60},
+		{body: `{"iterations": 100000}`, expected: int64(maxStreamDuration / streamSegmentDuration)},
+	} {
+		handler := NewHandler("", log.Log)
+		req := httptest.NewRequest("POST", "/negotiate/dash", strings.NewReader(tc.body))
+		w := httptest.NewRecorder()
+		handler.negotiate(w, req)
+		var msg model.NegotiateResponse
+		if err := json.NewDecoder(w.Result().Body).Decode(&msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.MaxIterations != tc.expected {
+			t.Fatal("unexpected max iterations", tc.body, msg.MaxIterations)
+		}
+	}
+}
+
+func TestServerNegotiateStreamEmulation(t *testing.T) {
+	negotiate := func(body string) *sessionInfo {
+		handler := NewHandler("", log.Log)
+		req := httptest.NewRequest("POST", "/negotiate/dash", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		handler.negotiate(w, req)
+		resp := w.Result()
+		if resp.StatusCode != 200 {
+			t.Fatal("Expected different status code")
+		}
+		var msg model.NegotiateResponse
+		if err := json.NewDecoder(resp.Body).Decode(&msg); err != nil {
+			t.Fatal(err)
+		}
+		return handler.popSession(msg.Authorization)
+	}
+
+	t.Run("without stream duration", func(t *testing.T) {
+		session := negotiate(`{"dash_rates": [100]}`)
+		if session.maxIterations(17) != 17 {
+			t.Fatal("unexpected max iterations")
+		}
+		if session.lifetime(0) != sessionLifetime {
+			t.Fatal("unexpected lifetime")
+		}
+	})
+
+	t.Run("with invalid body", func(t *testing.T) {
+		session := negotiate(`{`)
+		if session.request.StreamDuration != 0 {
+			t.Fatal("unexpected stream duration")
+		}
+	})
+
+	t.Run("with stream duration", func(t *testing.T) {
+		session := negotiate(`{"stream_duration": 120}`)
+		if session.maxIterations(17) != 60 {
+			t.Fatal("unexpected max iterations")
+		}
+		if session.lifetime(0) != sessionLifetime+120*time.Second {
+			t.Fatal("unexpected lifetime")
+		}
+	})
+
+	t.Run("with iterations and segment duration", func(t *testing.T) {
+		session := negotiate(`{"iterations": 30, "segment_duration": 4}`)
+		if session.maxIterations(17) != 30 {
+			t.Fatal("unexpected max iterations")
+		}
+		if session.lifetime(0) != sessionLifetime+120*time.Second {
+			t.Fatal("unexpected lifetime")
+		}
+		if session.lifetime(5*time.Second) != sessionLifetime+150*time.Second {
+			t.Fatal("unexpected lifetime with live pacing")
+		}
+	})
+
+	t.Run("with too many iterations and too long segments", func(t *testing.T) {
+		session := negotiate(`{"iterations": 100000, "segment_duration": 3600}`)
+		if session.segmentDuration() != maxSegmentDuration {
+			t.Fatal("unexpected segment duration")
+		}
+		if session.lifetime(0) != sessionLifetime+maxStreamDuration {
+			t.Fatal("unexpected lifetime")
+		}
+	})
+
+	t.Run("with too large stream duration", func(t *testing.T) {
+		session := negotiate(`{"stream_duration": 86400}`)
+		if session.streamDuration() != maxStreamDuration {
+			t.Fatal("unexpected stream duration")
+		}
+	})
+}
+
+func TestServerEffectiveScheme(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	handler.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+	t.Run("cleartext without proxy", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/negotiate/dash", nil)
+		req.RemoteAddr = "130.192.91.211:54321"
+		if scheme := handler.effectiveScheme(req); scheme != "http" {
+			t.Fatal("unexpected scheme", scheme)
+		}
+	})
+
+	t.Run("TLS without proxy", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "https://example.com/negotiate/dash", nil)
+		req.RemoteAddr = "130.192.91.211:54321"
+		if scheme := handler.effectiveScheme(req); scheme != "https" {
+			t.Fatal("unexpected scheme", scheme)
+		}
+	})
+
+	t.Run("X-Forwarded-Proto from untrusted client", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/negotiate/dash", nil)
+		req.RemoteAddr = "130.192.91.211:54321"
+		req.Header.Set("X-Forwarded-Proto", "https")
+		if scheme := handler.effectiveScheme(req); scheme != "http" {
+			t.Fatal("unexpected scheme", scheme)
+		}
+	})
+
+	t.Run("X-Forwarded-Proto from trusted proxy", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/negotiate/dash", nil)
+		req.RemoteAddr = "10.1.2.3:54321"
+		req.Header.Set("X-Forwarded-Proto", "HTTPS")
+		if scheme := handler.effectiveScheme(req); scheme != "https" {
+			t.Fatal("unexpected scheme", scheme)
+		}
+	})
+
+	t.Run("invalid X-Forwarded-Proto from trusted proxy", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/negotiate/dash", nil)
+		req.RemoteAddr = "10.1.2.3:54321"
+		req.Header.Set("X-Forwarded-Proto", "gopher")
+		if scheme := handler.effectiveScheme(req); scheme != "http" {
+			t.Fatal("unexpected scheme", scheme)
+		}
 	})
 }
 
@@ -160,6 +358,90 @@ func TestServerDownload(t *testing.T) {
 		}
 	})
 
+	t.Run("session over budget", func(t *testing.T) {
+		const session = "deadbeef"
+		handler := NewHandler("", log.Log)
+		handler.MaxSessionBytes = minSize
+		handler.createSession(session)
+		handler.updateSession(session, minSize)
+		var saved int
+		handler.deps.Savedata = func(session *sessionInfo) error {
+			saved++
+			return nil
+		}
+		req := new(http.Request)
+		req.Header = make(http.Header)
+		req.Header.Add(authorization, session)
+		w := httptest.NewRecorder()
+		handler.download(w, req)
+		resp := w.Result()
+		if resp.StatusCode != 429 {
+			t.Fatal("Expected different status code")
+		}
+		if saved != 1 {
+			t.Fatal("Expected the session to be saved")
+		}
+		if handler.getSessionState(session) != sessionMissing {
+			t.Fatal("Expected the session to be removed")
+		}
+	})
+
+	t.Run("last segment within the budget", func(t *testing.T) {
+		const session = "deadbeef"
+		handler := NewHandler("", log.Log)
+		handler.MaxSessionBytes = minSize + 1000
+		handler.createSession(session)
+		handler.updateSession(session, minSize)
+		req := httptest.NewRequest("GET", spec.DownloadPath+strconv.Itoa(minSize), nil)
+		req.Header.Add(authorization, session)
+		w := httptest.NewRecorder()
+		handler.download(w, req)
+		if w.Code != 200 || w.Body.Len() != 1000 {
+			t.Fatal("unexpected response", w.Code, w.Body.Len())
+		}
+		if handler.getSessionState(session) != sessionOverBudget {
+			t.Fatal("Expected the session to be over budget")
+		}
+	})
+
+	t.Run("live segment not produced yet", func(t *testing.T) {
+		const session = "deadbeef"
+		handler := NewHandler("", log.Log)
+		handler.LiveSegmentDuration = time.Hour
+		handler.createSession(session)
+		handler.updateSession(session, minSize)
+		req := new(http.Request)
+		req.Header = make(http.Header)
+		req.Header.Add(authorization, session)
+		w := httptest.NewRecorder()
+		handler.download(w, req)
+		resp := w.Result()
+		if resp.StatusCode != http.StatusTooEarly {
+			t.Fatal("Expected different status code")
+		}
+		if resp.Header.Get("Retry-After") == "" {
+			t.Fatal("Expected the Retry-After header")
+		}
+	})
+
+	t.Run("live segment already produced", func(t *testing.T) {
+		const session = "deadbeef"
+		handler := NewHandler("", log.Log)
+		handler.LiveSegmentDuration = time.Hour
+		handler.createSession(session)
+		req := new(http.Request)
+		req.URL = new(url.URL)
+		req.URL.Path = "/dash/download"
+		req.Header = make(http.Header)
+		req.Header.Add(authorization, session)
+		w := httptest.NewRecorder()
+		handler.download(w, req)
+		resp := w.Result()
+		if resp.StatusCode != 200 {
+			t.Fatal("Expected different status code")
+		}
+	})
+
 	t.Run("strcov.Atoi failure", func(t *testing.T) {
 		const session = "deadbeef"
 		handler := NewHandler("", log.Log)
@@ -220,6 +502,30 @@ func TestServerDownload(t *testing.T) {
 			t.Fatal("Expected different data length")
 		}
 	})
+	t.Run("with cache busting", func(t *testing.T) {
+		const session = "deadbeef"
+		handler := NewHandler("", log.Log)
+		handler.CacheBusting = true
+		handler.createSession(session)
+		req := new(http.Request)
+		req.URL = new(url.URL)
+		req.URL.Path = "/dash/download/3500000"
+		req.URL.RawQuery = spec.CacheBustingQuery + "=abc"
+		req.Header = make(http.Header)
+		req.Header.Add(authorization, session)
+		w := httptest.NewRecorder()
+		handler.download(w, req)
+		resp := w.Result()
+		if resp.StatusCode != 200 {
+			t.Fatal("Expected different status code")
+		}
+		if resp.Header.Get(spec.CacheBustingHeader) != "abc" {
+			t.Fatal("expected the token to be echoed")
+		}
+		if resp.Header.Get("Cache-Control") == "" {
+			t.Fatal("expected the Cache-Control header")
+		}
+	})
 }
 
 func TestServerSaveData(t *testing.T) {
@@ -312,19 +618,98 @@ func TestServerSaveData(t *testing.T) {
 		}
 		sessionInfo.stamp = time.Date(2024, time.January, 29, 20, 23, 0, 0, time.UTC) // predictable
 		expectFilename := "dash/2024/01/29/neubot-dash-20240129T202300.000000000Z.json.gz"
-		var gotFilename string
+		var gotFilename, gotLinked string
 		handler.deps.OSOpenFile = func(
 			name string, flag int, perm os.FileMode,
 		) (*os.File, error) {
 			gotFilename = name
 			return os.CreateTemp("", "neubot-dash-tests")
 		}
+		handler.deps.OSLink = func(oldname, newname string) error {
+			gotLinked = newname
+			return nil
+		}
+		err := handler.savedata(sessionInfo)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gotFilename != expectFilename+tempSuffix {
+			t.Fatal("expected", expectFilename+tempSuffix, "got", gotFilename)
+		}
+		if gotLinked != expectFilename {
+			t.Fatal("expected", expectFilename, "got", gotLinked)
+		}
+	})
+
+	t.Run("os.Link failure", func(t *testing.T) {
+		const session = "deadbeef"
+		datadir := t.TempDir()
+		handler := NewHandler(datadir, log.Log)
+		handler.createSession(session)
+		sessionInfo := handler.popSession(session)
+		handler.deps.OSLink = func(oldname, newname string) error {
+			return errors.New("Mocked error")
+		}
+		err := handler.savedata(sessionInfo)
+		if err == nil {
+			t.Fatal("Expected an error here")
+		}
+		matches, _ := filepath.Glob(filepath.Join(datadir, "dash", "*", "*", "*", "*"))
+		if len(matches) != 0 {
+			t.Fatal("expected the temporary file to be removed", matches)
+		}
+	})
+
+	t.Run("with an existing file", func(t *testing.T) {
+		const session = "deadbeef"
+		datadir := t.TempDir()
+		handler := NewHandler(datadir, log.Log)
+		handler.createSession(session)
+		sessionInfo := handler.popSession(session)
+		sessionInfo.stamp = time.Date(2024, time.January, 29, 20, 23, 0, 0, time.UTC) // predictable
+		name := filepath.Join(datadir, "dash/2024/01/29/neubot-dash-20240129T202300.000000000Z.json.gz")
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte("existing"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := handler.savedata(sessionInfo); !errors.Is(err, os.ErrExist) {
+			t.Fatal("not the error we expected", err)
+		}
+		data, err := os.ReadFile(name)
+		if err != nil || string(data) != "existing" {
+			t.Fatal("the existing file was overwritten", string(data), err)
+		}
+		if _, err := os.Stat(name + tempSuffix); !os.IsNotExist(err) {
+			t.Fatal("expected the temporary file to be removed", err)
+		}
+	})
+
+	t.Run("with directory sync", func(t *testing.T) {
+		const session = "deadbeef"
+		datadir := t.TempDir()
+		handler := NewHandler(datadir, log.Log)
+		handler.SyncDirectory = true
+		handler.createSession(session)
+		sessionInfo := handler.popSession(session)
+		sessionInfo.stamp = time.Date(2024, time.January, 29, 20, 23, 0, 0, time.UTC) // predictable
 		err := handler.savedata(sessionInfo)
 		if err != nil {
 			t.Fatal(err)
 		}
-		if gotFilename != expectFilename {
-			t.Fatal("expected", expectFilename, "got", gotFilename)
+		name := filepath.Join(datadir, "dash/2024/01/29/neubot-dash-20240129T202300.000000000Z.json.gz")
+		filep, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer filep.Close()
+		zipper, err := gzip.NewReader(filep)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadAll(zipper); err != nil {
+			t.Fatal(err)
 		}
 	})
 }
@@ -464,3 +849,68 @@ func TestServerReaper(t *testing.T) {
 	cancel()
 	handler.JoinReaper()
 }
+
+func TestServerNotFound(t *testing.T) {
+	counter := func() float64 {
+		value := &dto.Metric{}
+		if err := unknownPathRequests.WithLabelValues("http", "HTTP/1.1").Write(value); err != nil {
+			t.Fatal(err)
+		}
+		return value.Counter.GetValue()
+	}
+	handler := NewHandler("", log.Log)
+	mux := http.NewServeMux()
+	handler.RegisterHandlers(mux)
+	before := counter()
+	for _, path := range []string{"/", "/wp-login.php", "/negotiate/ndt"} {
+		req := httptest.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != 404 {
+			t.Fatal("Expected different status code")
+		}
+		if w.Body.Len() != 0 {
+			t.Fatal("expected an empty body")
+		}
+	}
+	if counter()-before != 3 {
+		t.Fatal("unexpected number of unknown path requests")
+	}
+}
+
+func TestServerDownloadSeededPayload(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	req := httptest.NewRequest("POST", "/negotiate/dash", nil)
+	w := httptest.NewRecorder()
+	handler.negotiate(w, req)
+	if w.Code != 200 {
+		t.Fatal("Expected different status code")
+	}
+	var negotiateResponse model.NegotiateResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &negotiateResponse); err != nil {
+		t.Fatal(err)
+	}
+	seed, err := hex.DecodeString(negotiateResponse.Seed)
+	if err != nil || len(seed) != spec.SeedSize {
+		t.Fatal("invalid seed", negotiateResponse.Seed)
+	}
+	var previous []byte
+	for iteration := int64(0); iteration < 2; iteration++ {
+		req := httptest.NewRequest("GET", fmt.Sprintf("/dash/download/%d", minSize), nil)
+		req.Header.Set(authorization, negotiateResponse.Authorization)
+		w := httptest.NewRecorder()
+		handler.download(w, req)
+		if w.Code != 200 {
+			t.Fatal("Expected different status code")
+		}
+		expect := make([]byte, minSize)
+		spec.FillPayload(seed, iteration, expect)
+		if !bytes.Equal(w.Body.Bytes(), expect) {
+			t.Fatal("unexpected payload at iteration", iteration)
+		}
+		if bytes.Equal(w.Body.Bytes(), previous) {
+			t.Fatal("expected different payloads for different iterations")
+		}
+		previous = w.Body.Bytes()
+	}
+}
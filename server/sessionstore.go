@@ -0,0 +1,233 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/neubot/dash/model"
+)
+
+// ErrSessionNotFound is the error returned by a [SessionStore] when
+// there is no session with the given UUID.
+var ErrSessionNotFound = errors.New("session not found")
+
+// sessionStoreTimeout is the maximum time for each [SessionStore] operation.
+const sessionStoreTimeout = 5 * time.Second
+
+// maxSessionSnapshotSize is the maximum size of a serialized session
+// that we are willing to read from a [SessionStore].
+const maxSessionSnapshotSize = 4 << 20
+
+// SessionStore is a store of sessions shared by several handlers, which
+// allows several dash-server processes, or several replicas behind a load
+// balancer, to serve the same sessions, such that, e.g., a download request
+// landing on a replica other than the one that negotiated still succeeds.
+// Because sessions are opaque byte slices with a time to live, it is easy
+// to implement this interface using a key-value store such as Redis (using
+// GET, SET with EX, and DEL) or memcached. Implementations MUST be safe for
+// concurrent use. See also [MemorySessionStore] and [RedisSessionStore].
+type SessionStore interface {
+	// Delete deletes the session with the given UUID, if any.
+	Delete(ctx context.Context, UUID string) error
+
+	// Load returns the session with the given UUID or ErrSessionNotFound.
+	Load(ctx context.Context, UUID string) ([]byte, error)
+
+	// Store saves the session with the given UUID, which expires
+	// after the given time to live.
+	Store(ctx context.Context, UUID string, data []byte, ttl time.Duration) error
+}
+
+// sessionSnapshot is the serialization of a [*sessionInfo] that we save
+// into the [SessionStore]. We do not save the downloads in progress, which
+// only make sense for the handler serving them.
+type sessionSnapshot struct {
+	Address      string                 `json:"address"`
+	Bytes        int64                  `json:"bytes"`
+	Iteration    int64                  `json:"iteration"`
+	Request      model.NegotiateRequest `json:"request"`
+	Seed         []byte                 `json:"seed,omitempty"`
+	ServerSchema model.ServerSchema     `json:"server_schema"`
+	Stamp        time.Time              `json:"stamp"`
+	UUID         string                 `json:"uuid"`
+}
+
+// publishSession saves the session with the given UUID, if any, into the
+// SessionStore, if configured, so that other handlers can serve it. Because
+// we cannot serve the session if we cannot share it, we log errors. The time
+// to live is the remaining lifetime of the session, such that the shared copy
+// never outlives our copy, and we do not publish sessions that are stale.
+func (h *Handler) publishSession(ctx context.Context, UUID string) {
+	if h.SessionStore == nil {
+		return
+	}
+	h.mtx.Lock()
+	session, found := h.sessions[UUID]
+	var (
+		data []byte
+		err  error
+		ttl  time.Duration
+	)
+	if found {
+		data, err = json.Marshal(&sessionSnapshot{
+			Address:      session.address,
+			Bytes:        session.bytes,
+			Iteration:    session.iteration,
+			Request:      session.request,
+			Seed:         session.seed,
+			ServerSchema: session.serverSchema,
+			Stamp:        session.stamp,
+			UUID:         session.uuid,
+		})
+		ttl = session.stamp.Add(session.lifetime(h.LiveSegmentDuration)).Sub(h.now())
+	}
+	h.mtx.Unlock()
+	if !found || ttl <= 0 {
+		return
+	}
+	if err != nil {
+		h.logger.Warnf("publishSession: json.Marshal: %s", err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, sessionStoreTimeout)
+	defer cancel()
+	if err := h.SessionStore.Store(ctx, UUID, data, ttl); err != nil {
+		h.logger.Warnf("publishSession: %s", err.Error())
+	}
+}
+
+// loadSharedSession loads the session with the given UUID from the
+// SessionStore, if configured, when we do not know the session, because
+// another handler negotiated it. When we know the session, we use our copy,
+// which avoids querying the store for each request of a session served by
+// the same handler, hence the recommendation that the load balancer routes
+// the requests of the same session to the same handler whenever possible.
+// We do not query the store for malformed session IDs.
+func (h *Handler) loadSharedSession(ctx context.Context, UUID string) {
+	if h.SessionStore == nil || !isSessionID(UUID) {
+		return
+	}
+	h.mtx.Lock()
+	_, found := h.sessions[UUID]
+	h.mtx.Unlock()
+	if found {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, sessionStoreTimeout)
+	defer cancel()
+	data, err := h.SessionStore.Load(ctx, UUID)
+	if err != nil {
+		if !errors.Is(err, ErrSessionNotFound) {
+			h.logger.Warnf("loadSharedSession: %s", err.Error())
+		}
+		return
+	}
+	var snapshot sessionSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil || snapshot.UUID != UUID {
+		h.logger.Warnf("loadSharedSession: invalid session %s", UUID)
+		return
+	}
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	if _, found := h.sessions[UUID]; found {
+		return // we raced with another request of the same session
+	}
+	session := &sessionInfo{}
+	h.sessions[UUID] = session
+	session.address = snapshot.Address
+	session.bytes = snapshot.Bytes
+	session.iteration = snapshot.Iteration
+	session.request = snapshot.Request
+	session.seed = snapshot.Seed
+	session.serverSchema = snapshot.ServerSchema
+	session.stamp = snapshot.Stamp
+	session.uuid = snapshot.UUID
+}
+
+// isSessionID returns whether the given value is a session ID, i.e.,
+// the canonical representation of a UUID, as generated by negotiate.
+func isSessionID(value string) bool {
+	parsed, err := uuid.Parse(value)
+	return err == nil && parsed.String() == value
+}
+
+// forgetSharedSession deletes the session with the given UUID from the
+// SessionStore, if configured, once the session is over or stale.
+func (h *Handler) forgetSharedSession(UUID string) {
+	if h.SessionStore == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sessionStoreTimeout)
+	defer cancel()
+	if err := h.SessionStore.Delete(ctx, UUID); err != nil {
+		h.logger.Warnf("forgetSharedSession: %s", err.Error())
+	}
+}
+
+// MemorySessionStore is a [SessionStore] keeping the sessions in memory,
+// which allows several handlers in the same process to share sessions and
+// serves as a reference for implementing other stores. The zero value is
+// invalid; use [NewMemorySessionStore] to construct a new instance.
+type MemorySessionStore struct {
+	// Clock is the source of time for the TTLs. This field is initialized
+	// by NewMemorySessionStore to a [Clock] using the system time.
+	Clock Clock
+
+	// entries maps the UUID of each session to the session.
+	entries map[string]memorySessionEntry
+
+	// mtx protects entries.
+	mtx sync.Mutex
+}
+
+// memorySessionEntry is an entry of [*MemorySessionStore].
+type memorySessionEntry struct {
+	data    []byte
+	expires time.Time
+}
+
+var _ SessionStore = &MemorySessionStore{}
+
+// NewMemorySessionStore creates a new [*MemorySessionStore] instance.
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{
+		Clock:   systemClock{},
+		entries: make(map[string]memorySessionEntry),
+		mtx:     sync.Mutex{},
+	}
+}
+
+// Delete implements [SessionStore].
+func (ms *MemorySessionStore) Delete(ctx context.Context, UUID string) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+	delete(ms.entries, UUID)
+	return nil
+}
+
+// Load implements [SessionStore].
+func (ms *MemorySessionStore) Load(ctx context.Context, UUID string) ([]byte, error) {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+	entry, found := ms.entries[UUID]
+	if !found || !ms.Clock.Now().Before(entry.expires) {
+		delete(ms.entries, UUID)
+		return nil, ErrSessionNotFound
+	}
+	return append([]byte{}, entry.data...), nil
+}
+
+// Store implements [SessionStore].
+func (ms *MemorySessionStore) Store(ctx context.Context, UUID string, data []byte, ttl time.Duration) error {
+	ms.mtx.Lock()
+	defer ms.mtx.Unlock()
+	ms.entries[UUID] = memorySessionEntry{
+		data:    append([]byte{}, data...),
+		expires: ms.Clock.Now().Add(ttl),
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/model"
+)
+
+func TestMemorySessionStore(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemorySessionStore()
+	if _, err := store.Load(ctx, "deadbeef"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatal("not the error we expected", err)
+	}
+	if err := store.Store(ctx, "deadbeef", []byte("abc"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	data, err := store.Load(ctx, "deadbeef")
+	if err != nil || string(data) != "abc" {
+		t.Fatal("unexpected result", string(data), err)
+	}
+	if err := store.Delete(ctx, "deadbeef"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(ctx, "deadbeef"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatal("not the error we expected", err)
+	}
+	if err := store.Store(ctx, "deadbeef", []byte("abc"), -time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(ctx, "deadbeef"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatal("expected the session to expire", err)
+	}
+}
+
+func TestMemorySessionStoreClock(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	store := NewMemorySessionStore()
+	store.Clock = clock
+	if err := store.Store(ctx, "deadbeef", []byte("abc"), time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(time.Minute - time.Second)
+	if _, err := store.Load(ctx, "deadbeef"); err != nil {
+		t.Fatal("the session should not have expired yet", err)
+	}
+	clock.Advance(time.Second)
+	if _, err := store.Load(ctx, "deadbeef"); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatal("expected the session to expire", err)
+	}
+}
+
+func TestServerSharedSessions(t *testing.T) {
+	store := NewMemorySessionStore()
+	replicas := []*Handler{NewHandler("", log.Log), NewHandler("", log.Log)}
+	for _, handler := range replicas {
+		handler.SessionStore = store
+		handler.deps.Savedata = func(session *sessionInfo) error {
+			return nil
+		}
+	}
+
+	// 1. negotiate with the first replica
+	w := httptest.NewRecorder()
+	replicas[0].negotiate(w, httptest.NewRequest("POST", "/negotiate/dash", nil))
+	if w.Code != 200 {
+		t.Fatal("Expected different status code")
+	}
+	var negotiateResponse model.NegotiateResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &negotiateResponse); err != nil {
+		t.Fatal(err)
+	}
+	UUID := negotiateResponse.Authorization
+
+	// 2. download using the second replica
+	for idx := 0; idx < 3; idx++ {
+		req := httptest.NewRequest("GET", "/dash/download/1000", nil)
+		req.Header.Set(authorization, UUID)
+		w := httptest.NewRecorder()
+		replicas[1].download(w, req)
+		if w.Code != 200 {
+			t.Fatal("Expected different status code")
+		}
+	}
+
+	// 3. collect with the second replica
+	req := httptest.NewRequest("POST", "/collect/dash", strings.NewReader("[]"))
+	req.Header.Set(authorization, UUID)
+	w = httptest.NewRecorder()
+	replicas[1].collect(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatal("Expected different status code")
+	}
+	var serverResults []model.ServerResults
+	if err := json.Unmarshal(w.Body.Bytes(), &serverResults); err != nil {
+		t.Fatal(err)
+	}
+	if len(serverResults) != 3 {
+		t.Fatal("expected the results of all the downloads", len(serverResults))
+	}
+	for idx, result := range serverResults {
+		if result.Iteration != int64(idx) {
+			t.Fatal("unexpected iteration", result.Iteration)
+		}
+	}
+	if _, err := store.Load(context.Background(), UUID); !errors.Is(err, ErrSessionNotFound) {
+		t.Fatal("expected the session to be deleted", err)
+	}
+}
+
+// countingSessionStore is a [SessionStore] counting the loads.
+type countingSessionStore struct {
+	*MemorySessionStore
+	loads atomic.Int64
+}
+
+func (cs *countingSessionStore) Load(ctx context.Context, UUID string) ([]byte, error) {
+	cs.loads.Add(1)
+	return cs.MemorySessionStore.Load(ctx, UUID)
+}
+
+func TestServerLoadSharedSession(t *testing.T) {
+	const UUID = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+	t.Run("with an invalid session", func(t *testing.T) {
+		store := NewMemorySessionStore()
+		if err := store.Store(context.Background(), UUID, []byte("{"), time.Minute); err != nil {
+			t.Fatal(err)
+		}
+		handler := NewHandler("", log.Log)
+		handler.SessionStore = store
+		handler.loadSharedSession(context.Background(), UUID)
+		if handler.CountSessions() != 0 {
+			t.Fatal("expected no sessions")
+		}
+	})
+
+	t.Run("we do not query the store for malformed session IDs", func(t *testing.T) {
+		store := &countingSessionStore{MemorySessionStore: NewMemorySessionStore()}
+		handler := NewHandler("", log.Log)
+		handler.SessionStore = store
+		for _, value := range []string{"", "deadbeef", strings.ToUpper(UUID), "{" + UUID + "}"} {
+			handler.loadSharedSession(context.Background(), value)
+		}
+		if store.loads.Load() != 0 {
+			t.Fatal("unexpected number of loads", store.loads.Load())
+		}
+	})
+
+	t.Run("we do not query the store for local sessions", func(t *testing.T) {
+		store := &countingSessionStore{MemorySessionStore: NewMemorySessionStore()}
+		handler := NewHandler("", log.Log)
+		handler.SessionStore = store
+		handler.createSession(UUID)
+		handler.loadSharedSession(context.Background(), UUID)
+		if store.loads.Load() != 0 {
+			t.Fatal("unexpected number of loads", store.loads.Load())
+		}
+	})
+}
+
+func TestServerReapSharedSessions(t *testing.T) {
+	const UUID = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+
+	t.Run("the reaper deletes stale sessions from the store", func(t *testing.T) {
+		clock := newFakeClock()
+		store := NewMemorySessionStore()
+		store.Clock = clock
+		handler := NewHandler("", log.Log)
+		handler.Clock = clock
+		handler.SessionStore = store
+		handler.createSession(UUID)
+		handler.publishSession(context.Background(), UUID)
+		clock.Advance(sessionLifetime - time.Second)
+		handler.reapStaleSessions()
+		if handler.CountSessions() != 1 {
+			t.Fatal("the session should not be stale yet")
+		}
+		handler.publishSession(context.Background(), UUID)
+		clock.Advance(2 * time.Second)
+		handler.reapStaleSessions()
+		if handler.CountSessions() != 0 {
+			t.Fatal("expected the reaper to reap the stale session")
+		}
+		if _, err := store.Load(context.Background(), UUID); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatal("expected the session to be deleted", err)
+		}
+		handler.loadSharedSession(context.Background(), UUID)
+		if handler.CountSessions() != 0 {
+			t.Fatal("the stale session should not come back")
+		}
+	})
+
+	t.Run("the shared copy does not outlive the session", func(t *testing.T) {
+		clock := newFakeClock()
+		store := NewMemorySessionStore()
+		store.Clock = clock
+		replicas := []*Handler{NewHandler("", log.Log), NewHandler("", log.Log)}
+		for _, handler := range replicas {
+			handler.Clock = clock
+			handler.SessionStore = store
+		}
+		replicas[0].createSession(UUID)
+		clock.Advance(sessionLifetime - time.Second)
+		replicas[0].publishSession(context.Background(), UUID)
+		clock.Advance(2 * time.Second)
+		replicas[1].loadSharedSession(context.Background(), UUID)
+		if replicas[1].CountSessions() != 0 {
+			t.Fatal("the shared copy should have expired with the session")
+		}
+	})
+
+	t.Run("we do not publish stale sessions", func(t *testing.T) {
+		clock := newFakeClock()
+		store := &countingSessionStore{MemorySessionStore: NewMemorySessionStore()}
+		handler := NewHandler("", log.Log)
+		handler.Clock = clock
+		handler.SessionStore = store
+		handler.createSession(UUID)
+		clock.Advance(sessionLifetime + time.Second)
+		handler.publishSession(context.Background(), UUID)
+		if _, err := store.MemorySessionStore.Load(context.Background(), UUID); !errors.Is(err, ErrSessionNotFound) {
+			t.Fatal("expected no shared copy", err)
+		}
+	})
+}
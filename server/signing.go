@@ -0,0 +1,51 @@
+package server
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+)
+
+var (
+	// errNoPEMBlock is returned when the signing key file does not
+	// contain any PEM block.
+	errNoPEMBlock = errors.New("no PEM block in signing key file")
+
+	// errNotEd25519Key is returned when the signing key file does not
+	// contain an Ed25519 private key.
+	errNotEd25519Key = errors.New("signing key is not an Ed25519 key")
+)
+
+// signatureSuffix is the suffix of the files containing signatures.
+const signatureSuffix = ".sig"
+
+// LoadSigningKey loads the Ed25519 private key for signing results from
+// the given PEM file containing a PKCS #8 private key, such as the one
+// generated by `openssl genpkey -algorithm ed25519`.
+func LoadSigningKey(filename string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errNoPEMBlock
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errNotEd25519Key
+	}
+	return privateKey, nil
+}
+
+// sign writes the detached signature of the given content of the
+// results file with the given name using the SigningKey.
+func (h *Handler) sign(name string, content []byte) error {
+	return h.writeFileAtomic(name+signatureSuffix, ed25519.Sign(h.SigningKey, content))
+}
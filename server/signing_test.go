@@ -0,0 +1,101 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+// writePrivateKey writes the given private key as a PKCS #8 PEM file.
+func writePrivateKey(t *testing.T, key any) string {
+	data, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "key.pem")
+	block := &pem.Block{Type: "PRIVATE KEY", Bytes: data}
+	if err := os.WriteFile(filename, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadSigningKey(t *testing.T) {
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := LoadSigningKey(filepath.Join(t.TempDir(), "nonexistent")); err == nil {
+			t.Fatal("Expected an error here")
+		}
+	})
+
+	t.Run("no PEM block", func(t *testing.T) {
+		filename := filepath.Join(t.TempDir(), "key.pem")
+		if err := os.WriteFile(filename, []byte("antani"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadSigningKey(filename); !errors.Is(err, errNoPEMBlock) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+
+	t.Run("not an Ed25519 key", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadSigningKey(writePrivateKey(t, key)); !errors.Is(err, errNotEd25519Key) {
+			t.Fatal("not the error we expected", err)
+		}
+	})
+
+	t.Run("common case", func(t *testing.T) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadSigningKey(writePrivateKey(t, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !loaded.Equal(key) {
+			t.Fatal("not the key we expected")
+		}
+	})
+}
+
+func TestServerSaveDataSigned(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	datadir := t.TempDir()
+	handler := NewHandler(datadir, log.Log)
+	handler.SigningKey = privateKey
+	handler.createSession("deadbeef")
+	session := handler.popSession("deadbeef")
+	session.stamp = time.Date(2024, time.January, 29, 20, 23, 0, 0, time.UTC) // predictable
+	if err := handler.savedata(session); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(datadir, "dash/2024/01/29/neubot-dash-20240129T202300.000000000Z.json.gz")
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := os.ReadFile(name + signatureSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(publicKey, content, signature) {
+		t.Fatal("invalid signature")
+	}
+}
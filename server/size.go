@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// maxRequestedSize is the maximum segment size we accept in the URL
+	// path, which is much larger than maxSize, because we clamp sizes (see
+	// clampSize), but small enough to reject clearly bogus requests.
+	maxRequestedSize = maxSize * 10
+
+	// maxSizeDigits is the maximum number of digits of the size, which is
+	// enough for maxRequestedSize and which we check before parsing, so
+	// that we never parse overlong inputs.
+	maxSizeDigits = 9
+)
+
+// These errors describe why the size in the URL path is malformed.
+var (
+	errSizeNegative   = errors.New("negative")
+	errSizeNotInteger = errors.New("not_integer")
+	errSizeTooLarge   = errors.New("too_large")
+	errSizeTooLong    = errors.New("too_long")
+)
+
+// malformedSizeResponse is the body of the 400 response we send
+// when the size in the URL path is malformed.
+type malformedSizeResponse struct {
+	// Error is always "malformed_size".
+	Error string `json:"error"`
+
+	// Reason is the reason why the size is malformed (e.g., "too_large").
+	Reason string `json:"reason"`
+}
+
+// parseSize parses the size in the URL path, which must only contain
+// decimal digits and must not be larger than maxRequestedSize.
+func parseSize(value string) (int, error) {
+	if len(value) > maxSizeDigits {
+		return 0, errSizeTooLong
+	}
+	if len(value) > 0 && value[0] == '-' {
+		return 0, errSizeNegative
+	}
+	if len(value) <= 0 {
+		return 0, errSizeNotInteger
+	}
+	for idx := 0; idx < len(value); idx++ {
+		if value[idx] < '0' || value[idx] > '9' {
+			return 0, errSizeNotInteger
+		}
+	}
+	count, err := strconv.Atoi(value) // cannot fail given the above checks
+	if err != nil {
+		return 0, errSizeNotInteger
+	}
+	if count > maxRequestedSize {
+		return 0, errSizeTooLarge
+	}
+	return count, nil
+}
+
+// rejectMalformedSize responds with 400 and a JSON body describing why
+// the size in the URL path is malformed, and counts the request.
+func (h *Handler) rejectMalformedSize(w http.ResponseWriter, r *http.Request, reason error) {
+	h.logger.Warnf("download: malformed size: %s", reason.Error())
+	h.reportAbuse(r, abuseMalformedSize)
+	scheme, proto := h.metricLabels(r)
+	malformedSizes.WithLabelValues(reason.Error(), scheme, proto).Inc()
+	data, _ := json.Marshal(&malformedSizeResponse{ // cannot fail
+		Error:  abuseMalformedSize,
+		Reason: reason.Error(),
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	setFailureReason(w, "malformed size: "+reason.Error())
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write(data)
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseSize(t *testing.T) {
+	cases := []struct {
+		input  string
+		count  int
+		reason error
+	}{
+		{input: "1000", count: 1000},
+		{input: "0", count: 0},
+		{input: "75000000", count: maxRequestedSize},
+		{input: "75000001", reason: errSizeTooLarge},
+		{input: "-1000", reason: errSizeNegative},
+		{input: "+1000", reason: errSizeNotInteger},
+		{input: "10e3", reason: errSizeNotInteger},
+		{input: "1000 ", reason: errSizeNotInteger},
+		{input: "", reason: errSizeNotInteger},
+		{input: "1234567890", reason: errSizeTooLong},
+		{input: "99999999999999999999999999", reason: errSizeTooLong},
+	}
+	for _, tc := range cases {
+		count, err := parseSize(tc.input)
+		if err != tc.reason || count != tc.count {
+			t.Fatal("unexpected result for", tc.input, count, err)
+		}
+	}
+}
+
+func TestServerMalformedSize(t *testing.T) {
+	counter := func(reason string) float64 {
+		value := &dto.Metric{}
+		if err := malformedSizes.WithLabelValues(reason, "http", "HTTP/1.1").Write(value); err != nil {
+			t.Fatal(err)
+		}
+		return value.Counter.GetValue()
+	}
+	handler := NewHandler("", log.Log)
+	handler.createSession("deadbeef")
+	before := counter("too_large")
+	req := httptest.NewRequest("GET", "/dash/download/99999999", nil)
+	req.Header.Set(authorization, "deadbeef")
+	w := httptest.NewRecorder()
+	handler.download(w, req)
+	if w.Code != 400 {
+		t.Fatal("Expected different status code")
+	}
+	var body malformedSizeResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error != "malformed_size" || body.Reason != "too_large" {
+		t.Fatalf("unexpected body: %+v", body)
+	}
+	if counter("too_large") != before+1 {
+		t.Fatal("expected the counter to increase")
+	}
+}
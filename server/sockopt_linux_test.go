@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// checkSocketOptions uses getsockopt to check whether the given socket
+// options have been applied to the given connection.
+func checkSocketOptions(t *testing.T, conn *net.TCPConn, opts SocketOptions) {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sndbuf, lowat int
+	var serr error
+	cerr := rc.Control(func(fd uintptr) {
+		if sndbuf, serr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_SNDBUF); serr != nil {
+			return
+		}
+		lowat, serr = unix.GetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT)
+	})
+	if cerr != nil {
+		t.Fatal(cerr)
+	}
+	if serr != nil {
+		t.Fatal(serr)
+	}
+	// Linux doubles the SO_SNDBUF value to allow space for bookkeeping.
+	if opts.SendBufferSize > 0 && sndbuf != 2*opts.SendBufferSize {
+		t.Fatalf("Unexpected SO_SNDBUF: %d", sndbuf)
+	}
+	if opts.NotSentLowat > 0 && lowat != opts.NotSentLowat {
+		t.Fatalf("Unexpected TCP_NOTSENT_LOWAT: %d", lowat)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !(linux || darwin)
+
+package server
+
+import "syscall"
+
+// setNotSentLowat always fails on this platform.
+func setNotSentLowat(rc syscall.RawConn, value int) error {
+	return errNotSentLowatNotSupported
+}
+
+// setReusePort always fails on this platform.
+func setReusePort(rc syscall.RawConn) error {
+	return errReusePortNotSupported
+}
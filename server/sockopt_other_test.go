@@ -0,0 +1,14 @@
+//go:build !linux
+
+package server
+
+import (
+	"net"
+	"testing"
+)
+
+// checkSocketOptions is a no-op on this platform, where we do not know
+// how the kernel reports the values of the socket options.
+func checkSocketOptions(t *testing.T, conn *net.TCPConn, opts SocketOptions) {
+	t.Log("cannot check the socket options on this platform")
+}
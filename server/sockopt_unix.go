@@ -0,0 +1,31 @@
+//go:build linux || darwin
+
+package server
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setNotSentLowat sets the TCP_NOTSENT_LOWAT socket option.
+func setNotSentLowat(rc syscall.RawConn, value int) (err error) {
+	cerr := rc.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_NOTSENT_LOWAT, value)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return
+}
+
+// setReusePort sets the SO_REUSEPORT socket option.
+func setReusePort(rc syscall.RawConn) (err error) {
+	cerr := rc.Control(func(fd uintptr) {
+		err = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if cerr != nil {
+		return cerr
+	}
+	return
+}
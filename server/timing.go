@@ -0,0 +1,29 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/neubot/dash/spec"
+)
+
+// declareServerTiming declares the server timing trailers, which we
+// must do before writing the headers of the download response.
+func declareServerTiming(w http.ResponseWriter) {
+	w.Header().Add("Trailer", spec.ServerQueueDelayTrailer)
+	w.Header().Add("Trailer", spec.ServerSendDurationTrailer)
+}
+
+// setServerTiming sets the values of the server timing trailers previously
+// declared using declareServerTiming, using seconds like the results do.
+func setServerTiming(w http.ResponseWriter, queueDelay, sendDuration time.Duration) {
+	w.Header().Set(spec.ServerQueueDelayTrailer, formatSeconds(queueDelay))
+	w.Header().Set(spec.ServerSendDurationTrailer, formatSeconds(sendDuration))
+}
+
+// formatSeconds formats the given duration as seconds with
+// microsecond precision (e.g., "0.001234").
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 6, 64)
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/spec"
+)
+
+func TestDownloadServerTiming(t *testing.T) {
+	download := func(t *testing.T, serverTiming bool) *http.Response {
+		handler := NewHandler("", log.Log)
+		handler.ServerTiming = serverTiming
+		mux := http.NewServeMux()
+		handler.RegisterHandlers(mux)
+		srvr := httptest.NewServer(mux)
+		defer srvr.Close()
+		const session = "deadbeef"
+		handler.createSession(session)
+		req, err := http.NewRequest("GET", srvr.URL+spec.DownloadPath+"1000", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", session)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if _, err := io.ReadAll(resp.Body); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 200 {
+			t.Fatal("Expected different status code")
+		}
+		return resp
+	}
+
+	t.Run("when enabled", func(t *testing.T) {
+		resp := download(t, true)
+		for _, name := range []string{spec.ServerQueueDelayTrailer, spec.ServerSendDurationTrailer} {
+			seconds, err := strconv.ParseFloat(resp.Trailer.Get(name), 64)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if seconds < 0 {
+				t.Fatal("unexpected negative value", name, seconds)
+			}
+		}
+	})
+
+	t.Run("when disabled", func(t *testing.T) {
+		resp := download(t, false)
+		if len(resp.Trailer) != 0 {
+			t.Fatal("unexpected trailers", resp.Trailer)
+		}
+		if resp.ContentLength <= 0 {
+			t.Fatal("expected the Content-Length", resp.ContentLength)
+		}
+	})
+}
+
+func TestFormatSeconds(t *testing.T) {
+	if value := formatSeconds(1234567 * time.Nanosecond); value != "0.001235" {
+		t.Fatal("unexpected value", value)
+	}
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// recordTLSResumption records in the given session whether the client
+// resumed a previous TLS session when establishing the connection used by
+// the request, because the cost of a full handshake skews the timing of the
+// first segment. This is a no-op for cleartext requests, including the ones
+// forwarded by TLS terminating proxies. The caller MUST own the session,
+// i.e., the session MUST NOT be inside .sessions yet.
+func (h *Handler) recordTLSResumption(session *sessionInfo, r *http.Request) {
+	if r.TLS == nil {
+		return
+	}
+	resumed := r.TLS.DidResume
+	scheme, proto := h.metricLabels(r)
+	tlsHandshakes.WithLabelValues(strconv.FormatBool(resumed), scheme, proto).Inc()
+	session.serverSchema.TLSResumed = &resumed
+}
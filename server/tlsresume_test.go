@@ -0,0 +1,48 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/apex/log"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRecordTLSResumption(t *testing.T) {
+	counter := func(resumed string) float64 {
+		value := &dto.Metric{}
+		if err := tlsHandshakes.WithLabelValues(resumed, "https", "HTTP/1.1").Write(value); err != nil {
+			t.Fatal(err)
+		}
+		return value.Counter.GetValue()
+	}
+
+	t.Run("cleartext request", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		session := &sessionInfo{}
+		handler.recordTLSResumption(session, httptest.NewRequest("POST", "/negotiate/dash", nil))
+		if session.serverSchema.TLSResumed != nil {
+			t.Fatal("expected no TLS resumption information")
+		}
+	})
+
+	for _, resumed := range []bool{false, true} {
+		label := map[bool]string{false: "false", true: "true"}[resumed]
+		t.Run("TLS request with resumed="+label, func(t *testing.T) {
+			handler := NewHandler("", log.Log)
+			session := &sessionInfo{}
+			req := httptest.NewRequest("POST", "/negotiate/dash", nil)
+			req.TLS = &tls.ConnectionState{DidResume: resumed}
+			before := counter(label)
+			handler.recordTLSResumption(session, req)
+			value := session.serverSchema.TLSResumed
+			if value == nil || *value != resumed {
+				t.Fatal("unexpected TLS resumption information")
+			}
+			if counter(label)-before != 1 {
+				t.Fatal("unexpected number of handshakes")
+			}
+		})
+	}
+}
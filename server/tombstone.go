@@ -0,0 +1,48 @@
+package server
+
+import "time"
+
+// tombstoneLifetime is for how long we remember collected sessions.
+const tombstoneLifetime = 60 * time.Second
+
+// tombstone is a recently collected session.
+type tombstone struct {
+	// data contains the response we sent when collecting.
+	data []byte
+
+	// stamp is when we collected.
+	stamp time.Time
+}
+
+// addTombstone SAFELY REMEMBERS that we collected the session with the given
+// UUID responding with the given data, so that we can respond again with the
+// same data if the client retries collecting because of a network error.
+func (h *Handler) addTombstone(UUID string, data []byte) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.tombstones[UUID] = &tombstone{data: data, stamp: h.now()}
+}
+
+// getTombstone SAFELY RETURNS the response we sent when collecting the
+// session with the given UUID, if we recently collected it.
+func (h *Handler) getTombstone(UUID string) ([]byte, bool) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	entry, ok := h.tombstones[UUID]
+	if !ok || h.now().Sub(entry.stamp) > tombstoneLifetime {
+		return nil, false
+	}
+	return entry.data, true
+}
+
+// reapTombstones SAFELY REMOVES the expired tombstones.
+func (h *Handler) reapTombstones() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	now := h.now()
+	for UUID, entry := range h.tombstones {
+		if now.Sub(entry.stamp) > tombstoneLifetime {
+			delete(h.tombstones, UUID)
+		}
+	}
+}
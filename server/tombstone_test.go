@@ -0,0 +1,61 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+)
+
+func TestServerCollectRetry(t *testing.T) {
+	const session = "deadbeef"
+	handler := NewHandler("", log.Log)
+	handler.createSession(session)
+	handler.deps.IOReadAll = func(r io.Reader) ([]byte, error) {
+		return []byte("[]"), nil
+	}
+	handler.deps.Savedata = func(session *sessionInfo) error {
+		return nil
+	}
+	collect := func() (int, string) {
+		req := new(http.Request)
+		req.Header = make(http.Header)
+		req.Header.Add(authorization, session)
+		w := httptest.NewRecorder()
+		handler.collect(w, req)
+		return w.Result().StatusCode, w.Body.String()
+	}
+	code, first := collect()
+	if code != 200 {
+		t.Fatal("Expected different status code")
+	}
+	code, second := collect()
+	if code != 200 {
+		t.Fatal("Expected different status code")
+	}
+	if first != second {
+		t.Fatal("expected the same response", first, second)
+	}
+}
+
+func TestTombstones(t *testing.T) {
+	handler := NewHandler("", log.Log)
+	handler.addTombstone("deadbeef", []byte("[]"))
+	if _, ok := handler.getTombstone("deadbeef"); !ok {
+		t.Fatal("expected a tombstone")
+	}
+	if _, ok := handler.getTombstone("antani"); ok {
+		t.Fatal("expected no tombstone")
+	}
+	handler.tombstones["deadbeef"].stamp = time.Now().Add(-2 * tombstoneLifetime)
+	if _, ok := handler.getTombstone("deadbeef"); ok {
+		t.Fatal("expected the tombstone to be expired")
+	}
+	handler.reapTombstones()
+	if len(handler.tombstones) != 0 {
+		t.Fatal("expected no tombstones")
+	}
+}
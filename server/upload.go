@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// upload implements the /dash/upload handler, which receives a segment
+// uploaded by the client and records an iteration of the session, so the
+// client can measure the upload direction. We read and discard at most
+// maxSize bytes, which count towards the MaxSessionBytes, and the client
+// measures the time to upload the segment. Like we shorten the last segment
+// of a download, we refuse the segment exceeding the remaining bytes of the
+// MaxSessionBytes, so that uploads cannot overshoot the budget.
+func (h *Handler) upload(w http.ResponseWriter, r *http.Request) {
+	// make sure we have a valid session, possibly loading it from the
+	// shared session store and saving it back when we are done
+	sessionID := r.Header.Get(authorization)
+	h.loadSharedSession(r.Context(), sessionID)
+	defer h.publishSession(context.Background(), sessionID)
+	switch h.getSessionState(sessionID) {
+	case sessionMissing:
+		h.logger.Warn("upload: session missing")
+		h.reportAbuse(r, abuseInvalidSession)
+		setFailureReason(w, "session missing")
+		w.WriteHeader(400)
+		return
+	case sessionExpired, sessionOverBudget:
+		h.logger.Warn("upload: session exhausted")
+		setFailureReason(w, "session exhausted")
+		w.WriteHeader(429)
+		return
+	}
+
+	// make sure the request complies with the session binding policy and
+	// the connection pinning, like we do for downloads
+	if !h.boundRequest(sessionID, r) {
+		h.logger.Warn("upload: session bound to another client")
+		h.reportAbuse(r, abuseUnboundSession)
+		setFailureReason(w, "session bound to another client")
+		w.WriteHeader(403)
+		return
+	}
+	if h.unpinnedConn(sessionID, r) {
+		h.logger.Warn("upload: session pinned to another connection")
+		setFailureReason(w, "session pinned to another connection")
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	// make sure the session is not already transferring a segment
+	if !h.beginDownload(sessionID) {
+		h.logger.Warn("upload: session already transferring")
+		setFailureReason(w, "session already transferring")
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	defer h.endDownload(sessionID)
+	h.trackConn(sessionID, r)
+
+	// read and discard the segment, refusing segments that are too large
+	// or that exceed the session budget, and making sure a stalled sender
+	// cannot hold this goroutine forever
+	limit := int64(maxSize)
+	if remaining := h.remainingBytes(sessionID); remaining >= 0 {
+		limit = min(limit, remaining)
+	}
+	expected := maxSize
+	if r.ContentLength > 0 && r.ContentLength < maxSize {
+		expected = int(r.ContentLength)
+	}
+	clearDeadline := h.setUploadDeadline(w, expected)
+	defer clearDeadline()
+	received := time.Now()
+	count, err := io.Copy(io.Discard, http.MaxBytesReader(w, r.Body, limit))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) && limit < maxSize {
+		h.logger.Warnf("upload: segment exceeding the remaining %d bytes", limit)
+		setFailureReason(w, "session exhausted")
+		w.WriteHeader(429)
+		return
+	}
+	if errors.As(err, &tooLarge) {
+		h.logger.Warnf("upload: segment larger than %d bytes", maxSize)
+		setFailureReason(w, "segment too large")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		h.logger.Warnf("upload: aborted after %d bytes: %s", count, err.Error())
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			scheme, proto := h.metricLabels(r)
+			expiredUploads.WithLabelValues(scheme, proto).Inc()
+		}
+		// the rest of the body is still in flight, so we cannot reuse
+		// the connection and we must not wait to discard the body
+		w.Header().Set("Connection", "close")
+		setFailureReason(w, "upload aborted")
+		w.WriteHeader(400)
+		return
+	}
+	h.logger.Debugf("upload: received %d bytes in %s", count, time.Since(received))
+
+	// register that the session has done an iteration
+	idx := h.updateSession(sessionID, int(count))
+	h.recordReceived(sessionID, idx, count)
+	w.Header().Set("Content-Length", "0")
+	w.WriteHeader(200)
+}
+
+// recordReceived SAFELY SETS the number of body bytes we received for the
+// idx-th measurement result of the session with the given UUID.
+func (h *Handler) recordReceived(UUID string, idx int, count int64) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	session, ok := h.sessions[UUID]
+	if ok && idx >= 0 && idx < len(session.serverSchema.Server) {
+		session.serverSchema.Server[idx].Received = count
+	}
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/apex/log"
+	"github.com/neubot/dash/spec"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestServerUpload(t *testing.T) {
+	t.Run("session missing", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		req := httptest.NewRequest("POST", spec.UploadPath, bytes.NewReader(make([]byte, 1000)))
+		w := httptest.NewRecorder()
+		handler.upload(w, req)
+		if w.Code != 400 {
+			t.Fatal("Expected different status code")
+		}
+	})
+
+	t.Run("segment too large", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		const session = "deadbeef"
+		handler.createSession(session)
+		req := httptest.NewRequest("POST", spec.UploadPath, bytes.NewReader(make([]byte, maxSize+1)))
+		req.Header.Set("Authorization", session)
+		w := httptest.NewRecorder()
+		handler.upload(w, req)
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Fatal("Expected different status code")
+		}
+	})
+
+	t.Run("common case", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		const session = "deadbeef"
+		handler.createSession(session)
+		req := httptest.NewRequest("POST", spec.UploadPath, bytes.NewReader(make([]byte, 1000)))
+		req.Header.Set("Authorization", session)
+		w := httptest.NewRecorder()
+		handler.upload(w, req)
+		if w.Code != 200 {
+			t.Fatal("Expected different status code")
+		}
+		server := handler.sessions[session].serverSchema.Server
+		if len(server) != 1 || server[0].Received != 1000 {
+			t.Fatal("unexpected server results", server)
+		}
+		if handler.sessions[session].iteration != 1 {
+			t.Fatal("the upload did not count as an iteration")
+		}
+	})
+
+	t.Run("uploads count towards the session budget", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.MaxSessionBytes = 1000
+		const session = "deadbeef"
+		handler.createSession(session)
+		for _, expect := range []int{200, 429} {
+			req := httptest.NewRequest("POST", spec.UploadPath, bytes.NewReader(make([]byte, 1000)))
+			req.Header.Set("Authorization", session)
+			w := httptest.NewRecorder()
+			handler.upload(w, req)
+			if w.Code != expect {
+				t.Fatal("Expected different status code", w.Code)
+			}
+		}
+	})
+
+	t.Run("last segment exceeding the budget", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.MaxSessionBytes = minSize + 1000
+		const session = "deadbeef"
+		handler.createSession(session)
+		handler.updateSession(session, minSize)
+		req := httptest.NewRequest("POST", spec.UploadPath, bytes.NewReader(make([]byte, 1001)))
+		req.Header.Set("Authorization", session)
+		w := httptest.NewRecorder()
+		handler.upload(w, req)
+		if w.Code != 429 {
+			t.Fatal("Expected different status code", w.Code)
+		}
+		if handler.sessions[session].bytes != minSize {
+			t.Fatal("the upload should not count towards the budget")
+		}
+	})
+
+	t.Run("last segment within the budget", func(t *testing.T) {
+		handler := NewHandler("", log.Log)
+		handler.MaxSessionBytes = minSize + 1000
+		const session = "deadbeef"
+		handler.createSession(session)
+		handler.updateSession(session, minSize)
+		req := httptest.NewRequest("POST", spec.UploadPath, bytes.NewReader(make([]byte, 1000)))
+		req.Header.Set("Authorization", session)
+		w := httptest.NewRecorder()
+		handler.upload(w, req)
+		if w.Code != 200 {
+			t.Fatal("Expected different status code", w.Code)
+		}
+		if handler.getSessionState(session) != sessionOverBudget {
+			t.Fatal("Expected the session to be over budget")
+		}
+	})
+
+	t.Run("we cut off a stalled sender", func(t *testing.T) {
+		counter := func() float64 {
+			value := &dto.Metric{}
+			if err := expiredUploads.WithLabelValues("http", "HTTP/1.1").Write(value); err != nil {
+				t.Fatal(err)
+			}
+			return value.Counter.GetValue()
+		}
+		handler := NewHandler("", log.Log)
+		handler.MinDownloadRate = 1 << 40 // so the deadline is the grace time
+		handler.deadlineGrace = 100 * time.Millisecond
+		mux := http.NewServeMux()
+		handler.RegisterHandlers(mux)
+		srvr := httptest.NewServer(mux)
+		defer srvr.Close()
+		const session = "deadbeef"
+		handler.createSession(session)
+		conn, err := net.Dial("tcp", srvr.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		before := counter()
+		// promise 1000 bytes but only send 10 of them
+		fmt.Fprintf(conn, "POST %s HTTP/1.1\r\nHost: %s\r\nAuthorization: %s\r\n"+
+			"Content-Length: 1000\r\n\r\n%s", spec.UploadPath, srvr.Listener.Addr(),
+			session, make([]byte, 10))
+		_ = conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Fatal("Expected different status code", resp.StatusCode)
+		}
+		if counter() != before+1 {
+			t.Fatal("the expired upload was not counted")
+		}
+	})
+}
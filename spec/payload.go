@@ -0,0 +1,37 @@
+package spec
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"math/rand/v2"
+)
+
+// SeedSize is the size in bytes of the session seed.
+const SeedSize = 32
+
+// NewPayloadReader returns a reader producing the deterministic payload of
+// the segment with the given iteration index within a session using the given
+// seed. The iteration index is the Iteration field of the corresponding server
+// results. The reader never fails and never ends, so one should only read
+// as many bytes as the size of the segment.
+//
+// The payload is the output of a ChaCha8 generator (see [rand.ChaCha8])
+// seeded with SHA-256(seed || iteration), where iteration is encoded as a
+// big endian 64 bit integer. Because the payload depends on the iteration,
+// each segment is different and the payload remains incompressible.
+func NewPayloadReader(seed []byte, iteration int64) io.Reader {
+	hash := sha256.New()
+	hash.Write(seed)
+	hash.Write(binary.BigEndian.AppendUint64(nil, uint64(iteration)))
+	var key [32]byte
+	copy(key[:], hash.Sum(nil))
+	return rand.NewChaCha8(key)
+}
+
+// FillPayload fills p with the deterministic payload of the segment with the
+// given iteration index within a session using the given seed (see
+// [NewPayloadReader] for more information).
+func FillPayload(seed []byte, iteration int64, p []byte) {
+	_, _ = NewPayloadReader(seed, iteration).Read(p) // never fails
+}
@@ -0,0 +1,32 @@
+package spec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func TestFillPayload(t *testing.T) {
+	seed := bytes.Repeat([]byte{7}, SeedSize)
+	first, second := make([]byte, 1<<16), make([]byte, 1<<16)
+	FillPayload(seed, 0, first)
+	FillPayload(seed, 0, second)
+	if !bytes.Equal(first, second) {
+		t.Fatal("expected a deterministic payload")
+	}
+	FillPayload(seed, 1, second)
+	if bytes.Equal(first, second) {
+		t.Fatal("expected different payloads for different iterations")
+	}
+	FillPayload(bytes.Repeat([]byte{8}, SeedSize), 0, second)
+	if bytes.Equal(first, second) {
+		t.Fatal("expected different payloads for different seeds")
+	}
+	compressed := &bytes.Buffer{}
+	writer := gzip.NewWriter(compressed)
+	writer.Write(first)
+	writer.Close()
+	if compressed.Len() < len(first) {
+		t.Fatal("expected an incompressible payload")
+	}
+}
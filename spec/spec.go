@@ -24,11 +24,36 @@ const (
 	// the server to send you as part of the next chunk.
 	DownloadPath = DownloadPathNoTrailingSlash + "/"
 
+	// UploadPath is the URL path used to upload DASH segments, which is an
+	// extension to the original DASH experiment allowing to measure the
+	// upload direction. Clients POST the segment as the request body.
+	UploadPath = "/dash/upload"
+
 	// CollectPath is the URL path used to collect. We use /collect/dash
 	// rather than /dash/collect for historical reasons. Neubot used to
 	// handle all requests for collection by handling the /collect prefix
 	// and routing to the proper experiment.
 	CollectPath = "/collect/dash"
+
+	// CacheBustingQuery is the name of the URL query parameter containing
+	// the random token that clients may add to download requests to make
+	// sure that transparent caches cannot serve the segments.
+	CacheBustingQuery = "token"
+
+	// CacheBustingHeader is the response header where servers configured
+	// for cache busting echo the token in the CacheBustingQuery parameter,
+	// which allows clients to detect responses served by caches.
+	CacheBustingHeader = "X-Dash-Token"
+
+	// ServerQueueDelayTrailer is the experimental download response trailer
+	// where servers configured for server timing report the time, in seconds,
+	// between receiving the request and starting to send the segment.
+	ServerQueueDelayTrailer = "X-Dash-Queue-Delay"
+
+	// ServerSendDurationTrailer is the experimental download response trailer
+	// where servers configured for server timing report the time, in seconds,
+	// for writing and flushing the segment to the connection.
+	ServerSendDurationTrailer = "X-Dash-Send-Duration"
 )
 
 // DefaultRates contains the default DASH rates in kbit/s.